@@ -0,0 +1,118 @@
+//go:build !js && !wasm
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	"tractor.dev/toolkit-go/engine/cli"
+	"tractor.dev/wanix/fs/casfs"
+	"tractor.dev/wanix/fs/localfs"
+)
+
+// openStore opens the content-addressed store in dir, defaulting to the
+// user cache directory.
+func openStore(dir string) (*casfs.Store, error) {
+	if dir == "" {
+		cache, err := os.UserCacheDir()
+		if err != nil {
+			return nil, err
+		}
+		dir = filepath.Join(cache, "wanix", "cas")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	fsys, err := localfs.New(dir)
+	if err != nil {
+		return nil, err
+	}
+	return casfs.NewStore(fsys), nil
+}
+
+func exportCmd() *cli.Command {
+	var (
+		storeDir string
+		output   string
+	)
+	cmd := &cli.Command{
+		Usage: "export <dir|digest>",
+		Short: "export a manifest and its blobs as an archive",
+		Run: func(ctx *cli.Context, args []string) {
+			if len(args) != 1 {
+				log.Fatal("usage: wanix export <dir|digest>")
+			}
+			store, err := openStore(storeDir)
+			fatal(err)
+
+			digest := args[0]
+			if !casfs.ValidDigest(digest) {
+				dir, err := filepath.Abs(args[0])
+				fatal(err)
+				dirfs, err := localfs.New(dir)
+				fatal(err)
+				m, err := store.Snapshot(dirfs, ".")
+				fatal(err)
+				digest, err = store.PutManifest(m)
+				fatal(err)
+			}
+
+			var w io.Writer = os.Stdout
+			if output != "" && output != "-" {
+				f, err := os.Create(output)
+				fatal(err)
+				defer f.Close()
+				w = f
+			}
+			fatal(store.Export(w, digest))
+			fmt.Fprintln(os.Stderr, digest)
+		},
+	}
+	cmd.Flags().StringVar(&storeDir, "store", "", "content store directory (default user cache)")
+	cmd.Flags().StringVar(&output, "output", "", "write the archive to file instead of stdout")
+	return cmd
+}
+
+func importCmd() *cli.Command {
+	var (
+		storeDir string
+		extract  string
+	)
+	cmd := &cli.Command{
+		Usage: "import [file]",
+		Short: "import a manifest archive into the content store",
+		Run: func(ctx *cli.Context, args []string) {
+			store, err := openStore(storeDir)
+			fatal(err)
+
+			var r io.Reader = os.Stdin
+			if len(args) > 0 && args[0] != "-" {
+				f, err := os.Open(args[0])
+				fatal(err)
+				defer f.Close()
+				r = f
+			}
+			digest, err := store.Import(r)
+			fatal(err)
+
+			if extract != "" {
+				dir, err := filepath.Abs(extract)
+				fatal(err)
+				fatal(os.MkdirAll(dir, 0755))
+				dirfs, err := localfs.New(dir)
+				fatal(err)
+				m, err := store.Manifest(digest)
+				fatal(err)
+				fatal(store.Extract(m, dirfs, "."))
+			}
+			fmt.Println(digest)
+		},
+	}
+	cmd.Flags().StringVar(&storeDir, "store", "", "content store directory (default user cache)")
+	cmd.Flags().StringVar(&extract, "extract", "", "also extract the tree into directory")
+	return cmd
+}
@@ -21,7 +21,8 @@ func (m *Main) InitializeCLI(root *cli.Command) {
 	root.Usage = "wanix"
 	root.Version = Version
 	root.AddCommand(serveCmd())
-
+	root.AddCommand(exportCmd())
+	root.AddCommand(importCmd())
 }
 
 func fatal(err error) {
@@ -19,7 +19,10 @@ import (
 	"github.com/hugelgupf/p9/p9"
 	"github.com/progrium/go-netstack/vnet"
 	altws "golang.org/x/net/websocket"
+	"tractor.dev/wanix/fs/davfs"
+	"tractor.dev/wanix/fs/httpfs"
 	"tractor.dev/wanix/fs/localfs"
+	"tractor.dev/wanix/fs/r2fs"
 
 	"tractor.dev/toolkit-go/duplex/mux"
 	"tractor.dev/toolkit-go/engine/cli"
@@ -30,6 +33,8 @@ func serveCmd() *cli.Command {
 	var (
 		listenAddr string
 		bundle     string
+		serveDAV   bool
+		s3         r2fs.Config
 	)
 	cmd := &cli.Command{
 		Usage: "serve [dir]",
@@ -78,6 +83,29 @@ func serveCmd() *cli.Command {
 			http.Handle("/.well-known/ethernet", ethernetHandler(vn))
 			http.Handle("/.well-known/export9p", export9pHandler())
 
+			if serveDAV {
+				dav := davfs.NewHandler(dirfs, "/.well-known/webdav")
+				http.Handle("/.well-known/webdav/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.Header().Add("Access-Control-Allow-Origin", "*")
+					dav.ServeHTTP(w, r)
+				}))
+				fmt.Printf("WebDAV available at: http://%s:%s/.well-known/webdav/\n", h, p)
+			}
+
+			if s3.Bucket != "" {
+				s3.Bucket, s3.BasePath, _ = strings.Cut(s3.Bucket, "/")
+				s3fs, err := r2fs.NewS3(context.Background(), s3)
+				if err != nil {
+					log.Fatal(err)
+				}
+				srv := httpfs.NewServerWithPrefix(s3fs, "/.well-known/s3")
+				http.Handle("/.well-known/s3/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.Header().Add("Access-Control-Allow-Origin", "*")
+					srv.ServeHTTP(w, r)
+				}))
+				fmt.Printf("Bucket %s available at: http://%s:%s/.well-known/s3/\n", s3.Bucket, h, p)
+			}
+
 			p9srv := p9.NewServer(p9kit.Attacher(dirfs, p9kit.WithXattrAttrStore()))
 
 			http.Handle("/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -97,6 +125,12 @@ func serveCmd() *cli.Command {
 	}
 	cmd.Flags().StringVar(&listenAddr, "listen", ":7654", "addr to serve on")
 	cmd.Flags().StringVar(&bundle, "bundle", "", "default bundle to use")
+	cmd.Flags().BoolVar(&serveDAV, "webdav", false, "also serve the directory over WebDAV")
+	cmd.Flags().StringVar(&s3.Bucket, "s3", "", "re-export an S3 bucket[/prefix] over httpfs")
+	cmd.Flags().StringVar(&s3.Endpoint, "s3-endpoint", "", "S3-compatible endpoint URL (default AWS)")
+	cmd.Flags().StringVar(&s3.Region, "s3-region", "", "S3 region")
+	cmd.Flags().BoolVar(&s3.PathStyle, "s3-path-style", false, "use path-style S3 addressing")
+	cmd.Flags().StringVar(&s3.Profile, "s3-profile", "", "AWS shared config profile for credentials")
 	return cmd
 }
 
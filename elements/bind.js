@@ -24,8 +24,11 @@ export class BindElement extends HTMLElement {
 
         switch (this.type) {
         case "archive":
+            // lazy archives are fetched as a Blob and indexed in place
+            // instead of being decompressed and copied into memory
+            const fetcher = this.hasAttribute('lazy') ? fetchBlob : fetchArchive;
             this.data = new Promise((resolve, reject) => {
-                fetchArchive(this.src).then(data => {
+                fetcher(this.src).then(data => {
                     resolve(data);
                 }).catch(err => {
                     console.error("Failed to fetch archive", this.src, err);
@@ -100,6 +103,13 @@ if (typeof window !== "undefined") {
 
 
 
+// fetch a resource as a Blob, which the browser may keep on disk
+async function fetchBlob(url) {
+    const res = await fetch(url);
+    if (!res.ok) throw new Error(`HTTP ${res.status}`);
+    return res.blob();
+}
+
 // fetch an archive and return a tar stream, decompressing if necessary
 async function fetchArchive(url) {
     const res = await fetch(url);
@@ -53,6 +53,7 @@ export class TerminalElement extends WanixElement {
         this._fitAddon.fit();
         this.#resizeObserver = new ResizeObserver(() => {
             this._fitAddon.fit();
+            this._sendSize();
         });
         this.#resizeObserver.observe(this);
         // this._resizeObserver.observe(this.parentElement);
@@ -147,36 +148,32 @@ export class TerminalElement extends WanixElement {
             const writable = await this._kernel.root.openWritable(dataPath);
             this.#writer = writable.getWriter();
 
+            if (this.raw) {
+                await this._kernel.root.writeFile(this.path + "/ctl", "rawon");
+            }
+            await this._sendSize();
+
+            // the terminal device does line discipline unless in raw mode
             const encoder = new TextEncoder();
-            let buffer = '';
             this.#dataDisposable = this._term.onData((data) => {
-                if (this.raw) {
-                    this.#writer.write(encoder.encode(data));
-                    return;
-                }
-                // may add line discipline as mode to terminals but for now we
-                // do as plan 9 and handle it here in "userspace"
-                if (data === '\r') {
-                    this._term.write('\r\n');           // echo newline
-                    if (this.#writer) {
-                        this.#writer.write(encoder.encode(buffer+"\n"));
-                    }
-                    buffer = '';
-                } else if (data === '\x7f') {   // backspace
-                    if (buffer.length > 0) {
-                      buffer = buffer.slice(0, -1);
-                      this._term.write('\b \b');
-                    }
-                } else {
-                    buffer += data;
-                    this._term.write(data);             // echo
-                }
+                this.#writer?.write(encoder.encode(data));
             });
         } catch (err) {
             console.error("wanix-term: failed to connect terminal:", err);
         }
     }
 
+    // tell the terminal device our size so its screen matches
+    async _sendSize() {
+        if (!this.#writer || !this._term) return;
+        const { cols, rows } = this._term;
+        try {
+            await this._kernel.root.writeFile(this.path + "/ctl", `size ${cols} ${rows}`);
+        } catch (err) {
+            console.error("wanix-term: failed to set size:", err);
+        }
+    }
+
     async _readLoop() {
         if (!this.#reader || !this._term) return;
 
@@ -80,6 +80,8 @@ export class VMElement extends WanixElement {
             }
 
             await this.task.taskRoot.bind(this.term, `${this._taskpath}/self/term`);
+            // the guest kernel does its own line discipline
+            await this._kernel.root.writeFile([this.term, "ctl"].join("/"), "rawon");
 
             const program = [this.term, "program"].join("/");
             await this.task.taskRoot.bind(program, [this.task.path, "fd/0"].join("/"));
@@ -8,6 +8,7 @@ import (
 	"go.bug.st/serial"
 	"tractor.dev/wanix"
 	"tractor.dev/wanix/fs/localfs"
+	wnet "tractor.dev/wanix/fs/net"
 	"tractor.dev/wanix/fs/p9kit"
 	"tractor.dev/wanix/native"
 	"tractor.dev/wanix/term"
@@ -36,12 +37,19 @@ func main() {
 		log.Fatal(err)
 	}
 
+	if err := root.NS().Bind(wnet.New(), ".", "#net"); err != nil {
+		log.Fatal(err)
+	}
+
 	if err := root.Bind("#task", "task"); err != nil {
 		log.Fatal(err)
 	}
 	if err := root.Bind("#term", "term"); err != nil {
 		log.Fatal(err)
 	}
+	if err := root.Bind("#net", "net"); err != nil {
+		log.Fatal(err)
+	}
 
 	var opts []p9.ServerOpt
 	// if os.Getenv("DEBUG") != "" {
@@ -0,0 +1,651 @@
+package casfs
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"tractor.dev/wanix/fs"
+	"tractor.dev/wanix/fs/fskit"
+)
+
+const maxSymlinks = 40
+
+// FS is a writable filesystem over a manifest whose file contents are kept
+// in a Store. Files with the same contents share a blob, including across
+// every FS on the same store, so it works well as the base or overlay of
+// layered environments. Written files are stored when they are closed.
+//
+// FS also implements the remote side of syncfs with Index and Patch.
+type FS struct {
+	store *Store
+	mu    sync.RWMutex
+	m     *Manifest
+}
+
+// New returns a filesystem over a copy of m backed by store. A nil m
+// starts empty.
+func New(store *Store, m *Manifest) *FS {
+	if m == nil {
+		m = NewManifest()
+	}
+	return &FS{store: store, m: m.Clone()}
+}
+
+// Load returns a filesystem over the manifest with digest in store.
+func Load(store *Store, digest string) (*FS, error) {
+	m, err := store.Manifest(digest)
+	if err != nil {
+		return nil, err
+	}
+	return &FS{store: store, m: m}, nil
+}
+
+// Store returns the blob store backing the filesystem.
+func (f *FS) Store() *Store {
+	return f.store
+}
+
+// Manifest returns a copy of the current manifest.
+func (f *FS) Manifest() *Manifest {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.m.Clone()
+}
+
+// Commit stores the current manifest, returning its digest.
+func (f *FS) Commit() (string, error) {
+	return f.store.PutManifest(f.Manifest())
+}
+
+// ============================================================================
+// Reads
+// ============================================================================
+
+func (f *FS) Open(name string) (fs.File, error) {
+	return f.OpenContext(context.Background(), name)
+}
+
+func (f *FS) OpenContext(ctx context.Context, name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	f.mu.RLock()
+	e, err := f.resolve("open", name, true)
+	if err != nil {
+		f.mu.RUnlock()
+		return nil, err
+	}
+	if e.Mode.IsDir() {
+		var entries []fs.DirEntry
+		for _, c := range f.m.Children(e.Path) {
+			entries = append(entries, c.node())
+		}
+		f.mu.RUnlock()
+		return fskit.DirFile(fskit.Entry(name, e.Mode, e.ModTime), entries...), nil
+	}
+	f.mu.RUnlock()
+
+	file, err := f.store.Open(e.Digest)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return &blobFile{File: file, info: e.node()}, nil
+}
+
+func (f *FS) Stat(name string) (fs.FileInfo, error) {
+	return f.StatContext(context.Background(), name)
+}
+
+func (f *FS) StatContext(ctx context.Context, name string) (fs.FileInfo, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	e, err := f.resolve("stat", name, true)
+	if err != nil {
+		return nil, err
+	}
+	return e.node(), nil
+}
+
+func (f *FS) Lstat(name string) (fs.FileInfo, error) {
+	return f.LstatContext(context.Background(), name)
+}
+
+func (f *FS) LstatContext(ctx context.Context, name string) (fs.FileInfo, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	e, err := f.resolve("lstat", name, false)
+	if err != nil {
+		return nil, err
+	}
+	return e.node(), nil
+}
+
+func (f *FS) ReadDirContext(ctx context.Context, name string) ([]fs.DirEntry, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	e, err := f.resolve("readdir", name, true)
+	if err != nil {
+		return nil, err
+	}
+	if !e.Mode.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	var entries []fs.DirEntry
+	for _, c := range f.m.Children(e.Path) {
+		entries = append(entries, c.node())
+	}
+	return entries, nil
+}
+
+func (f *FS) Readlink(name string) (string, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	e, err := f.resolve("readlink", name, false)
+	if err != nil {
+		return "", err
+	}
+	if e.Mode&fs.ModeSymlink == 0 {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+	return e.Target, nil
+}
+
+// resolve returns the entry at name, following symlinks in its parent
+// directories, and the final one if follow is set. Caller must hold mu.
+func (f *FS) resolve(op, name string, follow bool) (Entry, error) {
+	name = cleanPath(name)
+	for i := 0; i < maxSymlinks; i++ {
+		e, next, ok := f.walk(name, follow)
+		if !ok {
+			return Entry{}, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		}
+		if next == "" {
+			return e, nil
+		}
+		name = next
+	}
+	return Entry{}, &fs.PathError{Op: op, Path: name, Err: errors.New("too many levels of symbolic links")}
+}
+
+// walk looks up name a component at a time. At the first symlink to
+// follow it returns the path that results from replacing it with its
+// target, and otherwise the entry at name. Caller must hold mu.
+func (f *FS) walk(name string, follow bool) (e Entry, next string, ok bool) {
+	e, _ = f.m.Get(".")
+	if name == "." {
+		return e, "", true
+	}
+	parts := strings.Split(name, "/")
+	for i, part := range parts {
+		p := path.Join(e.Path, part)
+		if e, ok = f.m.Get(p); !ok {
+			return Entry{}, "", false
+		}
+		if e.Mode&fs.ModeSymlink == 0 || (i == len(parts)-1 && !follow) {
+			continue
+		}
+		target := e.Target
+		if !path.IsAbs(target) {
+			target = path.Join(path.Dir(p), target)
+		}
+		rest := append([]string{target}, parts[i+1:]...)
+		return e, cleanPath(path.Join(rest...)), true
+	}
+	return e, "", true
+}
+
+// ============================================================================
+// Writes
+// ============================================================================
+
+func (f *FS) Create(name string) (fs.File, error) {
+	return f.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+}
+
+func (f *FS) OpenFile(name string, flag int, perm fs.FileMode) (fs.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) == 0 {
+		return f.Open(name)
+	}
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	f.mu.RLock()
+	e, err := f.resolve("open", name, true)
+	if err == nil {
+		name = e.Path
+	}
+	f.mu.RUnlock()
+
+	switch {
+	case err == nil && flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrExist}
+	case err == nil && e.Mode.IsDir():
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	case errors.Is(err, fs.ErrNotExist) && flag&os.O_CREATE != 0:
+		p, err := f.checkParent("open", name)
+		if err != nil {
+			return nil, err
+		}
+		e = Entry{Path: p, Mode: perm.Perm()}
+	case err != nil:
+		return nil, err
+	}
+
+	wf := &writeFile{fsys: f, entry: e}
+	if flag&os.O_TRUNC == 0 && e.Digest != "" {
+		if wf.data, err = f.store.ReadBlob(e.Digest); err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+	}
+	if flag&os.O_APPEND != 0 {
+		wf.pos = int64(len(wf.data))
+	}
+	wf.dirty = flag&(os.O_CREATE|os.O_TRUNC) != 0
+	return wf, nil
+}
+
+func (f *FS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	digest, err := f.store.PutBytes(data)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	name, err = f.checkParentLocked("write", name)
+	if err != nil {
+		return err
+	}
+	mode := perm.Perm()
+	if e, ok := f.m.Get(name); ok {
+		if e.Mode.IsDir() {
+			return &fs.PathError{Op: "write", Path: name, Err: fs.ErrInvalid}
+		}
+		mode = e.Mode
+	}
+	f.m.Put(Entry{Path: name, Mode: mode, Size: int64(len(data)), ModTime: time.Now(), Digest: digest})
+	return nil
+}
+
+func (f *FS) Mkdir(name string, perm fs.FileMode) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p, err := f.checkParentLocked("mkdir", name)
+	if err != nil {
+		return err
+	}
+	if _, ok := f.m.Get(p); ok {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrExist}
+	}
+	f.m.Put(Entry{Path: p, Mode: fs.ModeDir | perm.Perm(), ModTime: time.Now()})
+	return nil
+}
+
+func (f *FS) Symlink(oldname, newname string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p, err := f.checkParentLocked("symlink", newname)
+	if err != nil {
+		return err
+	}
+	if _, ok := f.m.Get(p); ok {
+		return &fs.PathError{Op: "symlink", Path: newname, Err: fs.ErrExist}
+	}
+	f.m.Put(Entry{Path: p, Mode: fs.ModeSymlink | 0777, ModTime: time.Now(), Target: oldname})
+	return nil
+}
+
+func (f *FS) Remove(name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	e, err := f.resolve("remove", name, false)
+	if err != nil {
+		return err
+	}
+	if e.Path == "." {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	if e.Mode.IsDir() && len(f.m.Children(e.Path)) > 0 {
+		return &fs.PathError{Op: "remove", Path: name, Err: errors.New("directory not empty")}
+	}
+	f.m.Remove(e.Path)
+	return nil
+}
+
+func (f *FS) RemoveAll(name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if e, err := f.resolve("removeall", name, false); err == nil {
+		f.m.Remove(e.Path)
+	}
+	return nil
+}
+
+func (f *FS) Rename(oldname, newname string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	old, err := f.resolve("rename", oldname, false)
+	if err != nil {
+		return err
+	}
+	if old.Path == "." {
+		return &fs.PathError{Op: "rename", Path: oldname, Err: fs.ErrNotExist}
+	}
+	oldname = old.Path
+	newname, err = f.checkParentLocked("rename", newname)
+	if err != nil {
+		return err
+	}
+	f.m.Remove(newname)
+	for _, e := range f.m.Entries() {
+		if e.Path == oldname || strings.HasPrefix(e.Path, oldname+"/") {
+			f.m.Remove(e.Path)
+			e.Path = newname + e.Path[len(oldname):]
+			f.m.Put(e)
+		}
+	}
+	return nil
+}
+
+func (f *FS) Chmod(name string, mode fs.FileMode) error {
+	return f.update("chmod", name, func(e *Entry) {
+		e.Mode = e.Mode.Type() | mode.Perm()
+	})
+}
+
+func (f *FS) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return f.update("chtimes", name, func(e *Entry) {
+		e.ModTime = mtime
+	})
+}
+
+func (f *FS) Truncate(name string, size int64) error {
+	file, err := f.OpenFile(name, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	wf := file.(*writeFile)
+	wf.truncate(size)
+	return wf.Close()
+}
+
+// update changes the entry at name with fn.
+func (f *FS) update(op, name string, fn func(e *Entry)) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	e, err := f.resolve(op, name, true)
+	if err != nil {
+		return err
+	}
+	if e.Path == "." {
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrPermission}
+	}
+	fn(&e)
+	f.m.Put(e)
+	return nil
+}
+
+func (f *FS) checkParent(op, name string) (string, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.checkParentLocked(op, name)
+}
+
+// checkParentLocked returns name with the symlinks in its parent
+// directories resolved, or an error unless its parent is a directory.
+// Caller must hold mu.
+func (f *FS) checkParentLocked(op, name string) (string, error) {
+	name = cleanPath(name)
+	parent, err := f.resolve(op, path.Dir(name), true)
+	if err != nil {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	if !parent.Mode.IsDir() {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	return path.Join(parent.Path, path.Base(name)), nil
+}
+
+// ============================================================================
+// syncfs remote
+// ============================================================================
+
+// Index returns the tree at name for comparing with a local copy.
+func (f *FS) Index(ctx context.Context, name string) (fs.FS, error) {
+	if cleanPath(name) == "." {
+		return f, nil
+	}
+	return fs.Sub(f, name)
+}
+
+// Patch applies a tar archive of changes at name. Files are stored as
+// they are read, so contents already in the store aren't stored again.
+// Entries with a "delete" PAX record are removed. Entries applied before
+// ctx is done are kept.
+func (f *FS) Patch(ctx context.Context, name string, tarBuf bytes.Buffer) error {
+	tr := tar.NewReader(&tarBuf)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		p := path.Join(name, hdr.Name)
+		if _, ok := hdr.PAXRecords["delete"]; ok {
+			f.RemoveAll(p)
+			continue
+		}
+		e := Entry{Path: p, Mode: hdr.FileInfo().Mode(), ModTime: hdr.ModTime}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+		case tar.TypeSymlink:
+			e.Target = hdr.Linkname
+		case tar.TypeReg:
+			if e.Digest, e.Size, err = f.store.Put(&ctxReader{ctx, tr}); err != nil {
+				return err
+			}
+		default:
+			continue
+		}
+		f.mu.Lock()
+		if old, ok := f.m.Get(p); ok && old.Mode.IsDir() && !e.Mode.IsDir() {
+			f.m.Remove(p)
+		}
+		f.m.Put(e)
+		f.mu.Unlock()
+	}
+}
+
+// ctxReader reads from r until ctx is done.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (cr *ctxReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.r.Read(p)
+}
+
+// ============================================================================
+// Files
+// ============================================================================
+
+// node returns a FileInfo and DirEntry for e.
+func (e Entry) node() *fskit.Node {
+	return fskit.Entry(path.Base(e.Path), e.Mode, e.Size, e.ModTime)
+}
+
+// blobFile is a stored file reporting its manifest entry as its stat.
+type blobFile struct {
+	fs.File
+	info fs.FileInfo
+}
+
+func (bf *blobFile) Stat() (fs.FileInfo, error) { return bf.info, nil }
+
+func (bf *blobFile) ReadAt(p []byte, off int64) (int, error) {
+	ra, ok := bf.File.(io.ReaderAt)
+	if !ok {
+		return fs.ReadAt(bf.File, p, off)
+	}
+	return ra.ReadAt(p, off)
+}
+
+func (bf *blobFile) Seek(offset int64, whence int) (int64, error) {
+	s, ok := bf.File.(io.Seeker)
+	if !ok {
+		return 0, fs.ErrNotSupported
+	}
+	return s.Seek(offset, whence)
+}
+
+// writeFile buffers a file opened for writing and stores it on Close.
+type writeFile struct {
+	fsys   *FS
+	entry  Entry
+	data   []byte
+	pos    int64
+	dirty  bool
+	closed bool
+	mu     sync.Mutex
+}
+
+func (wf *writeFile) Stat() (fs.FileInfo, error) {
+	wf.mu.Lock()
+	defer wf.mu.Unlock()
+	e := wf.entry
+	e.Size = int64(len(wf.data))
+	return e.node(), nil
+}
+
+func (wf *writeFile) Read(p []byte) (int, error) {
+	wf.mu.Lock()
+	defer wf.mu.Unlock()
+	n, err := wf.readAt(p, wf.pos)
+	wf.pos += int64(n)
+	return n, err
+}
+
+func (wf *writeFile) ReadAt(p []byte, off int64) (int, error) {
+	wf.mu.Lock()
+	defer wf.mu.Unlock()
+	return wf.readAt(p, off)
+}
+
+func (wf *writeFile) readAt(p []byte, off int64) (int, error) {
+	if wf.closed {
+		return 0, fs.ErrClosed
+	}
+	if off >= int64(len(wf.data)) {
+		return 0, io.EOF
+	}
+	return copy(p, wf.data[off:]), nil
+}
+
+func (wf *writeFile) Write(p []byte) (int, error) {
+	wf.mu.Lock()
+	defer wf.mu.Unlock()
+	n, err := wf.writeAt(p, wf.pos)
+	wf.pos += int64(n)
+	return n, err
+}
+
+func (wf *writeFile) WriteAt(p []byte, off int64) (int, error) {
+	wf.mu.Lock()
+	defer wf.mu.Unlock()
+	return wf.writeAt(p, off)
+}
+
+func (wf *writeFile) writeAt(p []byte, off int64) (int, error) {
+	if wf.closed {
+		return 0, fs.ErrClosed
+	}
+	if end := off + int64(len(p)); end > int64(len(wf.data)) {
+		wf.data = append(wf.data, make([]byte, end-int64(len(wf.data)))...)
+	}
+	copy(wf.data[off:], p)
+	wf.dirty = true
+	return len(p), nil
+}
+
+func (wf *writeFile) Seek(offset int64, whence int) (int64, error) {
+	wf.mu.Lock()
+	defer wf.mu.Unlock()
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += wf.pos
+	case io.SeekEnd:
+		offset += int64(len(wf.data))
+	default:
+		return 0, fs.ErrInvalid
+	}
+	if offset < 0 {
+		return 0, fs.ErrInvalid
+	}
+	wf.pos = offset
+	return offset, nil
+}
+
+func (wf *writeFile) truncate(size int64) {
+	wf.mu.Lock()
+	defer wf.mu.Unlock()
+	if size < int64(len(wf.data)) {
+		wf.data = wf.data[:size]
+	} else {
+		wf.data = append(wf.data, make([]byte, size-int64(len(wf.data)))...)
+	}
+	wf.dirty = true
+}
+
+// Sync stores the file as written so far.
+func (wf *writeFile) Sync() error {
+	wf.mu.Lock()
+	defer wf.mu.Unlock()
+	return wf.flush()
+}
+
+func (wf *writeFile) Close() error {
+	wf.mu.Lock()
+	defer wf.mu.Unlock()
+	if wf.closed {
+		return fs.ErrClosed
+	}
+	wf.closed = true
+	return wf.flush()
+}
+
+// flush stores the contents and updates the manifest. Caller must hold mu.
+func (wf *writeFile) flush() error {
+	if !wf.dirty {
+		return nil
+	}
+	digest, err := wf.fsys.store.PutBytes(wf.data)
+	if err != nil {
+		return err
+	}
+	wf.entry.Digest = digest
+	wf.entry.Size = int64(len(wf.data))
+	wf.entry.ModTime = time.Now()
+	wf.dirty = false
+
+	wf.fsys.mu.Lock()
+	defer wf.fsys.mu.Unlock()
+	wf.fsys.m.Put(wf.entry)
+	return nil
+}
@@ -0,0 +1,340 @@
+package casfs
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"testing"
+
+	"tractor.dev/wanix/fs"
+	"tractor.dev/wanix/fs/cowfs"
+	"tractor.dev/wanix/fs/memfs"
+)
+
+func newTestStore(t *testing.T) (*memfs.FS, *Store) {
+	t.Helper()
+	backing := memfs.New()
+	return backing, NewStore(backing)
+}
+
+func blobCount(t *testing.T, s *Store) int {
+	t.Helper()
+	digests, err := s.Digests()
+	if err != nil {
+		t.Fatalf("Digests failed: %v", err)
+	}
+	return len(digests)
+}
+
+func TestPutDedups(t *testing.T) {
+	_, s := newTestStore(t)
+	d1, err := s.PutBytes([]byte("hello"))
+	if err != nil {
+		t.Fatalf("PutBytes failed: %v", err)
+	}
+	d2, _ := s.PutBytes([]byte("hello"))
+	if d1 != d2 || d1 != Digest([]byte("hello")) {
+		t.Errorf("expected equal digests, got %s and %s", d1, d2)
+	}
+	if n := blobCount(t, s); n != 1 {
+		t.Errorf("expected 1 blob, got %d", n)
+	}
+	data, err := s.ReadBlob(d1)
+	if err != nil || string(data) != "hello" {
+		t.Errorf("expected hello, got %q %v", data, err)
+	}
+	if _, err := s.Open("sha256:nope"); !errors.Is(err, ErrInvalidDigest) {
+		t.Errorf("expected ErrInvalidDigest, got %v", err)
+	}
+}
+
+func TestSnapshotDedupsAcrossLayers(t *testing.T) {
+	_, s := newTestStore(t)
+	layer1 := memfs.New()
+	fs.MkdirAll(layer1, "bin", 0755)
+	fs.WriteFile(layer1, "bin/tool.wasm", []byte("toolchain"), 0755)
+	fs.WriteFile(layer1, "readme", []byte("one"), 0644)
+	layer2 := memfs.New()
+	fs.MkdirAll(layer2, "opt/bin", 0755)
+	fs.WriteFile(layer2, "opt/bin/tool.wasm", []byte("toolchain"), 0755)
+	fs.WriteFile(layer2, "readme", []byte("two"), 0644)
+
+	m1, err := s.Snapshot(layer1, ".")
+	if err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	m2, err := s.Snapshot(layer2, ".")
+	if err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	if n := blobCount(t, s); n != 3 {
+		t.Errorf("expected 3 blobs for 4 files, got %d", n)
+	}
+
+	e1, _ := m1.Get("bin/tool.wasm")
+	e2, _ := m2.Get("opt/bin/tool.wasm")
+	if e1.Digest != e2.Digest || e1.Size != 9 {
+		t.Errorf("expected shared digest, got %+v and %+v", e1, e2)
+	}
+}
+
+func TestReadWrite(t *testing.T) {
+	_, s := newTestStore(t)
+	fsys := New(s, nil)
+
+	if err := fs.MkdirAll(fsys, "a/b", 0755); err != nil {
+		t.Fatalf("MkdirAll failed: %v", err)
+	}
+	if err := fs.WriteFile(fsys, "a/b/file.txt", []byte("hello"), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	f, err := fs.OpenFile(fsys, "a/b/file.txt", os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("OpenFile failed: %v", err)
+	}
+	if _, err := fs.Write(f, []byte(" world")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	f.Close()
+
+	data, err := fs.ReadFile(fsys, "a/b/file.txt")
+	if err != nil || string(data) != "hello world" {
+		t.Fatalf("expected hello world, got %q %v", data, err)
+	}
+	entries, err := fs.ReadDir(fsys, "a/b")
+	if err != nil || len(entries) != 1 || entries[0].Name() != "file.txt" {
+		t.Fatalf("unexpected entries %v %v", entries, err)
+	}
+
+	if err := fs.Symlink(fsys, "b/file.txt", "a/link"); err != nil {
+		t.Fatalf("Symlink failed: %v", err)
+	}
+	if data, _ := fs.ReadFile(fsys, "a/link"); string(data) != "hello world" {
+		t.Errorf("expected symlink to resolve, got %q", data)
+	}
+
+	// symlinks in parent directories are followed too
+	if err := fs.Symlink(fsys, "a/b", "dirlink"); err != nil {
+		t.Fatalf("Symlink failed: %v", err)
+	}
+	if data, _ := fs.ReadFile(fsys, "dirlink/file.txt"); string(data) != "hello world" {
+		t.Errorf("expected intermediate symlink to resolve, got %q", data)
+	}
+	if err := fs.WriteFile(fsys, "dirlink/other.txt", []byte("other"), 0644); err != nil {
+		t.Fatalf("WriteFile through symlink failed: %v", err)
+	}
+	if _, err := fs.Lstat(fsys, "a/b/other.txt"); err != nil {
+		t.Errorf("expected file written through symlink to be in target: %v", err)
+	}
+	if err := fs.Remove(fsys, "dirlink/other.txt"); err != nil {
+		t.Errorf("Remove through symlink failed: %v", err)
+	}
+
+	if err := fs.Rename(fsys, "a/b", "a/c"); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+	if _, err := fs.Stat(fsys, "a/c/file.txt"); err != nil {
+		t.Errorf("expected renamed file: %v", err)
+	}
+	if err := fs.Remove(fsys, "a/c"); err == nil {
+		t.Error("expected error removing non-empty dir")
+	}
+	if err := fs.WriteFile(fsys, "missing/file", nil, 0644); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected ErrNotExist without parent, got %v", err)
+	}
+}
+
+func TestCommitAndLoad(t *testing.T) {
+	_, s := newTestStore(t)
+	fsys := New(s, nil)
+	fs.WriteFile(fsys, "file.txt", []byte("hello"), 0644)
+	digest, err := fsys.Commit()
+	if err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+
+	loaded, err := Load(s, digest)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if data, _ := fs.ReadFile(loaded, "file.txt"); string(data) != "hello" {
+		t.Errorf("expected hello, got %q", data)
+	}
+
+	fs.WriteFile(loaded, "other.txt", []byte("x"), 0644)
+	if diff := loaded.Manifest().Diff(fsys.Manifest()); len(diff) != 1 || diff[0] != "other.txt" {
+		t.Errorf("unexpected diff %v", diff)
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	_, src := newTestStore(t)
+	fsys := New(src, nil)
+	fs.MkdirAll(fsys, "dir", 0755)
+	fs.WriteFile(fsys, "dir/a", []byte("aaa"), 0644)
+	fs.WriteFile(fsys, "dir/b", []byte("aaa"), 0644)
+	digest, _ := fsys.Commit()
+
+	var buf bytes.Buffer
+	if err := src.Export(&buf, digest); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+
+	_, dst := newTestStore(t)
+	got, err := dst.Import(&buf)
+	if err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	if got != digest {
+		t.Errorf("expected %s, got %s", digest, got)
+	}
+	if n := blobCount(t, dst); n != 2 {
+		t.Errorf("expected manifest and 1 content blob, got %d", n)
+	}
+
+	out := memfs.New()
+	m, _ := dst.Manifest(got)
+	if err := dst.Extract(m, out, "."); err != nil {
+		t.Fatalf("Extract failed: %v", err)
+	}
+	if data, _ := fs.ReadFile(out, "dir/b"); string(data) != "aaa" {
+		t.Errorf("expected extracted file, got %q", data)
+	}
+}
+
+func TestImportMismatchKeepsBlobs(t *testing.T) {
+	_, s := newTestStore(t)
+	good, _ := s.PutBytes([]byte("good"))
+
+	// a blob claiming another digest with contents already in the store
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Name: blobPath(Digest([]byte("other"))), Mode: 0644, Size: 4})
+	tw.Write([]byte("good"))
+	tw.Close()
+
+	if _, err := s.Import(&buf); !errors.Is(err, ErrInvalidDigest) {
+		t.Fatalf("expected ErrInvalidDigest, got %v", err)
+	}
+	if !s.Has(good) {
+		t.Error("expected existing blob to be kept")
+	}
+	if n := blobCount(t, s); n != 1 {
+		t.Errorf("expected 1 blob, got %d", n)
+	}
+}
+
+func TestGC(t *testing.T) {
+	_, s := newTestStore(t)
+	fsys := New(s, nil)
+	fs.WriteFile(fsys, "keep", []byte("keep"), 0644)
+	fs.WriteFile(fsys, "drop", []byte("drop"), 0644)
+	fs.Remove(fsys, "drop")
+	digest, _ := fsys.Commit()
+
+	removed, err := s.GC(digest)
+	if err != nil {
+		t.Fatalf("GC failed: %v", err)
+	}
+	if removed != 1 || !s.Has(Digest([]byte("keep"))) || s.Has(Digest([]byte("drop"))) {
+		t.Errorf("expected only the unreferenced blob removed, removed %d", removed)
+	}
+}
+
+func TestPatch(t *testing.T) {
+	_, s := newTestStore(t)
+	fsys := New(s, nil)
+	fs.WriteFile(fsys, "old", []byte("old"), 0644)
+
+	local := memfs.New()
+	fs.MkdirAll(local, "dir", 0755)
+	fs.WriteFile(local, "dir/new", []byte("new"), 0644)
+	var buf bytes.Buffer
+	tw := newTarWriter(t, &buf, local, "dir", "dir/new")
+	tw.deleted("old")
+	tw.Close()
+
+	if err := fsys.Patch(context.Background(), ".", buf); err != nil {
+		t.Fatalf("Patch failed: %v", err)
+	}
+	if data, _ := fs.ReadFile(fsys, "dir/new"); string(data) != "new" {
+		t.Errorf("expected patched file, got %q", data)
+	}
+	if _, err := fs.Stat(fsys, "old"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected deleted file, got %v", err)
+	}
+}
+
+func TestPatchCanceled(t *testing.T) {
+	_, s := newTestStore(t)
+	fsys := New(s, nil)
+
+	local := memfs.New()
+	fs.WriteFile(local, "new", []byte("new"), 0644)
+	var buf bytes.Buffer
+	tw := newTarWriter(t, &buf, local, "new")
+	tw.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := fsys.Patch(ctx, ".", buf); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if _, err := fs.Stat(fsys, "new"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected nothing applied, got %v", err)
+	}
+}
+
+func TestCowfsBase(t *testing.T) {
+	_, s := newTestStore(t)
+	base := New(s, nil)
+	fs.WriteFile(base, "file.txt", []byte("base"), 0644)
+
+	overlay := New(s, nil)
+	cow := &cowfs.FS{Base: base, Overlay: overlay}
+	if data, err := fs.ReadFile(cow, "file.txt"); err != nil || string(data) != "base" {
+		t.Fatalf("expected base content, got %q %v", data, err)
+	}
+	f, err := fs.OpenFile(cow, "file.txt", os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("OpenFile failed: %v", err)
+	}
+	io.WriteString(f.(io.Writer), "base")
+	f.Close()
+
+	// the copied-up file has the same contents, so no new blob
+	if n := blobCount(t, s); n != 1 {
+		t.Errorf("expected overlay to share the base blob, got %d blobs", n)
+	}
+}
+
+type tarWriter struct {
+	*tar.Writer
+	t *testing.T
+}
+
+// newTarWriter writes the named files from fsys to w as syncfs would.
+func newTarWriter(t *testing.T, w io.Writer, fsys fs.FS, names ...string) *tarWriter {
+	tw := &tarWriter{Writer: tar.NewWriter(w), t: t}
+	for _, name := range names {
+		info, err := fs.Stat(fsys, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hdr, _ := tar.FileInfoHeader(info, "")
+		hdr.Name = name
+		tw.WriteHeader(hdr)
+		if info.Mode().IsRegular() {
+			data, _ := fs.ReadFile(fsys, name)
+			tw.Write(data)
+		}
+	}
+	return tw
+}
+
+func (tw *tarWriter) deleted(name string) {
+	tw.WriteHeader(&tar.Header{Name: name, PAXRecords: map[string]string{"delete": ""}})
+}
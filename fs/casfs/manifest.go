@@ -0,0 +1,277 @@
+package casfs
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"tractor.dev/wanix/fs"
+)
+
+// Entry describes a file, directory or symlink in a manifest. Regular
+// files refer to their contents by Digest.
+type Entry struct {
+	Path    string      `json:"path"`
+	Mode    fs.FileMode `json:"mode"`
+	Size    int64       `json:"size,omitempty"`
+	ModTime time.Time   `json:"modTime"`
+	Digest  string      `json:"digest,omitempty"`
+	Target  string      `json:"target,omitempty"` // symlink target
+}
+
+// Manifest is a tree of entries keyed by path. The root directory is
+// implied.
+type Manifest struct {
+	entries map[string]Entry
+}
+
+// NewManifest returns an empty manifest.
+func NewManifest() *Manifest {
+	return &Manifest{entries: make(map[string]Entry)}
+}
+
+// Get returns the entry at name.
+func (m *Manifest) Get(name string) (Entry, bool) {
+	name = cleanPath(name)
+	if name == "." {
+		return Entry{Path: ".", Mode: fs.ModeDir | 0755}, true
+	}
+	e, ok := m.entries[name]
+	return e, ok
+}
+
+// Put adds or replaces an entry.
+func (m *Manifest) Put(e Entry) {
+	e.Path = cleanPath(e.Path)
+	if e.Path == "." {
+		return
+	}
+	m.entries[e.Path] = e
+}
+
+// Remove removes name and everything below it.
+func (m *Manifest) Remove(name string) {
+	name = cleanPath(name)
+	for p := range m.entries {
+		if name == "." || p == name || strings.HasPrefix(p, name+"/") {
+			delete(m.entries, p)
+		}
+	}
+}
+
+// Children returns the entries directly in dir, sorted by name.
+func (m *Manifest) Children(dir string) []Entry {
+	dir = cleanPath(dir)
+	var children []Entry
+	for p, e := range m.entries {
+		if path.Dir(p) == dir {
+			children = append(children, e)
+		}
+	}
+	sort.Slice(children, func(i, j int) bool { return children[i].Path < children[j].Path })
+	return children
+}
+
+// Entries returns all entries sorted by path.
+func (m *Manifest) Entries() []Entry {
+	entries := make([]Entry, 0, len(m.entries))
+	for _, e := range m.entries {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+	return entries
+}
+
+// Blobs returns the distinct digests of file contents in the manifest.
+func (m *Manifest) Blobs() []string {
+	seen := make(map[string]bool)
+	var digests []string
+	for _, e := range m.Entries() {
+		if e.Digest != "" && !seen[e.Digest] {
+			seen[e.Digest] = true
+			digests = append(digests, e.Digest)
+		}
+	}
+	return digests
+}
+
+// Clone returns a copy of the manifest.
+func (m *Manifest) Clone() *Manifest {
+	c := NewManifest()
+	for p, e := range m.entries {
+		c.entries[p] = e
+	}
+	return c
+}
+
+// Diff returns the paths that were added, changed or removed going from
+// old to m, sorted.
+func (m *Manifest) Diff(old *Manifest) []string {
+	var paths []string
+	for p, e := range m.entries {
+		if o, ok := old.entries[p]; !ok || !o.equal(e) {
+			paths = append(paths, p)
+		}
+	}
+	for p := range old.entries {
+		if _, ok := m.entries[p]; !ok {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func (e Entry) equal(o Entry) bool {
+	return e.Path == o.Path && e.Mode == o.Mode && e.Size == o.Size &&
+		e.ModTime.Equal(o.ModTime) && e.Digest == o.Digest && e.Target == o.Target
+}
+
+// MarshalJSON encodes the manifest as a list of entries sorted by path,
+// so equal manifests have equal digests.
+func (m *Manifest) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.Entries())
+}
+
+// UnmarshalJSON decodes a list of entries.
+func (m *Manifest) UnmarshalJSON(data []byte) error {
+	var entries []Entry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return err
+	}
+	m.entries = make(map[string]Entry, len(entries))
+	for _, e := range entries {
+		if e.Digest != "" && !ValidDigest(e.Digest) {
+			return fmt.Errorf("%s: %w", e.Path, ErrInvalidDigest)
+		}
+		m.Put(e)
+	}
+	return nil
+}
+
+// PutManifest stores m as a blob, returning its digest.
+func (s *Store) PutManifest(m *Manifest) (string, error) {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return "", err
+	}
+	return s.PutBytes(data)
+}
+
+// Manifest reads the manifest with digest from the store.
+func (s *Store) Manifest(digest string) (*Manifest, error) {
+	data, err := s.ReadBlob(digest)
+	if err != nil {
+		return nil, err
+	}
+	m := NewManifest()
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("manifest %s: %w", digest, err)
+	}
+	return m, nil
+}
+
+// Snapshot stores the contents of the tree at root in fsys, returning its
+// manifest. Files already in the store are not stored again.
+func (s *Store) Snapshot(fsys fs.FS, root string) (*Manifest, error) {
+	m := NewManifest()
+	err := fs.WalkDir(fsys, root, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel := name
+		if root != "." {
+			rel = strings.TrimPrefix(strings.TrimPrefix(name, root), "/")
+		}
+		if rel == "" || rel == "." {
+			return nil
+		}
+		info, err := fs.Lstat(fsys, name)
+		if err != nil {
+			return err
+		}
+		e := Entry{Path: rel, Mode: info.Mode(), ModTime: info.ModTime()}
+		switch {
+		case info.Mode()&fs.ModeSymlink != 0:
+			if e.Target, err = fs.Readlink(fsys, name); err != nil {
+				return err
+			}
+		case info.Mode().IsRegular():
+			f, err := fsys.Open(name)
+			if err != nil {
+				return err
+			}
+			e.Digest, e.Size, err = s.Put(f)
+			f.Close()
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+		}
+		m.Put(e)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Extract writes the tree described by m into dir in dst.
+func (s *Store) Extract(m *Manifest, dst fs.FS, dir string) error {
+	var dirs []Entry
+	for _, e := range m.Entries() {
+		name := path.Join(dir, e.Path)
+		switch {
+		case e.Mode.IsDir():
+			if err := fs.MkdirAll(dst, name, e.Mode.Perm()); err != nil {
+				return err
+			}
+			// times are set once the directory is filled
+			dirs = append(dirs, e)
+		case e.Mode&fs.ModeSymlink != 0:
+			if err := fs.Symlink(dst, e.Target, name); err != nil {
+				return err
+			}
+		default:
+			if err := s.extractFile(e, dst, name); err != nil {
+				return err
+			}
+			if err := fs.Chtimes(dst, name, e.ModTime, e.ModTime); err != nil {
+				return err
+			}
+		}
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		name := path.Join(dir, dirs[i].Path)
+		if err := fs.Chtimes(dst, name, dirs[i].ModTime, dirs[i].ModTime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) extractFile(e Entry, dst fs.FS, name string) error {
+	data, err := s.ReadBlob(e.Digest)
+	if err != nil {
+		return err
+	}
+	if Digest(data) != e.Digest {
+		return fmt.Errorf("%s: %w", e.Path, ErrInvalidDigest)
+	}
+	if err := fs.WriteFile(dst, name, data, e.Mode.Perm()); err != nil {
+		return err
+	}
+	return fs.Chmod(dst, name, e.Mode.Perm())
+}
+
+// cleanPath ensures consistent path format for manifest keys.
+func cleanPath(p string) string {
+	p = strings.Trim(path.Clean("/"+p), "/")
+	if p == "" {
+		return "."
+	}
+	return p
+}
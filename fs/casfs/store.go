@@ -0,0 +1,288 @@
+// Package casfs stores file contents by their sha256 digest, so identical
+// files are kept once no matter how many trees include them. Trees are
+// described by manifests, which are stored as blobs themselves.
+package casfs
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"path"
+	"strings"
+
+	"tractor.dev/wanix/fs"
+)
+
+const (
+	blobDir = "blobs/sha256"
+	tmpDir  = "tmp"
+
+	// rootFile names the root manifest in an exported archive.
+	rootFile = "ROOT"
+)
+
+// ErrInvalidDigest is returned for malformed digests and for blobs whose
+// contents don't match their digest.
+var ErrInvalidDigest = errors.New("invalid digest")
+
+// Store keeps blobs in a filesystem under blobs/sha256/<hex>.
+type Store struct {
+	fsys fs.FS
+}
+
+// NewStore returns a blob store kept in fsys.
+func NewStore(fsys fs.FS) *Store {
+	return &Store{fsys: fsys}
+}
+
+// Put stores the contents of r, returning its digest and size. Contents
+// already in the store are not stored again.
+func (s *Store) Put(r io.Reader) (string, int64, error) {
+	return s.put(r, "")
+}
+
+// put stores the contents of r like Put. If want is set, contents with
+// another digest are discarded before they reach the blob directory and
+// ErrInvalidDigest is returned.
+func (s *Store) put(r io.Reader, want string) (string, int64, error) {
+	if err := fs.MkdirAll(s.fsys, tmpDir, 0755); err != nil {
+		return "", 0, err
+	}
+	tmp := path.Join(tmpDir, randomName())
+	f, err := fs.Create(s.fsys, tmp)
+	if err != nil {
+		return "", 0, err
+	}
+	w, ok := f.(io.Writer)
+	if !ok {
+		f.Close()
+		fs.Remove(s.fsys, tmp)
+		return "", 0, fs.ErrNotSupported
+	}
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(w, h), r)
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		fs.Remove(s.fsys, tmp)
+		return "", 0, err
+	}
+
+	digest := digestOf(h)
+	if want != "" && digest != want {
+		fs.Remove(s.fsys, tmp)
+		return "", 0, ErrInvalidDigest
+	}
+	name := blobPath(digest)
+	if ok, _ := fs.Exists(s.fsys, name); ok {
+		fs.Remove(s.fsys, tmp)
+		return digest, n, nil
+	}
+	if err := fs.MkdirAll(s.fsys, path.Dir(name), 0755); err != nil {
+		fs.Remove(s.fsys, tmp)
+		return "", 0, err
+	}
+	if err := fs.Rename(s.fsys, tmp, name); err != nil {
+		fs.Remove(s.fsys, tmp)
+		return "", 0, err
+	}
+	return digest, n, nil
+}
+
+// PutBytes stores data, returning its digest.
+func (s *Store) PutBytes(data []byte) (string, error) {
+	digest, _, err := s.Put(bytes.NewReader(data))
+	return digest, err
+}
+
+// Open opens the blob with digest.
+func (s *Store) Open(digest string) (fs.File, error) {
+	if !ValidDigest(digest) {
+		return nil, &fs.PathError{Op: "open", Path: digest, Err: ErrInvalidDigest}
+	}
+	return s.fsys.Open(blobPath(digest))
+}
+
+// ReadBlob returns the contents of the blob with digest.
+func (s *Store) ReadBlob(digest string) ([]byte, error) {
+	f, err := s.Open(digest)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+// Has reports whether the blob with digest is in the store.
+func (s *Store) Has(digest string) bool {
+	if !ValidDigest(digest) {
+		return false
+	}
+	ok, _ := fs.Exists(s.fsys, blobPath(digest))
+	return ok
+}
+
+// Digests returns the digests of all blobs in the store.
+func (s *Store) Digests() ([]string, error) {
+	entries, err := fs.ReadDir(s.fsys, blobDir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var digests []string
+	for _, e := range entries {
+		if d := "sha256:" + e.Name(); ValidDigest(d) {
+			digests = append(digests, d)
+		}
+	}
+	return digests, nil
+}
+
+// GC removes blobs not referenced by the manifests with the given
+// digests, returning how many were removed.
+func (s *Store) GC(keep ...string) (int, error) {
+	live := make(map[string]bool)
+	for _, digest := range keep {
+		m, err := s.Manifest(digest)
+		if err != nil {
+			return 0, err
+		}
+		live[digest] = true
+		for _, d := range m.Blobs() {
+			live[d] = true
+		}
+	}
+	digests, err := s.Digests()
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, d := range digests {
+		if live[d] {
+			continue
+		}
+		if err := fs.Remove(s.fsys, blobPath(d)); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// Export writes the manifest with digest and every blob it references to
+// w as a tar archive, for Import on another machine.
+func (s *Store) Export(w io.Writer, digest string) error {
+	m, err := s.Manifest(digest)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(w)
+	root := []byte(digest + "\n")
+	if err := tw.WriteHeader(&tar.Header{Name: rootFile, Mode: 0644, Size: int64(len(root))}); err != nil {
+		return err
+	}
+	if _, err := tw.Write(root); err != nil {
+		return err
+	}
+	for _, d := range append([]string{digest}, m.Blobs()...) {
+		if err := s.exportBlob(tw, d); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+func (s *Store) exportBlob(tw *tar.Writer, digest string) error {
+	f, err := s.Open(digest)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: blobPath(digest), Mode: 0644, Size: info.Size()}); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// Import reads an archive written by Export into the store, verifying
+// each blob against its digest, and returns the digest of its manifest.
+func (s *Store) Import(r io.Reader) (string, error) {
+	tr := tar.NewReader(r)
+	var root string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		switch {
+		case hdr.Name == rootFile:
+			data, err := io.ReadAll(tr)
+			if err != nil {
+				return "", err
+			}
+			root = strings.TrimSpace(string(data))
+		case strings.HasPrefix(hdr.Name, blobDir+"/"):
+			want := "sha256:" + path.Base(hdr.Name)
+			if s.Has(want) {
+				continue
+			}
+			if _, _, err := s.put(tr, want); err != nil {
+				return "", fmt.Errorf("import %s: %w", want, err)
+			}
+		}
+	}
+	if root == "" {
+		return "", fmt.Errorf("import: no %s in archive", rootFile)
+	}
+	if _, err := s.Manifest(root); err != nil {
+		return "", err
+	}
+	return root, nil
+}
+
+// ValidDigest reports whether digest has the form sha256:<hex>.
+func ValidDigest(digest string) bool {
+	hexsum, ok := strings.CutPrefix(digest, "sha256:")
+	if !ok || len(hexsum) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(hexsum)
+	return err == nil
+}
+
+// Digest returns the digest of data.
+func Digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func digestOf(h hash.Hash) string {
+	return "sha256:" + hex.EncodeToString(h.Sum(nil))
+}
+
+func blobPath(digest string) string {
+	return path.Join(blobDir, strings.TrimPrefix(digest, "sha256:"))
+}
+
+func randomName() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,809 @@
+// Package contentcache caches the contents of files from a remote
+// filesystem in a second, local filesystem such as OPFS, IndexedDB or disk.
+package contentcache
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"tractor.dev/wanix/fs"
+)
+
+const (
+	// DefaultBudget is the default number of bytes of file contents kept
+	// in the store before least recently used files are evicted.
+	DefaultBudget = 512 << 20
+
+	// indexDelay is how long index changes are batched before the index
+	// is written to the store.
+	indexDelay = time.Second
+
+	indexFile = "index.json"
+	blobDir   = "blobs"
+)
+
+// entry is a cached file. Entries are keyed by path and the version of
+// the file they hold, its ETag if the remote has them or else its mtime
+// and size.
+type entry struct {
+	Path    string      `json:"path"`
+	Version string      `json:"version"`
+	Blob    string      `json:"blob"`
+	Size    int64       `json:"size"`
+	Mode    fs.FileMode `json:"mode"`
+	ModTime time.Time   `json:"modTime"`
+	Used    time.Time   `json:"used"`
+
+	elem *list.Element
+}
+
+// FS wraps a remote filesystem and caches file contents read through it
+// in a store filesystem. Cached files are reused as long as the remote
+// reports the same version, and served from the store when the remote
+// can't be reached. Writes go to the remote and drop the cached copy.
+//
+// Files are fetched as they are read: the first open of a version returns
+// right away and copies what is read into the store, recording the file
+// once it has been read to the end.
+//
+// The index of cached files is kept in the store, so a cache created on
+// the same store later picks up where the last one left off. Changes to it
+// are batched and written shortly after; Flush writes them immediately.
+type FS struct {
+	*fs.DefaultFS
+	store fs.FS
+
+	mu       sync.Mutex
+	entries  map[string]*entry
+	lru      *list.List // front is most recently used
+	used     int64
+	budget   int64
+	fetching map[string]bool
+
+	// saveMu orders index writes. dirty is set when the index has
+	// changes not yet written, and saving while a write is scheduled.
+	saveMu sync.Mutex
+	dirty  bool
+	saving bool
+
+	// readers counts the open files of each blob. Blobs of removed
+	// entries are only deleted once their last reader closes.
+	readers map[string]int
+	doomed  map[string]bool
+
+	hits      atomic.Uint64
+	misses    atomic.Uint64
+	evictions atomic.Uint64
+	fallbacks atomic.Uint64
+
+	log *slog.Logger
+}
+
+// New wraps remote, caching file contents in store using the default
+// budget. It loads the index of files already cached in store.
+func New(remote, store fs.FS) (*FS, error) {
+	fsys := &FS{
+		DefaultFS: fs.NewDefault(remote),
+		store:     store,
+		entries:   make(map[string]*entry),
+		lru:       list.New(),
+		budget:    DefaultBudget,
+		fetching:  make(map[string]bool),
+		readers:   make(map[string]int),
+		doomed:    make(map[string]bool),
+		log:       slog.Default(),
+	}
+	if err := fsys.load(); err != nil {
+		return nil, err
+	}
+	return fsys, nil
+}
+
+// SetLogger sets the logger for cache operations.
+func (f *FS) SetLogger(log *slog.Logger) {
+	f.log = log
+}
+
+// SetBudget sets the number of bytes the cache may use, evicting least
+// recently used files over the new budget. Zero means unbounded.
+func (f *FS) SetBudget(n int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.budget = n
+	if f.evictLocked() {
+		f.changedLocked()
+	}
+}
+
+// GetBudget returns the number of bytes the cache may use.
+func (f *FS) GetBudget() int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.budget
+}
+
+// Stats is a snapshot of cache counters.
+type Stats struct {
+	Entries   int
+	Bytes     int64
+	Budget    int64
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	Fallbacks uint64 // reads served from the store while the remote failed
+}
+
+// Stats returns a snapshot of the cache counters.
+func (f *FS) Stats() Stats {
+	f.mu.Lock()
+	entries, used, budget := len(f.entries), f.used, f.budget
+	f.mu.Unlock()
+	return Stats{
+		Entries:   entries,
+		Bytes:     used,
+		Budget:    budget,
+		Hits:      f.hits.Load(),
+		Misses:    f.misses.Load(),
+		Evictions: f.evictions.Load(),
+		Fallbacks: f.fallbacks.Load(),
+	}
+}
+
+// Invalidate drops the cached contents of name, or of everything below it
+// if it is a directory.
+func (f *FS) Invalidate(name string) {
+	name = normalizePath(name)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	changed := false
+	for p, e := range f.entries {
+		if name == "." || p == name || strings.HasPrefix(p, name+"/") {
+			f.removeLocked(e)
+			changed = true
+		}
+	}
+	if changed {
+		f.changedLocked()
+	}
+}
+
+// ============================================================================
+// Reads
+// ============================================================================
+
+// Open opens the named file for reading.
+func (f *FS) Open(name string) (fs.File, error) {
+	return f.OpenContext(context.Background(), name)
+}
+
+// OpenContext opens the named file for reading, from the store if its
+// current version is cached there. Otherwise the remote file is returned,
+// copying what is read from it into the store.
+func (f *FS) OpenContext(ctx context.Context, name string) (fs.File, error) {
+	key := normalizePath(name)
+	info, err := f.DefaultFS.StatContext(ctx, name)
+	if err != nil {
+		if file, ok := f.openFallback(key, err); ok {
+			return file, nil
+		}
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return f.DefaultFS.OpenContext(ctx, name)
+	}
+
+	version := versionOf(info)
+	for {
+		f.mu.Lock()
+		if e, ok := f.entries[key]; ok && e.Version == version {
+			f.touchLocked(e)
+			f.mu.Unlock()
+			if file, err := f.openBlob(e, info); err == nil {
+				f.hits.Add(1)
+				return file, nil
+			}
+			// the blob is gone from the store, fetch it again
+			f.Invalidate(key)
+			continue
+		}
+		f.misses.Add(1)
+		if f.fetching[key] || (f.budget > 0 && info.Size() > f.budget) {
+			// already being fetched by another reader, or too big to cache
+			f.mu.Unlock()
+			return f.DefaultFS.OpenContext(ctx, name)
+		}
+		f.fetching[key] = true
+		f.mu.Unlock()
+
+		file, err := f.fetch(ctx, name, key, version, info)
+		if err != nil {
+			f.mu.Lock()
+			delete(f.fetching, key)
+			f.mu.Unlock()
+		}
+		return file, err
+	}
+}
+
+// StatContext returns the remote file info, or the cached file info if
+// the remote can't be reached.
+func (f *FS) StatContext(ctx context.Context, name string) (fs.FileInfo, error) {
+	info, err := f.DefaultFS.StatContext(ctx, name)
+	if err != nil && offline(err) {
+		f.mu.Lock()
+		e, ok := f.entries[normalizePath(name)]
+		f.mu.Unlock()
+		if ok {
+			f.fallbacks.Add(1)
+			return e.info(), nil
+		}
+	}
+	return info, err
+}
+
+// Stat returns the remote file info, or the cached file info if the remote
+// can't be reached.
+func (f *FS) Stat(name string) (fs.FileInfo, error) {
+	return f.StatContext(context.Background(), name)
+}
+
+// openFallback opens the cached copy of key when the remote failed with
+// err for a reason other than the file not existing.
+func (f *FS) openFallback(key string, err error) (fs.File, bool) {
+	f.mu.Lock()
+	e, ok := f.entries[key]
+	if ok && errors.Is(err, fs.ErrNotExist) {
+		// it's gone from the remote
+		f.removeLocked(e)
+		f.changedLocked()
+		ok = false
+	}
+	f.mu.Unlock()
+	if !ok || !offline(err) {
+		return nil, false
+	}
+	file, openErr := f.openBlob(e, e.info())
+	if openErr != nil {
+		return nil, false
+	}
+	f.fallbacks.Add(1)
+	f.log.Debug("contentcache.fallback", "name", key, "err", err)
+	return file, true
+}
+
+// fetch opens the remote file and a blob in the store to copy it into as
+// it is read. If the store can't take it, the remote file is returned
+// as is. Caller must have marked key as fetching.
+func (f *FS) fetch(ctx context.Context, name, key, version string, info fs.FileInfo) (fs.File, error) {
+	src, err := f.DefaultFS.OpenContext(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	ff := &fetchFile{File: src, fsys: f, info: info, e: &entry{
+		Path:    key,
+		Version: version,
+		Mode:    info.Mode(),
+		ModTime: info.ModTime(),
+	}}
+
+	err = fs.MkdirAll(f.store, blobDir, 0755)
+	if err == nil {
+		f.mu.Lock()
+		ff.e.Blob = f.blobPathLocked(key, version)
+		f.mu.Unlock()
+		ff.dst, err = fs.Create(f.store, ff.e.Blob)
+	}
+	if err == nil {
+		var ok bool
+		if ff.w, ok = ff.dst.(io.Writer); !ok {
+			err = fs.ErrNotSupported
+		}
+	}
+	if err != nil {
+		f.log.Debug("contentcache.fetch", "name", name, "err", err)
+		ff.abandon()
+	}
+	return ff, nil
+}
+
+// record adds e, fetched into its blob, to the index.
+func (f *FS) record(e *entry) {
+	e.Used = time.Now()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if old, ok := f.entries[e.Path]; ok {
+		f.removeLocked(old)
+	}
+	f.addLocked(e)
+	f.evictLocked()
+	f.changedLocked()
+}
+
+// openBlob opens the stored contents of e, reporting info as its stat.
+// The blob is kept in the store until the file is closed, even if e is
+// removed in the meantime.
+func (f *FS) openBlob(e *entry, info fs.FileInfo) (fs.File, error) {
+	f.mu.Lock()
+	f.readers[e.Blob]++
+	f.mu.Unlock()
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			f.releaseLocked(e.Blob)
+		})
+	}
+	file, err := f.store.Open(e.Blob)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	return &File{File: file, info: info, onClose: release}, nil
+}
+
+// ============================================================================
+// Writes (Invalidating)
+// ============================================================================
+
+// OpenFile opens a file with the specified flag and permissions. Opening
+// for writing drops the cached copy.
+func (f *FS) OpenFile(name string, flag int, perm fs.FileMode) (fs.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) == 0 {
+		return f.OpenContext(context.Background(), name)
+	}
+	file, err := f.DefaultFS.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	f.Invalidate(name)
+	return &File{File: file, onClose: func() { f.Invalidate(name) }}, nil
+}
+
+// Create creates a file on the remote and drops the cached copy.
+func (f *FS) Create(name string) (fs.File, error) {
+	file, err := f.DefaultFS.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	f.Invalidate(name)
+	return &File{File: file, onClose: func() { f.Invalidate(name) }}, nil
+}
+
+// WriteFile writes a file on the remote and drops the cached copy.
+func (f *FS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	err := f.DefaultFS.WriteFile(name, data, perm)
+	f.Invalidate(name)
+	return err
+}
+
+// Remove removes a file or directory on the remote and drops cached copies.
+func (f *FS) Remove(name string) error {
+	err := f.DefaultFS.Remove(name)
+	if err == nil {
+		f.Invalidate(name)
+	}
+	return err
+}
+
+// RemoveAll removes a path and its children on the remote and drops
+// cached copies.
+func (f *FS) RemoveAll(name string) error {
+	err := f.DefaultFS.RemoveAll(name)
+	if err == nil {
+		f.Invalidate(name)
+	}
+	return err
+}
+
+// Rename renames a file or directory on the remote and drops cached copies.
+func (f *FS) Rename(oldname, newname string) error {
+	err := f.DefaultFS.Rename(oldname, newname)
+	if err == nil {
+		f.Invalidate(oldname)
+		f.Invalidate(newname)
+	}
+	return err
+}
+
+// Truncate truncates a file on the remote and drops the cached copy.
+func (f *FS) Truncate(name string, size int64) error {
+	err := f.DefaultFS.Truncate(name, size)
+	if err == nil {
+		f.Invalidate(name)
+	}
+	return err
+}
+
+// ============================================================================
+// File Wrapper
+// ============================================================================
+
+// File wraps a file from the store, reporting the remote file info, or a
+// file from the remote opened for writing, dropping the cached copy when
+// it is closed.
+type File struct {
+	fs.File
+	info    fs.FileInfo
+	onClose func()
+}
+
+// Stat returns the remote file info.
+func (wf *File) Stat() (fs.FileInfo, error) {
+	if wf.info != nil {
+		return wf.info, nil
+	}
+	return wf.File.Stat()
+}
+
+// Write delegates to the underlying file.
+func (wf *File) Write(p []byte) (int, error) {
+	w, ok := wf.File.(io.Writer)
+	if !ok || wf.info != nil {
+		return 0, fs.ErrPermission
+	}
+	return w.Write(p)
+}
+
+// WriteAt delegates to the underlying file.
+func (wf *File) WriteAt(p []byte, off int64) (int, error) {
+	wa, ok := wf.File.(io.WriterAt)
+	if !ok || wf.info != nil {
+		return 0, fs.ErrPermission
+	}
+	return wa.WriteAt(p, off)
+}
+
+// ReadAt delegates to the underlying file.
+func (wf *File) ReadAt(p []byte, off int64) (int, error) {
+	ra, ok := wf.File.(io.ReaderAt)
+	if !ok {
+		return fs.ReadAt(wf.File, p, off)
+	}
+	return ra.ReadAt(p, off)
+}
+
+// Seek delegates to the underlying file.
+func (wf *File) Seek(offset int64, whence int) (int64, error) {
+	s, ok := wf.File.(io.Seeker)
+	if !ok {
+		return 0, fs.ErrNotSupported
+	}
+	return s.Seek(offset, whence)
+}
+
+// Close closes the underlying file.
+func (wf *File) Close() error {
+	err := wf.File.Close()
+	if wf.onClose != nil {
+		wf.onClose()
+	}
+	return err
+}
+
+// fetchFile is a remote file being read for the first time. What is read
+// sequentially from it is copied into a blob, and the blob is recorded
+// when the end of the file is reached. Seeking elsewhere or closing early
+// abandons the blob.
+type fetchFile struct {
+	fs.File
+	fsys *FS
+	info fs.FileInfo
+	e    *entry
+
+	mu   sync.Mutex
+	dst  fs.File // nil once recorded or abandoned
+	w    io.Writer
+	done bool // fetching mark released
+}
+
+// Stat returns the remote file info.
+func (ff *fetchFile) Stat() (fs.FileInfo, error) {
+	return ff.info, nil
+}
+
+// Read reads from the remote file, copying what it reads into the blob.
+func (ff *fetchFile) Read(p []byte) (int, error) {
+	ff.mu.Lock()
+	defer ff.mu.Unlock()
+	n, err := ff.File.Read(p)
+	if ff.dst != nil && n > 0 {
+		if _, werr := ff.w.Write(p[:n]); werr != nil {
+			ff.fsys.log.Debug("contentcache.fetch", "name", ff.e.Path, "err", werr)
+			ff.abandonLocked()
+		} else {
+			ff.e.Size += int64(n)
+		}
+	}
+	if err == io.EOF && ff.dst != nil {
+		ff.finishLocked()
+	}
+	return n, err
+}
+
+// ReadAt reads from the remote file without copying.
+func (ff *fetchFile) ReadAt(p []byte, off int64) (int, error) {
+	return fs.ReadAt(ff.File, p, off)
+}
+
+// Seek seeks the remote file, abandoning the blob unless the offset stays
+// where the copy is.
+func (ff *fetchFile) Seek(offset int64, whence int) (int64, error) {
+	s, ok := ff.File.(io.Seeker)
+	if !ok {
+		return 0, fs.ErrNotSupported
+	}
+	ff.mu.Lock()
+	defer ff.mu.Unlock()
+	pos, err := s.Seek(offset, whence)
+	if err != nil || pos != ff.e.Size {
+		ff.abandonLocked()
+	}
+	return pos, err
+}
+
+// Close closes the remote file, abandoning the blob if it wasn't read to
+// the end.
+func (ff *fetchFile) Close() error {
+	ff.mu.Lock()
+	defer ff.mu.Unlock()
+	ff.abandonLocked()
+	return ff.File.Close()
+}
+
+func (ff *fetchFile) abandon() {
+	ff.mu.Lock()
+	defer ff.mu.Unlock()
+	ff.abandonLocked()
+}
+
+// finishLocked closes the blob and records it. Caller must hold ff.mu.
+func (ff *fetchFile) finishLocked() {
+	dst := ff.dst
+	ff.dst = nil
+	if err := dst.Close(); err != nil {
+		ff.fsys.log.Debug("contentcache.fetch", "name", ff.e.Path, "err", err)
+		fs.Remove(ff.fsys.store, ff.e.Blob)
+	} else {
+		ff.fsys.record(ff.e)
+	}
+	ff.releaseLocked()
+}
+
+// abandonLocked drops the blob if it is still being copied into. Caller
+// must hold ff.mu.
+func (ff *fetchFile) abandonLocked() {
+	if ff.dst != nil {
+		ff.dst.Close()
+		ff.dst = nil
+		fs.Remove(ff.fsys.store, ff.e.Blob)
+	}
+	ff.releaseLocked()
+}
+
+// releaseLocked clears the fetching mark, once. Caller must hold ff.mu.
+func (ff *fetchFile) releaseLocked() {
+	if ff.done {
+		return
+	}
+	ff.done = true
+	ff.fsys.mu.Lock()
+	delete(ff.fsys.fetching, ff.e.Path)
+	ff.fsys.mu.Unlock()
+}
+
+// ============================================================================
+// Index
+// ============================================================================
+
+// load reads the index from the store, skipping entries whose blobs are
+// missing.
+func (f *FS) load() error {
+	data, err := fs.ReadFile(f.store, indexFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var entries []*entry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		// start over rather than fail on a corrupt index
+		f.log.Warn("contentcache: discarding index", "err", err)
+		return nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	// entries are saved most recently used first
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		if _, err := fs.Stat(f.store, e.Blob); err != nil {
+			continue
+		}
+		f.addLocked(e)
+	}
+	return nil
+}
+
+// changedLocked marks the index as changed, scheduling it to be written
+// after indexDelay if a write isn't already scheduled. Caller must hold mu.
+func (f *FS) changedLocked() {
+	f.dirty = true
+	if !f.saving {
+		f.saving = true
+		time.AfterFunc(indexDelay, f.save)
+	}
+}
+
+func (f *FS) save() {
+	if err := f.Flush(); err != nil {
+		f.log.Debug("contentcache.save", "err", err)
+	}
+}
+
+// Flush writes the index to the store if it has changes not yet written.
+func (f *FS) Flush() error {
+	f.saveMu.Lock()
+	defer f.saveMu.Unlock()
+
+	f.mu.Lock()
+	f.saving = false
+	if !f.dirty {
+		f.mu.Unlock()
+		return nil
+	}
+	f.dirty = false
+	entries := make([]*entry, 0, f.lru.Len())
+	for el := f.lru.Front(); el != nil; el = el.Next() {
+		entries = append(entries, el.Value.(*entry))
+	}
+	data, err := json.Marshal(entries)
+	f.mu.Unlock()
+
+	if err == nil {
+		err = fs.WriteFile(f.store, indexFile, data, 0644)
+	}
+	if err != nil {
+		// try again with the next change
+		f.mu.Lock()
+		f.dirty = true
+		f.mu.Unlock()
+	}
+	return err
+}
+
+// addLocked records e as most recently used. Caller must hold mu.
+func (f *FS) addLocked(e *entry) {
+	e.elem = f.lru.PushFront(e)
+	f.entries[e.Path] = e
+	f.used += e.Size
+}
+
+// removeLocked forgets e and deletes its blob, or marks it to be deleted
+// when its last reader closes. Caller must hold mu.
+func (f *FS) removeLocked(e *entry) {
+	f.lru.Remove(e.elem)
+	delete(f.entries, e.Path)
+	f.used -= e.Size
+	if f.readers[e.Blob] > 0 {
+		f.doomed[e.Blob] = true
+		return
+	}
+	f.deleteBlobLocked(e.Blob)
+}
+
+// releaseLocked drops a reader of blob, deleting it if it was the last
+// one and its entry has been removed. Caller must hold mu.
+func (f *FS) releaseLocked(blob string) {
+	f.readers[blob]--
+	if f.readers[blob] > 0 {
+		return
+	}
+	delete(f.readers, blob)
+	if f.doomed[blob] {
+		delete(f.doomed, blob)
+		f.deleteBlobLocked(blob)
+	}
+}
+
+func (f *FS) deleteBlobLocked(blob string) {
+	if err := fs.Remove(f.store, blob); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		f.log.Debug("contentcache.remove", "blob", blob, "err", err)
+	}
+}
+
+// blobPathLocked returns the store path for a version of key, picking
+// another name if a removed copy at the usual one still has readers, so
+// they aren't overwritten. Caller must hold mu.
+func (f *FS) blobPathLocked(key, version string) string {
+	name := path.Join(blobDir, blobName(key, version))
+	blob := name
+	for i := 1; f.readers[blob] > 0; i++ {
+		blob = fmt.Sprintf("%s-%d", name, i)
+	}
+	return blob
+}
+
+// touchLocked marks e as most recently used. Caller must hold mu.
+func (f *FS) touchLocked(e *entry) {
+	e.Used = time.Now()
+	f.lru.MoveToFront(e.elem)
+	f.changedLocked()
+}
+
+// evictLocked removes least recently used entries until the cache is
+// within budget, reporting whether any were removed. Caller must hold mu.
+func (f *FS) evictLocked() bool {
+	evicted := false
+	for f.budget > 0 && f.used > f.budget && f.lru.Len() > 0 {
+		e := f.lru.Back().Value.(*entry)
+		f.removeLocked(e)
+		f.evictions.Add(1)
+		evicted = true
+		f.log.Debug("contentcache.evicted", "path", e.Path, "size", e.Size)
+	}
+	return evicted
+}
+
+// ============================================================================
+// Helpers
+// ============================================================================
+
+// info returns the file info recorded for e.
+func (e *entry) info() fs.FileInfo {
+	return fileInfo{e}
+}
+
+type fileInfo struct{ e *entry }
+
+func (fi fileInfo) Name() string       { return path.Base(fi.e.Path) }
+func (fi fileInfo) Size() int64        { return fi.e.Size }
+func (fi fileInfo) Mode() fs.FileMode  { return fi.e.Mode }
+func (fi fileInfo) ModTime() time.Time { return fi.e.ModTime }
+func (fi fileInfo) IsDir() bool        { return false }
+func (fi fileInfo) Sys() any           { return nil }
+
+// versionOf identifies the version of a file by its ETag if the remote
+// reports one, or else by its mtime and size.
+func versionOf(info fs.FileInfo) string {
+	if e, ok := info.(interface{ ETag() string }); ok && e.ETag() != "" {
+		return e.ETag()
+	}
+	return fmt.Sprintf("%d-%d", info.ModTime().UnixNano(), info.Size())
+}
+
+// blobName returns the store file name for a version of a path.
+func blobName(key, version string) string {
+	sum := sha256.Sum256([]byte(key + "\x00" + version))
+	return hex.EncodeToString(sum[:])
+}
+
+// offline reports whether err looks like the remote failing rather than
+// answering, so cached copies should be used.
+func offline(err error) bool {
+	return !errors.Is(err, fs.ErrNotExist) &&
+		!errors.Is(err, fs.ErrPermission) &&
+		!errors.Is(err, fs.ErrInvalid)
+}
+
+// normalizePath ensures consistent path format for cache keys.
+func normalizePath(p string) string {
+	p = strings.Trim(path.Clean("/"+p), "/")
+	if p == "" {
+		return "."
+	}
+	return p
+}
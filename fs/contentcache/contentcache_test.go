@@ -0,0 +1,288 @@
+package contentcache
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"tractor.dev/wanix/fs"
+	"tractor.dev/wanix/fs/memfs"
+)
+
+var errOffline = errors.New("connection refused")
+
+// remoteFS wraps a memfs, counting opens and failing when offline.
+type remoteFS struct {
+	*memfs.FS
+	opens   atomic.Int32
+	offline atomic.Bool
+}
+
+func (r *remoteFS) OpenContext(ctx context.Context, name string) (fs.File, error) {
+	if r.offline.Load() {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: errOffline}
+	}
+	r.opens.Add(1)
+	return r.FS.OpenContext(ctx, name)
+}
+
+func (r *remoteFS) StatContext(ctx context.Context, name string) (fs.FileInfo, error) {
+	if r.offline.Load() {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: errOffline}
+	}
+	return r.FS.StatContext(ctx, name)
+}
+
+func newTestFS(t *testing.T) (*remoteFS, *memfs.FS, *FS) {
+	t.Helper()
+	remote := &remoteFS{FS: memfs.New()}
+	store := memfs.New()
+	cached, err := New(remote, store)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	return remote, store, cached
+}
+
+func readFile(t *testing.T, fsys fs.FS, name string) string {
+	t.Helper()
+	f, err := fsys.Open(name)
+	if err != nil {
+		t.Fatalf("open %s: %v", name, err)
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatalf("read %s: %v", name, err)
+	}
+	return string(data)
+}
+
+func TestReadThrough(t *testing.T) {
+	remote, _, cached := newTestFS(t)
+	fs.WriteFile(remote.FS, "file.txt", []byte("hello"), 0644)
+
+	if got := readFile(t, cached, "file.txt"); got != "hello" {
+		t.Fatalf("expected hello, got %q", got)
+	}
+	if got := readFile(t, cached, "file.txt"); got != "hello" {
+		t.Fatalf("expected hello, got %q", got)
+	}
+	if n := remote.opens.Load(); n != 1 {
+		t.Errorf("expected 1 remote open, got %d", n)
+	}
+
+	f, _ := cached.Open("file.txt")
+	info, _ := f.Stat()
+	f.Close()
+	if info.Name() != "file.txt" || info.Size() != 5 {
+		t.Errorf("expected remote file info, got %s %d", info.Name(), info.Size())
+	}
+
+	stats := cached.Stats()
+	if stats.Hits != 2 || stats.Misses != 1 || stats.Entries != 1 || stats.Bytes != 5 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestNewVersionRefetches(t *testing.T) {
+	remote, _, cached := newTestFS(t)
+	fs.WriteFile(remote.FS, "file.txt", []byte("one"), 0644)
+	readFile(t, cached, "file.txt")
+
+	fs.WriteFile(remote.FS, "file.txt", []byte("two!"), 0644)
+	fs.Chtimes(remote.FS, "file.txt", time.Now(), time.Now().Add(time.Second))
+
+	if got := readFile(t, cached, "file.txt"); got != "two!" {
+		t.Fatalf("expected new version, got %q", got)
+	}
+	if stats := cached.Stats(); stats.Entries != 1 || stats.Bytes != 4 {
+		t.Errorf("expected old version replaced, got %+v", stats)
+	}
+}
+
+func TestBudgetEvictsLRU(t *testing.T) {
+	remote, store, cached := newTestFS(t)
+	cached.SetBudget(10)
+	fs.WriteFile(remote.FS, "a", []byte("aaaa"), 0644)
+	fs.WriteFile(remote.FS, "b", []byte("bbbb"), 0644)
+	fs.WriteFile(remote.FS, "c", []byte("cccc"), 0644)
+	fs.WriteFile(remote.FS, "big", []byte("this is more than ten bytes"), 0644)
+
+	readFile(t, cached, "a")
+	readFile(t, cached, "b")
+	readFile(t, cached, "a") // a is now most recently used
+	readFile(t, cached, "c") // evicts b
+
+	stats := cached.Stats()
+	if stats.Entries != 2 || stats.Bytes != 8 || stats.Evictions != 1 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+	if _, ok := cached.entries["b"]; ok {
+		t.Error("expected b to be evicted")
+	}
+	blobs, _ := fs.ReadDir(store, blobDir)
+	if len(blobs) != 2 {
+		t.Errorf("expected evicted blob to be removed, got %d blobs", len(blobs))
+	}
+
+	// files over budget are read from the remote
+	if got := readFile(t, cached, "big"); got != "this is more than ten bytes" {
+		t.Errorf("unexpected content %q", got)
+	}
+	if cached.Stats().Entries != 2 {
+		t.Error("expected file over budget not to be cached")
+	}
+}
+
+func TestRemoveKeepsOpenBlobs(t *testing.T) {
+	remote, store, cached := newTestFS(t)
+	fs.WriteFile(remote.FS, "file.txt", []byte("hello"), 0644)
+	readFile(t, cached, "file.txt")
+
+	f, err := cached.Open("file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cached.Invalidate("file.txt")
+	if blobs, _ := fs.ReadDir(store, blobDir); len(blobs) != 1 {
+		t.Fatalf("expected open blob to be kept, got %d blobs", len(blobs))
+	}
+
+	// fetching it again doesn't overwrite the open blob
+	if got := readFile(t, cached, "file.txt"); got != "hello" {
+		t.Fatalf("expected hello, got %q", got)
+	}
+	if blobs, _ := fs.ReadDir(store, blobDir); len(blobs) != 2 {
+		t.Fatalf("expected refetch to use a new blob, got %d blobs", len(blobs))
+	}
+
+	data, err := io.ReadAll(f)
+	if err != nil || string(data) != "hello" {
+		t.Fatalf("read after invalidate: %q %v", data, err)
+	}
+	f.Close()
+	f.Close()
+	if blobs, _ := fs.ReadDir(store, blobDir); len(blobs) != 1 {
+		t.Fatalf("expected removed blob to be deleted on close, got %d blobs", len(blobs))
+	}
+	if got := readFile(t, cached, "file.txt"); got != "hello" {
+		t.Fatalf("expected hello, got %q", got)
+	}
+	if n := remote.opens.Load(); n != 2 {
+		t.Errorf("expected 2 remote opens, got %d", n)
+	}
+}
+
+func TestOfflineFallback(t *testing.T) {
+	remote, _, cached := newTestFS(t)
+	fs.WriteFile(remote.FS, "file.txt", []byte("hello"), 0644)
+	readFile(t, cached, "file.txt")
+
+	remote.offline.Store(true)
+	if got := readFile(t, cached, "file.txt"); got != "hello" {
+		t.Fatalf("expected cached content while offline, got %q", got)
+	}
+	info, err := cached.Stat("file.txt")
+	if err != nil || info.Size() != 5 {
+		t.Fatalf("expected cached info while offline, got %v %v", info, err)
+	}
+	if _, err := cached.Open("other.txt"); !errors.Is(err, errOffline) {
+		t.Errorf("expected remote error for uncached file, got %v", err)
+	}
+	if n := cached.Stats().Fallbacks; n != 2 {
+		t.Errorf("expected 2 fallbacks, got %d", n)
+	}
+
+	// a file removed on the remote is dropped, not served
+	remote.offline.Store(false)
+	fs.Remove(remote.FS, "file.txt")
+	if _, err := cached.Open("file.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected ErrNotExist, got %v", err)
+	}
+	if cached.Stats().Entries != 0 {
+		t.Error("expected removed file to be dropped")
+	}
+}
+
+func TestIndexPersists(t *testing.T) {
+	remote, store, cached := newTestFS(t)
+	fs.WriteFile(remote.FS, "file.txt", []byte("hello"), 0644)
+	readFile(t, cached, "file.txt")
+	if _, err := fs.Stat(store, indexFile); err == nil {
+		t.Error("expected index write to be batched")
+	}
+	if err := cached.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	reopened, err := New(remote, store)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if got := readFile(t, reopened, "file.txt"); got != "hello" {
+		t.Fatalf("expected hello, got %q", got)
+	}
+	if n := remote.opens.Load(); n != 1 {
+		t.Errorf("expected reopened cache to reuse the stored copy, got %d remote opens", n)
+	}
+}
+
+func TestFetchOnRead(t *testing.T) {
+	remote, store, cached := newTestFS(t)
+	fs.WriteFile(remote.FS, "file.txt", []byte("hello world"), 0644)
+
+	// a partial read is not cached
+	f, err := cached.Open("file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(f, buf); err != nil || string(buf) != "hello" {
+		t.Fatalf("read: %q %v", buf, err)
+	}
+	f.Close()
+	if stats := cached.Stats(); stats.Entries != 0 {
+		t.Errorf("expected partial read not to be cached, got %+v", stats)
+	}
+	if blobs, _ := fs.ReadDir(store, blobDir); len(blobs) != 0 {
+		t.Errorf("expected partial blob to be removed, got %d blobs", len(blobs))
+	}
+
+	// reading to the end is
+	if got := readFile(t, cached, "file.txt"); got != "hello world" {
+		t.Fatalf("expected hello world, got %q", got)
+	}
+	if got := readFile(t, cached, "file.txt"); got != "hello world" {
+		t.Fatalf("expected hello world, got %q", got)
+	}
+	if n := remote.opens.Load(); n != 2 {
+		t.Errorf("expected 2 remote opens, got %d", n)
+	}
+}
+
+func TestWriteInvalidates(t *testing.T) {
+	remote, _, cached := newTestFS(t)
+	fs.WriteFile(remote.FS, "file.txt", []byte("hello"), 0644)
+	readFile(t, cached, "file.txt")
+
+	if err := fs.WriteFile(cached, "file.txt", []byte("changed"), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	if cached.Stats().Entries != 0 {
+		t.Error("expected write to drop the cached copy")
+	}
+	if got := readFile(t, cached, "file.txt"); got != "changed" {
+		t.Errorf("expected changed, got %q", got)
+	}
+
+	if err := cached.Remove("file.txt"); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	if cached.Stats().Entries != 0 {
+		t.Error("expected remove to drop the cached copy")
+	}
+}
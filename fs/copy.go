@@ -33,6 +33,9 @@ func CopyFS(srcFS FS, srcPath string, dstFS FS, dstPath string) error {
 		return copyDir(srcFS, srcPath, dstFS, dstPath, mode)
 	case 0:
 		return copyFile(srcFS, srcPath, dstFS, dstPath, mode)
+	case os.ModeNamedPipe, os.ModeSocket:
+		// a special file has no contents, only the node is copied
+		return Mknod(dstFS, dstPath, mode, 0)
 	default:
 		return fmt.Errorf("cannot copy file with mode %v", mode)
 	}
@@ -62,6 +65,9 @@ func CopyNewFS(srcFS FS, srcPath string, dstFS FS, dstPath string) error {
 		return copyDirNewer(srcFS, srcPath, dstFS, dstPath, mode)
 	case 0:
 		return copyFile(srcFS, srcPath, dstFS, dstPath, mode)
+	case os.ModeNamedPipe, os.ModeSocket:
+		// a special file has no contents, only the node is copied
+		return Mknod(dstFS, dstPath, mode, 0)
 	default:
 		return fmt.Errorf("cannot copy file with mode %v", mode)
 	}
@@ -104,6 +110,13 @@ func copyDirNewer(srcFS FS, srcPath string, dstFS FS, dstPath string, mode FileM
 			if err := copySymlink(srcFS, srcEntry, dstFS, dstEntry); err != nil {
 				return err
 			}
+		} else if info.Mode()&(os.ModeNamedPipe|os.ModeSocket) != 0 {
+			if _, err := Lstat(dstFS, dstEntry); err == nil {
+				continue
+			}
+			if err := Mknod(dstFS, dstEntry, info.Mode(), 0); err != nil {
+				return err
+			}
 		} else {
 			// For files, only copy if src is newer
 			dstInfo, dstErr := Stat(dstFS, dstEntry)
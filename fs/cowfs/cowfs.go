@@ -696,6 +696,71 @@ func (u *FS) Symlink(oldname, newname string) error {
 	return nil
 }
 
+// Mknod creates a special file node such as a named pipe or socket.
+// The node is always created in the overlay layer at the exact path specified.
+//
+// Behavior:
+//   - If name exists in overlay: returns fs.ErrExist
+//   - If name exists in base and isn't tombstoned: returns fs.ErrExist
+//   - Parent directory must exist in at least one layer
+//   - Parent directory is scaffolded in overlay if only in base
+//   - Tombstone is cleared after successful creation
+func (u *FS) Mknod(name string, mode os.FileMode, dev int) error {
+	// 1. Use raw path for new node (don't follow renames for create-destination)
+	path := filepath.Clean(name)
+
+	// 2. Check and prepare parent directory
+	dir := filepath.Dir(path)
+	if dir != "." {
+		parentInOverlay := false
+		if _, err := fs.Stat(u.Overlay, dir); err == nil {
+			parentInOverlay = true
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+
+		parentInBase := false
+		if _, err := fs.Stat(u.Base, dir); err == nil {
+			parentInBase = true
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+
+		if !parentInOverlay && !parentInBase {
+			return fs.ErrNotExist
+		}
+		if !parentInOverlay && parentInBase {
+			if err := fs.MkdirAll(u.Overlay, dir, 0o755); err != nil {
+				return err
+			}
+		}
+	}
+
+	// 3. Fail if name exists in either layer
+	if _, err := fs.Lstat(u.Overlay, path); err == nil {
+		return fs.ErrExist
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if _, err := fs.Lstat(u.Base, path); err == nil {
+		if _, dead := u.tombstones.Load(path); !dead {
+			return fs.ErrExist
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	// 4. Create the node in overlay
+	if err := fs.Mknod(u.Overlay, path, mode, dev); err != nil {
+		return err
+	}
+
+	// 5. Clear tombstone after successful creation
+	u.tombstones.Delete(path)
+
+	return nil
+}
+
 // Mkdir creates a new directory with the specified name and permission bits.
 // The directory is always created in the overlay layer at the exact path specified.
 //
@@ -931,7 +996,8 @@ func (u *FS) OpenFile(name string, flag int, perm os.FileMode) (fs.File, error)
 	}
 
 	existsInBase := false
-	if _, err := fs.Lstat(u.Base, path); err == nil {
+	baseInfo, err := fs.Lstat(u.Base, path)
+	if err == nil {
 		existsInBase = true
 	} else if !errors.Is(err, fs.ErrNotExist) {
 		return nil, err
@@ -990,6 +1056,12 @@ func (u *FS) OpenFile(name string, flag int, perm os.FileMode) (fs.File, error)
 			}
 		}
 
+		// Named pipes and sockets have no contents to copy up, and a copy
+		// would be a different pipe than readers opened in base
+		if existsInBase && !existsInOverlay && baseInfo.Mode()&(os.ModeNamedPipe|os.ModeSocket) != 0 {
+			return fs.OpenFile(u.Base, path, flag, perm)
+		}
+
 		// Handle copy-up for write modes
 		// For O_TRUNC without O_CREATE on base-only file: will fail naturally (POSIX)
 		if existsInBase && !existsInOverlay {
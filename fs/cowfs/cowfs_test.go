@@ -1442,3 +1442,56 @@ func TestWhiteoutRenameChains(t *testing.T) {
 	// Verify we can access the file via the original name
 	assertFileContent(t, fsys2, "file1.txt", "content1")
 }
+
+func TestNamedPipe(t *testing.T) {
+	cfs := testFS(t)
+	if err := fs.Mknod(cfs.Base, "base.fifo", fs.ModeNamedPipe|0644, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// opening a base pipe for writing doesn't copy it up, so it meets
+	// readers that opened it before
+	opened := make(chan fs.File)
+	go func() {
+		r, err := cfs.Open("base.fifo")
+		if err != nil {
+			t.Error(err)
+		}
+		opened <- r
+	}()
+	w, err := cfs.OpenFile("base.fifo", os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := <-opened
+	if _, err := fs.Write(w, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	b, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+	if string(b) != "hello" {
+		t.Fatalf("unexpected data: %q", b)
+	}
+	if _, err := fs.Lstat(cfs.Overlay, "base.fifo"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("pipe was copied up: %v", err)
+	}
+
+	// new nodes go in the overlay
+	if err := fs.Mknod(cfs, "new.fifo", fs.ModeNamedPipe|0644, 0); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := fs.Lstat(cfs.Overlay, "new.fifo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Type() != fs.ModeNamedPipe {
+		t.Fatalf("unexpected mode: %v", fi.Mode())
+	}
+	if err := fs.Mknod(cfs, "base.fifo", fs.ModeNamedPipe|0644, 0); !errors.Is(err, fs.ErrExist) {
+		t.Fatalf("expected ErrExist, got %v", err)
+	}
+}
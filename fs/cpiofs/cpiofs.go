@@ -0,0 +1,517 @@
+// cpiofs implements a read-only filesystem view of a cpio archive, as used
+// for Linux initramfs images. The "newc" and "crc" formats as well as the
+// portable "odc" format are supported. Headers are indexed in one pass and
+// file contents are read from the archive on demand.
+//
+// Like the kernel, it reads archives concatenated one after another, with
+// zero padding between them, as initramfs images often are: later entries
+// replace earlier ones of the same name. An archive that follows in gzip
+// or zstd form, like a compressed initramfs after an early microcode one,
+// is decompressed and read too.
+package cpiofs
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"tractor.dev/wanix/fs/pstat"
+	"tractor.dev/wanix/fs/tarfs"
+)
+
+const trailer = "TRAILER!!!"
+
+var (
+	magicNewc = "070701"
+	magicCRC  = "070702"
+	magicODC  = "070707"
+)
+
+// IsCPIO reports whether b starts with a cpio header magic.
+func IsCPIO(b []byte) bool {
+	if len(b) < 6 {
+		return false
+	}
+	switch string(b[:6]) {
+	case magicNewc, magicCRC, magicODC:
+		return true
+	}
+	return false
+}
+
+type Reader struct {
+	files   map[string]map[string]*File
+	closers []io.Closer
+}
+
+func splitpath(name string) (dir, file string) {
+	name = path.Clean("/" + strings.TrimPrefix(name, "./"))
+	dir, file = path.Split(name)
+	dir = path.Clean(dir)
+	return
+}
+
+type linkKey struct {
+	dev uint64
+	ino uint64
+}
+
+// Index scans the cpio archives in r. r must remain valid for the
+// lifetime of the returned Reader.
+func Index(r io.ReaderAt, size int64) (*Reader, error) {
+	fsys := &Reader{files: make(map[string]map[string]*File)}
+	if err := fsys.index(r, size); err != nil {
+		fsys.Close()
+		return nil, err
+	}
+
+	// Add a pseudoroot
+	fsys.mkdirAll("/")
+	if _, ok := fsys.files["/"][""]; !ok {
+		fsys.files["/"][""] = fsys.implicitDir("/")
+	}
+	return fsys, nil
+}
+
+// Close releases any decoders used to read compressed archives.
+func (fsys *Reader) Close() error {
+	for _, c := range fsys.closers {
+		c.Close()
+	}
+	fsys.closers = nil
+	return nil
+}
+
+// index reads the archives in r one after another.
+func (fsys *Reader) index(r io.ReaderAt, size int64) error {
+	var off int64
+	for {
+		end, err := fsys.indexArchive(r, off, size)
+		if err != nil {
+			return err
+		}
+		off, err = skipPadding(r, end, size)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		magic := make([]byte, 6)
+		n, _ := r.ReadAt(magic, off)
+		if IsCPIO(magic[:n]) {
+			continue
+		}
+
+		// the rest is a compressed archive, or concatenated ones
+		rest := size - off
+		dr, err := tarfs.Decompress(io.NewSectionReader(r, off, rest), rest)
+		if err != nil {
+			return err
+		}
+		if dr == nil {
+			return fmt.Errorf("cpiofs: unexpected data after archive at offset %d", off)
+		}
+		fsys.closers = append(fsys.closers, dr)
+		return fsys.index(dr, dr.Size())
+	}
+}
+
+// indexArchive reads the archive at off, returning where its trailer
+// ends.
+func (fsys *Reader) indexArchive(r io.ReaderAt, off, size int64) (int64, error) {
+	links := make(map[linkKey][]*File)
+	for {
+		h, next, err := readHeader(r, off)
+		if err != nil {
+			return 0, err
+		}
+		if next > size {
+			return 0, fmt.Errorf("cpiofs: %s: %w", h.name, io.ErrUnexpectedEOF)
+		}
+		if h.name == trailer {
+			off = next
+			break
+		}
+
+		d, f := splitpath(h.name)
+		if f == "" {
+			if h.mode.IsDir() {
+				fsys.mkdirAll("/")
+				fsys.files["/"][""] = &File{name: "/", h: h, ra: r, fs: fsys}
+			}
+			off = next
+			continue
+		}
+		h.name = f
+		file := &File{name: path.Join(d, f), h: h, ra: r, fs: fsys}
+		if h.mode&fs.ModeSymlink != 0 {
+			target := make([]byte, h.size)
+			if _, err := r.ReadAt(target, h.offset); err != nil {
+				return 0, err
+			}
+			h.link = string(target)
+		}
+		if h.nlink > 1 && !h.mode.IsDir() {
+			k := linkKey{h.dev, h.ino}
+			links[k] = append(links[k], file)
+		}
+
+		fsys.mkdirAll(d)
+		fsys.files[d][f] = file
+		off = next
+	}
+
+	// hard linked files only carry data on one of the entries
+	for _, group := range links {
+		var data *header
+		for _, file := range group {
+			if file.h.size > 0 {
+				data = file.h
+			}
+		}
+		if data == nil {
+			continue
+		}
+		for _, file := range group {
+			file.h.size = data.size
+			file.h.offset = data.offset
+		}
+	}
+	return off, nil
+}
+
+// skipPadding returns the offset of the first non-zero byte at or after
+// off, or io.EOF if there's none.
+func skipPadding(r io.ReaderAt, off, size int64) (int64, error) {
+	buf := make([]byte, 512)
+	for off < size {
+		n, err := r.ReadAt(buf[:min(int64(len(buf)), size-off)], off)
+		if i := bytes.IndexFunc(buf[:n], func(r rune) bool { return r != 0 }); i >= 0 {
+			return off + int64(i), nil
+		}
+		off += int64(n)
+		if err != nil {
+			return off, err
+		}
+	}
+	return off, io.EOF
+}
+
+// readHeader reads the header at off and returns it along with the
+// offset of the next header.
+func readHeader(r io.ReaderAt, off int64) (*header, int64, error) {
+	magic := make([]byte, 6)
+	if _, err := r.ReadAt(magic, off); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, 0, fmt.Errorf("cpiofs: reading header: %w", err)
+	}
+	switch string(magic) {
+	case magicNewc, magicCRC:
+		return readNewc(r, off)
+	case magicODC:
+		return readODC(r, off)
+	}
+	return nil, 0, fmt.Errorf("cpiofs: bad magic %q at offset %d", magic, off)
+}
+
+func pad4(n int64) int64 {
+	return (n + 3) &^ 3
+}
+
+func readNewc(r io.ReaderAt, off int64) (*header, int64, error) {
+	const size = 110
+	buf := make([]byte, size)
+	if _, err := r.ReadAt(buf, off); err != nil {
+		return nil, 0, fmt.Errorf("cpiofs: reading header: %w", err)
+	}
+	var fields [13]uint64
+	for i := range fields {
+		v, err := strconv.ParseUint(string(buf[6+i*8:14+i*8]), 16, 32)
+		if err != nil {
+			return nil, 0, fmt.Errorf("cpiofs: malformed header at offset %d: %w", off, err)
+		}
+		fields[i] = v
+	}
+	h := &header{
+		ino:   fields[0],
+		mode:  unixMode(fields[1]),
+		uid:   int(fields[2]),
+		gid:   int(fields[3]),
+		nlink: fields[4],
+		mtime: time.Unix(int64(fields[5]), 0),
+		size:  int64(fields[6]),
+		dev:   fields[7]<<32 | fields[8],
+	}
+	namesize := int64(fields[11])
+	name, err := readName(r, off+size, namesize)
+	if err != nil {
+		return nil, 0, err
+	}
+	h.name = name
+	h.offset = pad4(off + size + namesize)
+	return h, pad4(h.offset + h.size), nil
+}
+
+func readODC(r io.ReaderAt, off int64) (*header, int64, error) {
+	const size = 76
+	buf := make([]byte, size)
+	if _, err := r.ReadAt(buf, off); err != nil {
+		return nil, 0, fmt.Errorf("cpiofs: reading header: %w", err)
+	}
+	field := func(start, width int) (uint64, error) {
+		return strconv.ParseUint(string(buf[start:start+width]), 8, 64)
+	}
+	var fields [10]uint64
+	widths := [10]int{6, 6, 6, 6, 6, 6, 6, 11, 6, 11}
+	start := 6
+	for i, w := range widths {
+		v, err := field(start, w)
+		if err != nil {
+			return nil, 0, fmt.Errorf("cpiofs: malformed header at offset %d: %w", off, err)
+		}
+		fields[i] = v
+		start += w
+	}
+	h := &header{
+		dev:   fields[0],
+		ino:   fields[1],
+		mode:  unixMode(fields[2]),
+		uid:   int(fields[3]),
+		gid:   int(fields[4]),
+		nlink: fields[5],
+		mtime: time.Unix(int64(fields[7]), 0),
+		size:  int64(fields[9]),
+	}
+	namesize := int64(fields[8])
+	name, err := readName(r, off+size, namesize)
+	if err != nil {
+		return nil, 0, err
+	}
+	h.name = name
+	h.offset = off + size + namesize
+	return h, h.offset + h.size, nil
+}
+
+func unixMode(m uint64) fs.FileMode {
+	mode := pstat.UnixModeToFileMode(uint32(m))
+	if m&0o4000 != 0 {
+		mode |= fs.ModeSetuid
+	}
+	if m&0o2000 != 0 {
+		mode |= fs.ModeSetgid
+	}
+	if m&0o1000 != 0 {
+		mode |= fs.ModeSticky
+	}
+	return mode
+}
+
+func readName(r io.ReaderAt, off, size int64) (string, error) {
+	if size <= 0 || size > 4096 {
+		return "", errors.New("cpiofs: bad name size")
+	}
+	buf := make([]byte, size)
+	if _, err := r.ReadAt(buf, off); err != nil {
+		return "", fmt.Errorf("cpiofs: reading name: %w", err)
+	}
+	return strings.TrimRight(string(buf), "\x00"), nil
+}
+
+func (fsys *Reader) mkdirAll(dir string) {
+	if _, ok := fsys.files[dir]; ok {
+		return
+	}
+	fsys.files[dir] = make(map[string]*File)
+	if dir == "/" {
+		return
+	}
+	d, f := splitpath(dir)
+	fsys.mkdirAll(d)
+	if _, ok := fsys.files[d][f]; !ok {
+		fsys.files[d][f] = fsys.implicitDir(dir)
+	}
+}
+
+func (fsys *Reader) implicitDir(name string) *File {
+	return &File{
+		name: name,
+		h:    &header{name: path.Base(name), mode: fs.ModeDir | 0555},
+		fs:   fsys,
+	}
+}
+
+func (fsys *Reader) lookup(op, name string) (*File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &os.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	d, f := splitpath(name)
+	if _, ok := fsys.files[d]; !ok {
+		return nil, &os.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	file, ok := fsys.files[d][f]
+	if !ok {
+		return nil, &os.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return file, nil
+}
+
+func (fsys *Reader) Open(name string) (fs.File, error) {
+	file, err := fsys.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	nf := &File{
+		name: file.name,
+		h:    file.h,
+		ra:   file.ra,
+		fs:   fsys,
+	}
+	if file.ra != nil && file.h.mode.IsRegular() {
+		nf.data = io.NewSectionReader(file.ra, file.h.offset, file.h.size)
+	}
+	return nf, nil
+}
+
+func (fsys *Reader) Stat(name string) (fs.FileInfo, error) {
+	file, err := fsys.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return file.h, nil
+}
+
+func (fsys *Reader) Readlink(name string) (string, error) {
+	file, err := fsys.lookup("readlink", name)
+	if err != nil {
+		return "", err
+	}
+	if file.h.mode&fs.ModeSymlink == 0 {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+	return file.h.link, nil
+}
+
+type File struct {
+	name   string
+	h      *header
+	ra     io.ReaderAt
+	data   *io.SectionReader
+	dirpos int
+	closed bool
+	fs     *Reader
+}
+
+func (f *File) Close() error {
+	if f.closed {
+		return fs.ErrClosed
+	}
+	f.closed = true
+	f.data = nil
+	return nil
+}
+
+func (f *File) Read(p []byte) (n int, err error) {
+	if f.closed {
+		return 0, fs.ErrClosed
+	}
+	if f.data == nil {
+		return 0, fs.ErrInvalid
+	}
+	return f.data.Read(p)
+}
+
+func (f *File) ReadAt(p []byte, off int64) (n int, err error) {
+	if f.closed {
+		return 0, fs.ErrClosed
+	}
+	if f.data == nil {
+		return 0, fs.ErrInvalid
+	}
+	return f.data.ReadAt(p, off)
+}
+
+func (f *File) Seek(offset int64, whence int) (int64, error) {
+	if f.closed {
+		return 0, fs.ErrClosed
+	}
+	if f.data == nil {
+		return 0, fs.ErrInvalid
+	}
+	return f.data.Seek(offset, whence)
+}
+
+func (f *File) ReadDir(count int) ([]fs.DirEntry, error) {
+	if f.closed {
+		return nil, fs.ErrClosed
+	}
+	if !f.h.IsDir() {
+		return nil, fs.ErrInvalid
+	}
+
+	d := f.fs.files[f.name]
+	var names []string
+	for n := range d {
+		if n != "" {
+			names = append(names, n)
+		}
+	}
+	sort.Strings(names)
+
+	names = names[min(f.dirpos, len(names)):]
+	if count > 0 {
+		if len(names) == 0 {
+			return nil, io.EOF
+		}
+		names = names[:min(count, len(names))]
+	}
+	f.dirpos += len(names)
+
+	var entries []fs.DirEntry
+	for _, n := range names {
+		entries = append(entries, fs.FileInfoToDirEntry(d[n].h))
+	}
+	return entries, nil
+}
+
+func (f *File) Stat() (fs.FileInfo, error) { return f.h, nil }
+
+// header is a parsed cpio entry and serves as its fs.FileInfo.
+type header struct {
+	name   string
+	mode   fs.FileMode
+	uid    int
+	gid    int
+	nlink  uint64
+	mtime  time.Time
+	size   int64
+	dev    uint64
+	ino    uint64
+	link   string
+	offset int64 // of the entry's data in the archive
+}
+
+func (h *header) Name() string       { return h.name }
+func (h *header) Mode() fs.FileMode  { return h.mode }
+func (h *header) ModTime() time.Time { return h.mtime }
+func (h *header) IsDir() bool        { return h.mode.IsDir() }
+func (h *header) Sys() any           { return nil }
+func (h *header) GetUID() int        { return h.uid }
+func (h *header) GetGID() int        { return h.gid }
+
+func (h *header) Size() int64 {
+	if !h.mode.IsRegular() {
+		return 0
+	}
+	return h.size
+}
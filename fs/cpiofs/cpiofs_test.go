@@ -0,0 +1,179 @@
+package cpiofs
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	"tractor.dev/wanix/fs/tarfs"
+)
+
+type entry struct {
+	name  string
+	mode  uint32
+	ino   int
+	nlink int
+	body  string
+}
+
+// newc writes entries in the newc format used by initramfs.
+func newc(entries []entry) []byte {
+	var buf bytes.Buffer
+	write := func(e entry) {
+		fmt.Fprintf(&buf, "070701%08X%08X%08X%08X%08X%08X%08X%08X%08X%08X%08X%08X%08X",
+			e.ino, e.mode, 1000, 1000, max(e.nlink, 1), 1700000000, len(e.body),
+			0, 0, 0, 0, len(e.name)+1, 0)
+		buf.WriteString(e.name + "\x00")
+		for buf.Len()%4 != 0 {
+			buf.WriteByte(0)
+		}
+		buf.WriteString(e.body)
+		for buf.Len()%4 != 0 {
+			buf.WriteByte(0)
+		}
+	}
+	for _, e := range entries {
+		write(e)
+	}
+	write(entry{name: trailer})
+	return buf.Bytes()
+}
+
+func TestCPIOFS(t *testing.T) {
+	data := newc([]entry{
+		{name: ".", mode: 0o40755, ino: 1},
+		{name: "bin", mode: 0o40755, ino: 2},
+		{name: "bin/busybox", mode: 0o100755, ino: 3, body: "\x7fELF..."},
+		{name: "bin/sh", mode: 0o120777, ino: 4, body: "busybox"},
+		{name: "etc/motd", mode: 0o100644, ino: 5, body: "welcome\n"},
+		{name: "init", mode: 0o100755, ino: 6, nlink: 2},
+		{name: "sbin-init", mode: 0o100755, ino: 6, nlink: 2, body: "#!/bin/sh\n"},
+	})
+	fsys, err := Index(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := fstest.TestFS(fsys, "bin/busybox", "etc/motd", "init"); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := fs.ReadFile(fsys, "etc/motd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "welcome\n" {
+		t.Fatalf("unexpected motd: %q", b)
+	}
+
+	b, err = fs.ReadFile(fsys, "init")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "#!/bin/sh\n" {
+		t.Fatalf("hard link not resolved: %q", b)
+	}
+
+	link, err := fsys.Readlink("bin/sh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if link != "busybox" {
+		t.Fatalf("unexpected link target: %q", link)
+	}
+
+	fi, err := fsys.Stat("bin/busybox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode() != 0o755 {
+		t.Fatalf("unexpected mode: %v", fi.Mode())
+	}
+	if p, ok := fi.(interface{ GetUID() int }); !ok || p.GetUID() != 1000 {
+		t.Fatal("expected uid 1000")
+	}
+}
+
+func TestTruncated(t *testing.T) {
+	data := newc([]entry{
+		{name: "file", mode: 0o100644, ino: 1, body: "some contents"},
+	})
+	data = data[:len(data)-120]
+	if _, err := Index(bytes.NewReader(data), int64(len(data))); err == nil {
+		t.Fatal("expected error")
+	} else if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestCompressed(t *testing.T) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(newc([]entry{
+		{name: "init", mode: 0o100755, ino: 1, body: "#!/bin/sh\n"},
+	}))
+	zw.Close()
+
+	// a .cpio.gz of unknown decompressed size, as archive binds see it
+	dr, err := tarfs.Decompress(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil || dr == nil {
+		t.Fatalf("decompress: %v", err)
+	}
+	defer dr.Close()
+	magic := make([]byte, 6)
+	if _, err := dr.ReadAt(magic, 0); err != nil || !IsCPIO(magic) {
+		t.Fatalf("expected cpio magic, got %q: %v", magic, err)
+	}
+	fsys, err := Index(dr, dr.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := fs.ReadFile(fsys, "init")
+	if err != nil || string(b) != "#!/bin/sh\n" {
+		t.Fatalf("read init: %q, %v", b, err)
+	}
+}
+
+func TestConcatenated(t *testing.T) {
+	// early microcode, padding, the main archive, then a compressed one
+	var buf bytes.Buffer
+	buf.Write(newc([]entry{
+		{name: "kernel/x86/microcode/GenuineIntel.bin", mode: 0o100644, ino: 1, body: "ucode"},
+		{name: "init", mode: 0o100755, ino: 2, body: "old"},
+	}))
+	buf.Write(make([]byte, 512-buf.Len()%512))
+	buf.Write(newc([]entry{
+		{name: "init", mode: 0o100755, ino: 1, body: "#!/bin/sh\n"},
+	}))
+	zw := gzip.NewWriter(&buf)
+	zw.Write(newc([]entry{
+		{name: "etc/motd", mode: 0o100644, ino: 1, body: "welcome\n"},
+	}))
+	zw.Close()
+
+	fsys, err := Index(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fsys.Close()
+	for name, want := range map[string]string{
+		"kernel/x86/microcode/GenuineIntel.bin": "ucode",
+		"init":                                  "#!/bin/sh\n",
+		"etc/motd":                              "welcome\n",
+	} {
+		b, err := fs.ReadFile(fsys, name)
+		if err != nil || string(b) != want {
+			t.Fatalf("%s: %q, %v", name, b, err)
+		}
+	}
+
+	junk := append(newc([]entry{{name: "init", mode: 0o100644, ino: 1}}), "junk"...)
+	if _, err := Index(bytes.NewReader(junk), int64(len(junk))); err == nil {
+		t.Fatal("expected error for data after the trailer")
+	}
+}
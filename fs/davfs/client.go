@@ -0,0 +1,733 @@
+package davfs
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"tractor.dev/wanix/fs"
+	"tractor.dev/wanix/fs/fskit"
+)
+
+// FS is a filesystem on a WebDAV server. Files opened for writing are
+// buffered and uploaded with PUT when they are closed.
+type FS struct {
+	base   *url.URL
+	client *http.Client
+	log    *slog.Logger
+}
+
+// New returns a filesystem for the WebDAV collection at baseURL. A nil
+// client uses http.DefaultClient.
+func New(baseURL string, client *http.Client) (*FS, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &FS{base: u, client: client, log: slog.Default()}, nil
+}
+
+// SetLogger sets the logger for requests.
+func (fsys *FS) SetLogger(log *slog.Logger) {
+	fsys.log = log
+}
+
+// url returns the URL of name, with a trailing slash for collections.
+func (fsys *FS) url(name string, dir bool) string {
+	name = cleanName(name)
+	u := *fsys.base
+	if name != "." {
+		u.Path += name
+		if dir {
+			u.Path += "/"
+		}
+	}
+	return u.String()
+}
+
+// do sends a request for name, returning the response if its status is
+// one of ok.
+func (fsys *FS) do(ctx context.Context, op, method, name string, body io.Reader, header http.Header, ok ...int) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, fsys.url(name, method == "MKCOL"), body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := fsys.client.Do(req)
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	for _, status := range ok {
+		if resp.StatusCode == status {
+			return resp, nil
+		}
+	}
+	resp.Body.Close()
+	fsys.log.Debug("davfs", "method", method, "name", name, "status", resp.Status)
+	return nil, &fs.PathError{Op: op, Path: name, Err: statusError(method, resp.StatusCode)}
+}
+
+// statusError maps a failed response status to an fs error.
+func statusError(method string, status int) error {
+	switch status {
+	case http.StatusNotFound:
+		return fs.ErrNotExist
+	case http.StatusConflict:
+		// a missing parent collection
+		return fs.ErrNotExist
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusLocked:
+		return fs.ErrPermission
+	case http.StatusMethodNotAllowed:
+		if method == "MKCOL" {
+			return fs.ErrExist
+		}
+		return fs.ErrNotSupported
+	case http.StatusPreconditionFailed:
+		return fs.ErrExist
+	}
+	return fmt.Errorf("unexpected status %d %s", status, http.StatusText(status))
+}
+
+// ============================================================================
+// PROPFIND
+// ============================================================================
+
+type multistatus struct {
+	Responses []response `xml:"DAV: response"`
+}
+
+type response struct {
+	Href      string     `xml:"DAV: href"`
+	Propstats []propstat `xml:"DAV: propstat"`
+}
+
+type propstat struct {
+	Prop   prop   `xml:"DAV: prop"`
+	Status string `xml:"DAV: status"`
+}
+
+type prop struct {
+	Props []rawProp `xml:",any"`
+}
+
+type rawProp struct {
+	XMLName  xml.Name
+	InnerXML []byte `xml:",innerxml"`
+}
+
+// resource is a PROPFIND result.
+type resource struct {
+	name  string
+	mode  fs.FileMode
+	size  int64
+	mtime time.Time
+	etag  string
+	props map[xml.Name]string
+}
+
+func (r *resource) info() *fileInfo {
+	return &fileInfo{
+		Node: fskit.Entry(path.Base(r.name), r.mode, r.size, r.mtime),
+		etag: r.etag,
+	}
+}
+
+// fileInfo is a resource's FileInfo, keeping its ETag.
+type fileInfo struct {
+	*fskit.Node
+	etag string
+}
+
+// ETag returns the server's ETag for the resource.
+func (i *fileInfo) ETag() string { return i.etag }
+
+const allprop = `<?xml version="1.0" encoding="utf-8"?><D:propfind xmlns:D="DAV:"><D:allprop/></D:propfind>`
+
+// propfind returns name, and its children if depth is 1.
+func (fsys *FS) propfind(ctx context.Context, op, name string, depth int) ([]*resource, error) {
+	header := http.Header{
+		"Depth":        {strconv.Itoa(depth)},
+		"Content-Type": {"application/xml; charset=utf-8"},
+	}
+	resp, err := fsys.do(ctx, op, "PROPFIND", name, strings.NewReader(allprop), header, http.StatusMultiStatus)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var ms multistatus
+	if err := xml.NewDecoder(resp.Body).Decode(&ms); err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+
+	var resources []*resource
+	for _, r := range ms.Responses {
+		res, err := fsys.parseResponse(r)
+		if err != nil {
+			fsys.log.Debug("davfs propfind", "href", r.Href, "err", err)
+			continue
+		}
+		resources = append(resources, res)
+	}
+	return resources, nil
+}
+
+func (fsys *FS) parseResponse(r response) (*resource, error) {
+	href, err := url.Parse(r.Href)
+	if err != nil {
+		return nil, err
+	}
+	rel, ok := strings.CutPrefix(href.Path, fsys.base.Path)
+	if !ok {
+		if href.Path+"/" != fsys.base.Path {
+			return nil, fmt.Errorf("outside of %s", fsys.base.Path)
+		}
+		// the collection itself, without its trailing slash
+		rel = "."
+	}
+	res := &resource{name: cleanName(rel), mode: 0644, props: make(map[xml.Name]string)}
+	var mode fs.FileMode
+	for _, ps := range r.Propstats {
+		if !strings.Contains(ps.Status, " 200") {
+			continue
+		}
+		for _, p := range ps.Prop.Props {
+			switch p.XMLName {
+			case xml.Name{Space: "DAV:", Local: "resourcetype"}:
+				if bytes.Contains(p.InnerXML, []byte("collection")) {
+					res.mode = fs.ModeDir | 0755
+				}
+			case xml.Name{Space: "DAV:", Local: "getcontentlength"}:
+				res.size, _ = strconv.ParseInt(strings.TrimSpace(innerText(p.InnerXML)), 10, 64)
+			case xml.Name{Space: "DAV:", Local: "getlastmodified"}:
+				res.mtime, _ = http.ParseTime(strings.TrimSpace(innerText(p.InnerXML)))
+			case xml.Name{Space: "DAV:", Local: "getetag"}:
+				res.etag = strings.TrimSpace(innerText(p.InnerXML))
+			case ModeProp:
+				mode, _ = parseMode(innerText(p.InnerXML))
+			default:
+				res.props[p.XMLName] = innerText(p.InnerXML)
+			}
+		}
+	}
+	if mode != 0 {
+		// the server's mode, keeping the type from resourcetype
+		res.mode = res.mode.Type() | mode.Perm()
+	}
+	return res, nil
+}
+
+// ============================================================================
+// Reads
+// ============================================================================
+
+func (fsys *FS) Open(name string) (fs.File, error) {
+	return fsys.OpenContext(context.Background(), name)
+}
+
+func (fsys *FS) OpenContext(ctx context.Context, name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	resources, err := fsys.propfind(ctx, "open", name, 1)
+	if err != nil {
+		return nil, err
+	}
+	self, children := split(name, resources)
+	if self == nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if self.mode.IsDir() {
+		var entries []fs.DirEntry
+		for _, c := range children {
+			entries = append(entries, c.info())
+		}
+		return fskit.DirFile(fskit.Entry(name, self.mode, self.mtime), entries...), nil
+	}
+	return &remoteFile{fsys: fsys, name: cleanName(name), info: self.info(), ctx: ctx}, nil
+}
+
+func (fsys *FS) Stat(name string) (fs.FileInfo, error) {
+	return fsys.StatContext(context.Background(), name)
+}
+
+func (fsys *FS) StatContext(ctx context.Context, name string) (fs.FileInfo, error) {
+	resources, err := fsys.propfind(ctx, "stat", name, 0)
+	if err != nil {
+		return nil, err
+	}
+	self, _ := split(name, resources)
+	if self == nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	return self.info(), nil
+}
+
+func (fsys *FS) ReadDirContext(ctx context.Context, name string) ([]fs.DirEntry, error) {
+	resources, err := fsys.propfind(ctx, "readdir", name, 1)
+	if err != nil {
+		return nil, err
+	}
+	self, children := split(name, resources)
+	if self == nil || !self.mode.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	var entries []fs.DirEntry
+	for _, c := range children {
+		entries = append(entries, c.info())
+	}
+	return entries, nil
+}
+
+// split separates name from its children in a PROPFIND result.
+func split(name string, resources []*resource) (self *resource, children []*resource) {
+	name = cleanName(name)
+	for _, r := range resources {
+		if r.name == name {
+			self = r
+		} else {
+			children = append(children, r)
+		}
+	}
+	return self, children
+}
+
+// ============================================================================
+// Writes
+// ============================================================================
+
+func (fsys *FS) Create(name string) (fs.File, error) {
+	return fsys.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+}
+
+func (fsys *FS) OpenFile(name string, flag int, perm fs.FileMode) (fs.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) == 0 {
+		return fsys.Open(name)
+	}
+	ctx := context.Background()
+	info, err := fsys.StatContext(ctx, name)
+	switch {
+	case err == nil && flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrExist}
+	case err == nil && info.IsDir():
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	case err != nil && !(flag&os.O_CREATE != 0 && os.IsNotExist(err)):
+		return nil, err
+	}
+
+	wf := &writeFile{fsys: fsys, name: cleanName(name), perm: perm.Perm(), dirty: flag&(os.O_CREATE|os.O_TRUNC) != 0}
+	if err == nil {
+		wf.perm = info.Mode().Perm()
+		if flag&os.O_TRUNC == 0 {
+			if wf.data, err = fsys.readAll(ctx, name); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if flag&os.O_APPEND != 0 {
+		wf.pos = int64(len(wf.data))
+	}
+	return wf, nil
+}
+
+func (fsys *FS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	return fsys.put(context.Background(), name, data, perm)
+}
+
+// put uploads data to name and sets its mode.
+func (fsys *FS) put(ctx context.Context, name string, data []byte, perm fs.FileMode) error {
+	resp, err := fsys.do(ctx, "write", "PUT", name, bytes.NewReader(data), nil,
+		http.StatusOK, http.StatusCreated, http.StatusNoContent)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	// servers without the mode property ignore it
+	fsys.proppatch(ctx, "chmod", name, false, map[xml.Name]string{ModeProp: formatMode(perm)})
+	return nil
+}
+
+func (fsys *FS) readAll(ctx context.Context, name string) ([]byte, error) {
+	resp, err := fsys.do(ctx, "read", "GET", name, nil, nil, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+func (fsys *FS) Mkdir(name string, perm fs.FileMode) error {
+	ctx := context.Background()
+	resp, err := fsys.do(ctx, "mkdir", "MKCOL", name, nil, nil, http.StatusCreated)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	fsys.proppatch(ctx, "chmod", name, false, map[xml.Name]string{ModeProp: formatMode(fs.ModeDir | perm.Perm())})
+	return nil
+}
+
+func (fsys *FS) Remove(name string) error {
+	ctx := context.Background()
+	entries, err := fsys.ReadDirContext(ctx, name)
+	if err == nil && len(entries) > 0 {
+		return &fs.PathError{Op: "remove", Path: name, Err: fmt.Errorf("directory not empty")}
+	}
+	resp, err := fsys.do(ctx, "remove", "DELETE", name, nil, nil, http.StatusOK, http.StatusNoContent)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (fsys *FS) RemoveAll(name string) error {
+	resp, err := fsys.do(context.Background(), "remove", "DELETE", name, nil, nil, http.StatusOK, http.StatusNoContent)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (fsys *FS) Rename(oldname, newname string) error {
+	header := http.Header{
+		"Destination": {fsys.url(newname, false)},
+		"Overwrite":   {"T"},
+	}
+	resp, err := fsys.do(context.Background(), "rename", "MOVE", oldname, nil, header, http.StatusCreated, http.StatusNoContent)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (fsys *FS) Chmod(name string, mode fs.FileMode) error {
+	ctx := context.Background()
+	info, err := fsys.StatContext(ctx, name)
+	if err != nil {
+		return err
+	}
+	return fsys.proppatch(ctx, "chmod", name, false, map[xml.Name]string{ModeProp: formatMode(info.Mode().Type() | mode.Perm())})
+}
+
+// ============================================================================
+// Extended attributes (dead properties)
+// ============================================================================
+
+func (fsys *FS) SetXattr(ctx context.Context, name string, attr string, data []byte, flags int) error {
+	return fsys.proppatch(ctx, "setxattr", name, false, map[xml.Name]string{{Space: XattrNamespace, Local: attr}: string(data)})
+}
+
+func (fsys *FS) GetXattr(ctx context.Context, name string, attr string) ([]byte, error) {
+	resources, err := fsys.propfind(ctx, "getxattr", name, 0)
+	if err != nil {
+		return nil, err
+	}
+	self, _ := split(name, resources)
+	if self != nil {
+		if v, ok := self.props[xml.Name{Space: XattrNamespace, Local: attr}]; ok {
+			return []byte(v), nil
+		}
+	}
+	return nil, &fs.PathError{Op: "getxattr", Path: name, Err: fs.ErrNotExist}
+}
+
+func (fsys *FS) ListXattrs(ctx context.Context, name string) ([]string, error) {
+	resources, err := fsys.propfind(ctx, "listxattr", name, 0)
+	if err != nil {
+		return nil, err
+	}
+	self, _ := split(name, resources)
+	if self == nil {
+		return nil, &fs.PathError{Op: "listxattr", Path: name, Err: fs.ErrNotExist}
+	}
+	var attrs []string
+	for n := range self.props {
+		if n.Space == XattrNamespace {
+			attrs = append(attrs, n.Local)
+		}
+	}
+	return attrs, nil
+}
+
+func (fsys *FS) RemoveXattr(ctx context.Context, name string, attr string) error {
+	return fsys.proppatch(ctx, "removexattr", name, true, map[xml.Name]string{{Space: XattrNamespace, Local: attr}: ""})
+}
+
+// proppatch sets or removes properties on name.
+func (fsys *FS) proppatch(ctx context.Context, op, name string, remove bool, props map[xml.Name]string) error {
+	var body bytes.Buffer
+	action := "set"
+	if remove {
+		action = "remove"
+	}
+	fmt.Fprintf(&body, `<?xml version="1.0" encoding="utf-8"?><D:propertyupdate xmlns:D="DAV:"><D:%s><D:prop>`, action)
+	for n, v := range props {
+		fmt.Fprintf(&body, `<%s xmlns="%s">`, n.Local, n.Space)
+		xml.EscapeText(&body, []byte(v))
+		fmt.Fprintf(&body, `</%s>`, n.Local)
+	}
+	fmt.Fprintf(&body, `</D:prop></D:%s></D:propertyupdate>`, action)
+
+	header := http.Header{"Content-Type": {"application/xml; charset=utf-8"}}
+	resp, err := fsys.do(ctx, op, "PROPPATCH", name, &body, header, http.StatusMultiStatus)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var ms multistatus
+	if err := xml.NewDecoder(resp.Body).Decode(&ms); err != nil {
+		return &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	for _, r := range ms.Responses {
+		for _, ps := range r.Propstats {
+			if !strings.Contains(ps.Status, " 200") {
+				return &fs.PathError{Op: op, Path: name, Err: fs.ErrPermission}
+			}
+		}
+	}
+	return nil
+}
+
+// ============================================================================
+// Files
+// ============================================================================
+
+// remoteFile reads a file with Range requests.
+type remoteFile struct {
+	fsys *FS
+	name string
+	info fs.FileInfo
+	ctx  context.Context
+
+	mu     sync.Mutex
+	body   io.ReadCloser
+	offset int64
+}
+
+func (f *remoteFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+
+func (f *remoteFile) Read(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.body == nil {
+		if f.offset >= f.info.Size() && f.info.Size() > 0 {
+			return 0, io.EOF
+		}
+		body, err := f.get(f.offset, -1)
+		if err != nil {
+			return 0, err
+		}
+		f.body = body
+	}
+	n, err := f.body.Read(p)
+	f.offset += int64(n)
+	return n, err
+}
+
+func (f *remoteFile) ReadAt(p []byte, off int64) (int, error) {
+	if off >= f.info.Size() {
+		return 0, io.EOF
+	}
+	body, err := f.get(off, int64(len(p)))
+	if err != nil {
+		return 0, err
+	}
+	defer body.Close()
+	n, err := io.ReadFull(body, p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (f *remoteFile) Seek(offset int64, whence int) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.info.Size()
+	default:
+		return 0, fs.ErrInvalid
+	}
+	if offset < 0 {
+		return 0, fs.ErrInvalid
+	}
+	if offset != f.offset && f.body != nil {
+		f.body.Close()
+		f.body = nil
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *remoteFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.body != nil {
+		f.body.Close()
+		f.body = nil
+	}
+	return nil
+}
+
+// get requests length bytes from off, or the rest if length is negative.
+func (f *remoteFile) get(off, length int64) (io.ReadCloser, error) {
+	header := http.Header{}
+	if off > 0 || length >= 0 {
+		end := ""
+		if length >= 0 {
+			end = strconv.FormatInt(off+length-1, 10)
+		}
+		header.Set("Range", fmt.Sprintf("bytes=%d-%s", off, end))
+	}
+	resp, err := f.fsys.do(f.ctx, "read", "GET", f.name, nil, header, http.StatusOK, http.StatusPartialContent)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusOK && off > 0 {
+		// the server ignored the range
+		if _, err := io.CopyN(io.Discard, resp.Body, off); err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+	}
+	if length >= 0 {
+		return readCloser{io.LimitReader(resp.Body, length), resp.Body}, nil
+	}
+	return resp.Body, nil
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// writeFile buffers a file opened for writing and uploads it on Close.
+type writeFile struct {
+	fsys   *FS
+	name   string
+	perm   fs.FileMode
+	data   []byte
+	pos    int64
+	dirty  bool
+	closed bool
+	mu     sync.Mutex
+}
+
+func (wf *writeFile) Stat() (fs.FileInfo, error) {
+	wf.mu.Lock()
+	defer wf.mu.Unlock()
+	return fskit.Entry(path.Base(wf.name), wf.perm, int64(len(wf.data)), time.Now()), nil
+}
+
+func (wf *writeFile) Read(p []byte) (int, error) {
+	wf.mu.Lock()
+	defer wf.mu.Unlock()
+	if wf.pos >= int64(len(wf.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, wf.data[wf.pos:])
+	wf.pos += int64(n)
+	return n, nil
+}
+
+func (wf *writeFile) Write(p []byte) (int, error) {
+	wf.mu.Lock()
+	defer wf.mu.Unlock()
+	n, err := wf.writeAt(p, wf.pos)
+	wf.pos += int64(n)
+	return n, err
+}
+
+func (wf *writeFile) WriteAt(p []byte, off int64) (int, error) {
+	wf.mu.Lock()
+	defer wf.mu.Unlock()
+	return wf.writeAt(p, off)
+}
+
+func (wf *writeFile) writeAt(p []byte, off int64) (int, error) {
+	if wf.closed {
+		return 0, fs.ErrClosed
+	}
+	if end := off + int64(len(p)); end > int64(len(wf.data)) {
+		wf.data = append(wf.data, make([]byte, end-int64(len(wf.data)))...)
+	}
+	copy(wf.data[off:], p)
+	wf.dirty = true
+	return len(p), nil
+}
+
+func (wf *writeFile) Seek(offset int64, whence int) (int64, error) {
+	wf.mu.Lock()
+	defer wf.mu.Unlock()
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += wf.pos
+	case io.SeekEnd:
+		offset += int64(len(wf.data))
+	default:
+		return 0, fs.ErrInvalid
+	}
+	if offset < 0 {
+		return 0, fs.ErrInvalid
+	}
+	wf.pos = offset
+	return offset, nil
+}
+
+// Sync uploads the file as written so far.
+func (wf *writeFile) Sync() error {
+	wf.mu.Lock()
+	defer wf.mu.Unlock()
+	return wf.flush()
+}
+
+func (wf *writeFile) Close() error {
+	wf.mu.Lock()
+	defer wf.mu.Unlock()
+	if wf.closed {
+		return fs.ErrClosed
+	}
+	wf.closed = true
+	return wf.flush()
+}
+
+// flush uploads the contents if they changed. Caller must hold mu.
+func (wf *writeFile) flush() error {
+	if !wf.dirty {
+		return nil
+	}
+	if err := wf.fsys.put(context.Background(), wf.name, wf.data, wf.perm); err != nil {
+		return err
+	}
+	wf.dirty = false
+	return nil
+}
@@ -0,0 +1,279 @@
+package davfs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"tractor.dev/wanix/fs"
+	"tractor.dev/wanix/fs/memfs"
+)
+
+// xattrFS adds in-memory extended attributes to a memfs.
+type xattrFS struct {
+	*memfs.FS
+	mu     sync.Mutex
+	xattrs map[string]map[string][]byte
+}
+
+func (x *xattrFS) SetXattr(ctx context.Context, name, attr string, data []byte, flags int) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.xattrs[name] == nil {
+		x.xattrs[name] = make(map[string][]byte)
+	}
+	x.xattrs[name][attr] = data
+	return nil
+}
+
+func (x *xattrFS) GetXattr(ctx context.Context, name, attr string) ([]byte, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	data, ok := x.xattrs[name][attr]
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+	return data, nil
+}
+
+func (x *xattrFS) ListXattrs(ctx context.Context, name string) ([]string, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	var attrs []string
+	for attr := range x.xattrs[name] {
+		attrs = append(attrs, attr)
+	}
+	return attrs, nil
+}
+
+func (x *xattrFS) RemoveXattr(ctx context.Context, name, attr string) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	delete(x.xattrs[name], attr)
+	return nil
+}
+
+func newTestFS(t *testing.T) (*xattrFS, *FS) {
+	t.Helper()
+	server := &xattrFS{FS: memfs.New(), xattrs: make(map[string]map[string][]byte)}
+	ts := httptest.NewServer(NewHandler(server, "/dav"))
+	t.Cleanup(ts.Close)
+	client, err := New(ts.URL+"/dav", ts.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server, client
+}
+
+func TestReadWrite(t *testing.T) {
+	server, client := newTestFS(t)
+
+	if err := fs.WriteFile(client, "hello.txt", []byte("hello world"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	data, err := fs.ReadFile(server, "hello.txt")
+	if err != nil || string(data) != "hello world" {
+		t.Fatalf("server has %q, %v", data, err)
+	}
+
+	data, err = fs.ReadFile(client, "hello.txt")
+	if err != nil || string(data) != "hello world" {
+		t.Fatalf("client read %q, %v", data, err)
+	}
+
+	info, err := fs.Stat(client, "hello.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != 11 || info.IsDir() {
+		t.Fatalf("stat = size %d dir %v", info.Size(), info.IsDir())
+	}
+
+	f, err := client.Open("hello.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	buf := make([]byte, 5)
+	if _, err := f.(interface {
+		ReadAt([]byte, int64) (int, error)
+	}).ReadAt(buf, 6); err != nil || string(buf) != "world" {
+		t.Fatalf("ReadAt = %q, %v", buf, err)
+	}
+
+	if _, err := fs.Stat(client, "missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("stat missing = %v, want not exist", err)
+	}
+}
+
+func TestAppend(t *testing.T) {
+	server, client := newTestFS(t)
+	if err := fs.WriteFile(server, "log", []byte("one\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := client.OpenFile("log", os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Write(f, []byte("two\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := fs.ReadFile(server, "log")
+	if string(data) != "one\ntwo\n" {
+		t.Fatalf("got %q", data)
+	}
+}
+
+func TestDirectories(t *testing.T) {
+	server, client := newTestFS(t)
+
+	if err := client.Mkdir("dir", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Mkdir("dir", 0755); !errors.Is(err, fs.ErrExist) {
+		t.Fatalf("second mkdir = %v, want exist", err)
+	}
+	for _, name := range []string{"dir/a", "dir/b"} {
+		if err := fs.WriteFile(client, name, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := fs.ReadDir(client, "dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	sort.Strings(names)
+	if len(names) != 2 || names[0] != "a" || names[1] != "b" {
+		t.Fatalf("readdir = %v", names)
+	}
+
+	if err := client.Rename("dir/a", "dir/c"); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := fs.ReadFile(server, "dir/c"); err != nil || string(data) != "dir/a" {
+		t.Fatalf("renamed file = %q, %v", data, err)
+	}
+
+	if err := client.Remove("dir"); err == nil {
+		t.Fatal("removed non-empty directory")
+	}
+	if err := client.RemoveAll("dir"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Stat(server, "dir"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("dir still exists: %v", err)
+	}
+}
+
+func TestMode(t *testing.T) {
+	server, client := newTestFS(t)
+	if err := fs.WriteFile(client, "run.sh", []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	info, err := fs.Stat(server, "run.sh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0755 {
+		t.Fatalf("server mode = %v, want 0755", info.Mode())
+	}
+
+	if err := client.Chmod("run.sh", 0700); err != nil {
+		t.Fatal(err)
+	}
+	info, err = fs.Stat(client, "run.sh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode() != 0700 {
+		t.Fatalf("client mode = %v, want 0700", info.Mode())
+	}
+}
+
+func TestXattrs(t *testing.T) {
+	server, client := newTestFS(t)
+	ctx := context.Background()
+	if err := fs.WriteFile(server, "file", []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.SetXattr(ctx, "file", "user.comment", []byte("a & b"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := server.GetXattr(ctx, "file", "user.comment"); string(data) != "a & b" {
+		t.Fatalf("server xattr = %q", data)
+	}
+
+	data, err := client.GetXattr(ctx, "file", "user.comment")
+	if err != nil || string(data) != "a & b" {
+		t.Fatalf("client xattr = %q, %v", data, err)
+	}
+	attrs, err := client.ListXattrs(ctx, "file")
+	if err != nil || len(attrs) != 1 || attrs[0] != "user.comment" {
+		t.Fatalf("list = %v, %v", attrs, err)
+	}
+
+	if err := client.RemoveXattr(ctx, "file", "user.comment"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.GetXattr(ctx, "file", "user.comment"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("removed xattr = %v, want not exist", err)
+	}
+}
+
+func TestCollectionWithoutSlash(t *testing.T) {
+	_, client := newTestFS(t)
+	res, err := client.parseResponse(response{Href: "/dav"})
+	if err != nil || res.name != "." {
+		t.Fatalf("parseResponse = %v, %v, want .", res, err)
+	}
+}
+
+func TestProppatchAllOrNothing(t *testing.T) {
+	server := &xattrFS{FS: memfs.New(), xattrs: make(map[string]map[string][]byte)}
+	ts := httptest.NewServer(NewHandler(server, "/dav"))
+	defer ts.Close()
+	if err := fs.WriteFile(server, "file", []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	server.SetXattr(context.Background(), "file", "user.kept", []byte("old"), 0)
+
+	body := `<?xml version="1.0" encoding="utf-8"?>
+<D:propertyupdate xmlns:D="DAV:" xmlns:X="urn:wanix:xattr:" xmlns:O="urn:other:">
+<D:set><D:prop><X:user.kept>new</X:user.kept><X:user.added>a</X:user.added></D:prop></D:set>
+<D:set><D:prop><O:unsupported>b</O:unsupported></D:prop></D:set>
+</D:propertyupdate>`
+	req, _ := http.NewRequest("PROPPATCH", ts.URL+"/dav/file", strings.NewReader(body))
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(data), "424") || !strings.Contains(string(data), "403") {
+		t.Errorf("expected 403 and 424 propstats, got %s", data)
+	}
+
+	ctx := context.Background()
+	if data, _ := server.GetXattr(ctx, "file", "user.kept"); string(data) != "old" {
+		t.Errorf("expected user.kept to be restored, got %q", data)
+	}
+	if _, err := server.GetXattr(ctx, "file", "user.added"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected user.added to be undone, got %v", err)
+	}
+}
@@ -0,0 +1,314 @@
+// Package davfs serves filesystems over WebDAV and mounts WebDAV servers
+// as filesystems, so standard tools like rclone, file managers and editors
+// can reach the namespace.
+//
+// Besides the standard live properties, file modes are exposed as the
+// content-mode property, a Unix mode in decimal like the httpfs
+// Content-Mode header, and extended attributes as dead properties in the
+// xattr namespace.
+package davfs
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/webdav"
+	"tractor.dev/wanix/fs"
+	"tractor.dev/wanix/fs/pstat"
+)
+
+const (
+	// Namespace is the XML namespace of wanix properties.
+	Namespace = "urn:wanix:"
+
+	// XattrNamespace is the XML namespace of properties mapped to extended
+	// attributes, which keep their names, like user.comment.
+	XattrNamespace = "urn:wanix:xattr:"
+)
+
+// ModeProp is the property holding a file's mode.
+var ModeProp = xml.Name{Space: Namespace, Local: "content-mode"}
+
+// NewHandler returns a WebDAV handler serving fsys below the URL path
+// prefix. Locks are kept in memory.
+func NewHandler(fsys fs.FS, prefix string) *webdav.Handler {
+	return &webdav.Handler{
+		Prefix:     strings.TrimSuffix(prefix, "/"),
+		FileSystem: &fileSystem{fsys: fsys},
+		LockSystem: webdav.NewMemLS(),
+	}
+}
+
+// fileSystem adapts an fs.FS to webdav.FileSystem.
+type fileSystem struct {
+	fsys fs.FS
+}
+
+func (d *fileSystem) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	return fs.Mkdir(d.fsys, cleanName(name), perm)
+}
+
+func (d *fileSystem) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	name = cleanName(name)
+	var (
+		f   fs.File
+		err error
+	)
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) == 0 {
+		f, err = fs.OpenContext(ctx, d.fsys, name)
+	} else {
+		f, err = fs.OpenFile(d.fsys, name, flag, perm)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &file{File: f, fsys: d.fsys, name: name, ctx: ctx}, nil
+}
+
+func (d *fileSystem) RemoveAll(ctx context.Context, name string) error {
+	name = cleanName(name)
+	if name == "." {
+		return fs.ErrPermission
+	}
+	return fs.RemoveAll(d.fsys, name)
+}
+
+func (d *fileSystem) Rename(ctx context.Context, oldName, newName string) error {
+	return fs.Rename(d.fsys, cleanName(oldName), cleanName(newName))
+}
+
+func (d *fileSystem) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	info, err := fs.StatContext(ctx, d.fsys, cleanName(name))
+	if err != nil {
+		return nil, err
+	}
+	return withETag(info), nil
+}
+
+// etagInfo passes through the ETag of files that have one, such as httpfs
+// files, instead of one derived from size and mtime.
+type etagInfo struct {
+	fs.FileInfo
+	etag string
+}
+
+func (i etagInfo) ETag(ctx context.Context) (string, error) {
+	return i.etag, nil
+}
+
+func withETag(info fs.FileInfo) fs.FileInfo {
+	if e, ok := info.(interface{ ETag() string }); ok && e.ETag() != "" {
+		return etagInfo{FileInfo: info, etag: e.ETag()}
+	}
+	return info
+}
+
+// file adapts an fs.File to webdav.File, holding the file's mode and
+// extended attributes as dead properties.
+type file struct {
+	fs.File
+	fsys fs.FS
+	name string
+	ctx  context.Context
+}
+
+func (f *file) Write(p []byte) (int, error) {
+	w, ok := f.File.(io.Writer)
+	if !ok {
+		return 0, fs.ErrPermission
+	}
+	return w.Write(p)
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	s, ok := f.File.(io.Seeker)
+	if !ok {
+		return 0, fs.ErrNotSupported
+	}
+	return s.Seek(offset, whence)
+}
+
+func (f *file) Readdir(count int) ([]os.FileInfo, error) {
+	d, ok := f.File.(fs.ReadDirFile)
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: f.name, Err: fs.ErrInvalid}
+	}
+	entries, err := d.ReadDir(count)
+	var infos []os.FileInfo
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		infos = append(infos, info)
+	}
+	return infos, err
+}
+
+func (f *file) Stat() (os.FileInfo, error) {
+	info, err := f.File.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return withETag(info), nil
+}
+
+func (f *file) DeadProps() (map[xml.Name]webdav.Property, error) {
+	props := make(map[xml.Name]webdav.Property)
+	if info, err := f.Stat(); err == nil {
+		props[ModeProp] = textProp(ModeProp, formatMode(info.Mode()))
+	}
+	attrs, err := fs.ListXattrs(f.ctx, f.fsys, f.name)
+	if err != nil {
+		// not every filesystem has extended attributes
+		return props, nil
+	}
+	for _, attr := range attrs {
+		data, err := fs.GetXattr(f.ctx, f.fsys, f.name, attr)
+		if err != nil {
+			continue
+		}
+		name := xml.Name{Space: XattrNamespace, Local: attr}
+		props[name] = textProp(name, string(data))
+	}
+	return props, nil
+}
+
+// Patch applies all of patches or none of them. Once one fails, those
+// already applied are undone and every other property is reported as a
+// failed dependency.
+func (f *file) Patch(patches []webdav.Proppatch) ([]webdav.Propstat, error) {
+	applied := webdav.Propstat{Status: http.StatusOK}
+	failed := webdav.Propstat{Status: http.StatusForbidden}
+	skipped := webdav.Propstat{Status: http.StatusFailedDependency}
+	var undo []func() error
+	for _, patch := range patches {
+		for _, prop := range patch.Props {
+			name := webdav.Property{XMLName: prop.XMLName}
+			if len(failed.Props) > 0 {
+				skipped.Props = append(skipped.Props, name)
+				continue
+			}
+			u, err := f.patch(prop, patch.Remove)
+			if err != nil {
+				failed.Props = append(failed.Props, name)
+				continue
+			}
+			applied.Props = append(applied.Props, name)
+			undo = append(undo, u)
+		}
+	}
+	if len(failed.Props) == 0 {
+		return []webdav.Propstat{applied}, nil
+	}
+	for i := len(undo) - 1; i >= 0; i-- {
+		if err := undo[i](); err != nil {
+			return nil, err
+		}
+	}
+	skipped.Props = append(applied.Props, skipped.Props...)
+	stats := []webdav.Propstat{failed}
+	if len(skipped.Props) > 0 {
+		stats = append(stats, skipped)
+	}
+	return stats, nil
+}
+
+// patch sets or removes prop, returning a function that restores it.
+func (f *file) patch(prop webdav.Property, remove bool) (func() error, error) {
+	switch {
+	case prop.XMLName == ModeProp:
+		if remove {
+			return nil, fs.ErrPermission
+		}
+		mode, err := parseMode(propText(prop))
+		if err != nil {
+			return nil, err
+		}
+		info, err := fs.Stat(f.fsys, f.name)
+		if err != nil {
+			return nil, err
+		}
+		if err := fs.Chmod(f.fsys, f.name, mode); err != nil {
+			return nil, err
+		}
+		return func() error { return fs.Chmod(f.fsys, f.name, info.Mode()) }, nil
+	case prop.XMLName.Space == XattrNamespace:
+		attr := prop.XMLName.Local
+		old, getErr := fs.GetXattr(f.ctx, f.fsys, f.name, attr)
+		var err error
+		if remove {
+			err = fs.RemoveXattr(f.ctx, f.fsys, f.name, attr)
+		} else {
+			err = fs.SetXattr(f.ctx, f.fsys, f.name, attr, []byte(propText(prop)), 0)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return func() error {
+			if getErr != nil {
+				return fs.RemoveXattr(f.ctx, f.fsys, f.name, attr)
+			}
+			return fs.SetXattr(f.ctx, f.fsys, f.name, attr, old, 0)
+		}, nil
+	default:
+		return nil, fs.ErrNotSupported
+	}
+}
+
+// textProp returns a property with text as its escaped contents.
+func textProp(name xml.Name, text string) webdav.Property {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(text))
+	return webdav.Property{XMLName: name, InnerXML: buf.Bytes()}
+}
+
+// propText returns the text contents of a property.
+func propText(prop webdav.Property) string {
+	return innerText(prop.InnerXML)
+}
+
+// innerText returns the character data in an XML fragment.
+func innerText(inner []byte) string {
+	var text strings.Builder
+	d := xml.NewDecoder(bytes.NewReader(inner))
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			break
+		}
+		if cd, ok := tok.(xml.CharData); ok {
+			text.Write(cd)
+		}
+	}
+	return text.String()
+}
+
+func formatMode(mode fs.FileMode) string {
+	return strconv.FormatUint(uint64(pstat.FileModeToUnixMode(mode)), 10)
+}
+
+func parseMode(s string) (fs.FileMode, error) {
+	unixMode, err := strconv.ParseUint(strings.TrimSpace(s), 10, 32)
+	if err != nil {
+		return 0, errors.Join(fs.ErrInvalid, err)
+	}
+	return pstat.UnixModeToFileMode(uint32(unixMode)), nil
+}
+
+// cleanName converts a WebDAV path to an fs.FS name.
+func cleanName(name string) string {
+	name = strings.Trim(path.Clean("/"+name), "/")
+	if name == "" {
+		return "."
+	}
+	return name
+}
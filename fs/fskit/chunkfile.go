@@ -0,0 +1,153 @@
+package fskit
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"tractor.dev/wanix/fs"
+)
+
+// chunkFile is an open handle on a node backed by Chunks. Like nodeFile
+// it works on its own copy of the data, synced to the node on close, but
+// the copy shares pages with the node until written, so opening a large
+// file is free.
+type chunkFile struct {
+	chunks  *Chunks
+	inode   *Node
+	dirty   bool
+	offset  int64
+	closed  bool
+	modTime time.Time
+	mu      sync.Mutex
+}
+
+func (f *chunkFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return fs.ErrClosed
+	}
+	if f.dirty {
+		SetChunks(f.inode, f.chunks)
+		SetModTime(f.inode, f.modTime)
+	}
+	f.closed = true
+	return nil
+}
+
+func (f *chunkFile) Stat() (fs.FileInfo, error) {
+	return f.inode, nil
+}
+
+func (f *chunkFile) Read(b []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return 0, fs.ErrClosed
+	}
+	n, err := f.chunks.ReadAt(b, f.offset)
+	f.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+func (f *chunkFile) ReadAt(b []byte, off int64) (int, error) {
+	if f.isClosed() {
+		return 0, fs.ErrClosed
+	}
+	if off < 0 {
+		return 0, &fs.PathError{Op: "read", Path: f.inode.Path(), Err: fs.ErrInvalid}
+	}
+	return f.chunks.ReadAt(b, off)
+}
+
+func (f *chunkFile) Write(b []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return 0, fs.ErrClosed
+	}
+	n, err := f.chunks.WriteAt(b, f.offset)
+	f.offset += int64(n)
+	f.touch()
+	return n, err
+}
+
+// WriteAt may write past the end of the file, leaving a hole.
+func (f *chunkFile) WriteAt(b []byte, off int64) (int, error) {
+	if f.isClosed() {
+		return 0, fs.ErrClosed
+	}
+	if off < 0 {
+		return 0, &fs.PathError{Op: "write", Path: f.inode.Path(), Err: fs.ErrInvalid}
+	}
+	n, err := f.chunks.WriteAt(b, off)
+	f.mu.Lock()
+	f.touch()
+	f.mu.Unlock()
+	return n, err
+}
+
+// Seek supports SeekData and SeekHole in addition to the io.Seeker
+// whence values.
+func (f *chunkFile) Seek(offset int64, whence int) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return 0, fs.ErrClosed
+	}
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.chunks.Size()
+	case SeekData, SeekHole:
+		off, err := f.chunks.Seek(offset, whence)
+		if err != nil {
+			return 0, &fs.PathError{Op: "seek", Path: f.inode.Path(), Err: err}
+		}
+		offset = off
+	default:
+		return 0, &fs.PathError{Op: "seek", Path: f.inode.Path(), Err: fs.ErrInvalid}
+	}
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "seek", Path: f.inode.Path(), Err: fs.ErrInvalid}
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *chunkFile) Truncate(size int64) error {
+	if f.isClosed() {
+		return fs.ErrClosed
+	}
+	if size < 0 {
+		return &fs.PathError{Op: "truncate", Path: f.inode.Path(), Err: fs.ErrInvalid}
+	}
+	f.chunks.Truncate(size)
+	f.mu.Lock()
+	f.touch()
+	f.mu.Unlock()
+	return nil
+}
+
+func (f *chunkFile) Sync() error {
+	return nil
+}
+
+// touch marks the handle's copy as changed. Must be called with f.mu held.
+func (f *chunkFile) touch() {
+	f.dirty = true
+	f.modTime = time.Now()
+}
+
+func (f *chunkFile) isClosed() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.closed
+}
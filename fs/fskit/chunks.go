@@ -0,0 +1,248 @@
+package fskit
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// ChunkSize is the size of the pages Chunks stores data in.
+const ChunkSize = 64 << 10
+
+// Seek whence values for finding data and holes in sparse files,
+// matching lseek(2) on Linux.
+const (
+	SeekData = 3
+	SeekHole = 4
+)
+
+// Chunks is sparse byte storage split into fixed size pages. Pages that
+// have never been written are holes: they take no memory and read as
+// zeros. Writing past the end or truncating only touches the pages
+// involved, so large mostly-empty files like disk images stay cheap.
+type Chunks struct {
+	mu     sync.RWMutex
+	pages  map[int64][]byte
+	shared map[int64]bool // pages also referenced by a clone
+	size   int64
+}
+
+func NewChunks() *Chunks {
+	return &Chunks{pages: make(map[int64][]byte)}
+}
+
+// ChunksFrom returns Chunks holding a copy of data.
+func ChunksFrom(data []byte) *Chunks {
+	c := NewChunks()
+	c.WriteAt(data, 0)
+	return c
+}
+
+// Clone returns Chunks with the same contents. Pages are shared until
+// either side writes to them, at which point the writer gets its own copy.
+func (c *Chunks) Clone() *Chunks {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cc := &Chunks{
+		pages:  make(map[int64][]byte, len(c.pages)),
+		shared: make(map[int64]bool, len(c.pages)),
+		size:   c.size,
+	}
+	if c.shared == nil {
+		c.shared = make(map[int64]bool, len(c.pages))
+	}
+	for idx, page := range c.pages {
+		cc.pages[idx] = page
+		cc.shared[idx] = true
+		c.shared[idx] = true
+	}
+	return cc
+}
+
+func (c *Chunks) Size() int64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.size
+}
+
+// Allocated returns the number of bytes of memory held by pages.
+func (c *Chunks) Allocated() int64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return int64(len(c.pages)) * ChunkSize
+}
+
+func (c *Chunks) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if off >= c.size {
+		return 0, io.EOF
+	}
+	if remain := c.size - off; int64(len(p)) > remain {
+		p = p[:remain]
+		err = io.EOF
+	}
+	for n < len(p) {
+		idx, po := (off+int64(n))/ChunkSize, (off+int64(n))%ChunkSize
+		dst := p[n:min(len(p), n+int(ChunkSize-po))]
+		if page, ok := c.pages[idx]; ok {
+			copy(dst, page[po:])
+		} else {
+			clear(dst)
+		}
+		n += len(dst)
+	}
+	return n, err
+}
+
+func (c *Chunks) WriteAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for n < len(p) {
+		idx, po := (off+int64(n))/ChunkSize, (off+int64(n))%ChunkSize
+		src := p[n:min(len(p), n+int(ChunkSize-po))]
+		page, ok := c.page(idx)
+		if !ok {
+			if isZero(src) {
+				// writing zeros into a hole leaves it a hole
+				n += len(src)
+				continue
+			}
+			page = make([]byte, ChunkSize)
+			c.pages[idx] = page
+		}
+		copy(page[po:], src)
+		n += len(src)
+	}
+	if end := off + int64(n); end > c.size {
+		c.size = end
+	}
+	return n, nil
+}
+
+// Truncate changes the size to size. Growing only moves the end of the
+// file, creating a hole; shrinking drops the pages past the new end.
+func (c *Chunks) Truncate(size int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if size < c.size {
+		first := (size + ChunkSize - 1) / ChunkSize
+		last := (c.size + ChunkSize - 1) / ChunkSize
+		if last-first > int64(len(c.pages)) {
+			for idx := range c.pages {
+				if idx >= first {
+					c.drop(idx)
+				}
+			}
+		} else {
+			for idx := first; idx < last; idx++ {
+				c.drop(idx)
+			}
+		}
+		// zero the tail of the last page so growing again reads zeros
+		if po := size % ChunkSize; po != 0 {
+			if page, ok := c.page(size / ChunkSize); ok {
+				clear(page[po:])
+			}
+		}
+	}
+	c.size = size
+}
+
+// PunchHole zeros length bytes at off, releasing any pages that are
+// entirely covered. The size is unchanged.
+func (c *Chunks) PunchHole(off, length int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	end := min(off+length, c.size)
+	for off < end {
+		idx, po := off/ChunkSize, off%ChunkSize
+		n := min(ChunkSize-po, end-off)
+		if po == 0 && n == ChunkSize {
+			c.drop(idx)
+		} else if page, ok := c.page(idx); ok {
+			clear(page[po : po+n])
+		}
+		off += n
+	}
+}
+
+// Seek finds the next data (SeekData) or hole (SeekHole) at or after off,
+// with the same semantics as lseek(2). The end of the file counts as a
+// hole. io.EOF is returned when off is past the end, or when there is no
+// more data.
+func (c *Chunks) Seek(off int64, whence int) (int64, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if off < 0 || off >= c.size {
+		return 0, io.EOF
+	}
+	for idx := off / ChunkSize; idx*ChunkSize < c.size; idx++ {
+		_, data := c.pages[idx]
+		if data == (whence == SeekData) {
+			return max(off, idx*ChunkSize), nil
+		}
+	}
+	if whence == SeekHole {
+		return c.size, nil
+	}
+	return 0, io.EOF
+}
+
+// Bytes returns the contents as a single slice, with holes filled in.
+func (c *Chunks) Bytes() []byte {
+	c.mu.RLock()
+	size := c.size
+	c.mu.RUnlock()
+	b := make([]byte, size)
+	n, _ := c.ReadAt(b, 0)
+	return b[:n]
+}
+
+// SetBytes replaces the contents with a copy of data.
+func (c *Chunks) SetBytes(data []byte) {
+	c.mu.Lock()
+	c.pages = make(map[int64][]byte)
+	c.shared = nil
+	c.size = 0
+	c.mu.Unlock()
+	c.WriteAt(data, 0)
+}
+
+// page returns the page at idx for writing, copying it first if it is
+// shared with a clone. Must be called with c.mu held.
+func (c *Chunks) page(idx int64) ([]byte, bool) {
+	page, ok := c.pages[idx]
+	if ok && c.shared[idx] {
+		page = bytes.Clone(page)
+		c.pages[idx] = page
+		delete(c.shared, idx)
+	}
+	return page, ok
+}
+
+// drop releases the page at idx. Must be called with c.mu held.
+func (c *Chunks) drop(idx int64) {
+	delete(c.pages, idx)
+	delete(c.shared, idx)
+}
+
+func isZero(b []byte) bool {
+	for _, v := range b {
+		if v != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,93 @@
+package fskit
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestChunksSparseWrite(t *testing.T) {
+	c := NewChunks()
+	off := int64(1 << 30)
+	if _, err := c.WriteAt([]byte("hello"), off); err != nil {
+		t.Fatal(err)
+	}
+	if c.Size() != off+5 {
+		t.Fatalf("size = %d, want %d", c.Size(), off+5)
+	}
+	if c.Allocated() != ChunkSize {
+		t.Fatalf("allocated = %d, want one page", c.Allocated())
+	}
+
+	p := make([]byte, 10)
+	n, err := c.ReadAt(p, off-5)
+	if err != nil || n != 10 {
+		t.Fatalf("ReadAt = %d, %v", n, err)
+	}
+	if !bytes.Equal(p, []byte("\x00\x00\x00\x00\x00hello")) {
+		t.Fatalf("unexpected data: %q", p)
+	}
+
+	// writing zeros into a hole doesn't allocate
+	if _, err := c.WriteAt(make([]byte, ChunkSize*4), 0); err != nil {
+		t.Fatal(err)
+	}
+	if c.Allocated() != ChunkSize {
+		t.Fatalf("allocated = %d after zero write", c.Allocated())
+	}
+}
+
+func TestChunksTruncate(t *testing.T) {
+	c := ChunksFrom(bytes.Repeat([]byte("x"), ChunkSize*3))
+	c.Truncate(10)
+	if c.Size() != 10 || c.Allocated() != ChunkSize {
+		t.Fatalf("size = %d, allocated = %d", c.Size(), c.Allocated())
+	}
+
+	// growing again must not resurface old data
+	c.Truncate(ChunkSize * 2)
+	b := c.Bytes()
+	if len(b) != ChunkSize*2 {
+		t.Fatalf("len = %d", len(b))
+	}
+	if string(b[:10]) != "xxxxxxxxxx" || b[10] != 0 || b[ChunkSize+1] != 0 {
+		t.Fatal("unexpected data after truncate and grow")
+	}
+}
+
+func TestChunksSeekHole(t *testing.T) {
+	c := NewChunks()
+	c.WriteAt([]byte("data"), ChunkSize*2+5)
+	c.Truncate(ChunkSize * 5)
+
+	off, err := c.Seek(0, SeekData)
+	if err != nil || off != ChunkSize*2 {
+		t.Fatalf("SeekData = %d, %v", off, err)
+	}
+	off, err = c.Seek(off, SeekHole)
+	if err != nil || off != ChunkSize*3 {
+		t.Fatalf("SeekHole = %d, %v", off, err)
+	}
+	if _, err := c.Seek(off, SeekData); err != io.EOF {
+		t.Fatalf("expected EOF seeking data past last page, got %v", err)
+	}
+
+	c.PunchHole(ChunkSize*2, ChunkSize)
+	if c.Allocated() != 0 {
+		t.Fatalf("allocated = %d after punching hole", c.Allocated())
+	}
+}
+
+func TestChunksClone(t *testing.T) {
+	c := ChunksFrom([]byte("original"))
+	cc := c.Clone()
+
+	cc.WriteAt([]byte("O"), 0)
+	c.Truncate(4)
+	if string(c.Bytes()) != "orig" {
+		t.Fatalf("unexpected data: %q", c.Bytes())
+	}
+	if string(cc.Bytes()) != "Original" {
+		t.Fatalf("clone affected by original: %q", cc.Bytes())
+	}
+}
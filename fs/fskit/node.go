@@ -21,6 +21,7 @@ type Node struct {
 	uid     int
 	gid     int
 	data    []byte
+	chunks  *Chunks
 	log     *slog.Logger
 
 	reader io.Reader
@@ -42,6 +43,7 @@ func RawNode(attrs ...any) *Node {
 			n.uid = v.uid
 			n.gid = v.gid
 			n.data = v.data
+			n.chunks = v.chunks
 			n.reader = v.reader
 			n.writer = v.writer
 			n.log = v.log
@@ -55,6 +57,8 @@ func RawNode(attrs ...any) *Node {
 			n.modTime = v
 		case []byte:
 			n.data = v
+		case *Chunks:
+			n.chunks = v
 		case string:
 			n.path = v
 		case fs.FileMode:
@@ -145,6 +149,9 @@ func (n *Node) Sys() any {
 func (n *Node) Size() int64 {
 	n.mu.Lock()
 	defer n.mu.Unlock()
+	if n.chunks != nil {
+		return n.chunks.Size()
+	}
 	if n.size < 0 {
 		return 0
 	}
@@ -161,6 +168,9 @@ func (n *Node) String() string {
 func (n *Node) Data() []byte {
 	n.mu.Lock()
 	defer n.mu.Unlock()
+	if n.chunks != nil {
+		return n.chunks.Bytes()
+	}
 	if n.data == nil {
 		return nil
 	}
@@ -169,6 +179,25 @@ func (n *Node) Data() []byte {
 	return dataCopy
 }
 
+// Clone returns a copy of n. Chunked data is shared copy-on-write, so
+// cloning is cheap regardless of the size of the file.
+func (n *Node) Clone() *Node {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	c := RawNode(n)
+	if n.chunks != nil {
+		c.chunks = n.chunks.Clone()
+	}
+	return c
+}
+
+// Chunks returns the sparse storage backing the node, if it has any.
+func (n *Node) Chunks() *Chunks {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.chunks
+}
+
 func (n *Node) Log() *slog.Logger {
 	n.mu.Lock()
 	defer n.mu.Unlock()
@@ -202,9 +231,22 @@ func SetName(n *Node, name string) {
 func SetData(n *Node, data []byte) {
 	n.mu.Lock()
 	defer n.mu.Unlock()
+	if n.chunks != nil {
+		n.chunks.SetBytes(data)
+		return
+	}
 	n.data = data
 }
 
+// SetChunks switches the node to sparse storage. Files opened from the
+// node afterwards copy c page by page as they write to it.
+func SetChunks(n *Node, c *Chunks) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.chunks = c
+	n.data = nil
+}
+
 func SetMode(n *Node, mode fs.FileMode) {
 	n.mu.Lock()
 	defer n.mu.Unlock()
@@ -264,6 +306,9 @@ func (n *Node) Open(name string) (fs.File, error) {
 // TODO: open sub nodes
 func (n *Node) OpenContext(ctx context.Context, name string) (fs.File, error) {
 	if name == "." {
+		if c := n.Chunks(); c != nil {
+			return &chunkFile{chunks: c.Clone(), inode: n}, nil
+		}
 		return n.openFile(), nil
 	}
 	return nil, fs.ErrNotExist
@@ -184,6 +184,10 @@ func (n *node) Lookup(ctx context.Context, name string, out *fuse.EntryOut) (*fs
 		mode = fuse.S_IFDIR
 	} else if fi.Mode()&iofs.ModeSymlink != 0 {
 		mode = fuse.S_IFLNK
+	} else if fi.Mode()&iofs.ModeNamedPipe != 0 {
+		mode = fuse.S_IFIFO
+	} else if fi.Mode()&iofs.ModeSocket != 0 {
+		mode = syscall.S_IFSOCK
 	}
 
 	return n.Inode.NewPersistentInode(ctx, &node{
@@ -373,6 +377,39 @@ func (n *node) Symlink(ctx context.Context, target, name string, out *fuse.Entry
 	}), 0
 }
 
+var _ = (fs.NodeMknoder)((*node)(nil))
+
+func (n *node) Mknod(ctx context.Context, name string, mode uint32, dev uint32, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	// log.Println("mknod", n.path, name, mode, dev)
+
+	if err := iofs.Mknod(n.fs, name, pstat.UnixModeToFileMode(mode), int(dev)); err != nil {
+		return nil, sysErrno(err)
+	}
+
+	// Use lstat so opening a named pipe doesn't wait for the other end
+	fi, err := iofs.LstatContext(n.ctx, n.fs, name)
+	if err != nil {
+		return nil, sysErrno(err)
+	}
+
+	applyStat(&out.Attr, fi)
+
+	subfs, err := iofs.Sub(n.fs, name)
+	if err != nil {
+		return nil, sysErrno(err)
+	}
+
+	return n.Inode.NewPersistentInode(ctx, &node{
+		rootfs: n.rootfs,
+		ctx:    n.ctx,
+		fs:     subfs,
+		path:   filepath.Join(n.path, name),
+	}, fs.StableAttr{
+		Mode: mode & syscall.S_IFMT,
+		Ino:  getIno(filepath.Join(n.path, name), fi),
+	}), 0
+}
+
 var _ = (fs.NodeReadlinker)((*node)(nil))
 
 func (n *node) Readlink(ctx context.Context) ([]byte, syscall.Errno) {
@@ -22,6 +22,11 @@ type cacheEntry struct {
 	cachedAt  time.Time
 	renewsAt  time.Time
 	expiresAt time.Time
+
+	// etag validates the response the entry came from, and parts lists
+	// the other entries cached from the same response.
+	etag  string
+	parts []string
 }
 
 // Cacher wraps an FS and adds caching functionality
@@ -107,6 +112,34 @@ func (fsys *Cacher) CacheNode(node *Node) {
 		cachedAt:  now,
 		expiresAt: now.Add(fsys.ttl),
 		renewsAt:  now.Add(fsys.ttl / 2),
+		etag:      node.etag,
+	}
+}
+
+// cachedETag returns the ETag of the response the node at path was cached
+// from, along with the node.
+func (fsys *Cacher) cachedETag(path string) (string, *Node, []string) {
+	fsys.cacheMu.RLock()
+	defer fsys.cacheMu.RUnlock()
+	entry, ok := fsys.nodeCache[path]
+	if !ok || entry.node == nil {
+		return "", nil, nil
+	}
+	return entry.etag, entry.node, entry.parts
+}
+
+// renewNodes extends the lifetime of cached nodes after the server
+// confirmed they are still current.
+func (fsys *Cacher) renewNodes(paths ...string) {
+	fsys.cacheMu.Lock()
+	defer fsys.cacheMu.Unlock()
+	now := time.Now()
+	for _, path := range paths {
+		if entry, ok := fsys.nodeCache[path]; ok && entry.err == nil {
+			entry.cachedAt = now
+			entry.expiresAt = now.Add(fsys.ttl)
+			entry.renewsAt = now.Add(fsys.ttl / 2)
+		}
 	}
 }
 
@@ -218,7 +251,7 @@ func (fsys *Cacher) PullNode(ctx context.Context, name string, recursivePrefetch
 	for _, entry := range node.Entries() {
 		if entry.IsDir() {
 			go func(path string) {
-				for n, err := range fsys.fs.streamTree(ctx, path) {
+				for n, err := range fsys.fs.streamTree(ctx, path, -1) {
 					if err != nil {
 						fsys.log.Debug("incomplete prefetch", "path", path, "err", err)
 						return
@@ -247,12 +280,20 @@ func (fsys *Cacher) PullDir(ctx context.Context, name string) (*Node, error) {
 	}
 
 	req.Header.Set("Accept", "multipart/mixed")
+	etag, cached, parts := fsys.cachedETag(name)
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
 	resp, err := fsys.fs.doRequest(req)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		fsys.renewNodes(append(parts, name)...)
+		return cached, nil
+	}
 	if resp.StatusCode != http.StatusOK {
 		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, resp.Status)
 	}
@@ -271,6 +312,7 @@ func (fsys *Cacher) PullDir(ctx context.Context, name string) (*Node, error) {
 
 	// Parse multipart response
 	var dirNode *Node
+	parts = nil
 	for fileNode, err := range parseNodesMultipart(fsys, resp.Body, boundary) {
 		if err != nil {
 			return nil, err
@@ -278,8 +320,19 @@ func (fsys *Cacher) PullDir(ctx context.Context, name string) (*Node, error) {
 		fsys.CacheNode(fileNode)
 		if dirNode == nil {
 			dirNode = fileNode
+		} else {
+			parts = append(parts, fileNode.Path())
 		}
 	}
+	if dirNode != nil {
+		// remember the listing's ETag to revalidate it later
+		fsys.cacheMu.Lock()
+		if entry, ok := fsys.nodeCache[dirNode.Path()]; ok {
+			entry.etag = resp.Header.Get("ETag")
+			entry.parts = parts
+		}
+		fsys.cacheMu.Unlock()
+	}
 	return dirNode, nil
 }
 
@@ -289,7 +342,8 @@ func (fsys *Cacher) PullMeta(ctx context.Context, path string) (*Node, error) {
 	path = normalizePath(path)
 	fsys.log.Debug("PullMeta", "path", path)
 
-	node, err := fsys.fs.StatContext(ctx, path)
+	etag, cached, _ := fsys.cachedETag(path)
+	fileNode, err := fsys.fs.statIfNoneMatch(ctx, path, etag)
 	if err != nil {
 		if errors.Is(err, fs.ErrNotExist) {
 			// Cache the 404 error
@@ -297,10 +351,10 @@ func (fsys *Cacher) PullMeta(ctx context.Context, path string) (*Node, error) {
 		}
 		return nil, err
 	}
-
-	fileNode, ok := node.(*Node)
-	if !ok {
-		return nil, fmt.Errorf("unexpected node type")
+	if fileNode == nil {
+		// not modified
+		fsys.renewNodes(path)
+		return cached, nil
 	}
 
 	// Cache the result
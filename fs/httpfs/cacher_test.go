@@ -2,10 +2,12 @@ package httpfs
 
 import (
 	"context"
+	"net/http"
 	"net/http/httptest"
 	"testing"
 	"time"
 
+	"tractor.dev/wanix/fs"
 	"tractor.dev/wanix/fs/memfs"
 )
 
@@ -155,3 +157,98 @@ func TestWriteFileWithCache(t *testing.T) {
 		t.Errorf("Expected size %d, got %d (cache not invalidated?)", len(newData), info2.Size())
 	}
 }
+
+func TestCacherRevalidate(t *testing.T) {
+	memFS := memfs.New()
+	var notModified int
+	srv := NewServer(memFS)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w}
+		srv.ServeHTTP(rec, r)
+		if rec.status == http.StatusNotModified {
+			notModified++
+		}
+	}))
+	defer server.Close()
+	cacher := NewCacher(New(server.URL, nil))
+
+	if err := fs.WriteFile(memFS, "file.txt", []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cacher.PullDir(context.Background(), "."); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cacher.PullMeta(context.Background(), "file.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	// nothing changed, so both revalidate without refetching
+	if _, err := cacher.PullMeta(context.Background(), "file.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cacher.PullDir(context.Background(), "."); err != nil {
+		t.Fatal(err)
+	}
+	if notModified != 2 {
+		t.Fatalf("got %d 304 responses, want 2", notModified)
+	}
+
+	// a change is picked up
+	if err := fs.WriteFile(memFS, "file.txt", []byte("longer data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := cacher.PullMeta(context.Background(), "file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != 11 {
+		t.Fatalf("size = %d after change", info.Size())
+	}
+}
+
+func TestCacherSubscribe(t *testing.T) {
+	_, server, cacher := newTestServerWithCache()
+	defer server.Close()
+	other := New(server.URL, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := cacher.Subscribe(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := fs.WriteFile(other, "file.txt", []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cacher.Stat("file.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.WriteFile(other, "file.txt", []byte("longer data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// the TTL hasn't passed, but the change invalidates the cached node
+	for i := 0; ; i++ {
+		info, err := cacher.Stat("file.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() == 11 {
+			break
+		}
+		if i == 100 {
+			t.Fatal("cached node was not invalidated")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
@@ -0,0 +1,292 @@
+package httpfs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"tractor.dev/wanix/fs"
+)
+
+// Change stream ops in addition to whatever the served FS's Watch reports.
+// A reset means changes were missed and everything under the path should
+// be considered stale.
+const (
+	OpWrite  = "write"
+	OpMeta   = "meta"
+	OpRemove = "remove"
+	OpReset  = "reset"
+)
+
+const (
+	// changeHistory is how many changes are kept for clients resuming a
+	// change stream with Last-Event-ID.
+	changeHistory = 1024
+
+	// changeBuffer is how far a subscriber may fall behind before it is
+	// dropped and has to resume.
+	changeBuffer = 256
+
+	heartbeatInterval = 15 * time.Second
+)
+
+type change struct {
+	id   uint64
+	Path string `json:"path"`
+	Op   string `json:"op"`
+}
+
+// changeFeed fans out changes to change stream subscribers and keeps a
+// short history for subscribers that reconnect.
+type changeFeed struct {
+	mu      sync.Mutex
+	seq     uint64
+	history []change
+	subs    map[chan change]bool
+
+	// watching is set when changes come from fs.Watch on the served FS.
+	// Otherwise the server reports changes made through itself.
+	watching bool
+}
+
+func (f *changeFeed) publish(path, op string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.seq++
+	c := change{id: f.seq, Path: path, Op: op}
+	f.history = append(f.history, c)
+	if len(f.history) > changeHistory {
+		f.history = f.history[len(f.history)-changeHistory:]
+	}
+	for ch := range f.subs {
+		select {
+		case ch <- c:
+		default:
+			// too far behind, it will have to resume
+			delete(f.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// subscribe returns a channel of changes after since, along with the
+// changes already missed. reset is set if the missed changes are no
+// longer in the history, or since came from an earlier run of the server.
+func (f *changeFeed) subscribe(since uint64, stale bool) (ch chan change, backlog []change, reset bool, last uint64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.subs == nil {
+		f.subs = make(map[chan change]bool)
+	}
+	ch = make(chan change, changeBuffer)
+	f.subs[ch] = true
+	if stale || since > f.seq {
+		reset = true
+	} else if since > 0 && since < f.seq {
+		if len(f.history) == 0 || f.history[0].id > since+1 {
+			reset = true
+		} else {
+			for _, c := range f.history {
+				if c.id > since {
+					backlog = append(backlog, c)
+				}
+			}
+		}
+	}
+	return ch, backlog, reset, f.seq
+}
+
+func (f *changeFeed) unsubscribe(ch chan change) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.subs[ch] {
+		delete(f.subs, ch)
+		close(ch)
+	}
+}
+
+// changeFeed returns the server's change feed, starting to watch the
+// served FS the first time it is used if it supports fs.Watch.
+func (s *Server) changeFeed() *changeFeed {
+	s.feedOnce.Do(func() {
+		events, err := fs.Watch(s.fs, context.Background(), "./...")
+		if err != nil {
+			return
+		}
+		s.feed.watching = true
+		go func() {
+			for e := range events {
+				if e.Err != nil {
+					continue
+				}
+				name := strings.TrimPrefix(e.Path, "/")
+				if name == "" {
+					name = "."
+				}
+				s.feed.publish(name, e.Op)
+			}
+		}()
+	})
+	return &s.feed
+}
+
+// changed reports a change made through the server, unless the served FS
+// already reports changes itself.
+func (s *Server) changed(path, op string) {
+	if feed := s.changeFeed(); !feed.watching {
+		feed.publish(path, op)
+	}
+}
+
+// handleChanges streams changes under dir as Server-Sent Events. Clients
+// resume with the Last-Event-ID header, getting the changes they missed
+// or a reset event if too many happened.
+func (s *Server) handleChanges(w http.ResponseWriter, r *http.Request, dir string) {
+	info, err := fs.Stat(s.fs, dir)
+	if err != nil || !info.IsDir() {
+		writeNotFound(w)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	since, stale := s.parseEventID(r.Header.Get("Last-Event-ID"))
+	feed := s.changeFeed()
+	ch, backlog, reset, last := feed.subscribe(since, stale)
+	defer feed.unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	epoch := s.epoch()
+	if reset {
+		writeChange(w, epoch, change{id: last, Path: dir, Op: OpReset})
+	}
+	for _, c := range backlog {
+		if within(dir, c.Path) {
+			writeChange(w, epoch, c)
+		}
+	}
+	// an initial comment lets the client know the stream is live
+	fmt.Fprint(w, ": ok\n\n")
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+		case c, ok := <-ch:
+			if !ok {
+				return
+			}
+			if !within(dir, c.Path) {
+				continue
+			}
+			writeChange(w, epoch, c)
+		}
+		flusher.Flush()
+	}
+}
+
+// writeChange writes c as an event with an id made of the server epoch
+// and its sequence number.
+func writeChange(w http.ResponseWriter, epoch string, c change) {
+	data, _ := json.Marshal(c)
+	fmt.Fprintf(w, "id: %s-%d\nevent: change\ndata: %s\n\n", epoch, c.id, data)
+}
+
+// parseEventID returns the sequence number of an event id, and whether
+// it came from another run of the server, in which case the changes
+// since can't be known.
+func (s *Server) parseEventID(id string) (since uint64, stale bool) {
+	if id == "" {
+		return 0, false
+	}
+	epoch, seq, ok := strings.Cut(id, "-")
+	if !ok || epoch != s.epoch() {
+		return 0, true
+	}
+	since, err := strconv.ParseUint(seq, 10, 64)
+	if err != nil {
+		return 0, true
+	}
+	return since, false
+}
+
+// epoch returns a random id for this run of the server, which event ids
+// and file versions carry so ones from an earlier run are told apart.
+func (s *Server) epoch() string {
+	s.epochOnce.Do(func() {
+		s.epochID = rand.Text()[:12]
+	})
+	return s.epochID
+}
+
+// within reports whether name is dir or below it.
+func within(dir, name string) bool {
+	return dir == "." || name == dir || strings.HasPrefix(name, dir+"/")
+}
+
+// publishChange reports the change made by a successful request.
+func (s *Server) publishChange(w *statusWriter, r *http.Request, path string) {
+	if w.status >= 300 {
+		return
+	}
+	path = strings.TrimSuffix(path, "/")
+	switch r.Method {
+	case http.MethodPut:
+		s.changed(path, OpWrite)
+	case http.MethodDelete:
+		s.changed(path, OpRemove)
+	case http.MethodPatch:
+		if strings.Contains(r.Header.Get("Content-Type"), "application/x-tar") {
+			// an archive can touch anything below path
+			s.changed(path, OpReset)
+		} else {
+			s.changed(path, OpMeta)
+		}
+	case "MOVE", "COPY":
+		dest, _, err := s.parseDestination(r.Header.Get("Destination"))
+		if err != nil {
+			return
+		}
+		if r.Method == "MOVE" {
+			s.changed(path, OpRemove)
+		}
+		s.changed(strings.TrimSuffix(dest, "/"), OpWrite)
+	}
+}
+
+// statusWriter remembers the status of a response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(p)
+}
@@ -0,0 +1,258 @@
+package httpfs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+
+	"tractor.dev/wanix/fs"
+)
+
+// ErrConflict is returned when a conditional request fails because the
+// resource changed since its ETag was read, usually because another client
+// wrote to it.
+var ErrConflict = errors.New("httpfs: resource changed on server")
+
+type ifMatchKey struct{}
+
+// WithIfMatch returns a context that makes writes with it conditional on
+// the resource still having the given ETag. Writes fail with ErrConflict
+// otherwise.
+func WithIfMatch(ctx context.Context, etag string) context.Context {
+	return context.WithValue(ctx, ifMatchKey{}, etag)
+}
+
+// setPreconditions adds conditional headers for ctx to req.
+func setPreconditions(ctx context.Context, req *http.Request) {
+	if etag, ok := ctx.Value(ifMatchKey{}).(string); ok && etag != "" {
+		req.Header.Set("If-Match", etag)
+	}
+}
+
+// strongETag returns a quoted strong ETag for the data written to h.
+func strongETag(h hash.Hash) string {
+	return `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+}
+
+// writeMeta adds the metadata served in headers to an ETag hash, since
+// it is part of what clients see.
+func writeMeta(h hash.Hash, info fs.FileInfo) {
+	uid, gid := ownership(info)
+	fmt.Fprintf(h, "%d %v %d %d:%d\n", info.Size(), info.Mode(), info.ModTime().UnixNano(), uid, gid)
+}
+
+// etag returns the ETag of path. Directory and symlink ETags hash their
+// listing or target along with their metadata.
+//
+// File ETags are computed from metadata alone, so they're cheap even for
+// large files, and have two parts: a validator of the data made from the
+// size, modification time and version, and a validator of the mode and
+// ownership. Cache revalidation with If-None-Match compares both, while
+// If-Match only compares the data, so a Chmod doesn't make a client's
+// pending write conflict with itself.
+func (s *Server) etag(path string, info fs.FileInfo) (string, error) {
+	if info.Mode().IsRegular() {
+		stamp := fmt.Appendf(nil, "%d %d", info.Size(), info.ModTime().UnixNano())
+		if epoch, v := s.version(path); v != 0 {
+			stamp = fmt.Appendf(stamp, " %s %d", epoch, v)
+		}
+		data := sha256.Sum256(stamp)
+		uid, gid := ownership(info)
+		meta := sha256.Sum256(fmt.Appendf(nil, "%v %d:%d", info.Mode(), uid, gid))
+		return `"` + hex.EncodeToString(data[:12]) + "-" + hex.EncodeToString(meta[:4]) + `"`, nil
+	}
+
+	h := sha256.New()
+	writeMeta(h, info)
+	if info.IsDir() {
+		l, err := s.listing(path, info)
+		if err != nil {
+			return "", err
+		}
+		h.Write(l.sum[:])
+	} else if info.Mode()&fs.ModeSymlink != 0 {
+		target, err := fs.Readlink(s.fs, path)
+		if err != nil {
+			return "", err
+		}
+		io.WriteString(h, target)
+	}
+	return strongETag(h), nil
+}
+
+// versions records the changes made through the server, since writes
+// within one tick of a coarse clock, or moving a file of the same size
+// and modification time into place, leave the metadata file ETags are
+// made from as it was. Changes made to the filesystem directly are only
+// seen through the metadata.
+type versions struct {
+	mu    sync.Mutex
+	last  uint64
+	paths map[string]uint64
+}
+
+// bump gives paths, and so everything below them, a new version.
+func (s *Server) bump(paths ...string) {
+	s.versions.mu.Lock()
+	defer s.versions.mu.Unlock()
+	if s.versions.paths == nil {
+		s.versions.paths = make(map[string]uint64)
+	}
+	for _, p := range paths {
+		s.versions.last++
+		s.versions.paths[p] = s.versions.last
+	}
+}
+
+// version returns the server epoch and the version of path, which is
+// the latest of those of path and its parents. Versions only grow, so
+// any bump changes it. It is zero if none was ever bumped, so ETags of
+// files untouched by the server stay the same across restarts.
+func (s *Server) version(name string) (string, uint64) {
+	s.versions.mu.Lock()
+	defer s.versions.mu.Unlock()
+	var v uint64
+	for p := name; ; p = path.Dir(p) {
+		v = max(v, s.versions.paths[p])
+		if p == "." || p == "/" {
+			break
+		}
+	}
+	return s.epoch(), v
+}
+
+// noteChange bumps the versions of the paths a successful request changed.
+func (s *Server) noteChange(w *statusWriter, r *http.Request, name string) {
+	if w.status >= 300 {
+		return
+	}
+	s.forgetListings()
+	s.bump(s.modifies(r, name)...)
+}
+
+// modifies returns the paths r changes the contents of. PATCH requests
+// that only change the mode or ownership are left out, as they don't
+// change the data part of file ETags.
+func (s *Server) modifies(r *http.Request, name string) []string {
+	name = path.Clean(name)
+	switch r.Method {
+	case http.MethodPut, http.MethodDelete:
+		return []string{name}
+	case http.MethodPatch:
+		if strings.Contains(r.Header.Get("Content-Type"), "application/x-tar") ||
+			r.Header.Get("Content-Modified") != "" {
+			return []string{name}
+		}
+	case "MOVE", "COPY":
+		dest, _, err := s.parseDestination(r.Header.Get("Destination"))
+		if err != nil {
+			return nil
+		}
+		if r.Method == "MOVE" {
+			return []string{name, path.Clean(dest)}
+		}
+		return []string{path.Clean(dest)}
+	}
+	return nil
+}
+
+// checkPreconditions evaluates If-Match and If-None-Match for a request
+// that modifies path. It writes a 412 response and returns false if the
+// request should not go ahead.
+func (s *Server) checkPreconditions(w http.ResponseWriter, r *http.Request, path string) bool {
+	if r.Header.Get("If-Match") == "" && r.Header.Get("If-None-Match") == "" {
+		return true
+	}
+	var etag string
+	if info, err := fs.StatContext(fs.WithNoFollow(r.Context()), s.fs, path); err == nil {
+		if etag, err = s.etag(path, info); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return false
+		}
+	}
+	return checkETag(w, r, etag)
+}
+
+// checkETag evaluates If-Match and If-None-Match against etag, which is
+// empty if the resource doesn't exist. Failed If-None-Match conditions on
+// GET and HEAD are answered with 304, everything else with 412.
+func checkETag(w http.ResponseWriter, r *http.Request, etag string) bool {
+	if im := r.Header.Get("If-Match"); im != "" && !etagMatch(im, etag, false, dataETag) {
+		http.Error(w, "Precondition Failed\n", http.StatusPreconditionFailed)
+		return false
+	}
+	if inm := r.Header.Get("If-None-Match"); inm != "" && etagMatch(inm, etag, true, nil) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			if etag != "" {
+				w.Header().Set("ETag", etag)
+			}
+			w.WriteHeader(http.StatusNotModified)
+			return false
+		}
+		http.Error(w, "Precondition Failed\n", http.StatusPreconditionFailed)
+		return false
+	}
+	return true
+}
+
+// etagMatch reports whether etag is in the list of ETags in header. "*"
+// matches any existing resource. Weak ETags only match when weak is set.
+// If part is set, only the parts of the ETags it returns are compared.
+func etagMatch(header, etag string, weak bool, part func(string) string) bool {
+	if etag == "" {
+		return false
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	if part != nil {
+		etag = part(etag)
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if strings.HasPrefix(candidate, "W/") {
+			if !weak {
+				continue
+			}
+			candidate = candidate[2:]
+		}
+		if part != nil {
+			candidate = part(candidate)
+		}
+		if candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// dataETag returns the part of a file ETag that validates its data, or
+// etag itself if it has only one part.
+func dataETag(etag string) string {
+	if data, _, ok := strings.Cut(etag, "-"); ok {
+		return data + `"`
+	}
+	return etag
+}
+
+// ownership returns the uid and gid served for info.
+func ownership(info fs.FileInfo) (uid, gid int) {
+	if sys := info.Sys(); sys != nil {
+		if stat, ok := sys.(interface {
+			Uid() int
+			Gid() int
+		}); ok {
+			return stat.Uid(), stat.Gid()
+		}
+	}
+	return 0, 0
+}
@@ -28,8 +28,13 @@ type Node struct {
 	content []byte
 	closed  bool
 	isDirty bool
+	etag    string
 	iter    *fskit.DirIter
 
+	// remote reads content on demand when the server supports ranges.
+	// It is dropped in favor of content once the file is written to.
+	remote *rangeReader
+
 	// Directory-specific fields
 	entries []*Node // parsed directory entries (when it's a directory)
 
@@ -86,6 +91,7 @@ func ParseNode(fsys wrappedFS, path string, headers http.Header, content []byte)
 		content: raw,
 		mode:    mode,
 		modTime: parseModTime(headers.Get("Content-Modified")),
+		etag:    headers.Get("ETag"),
 		isDir:   isDir,
 		entries: entries,
 		log:     slog.Default(), // for now
@@ -105,6 +111,10 @@ func (n *Node) ModTime() time.Time { return n.modTime }
 func (n *Node) IsDir() bool        { return n.isDir }
 func (n *Node) Sys() interface{}   { return nil }
 
+// ETag returns the server's ETag for the version of the node that was
+// read, if it sent one.
+func (n *Node) ETag() string { return n.etag }
+
 // fs.DirEntry interface implementation
 func (n *Node) Type() fs.FileMode {
 	return n.mode.Type()
@@ -132,6 +142,12 @@ func (n *Node) Read(p []byte) (int, error) {
 		return 0, fs.ErrInvalid
 	}
 
+	if n.remote != nil {
+		c, err := n.remote.read(p, n.pos)
+		n.pos += int64(c)
+		return c, err
+	}
+
 	if n.pos >= int64(len(n.content)) {
 		return 0, io.EOF
 	}
@@ -141,10 +157,53 @@ func (n *Node) Read(p []byte) (int, error) {
 	return c, nil
 }
 
+func (n *Node) ReadAt(p []byte, off int64) (int, error) {
+	if n.closed {
+		return 0, fs.ErrClosed
+	}
+	if n.isDir {
+		return 0, fs.ErrInvalid
+	}
+
+	if n.remote != nil {
+		return n.remote.ReadAt(p, off)
+	}
+
+	if off < 0 {
+		return 0, fs.ErrInvalid
+	}
+	if off >= int64(len(n.content)) {
+		return 0, io.EOF
+	}
+	c := copy(p, n.content[off:])
+	if c < len(p) {
+		return c, io.EOF
+	}
+	return c, nil
+}
+
+// load reads the rest of a remote file into content so it can be modified.
+func (n *Node) load() error {
+	if n.remote == nil {
+		return nil
+	}
+	content := make([]byte, n.remote.size)
+	if _, err := n.remote.ReadAt(content, 0); err != nil && err != io.EOF {
+		return err
+	}
+	n.remote.Close()
+	n.remote = nil
+	n.content = content
+	return nil
+}
+
 func (f *Node) Write(p []byte) (int, error) {
 	if f.closed {
 		return 0, fs.ErrClosed
 	}
+	if err := f.load(); err != nil {
+		return 0, err
+	}
 
 	// Extend content if necessary
 	newLen := f.pos + int64(len(p))
@@ -178,6 +237,9 @@ func (n *Node) Seek(offset int64, whence int) (int64, error) {
 		newPos = n.pos + offset
 	case io.SeekEnd:
 		newPos = int64(len(n.content)) + offset
+		if n.remote != nil {
+			newPos = n.remote.size + offset
+		}
 	default:
 		return 0, fmt.Errorf("invalid whence")
 	}
@@ -197,16 +259,21 @@ func (n *Node) Close() error {
 	n.closed = true
 	// Reset iterator so it can be recreated fresh if the node is reopened
 	n.iter = nil
+	if n.remote != nil {
+		n.remote.Close()
+	}
 
 	if n.isDirty && !n.isDir {
+		// Only write back if nobody else changed the file since we read it
+		ctx := WithIfMatch(context.Background(), n.etag)
 		// Fetch current metadata to preserve any Chmod/Chtimes changes
 		info, err := fs.Stat(n.fs, n.path)
 		if err == nil {
 			// Use current server metadata (preserves Chmod/Chtimes)
-			return n.fs.unwrap().WriteFileContext(context.Background(), n.path, n.content, info.Mode(), info.ModTime())
+			return n.fs.unwrap().WriteFileContext(ctx, n.path, n.content, info.Mode(), info.ModTime())
 		}
 		// Fallback for new files or if stat fails
-		return n.fs.unwrap().WriteFileContext(context.Background(), n.path, n.content, n.mode, n.modTime)
+		return n.fs.unwrap().WriteFileContext(ctx, n.path, n.content, n.mode, n.modTime)
 	}
 	return nil
 }
@@ -0,0 +1,277 @@
+package httpfs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"tractor.dev/wanix/fs"
+)
+
+// BlockSize is the unit file contents are fetched and cached in when
+// reading at arbitrary offsets.
+const BlockSize = 64 << 10
+
+// maxCachedBlocks bounds the block cache of each open file.
+const maxCachedBlocks = 256
+
+// rangeReader reads a remote file on demand using HTTP Range requests.
+// Random access goes through a small LRU cache of blocks, while sequential
+// reads share a single streaming request for as long as they stay in order.
+type rangeReader struct {
+	ctx  context.Context // the open's, for the requests made on reads
+	fs   *FS
+	name string
+	size int64
+	etag string // requests fail with ErrConflict if the file changes
+
+	mu        sync.Mutex
+	blocks    map[int64][]byte
+	lru       []int64 // least recently used first
+	stream    io.ReadCloser
+	streamOff int64
+}
+
+func newRangeReader(ctx context.Context, fsys *FS, name string, size int64, etag string) *rangeReader {
+	return &rangeReader{
+		ctx:    ctx,
+		fs:     fsys,
+		name:   name,
+		size:   size,
+		etag:   etag,
+		blocks: make(map[int64][]byte),
+	}
+}
+
+// ReadAt reads len(p) bytes at off, fetching any blocks not in the cache
+// with a single request.
+func (r *rangeReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, &fs.PathError{Op: "read", Path: r.name, Err: fs.ErrInvalid}
+	}
+	if off >= r.size {
+		return 0, io.EOF
+	}
+	end := min(off+int64(len(p)), r.size)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := 0
+	for pos := off; pos < end; pos = off + int64(n) {
+		idx := pos / BlockSize
+		b, ok := r.cached(idx)
+		if !ok {
+			var err error
+			if b, err = r.fetchBlocks(idx, (end-1)/BlockSize); err != nil {
+				return n, err
+			}
+		}
+		n += copy(p[n:end-off], b[pos-idx*BlockSize:])
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// read is used for sequential reads at off. A read continuing where the
+// last one stopped is served from the open stream, otherwise from the
+// cache, and failing that a new stream is started at off.
+func (r *rangeReader) read(p []byte, off int64) (int, error) {
+	if off >= r.size {
+		return 0, io.EOF
+	}
+	p = p[:min(int64(len(p)), r.size-off)]
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.stream == nil || r.streamOff != off {
+		idx := off / BlockSize
+		if b, ok := r.cached(idx); ok {
+			return copy(p, b[off-idx*BlockSize:]), nil
+		}
+		if err := r.openStream(off); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.stream.Read(p)
+	r.streamOff += int64(n)
+	if err != nil {
+		r.closeStream()
+		if err == io.EOF {
+			// we haven't reached the end of the file yet, so the stream
+			// ending is only an error if it gave us nothing
+			err = nil
+			if n == 0 {
+				err = io.ErrUnexpectedEOF
+			}
+		}
+	}
+	return n, err
+}
+
+// cached returns block idx if it is cached. Must be called with r.mu held.
+func (r *rangeReader) cached(idx int64) ([]byte, bool) {
+	b, ok := r.blocks[idx]
+	if ok {
+		r.touch(idx)
+	}
+	return b, ok
+}
+
+// store adds block idx to the cache, evicting the least recently used
+// block if it is full. Must be called with r.mu held.
+func (r *rangeReader) store(idx int64, b []byte) {
+	if _, ok := r.blocks[idx]; !ok && len(r.blocks) >= maxCachedBlocks {
+		delete(r.blocks, r.lru[0])
+		r.lru = r.lru[1:]
+	}
+	r.blocks[idx] = b
+	r.touch(idx)
+}
+
+func (r *rangeReader) touch(idx int64) {
+	if i := slices.Index(r.lru, idx); i >= 0 {
+		r.lru = slices.Delete(r.lru, i, i+1)
+	}
+	r.lru = append(r.lru, idx)
+}
+
+// fetchBlocks fetches blocks first through the block before the next one
+// already cached, or last, and returns block first. Must be called with
+// r.mu held.
+func (r *rangeReader) fetchBlocks(first, last int64) ([]byte, error) {
+	end := first + 1
+	for end <= last {
+		if _, ok := r.blocks[end]; ok {
+			break
+		}
+		end++
+	}
+	start := first * BlockSize
+	stop := min(end*BlockSize, r.size)
+
+	resp, err := r.get(start, stop-1)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data := make([]byte, stop-start)
+	if resp.StatusCode == http.StatusOK {
+		// the server ignored the range, so skip to what we asked for
+		if _, err := io.CopyN(io.Discard, resp.Body, start); err != nil {
+			return nil, fmt.Errorf("httpfs: reading %s: %w", r.name, err)
+		}
+	}
+	if _, err := io.ReadFull(resp.Body, data); err != nil {
+		return nil, fmt.Errorf("httpfs: reading %s: %w", r.name, err)
+	}
+	for idx := first; idx < end; idx++ {
+		off := (idx - first) * BlockSize
+		r.store(idx, data[off:min(off+BlockSize, int64(len(data)))])
+	}
+	return r.blocks[first], nil
+}
+
+// openStream starts a request for everything from off to the end of the
+// file. Must be called with r.mu held.
+func (r *rangeReader) openStream(off int64) error {
+	r.closeStream()
+	resp, err := r.get(off, -1)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusOK && off > 0 {
+		if _, err := io.CopyN(io.Discard, resp.Body, off); err != nil {
+			resp.Body.Close()
+			return fmt.Errorf("httpfs: reading %s: %w", r.name, err)
+		}
+	}
+	r.stream = resp.Body
+	r.streamOff = off
+	return nil
+}
+
+func (r *rangeReader) closeStream() {
+	if r.stream != nil {
+		r.stream.Close()
+		r.stream = nil
+	}
+}
+
+// get requests bytes start through end inclusive, or through the end of
+// the file if end is negative. The response is either 206 with the range
+// or 200 with the whole file.
+func (r *rangeReader) get(start, end int64) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(r.ctx, "GET", r.fs.buildURL(r.name), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", formatRange(start, end))
+	if r.etag != "" {
+		req.Header.Set("If-Match", r.etag)
+	}
+	resp, err := r.fs.doRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		if s, _, ok := parseContentRange(resp.Header.Get("Content-Range")); !ok || s != start {
+			resp.Body.Close()
+			return nil, fmt.Errorf("httpfs: reading %s: unexpected Content-Range %q", r.name, resp.Header.Get("Content-Range"))
+		}
+		return resp, nil
+	case http.StatusOK:
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	return nil, pathError("read", r.name, resp)
+}
+
+func (r *rangeReader) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closeStream()
+	r.blocks = nil
+	r.lru = nil
+	return nil
+}
+
+func formatRange(start, end int64) string {
+	if end < 0 {
+		return fmt.Sprintf("bytes=%d-", start)
+	}
+	return fmt.Sprintf("bytes=%d-%d", start, end)
+}
+
+// parseContentRange parses the start and end of a "bytes start-end/size"
+// Content-Range header.
+func parseContentRange(contentRange string) (start, end int64, ok bool) {
+	spec, found := strings.CutPrefix(contentRange, "bytes ")
+	if !found {
+		return 0, 0, false
+	}
+	spec, _, _ = strings.Cut(spec, "/")
+	s, e, found := strings.Cut(spec, "-")
+	if !found {
+		return 0, 0, false
+	}
+	start, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	end, err = strconv.ParseInt(e, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return start, end, true
+}
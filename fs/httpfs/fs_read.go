@@ -7,10 +7,11 @@ import (
 	"iter"
 	"mime"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 
 	"tractor.dev/wanix/fs"
-	"tractor.dev/wanix/fs/memfs"
 )
 
 // Open opens the named file for reading
@@ -30,6 +31,9 @@ func (fsys *FS) OpenContext(ctx context.Context, name string) (fs.File, error) {
 	if err != nil {
 		return nil, err
 	}
+	// Only ask for the first block. Servers that support ranges answer
+	// with 206 and the rest of the file is read on demand.
+	req.Header.Set("Range", formatRange(0, BlockSize-1))
 
 	resp, err := fsys.doRequest(req)
 	if err != nil {
@@ -37,7 +41,24 @@ func (fsys *FS) OpenContext(ctx context.Context, name string) (fs.File, error) {
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusPartialContent:
+		block, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		node, err := ParseNode(fsys, name, resp.Header, nil)
+		if err != nil {
+			return nil, err
+		}
+		node.remote = newRangeReader(ctx, fsys, name, node.size, node.etag)
+		node.remote.store(0, block)
+		return node, nil
+	case http.StatusRequestedRangeNotSatisfiable:
+		// the file is empty
+		return ParseNode(fsys, name, resp.Header, nil)
+	default:
 		return nil, pathError("open", name, resp)
 	}
 
@@ -65,12 +86,25 @@ func (fsys *FS) StatContext(ctx context.Context, name string) (fs.FileInfo, erro
 		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
 	}
 	fsys.log.Debug("Stat", "name", name)
+	node, err := fsys.statIfNoneMatch(ctx, name, "")
+	if err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+// statIfNoneMatch performs a HEAD request for name. If etag is set and
+// still current it returns a nil Node without error.
+func (fsys *FS) statIfNoneMatch(ctx context.Context, name, etag string) (*Node, error) {
 	url := fsys.buildURL(name)
 
 	req, err := http.NewRequestWithContext(ctx, "HEAD", url, nil)
 	if err != nil {
 		return nil, err
 	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
 
 	resp, err := fsys.doRequest(req)
 	if err != nil {
@@ -78,16 +112,14 @@ func (fsys *FS) StatContext(ctx context.Context, name string) (fs.FileInfo, erro
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotModified && etag != "" {
+		return nil, nil
+	}
 	if resp.StatusCode != http.StatusOK {
 		return nil, pathError("stat", name, resp)
 	}
 
-	node, err := ParseNode(fsys, name, resp.Header, nil)
-	if err != nil {
-		return nil, err
-	}
-
-	return node, nil
+	return ParseNode(fsys, name, resp.Header, nil)
 }
 
 // ReadDir reads the named directory and returns a list of directory entries
@@ -95,46 +127,52 @@ func (fsys *FS) ReadDir(name string) ([]fs.DirEntry, error) {
 	return fsys.ReadDirContext(context.Background(), name)
 }
 
-// ReadDirContext reads the named directory with context
+// ReadDirContext reads the named directory with context. Listings are
+// requested a page at a time, and servers without paging send them whole.
 func (fsys *FS) ReadDirContext(ctx context.Context, name string) ([]fs.DirEntry, error) {
 	fsys.log.Debug("ReadDir", "name", name)
-	url := fsys.buildURL(name)
-
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
-	if err != nil {
-		return nil, err
-	}
-
-	resp, err := fsys.doRequest(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, pathError("readdir", name, resp)
-	}
 
-	content, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
+	var entries []fs.DirEntry
+	cursor := ""
+	for {
+		params := url.Values{"limit": {strconv.Itoa(fsys.pageSize)}}
+		if cursor != "" {
+			params.Set("cursor", cursor)
+		}
+		req, err := http.NewRequestWithContext(ctx, "GET", withQuery(fsys.buildURL(name), params), nil)
+		if err != nil {
+			return nil, err
+		}
 
-	node, err := ParseNode(fsys, name, resp.Header, content)
-	if err != nil {
-		return nil, err
-	}
+		resp, err := fsys.doRequest(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			defer resp.Body.Close()
+			return nil, pathError("readdir", name, resp)
+		}
+		content, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
 
-	if !node.IsDir() {
-		return nil, fmt.Errorf("not a directory")
-	}
+		node, err := ParseNode(fsys, name, resp.Header, content)
+		if err != nil {
+			return nil, err
+		}
+		if !node.IsDir() {
+			return nil, fmt.Errorf("not a directory")
+		}
+		entries = append(entries, node.Entries()...)
 
-	// Convert fileNode entries to fs.DirEntry
-	entries := make([]fs.DirEntry, len(node.Entries()))
-	for i, entry := range node.Entries() {
-		entries[i] = entry
+		next := resp.Header.Get("Listing-Cursor")
+		if next == "" || next == cursor {
+			return entries, nil
+		}
+		cursor = next
 	}
-	return entries, nil
 }
 
 func (fsys *FS) Readlink(name string) (string, error) {
@@ -208,24 +246,17 @@ func (fsys *FS) ReadFileContext(ctx context.Context, name string) ([]byte, error
 	return content, nil
 }
 
-func (fsys *FS) Index(ctx context.Context, name string) (fs.FS, error) {
-	index := memfs.New()
-	for n, err := range fsys.streamTree(ctx, name) {
-		if err != nil {
-			return nil, err
-		}
-		index.SetNode(n.Path(), n.ToNode())
-	}
-	return index, nil
-}
-
-// streamTree fetches a directory tree using multipart response
-func (fsys *FS) streamTree(ctx context.Context, name string) iter.Seq2[*Node, error] {
+// streamTree fetches a directory tree using multipart response, down to
+// depth levels below name, or all of it if depth is negative.
+func (fsys *FS) streamTree(ctx context.Context, name string, depth int) iter.Seq2[*Node, error] {
 	return func(yield func(*Node, error) bool) {
 		// Request the directory tree with "..." suffix for streaming recursive multipart response
-		url := fsys.buildURL(name + "/...")
+		treeURL := fsys.buildURL(name + "/...")
+		if depth >= 0 {
+			treeURL = withQuery(treeURL, url.Values{"depth": {strconv.Itoa(depth)}})
+		}
 
-		req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+		req, err := http.NewRequestWithContext(ctx, "GET", treeURL, nil)
 		if err != nil {
 			yield(nil, err)
 			return
@@ -18,7 +18,7 @@ import (
 func (fsys *FS) Patch(ctx context.Context, name string, tarBuf bytes.Buffer) error {
 	fsys.log.Debug("Patch", "name", name)
 	url := fsys.buildURL(name)
-	req, err := http.NewRequestWithContext(context.Background(), "PATCH", url, &tarBuf)
+	req, err := http.NewRequestWithContext(ctx, "PATCH", url, &tarBuf)
 	if err != nil {
 		return err
 	}
@@ -27,6 +27,7 @@ func (fsys *FS) Patch(ctx context.Context, name string, tarBuf bytes.Buffer) err
 	req.Header.Set("Content-Type", "application/x-tar")
 	req.Header.Set("Change-Timestamp", strconv.FormatInt(time.Now().UnixMicro(), 10))
 
+	setPreconditions(ctx, req)
 	resp, err := fsys.doRequest(req)
 	if err != nil {
 		return err
@@ -64,6 +65,7 @@ func (fsys *FS) WriteFileContext(ctx context.Context, name string, data []byte,
 	req.Header.Set("Content-Length", strconv.Itoa(len(data)))
 	req.Header.Set("Change-Timestamp", strconv.FormatInt(time.Now().UnixMicro(), 10))
 
+	setPreconditions(ctx, req)
 	resp, err := fsys.doRequest(req)
 	if err != nil {
 		return err
@@ -171,6 +173,7 @@ func (fsys *FS) SymlinkContext(ctx context.Context, oldname, newname string) err
 	req.Header.Set("Content-Ownership", "0:0")
 	req.Header.Set("Change-Timestamp", strconv.FormatInt(time.Now().UnixMicro(), 10))
 
+	setPreconditions(ctx, req)
 	resp, err := fsys.doRequest(req)
 	if err != nil {
 		return err
@@ -203,6 +206,7 @@ func (fsys *FS) RenameContext(ctx context.Context, oldname, newname string) erro
 	}
 	req.Header.Set("Destination", dest.Path)
 
+	setPreconditions(ctx, req)
 	resp, err := fsys.doRequest(req)
 	if err != nil {
 		return err
@@ -239,6 +243,7 @@ func (fsys *FS) MkdirContext(ctx context.Context, name string, perm fs.FileMode)
 	req.Header.Set("Content-Length", "0")
 	req.Header.Set("Change-Timestamp", strconv.FormatInt(time.Now().UnixMicro(), 10))
 
+	setPreconditions(ctx, req)
 	resp, err := fsys.doRequest(req)
 	if err != nil {
 		return err
@@ -267,6 +272,7 @@ func (fsys *FS) RemoveContext(ctx context.Context, name string) error {
 		return err
 	}
 
+	setPreconditions(ctx, req)
 	resp, err := fsys.doRequest(req)
 	if err != nil {
 		return err
@@ -298,6 +304,7 @@ func (fsys *FS) ChmodContext(ctx context.Context, name string, mode fs.FileMode)
 	req.Header.Set("Content-Mode", formatMode(mode))
 	req.Header.Set("Change-Timestamp", strconv.FormatInt(time.Now().UnixMicro(), 10))
 
+	setPreconditions(ctx, req)
 	resp, err := fsys.doRequest(req)
 	if err != nil {
 		return err
@@ -330,6 +337,7 @@ func (fsys *FS) ChownContect(ctx context.Context, name string, uid, gid int) err
 	req.Header.Set("Content-Ownership", ownership)
 	req.Header.Set("Change-Timestamp", strconv.FormatInt(time.Now().UnixMicro(), 10))
 
+	setPreconditions(ctx, req)
 	resp, err := fsys.doRequest(req)
 	if err != nil {
 		return err
@@ -362,6 +370,7 @@ func (fsys *FS) ChtimesContext(ctx context.Context, name string, atime time.Time
 	req.Header.Set("Content-Modified", strconv.FormatInt(mtime.Unix(), 10))
 	req.Header.Set("Change-Timestamp", strconv.FormatInt(time.Now().UnixMicro(), 10))
 
+	setPreconditions(ctx, req)
 	resp, err := fsys.doRequest(req)
 	if err != nil {
 		return err
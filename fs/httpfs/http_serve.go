@@ -3,6 +3,7 @@ package httpfs
 import (
 	"archive/tar"
 	"bytes"
+	"crypto/sha256"
 	"errors"
 	"fmt"
 	"io"
@@ -12,6 +13,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"tractor.dev/wanix/fs"
@@ -21,6 +23,10 @@ import (
 
 const (
 	protocolMethods = "GET, HEAD, PUT, PATCH, DELETE, MOVE, COPY, OPTIONS"
+
+	// DefaultMaxPageSize is the largest page of directory entries served
+	// to clients that ask for paged listings.
+	DefaultMaxPageSize = 5000
 )
 
 func writeOK(w http.ResponseWriter) {
@@ -40,6 +46,17 @@ func acceptsMultipart(accept string) bool {
 type Server struct {
 	fs     fs.FS
 	prefix string
+
+	versions versions
+	listings listings
+
+	feed     changeFeed
+	feedOnce sync.Once
+
+	epochID   string
+	epochOnce sync.Once
+
+	maxPageSize int
 }
 
 // NewServer creates a new HTTP server for the given filesystem
@@ -55,6 +72,36 @@ func NewServerWithPrefix(fsys fs.FS, prefix string) *Server {
 	}
 }
 
+// SetMaxPageSize sets the largest page of directory entries served to
+// clients that ask for paged listings. Listings requested without a limit
+// are always served whole.
+func (s *Server) SetMaxPageSize(n int) {
+	s.maxPageSize = n
+}
+
+// page returns the cursor and limit of a paged listing request. A zero
+// limit means the whole listing.
+func (s *Server) page(r *http.Request) (cursor string, limit int, err error) {
+	q := r.URL.Query()
+	cursor = q.Get("cursor")
+	if l := q.Get("limit"); l != "" {
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit <= 0 {
+			return "", 0, fmt.Errorf("invalid limit %q", l)
+		}
+	} else if cursor != "" {
+		limit = DefaultMaxPageSize
+	}
+	max := s.maxPageSize
+	if max <= 0 {
+		max = DefaultMaxPageSize
+	}
+	if limit > max {
+		limit = max
+	}
+	return cursor, limit, nil
+}
+
 // ServeHTTP implements http.Handler
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// Strip prefix if present
@@ -64,6 +111,15 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		path = "."
 	}
 
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+	default:
+		sw := &statusWriter{ResponseWriter: w}
+		defer s.publishChange(sw, r, path)
+		defer s.noteChange(sw, r, path)
+		w = sw
+	}
+
 	switch r.Method {
 	case http.MethodGet:
 		s.handleGet(w, r, path)
@@ -90,15 +146,28 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 // handleGet handles GET requests for files and directories
 func (s *Server) handleGet(w http.ResponseWriter, r *http.Request, path string) {
-	// Check for multipart directory streaming suffixes
-	if strings.HasSuffix(path, "/...") {
+	// Check for the change stream and multipart directory streaming suffixes
+	if path == ".../changes" || strings.HasSuffix(path, "/.../changes") {
+		path = strings.TrimSuffix(strings.TrimSuffix(path, ".../changes"), "/")
+		if path == "" {
+			path = "."
+		}
+		s.handleChanges(w, r, path)
+		return
+	} else if path == "..." || strings.HasSuffix(path, "/...") {
 		// Recursive tree streaming
-		path = strings.TrimSuffix(path, "/...")
+		path = strings.TrimSuffix(strings.TrimSuffix(path, "..."), "/")
+		if path == "" {
+			path = "."
+		}
 		s.handleRecursiveStream(w, r, path)
 		return
-	} else if strings.HasSuffix(path, "/:") {
+	} else if path == ":" || strings.HasSuffix(path, "/:") {
 		// Directory metadata streaming (SPEC extension)
-		path = strings.TrimSuffix(path, "/:")
+		path = strings.TrimSuffix(strings.TrimSuffix(path, ":"), "/")
+		if path == "" {
+			path = "."
+		}
 		s.handleDirStream(w, r, path, 1)
 		return
 	}
@@ -133,6 +202,9 @@ func (s *Server) handleGet(w http.ResponseWriter, r *http.Request, path string)
 	}
 
 	s.writeMetadataHeaders(w, info, path)
+	if !s.writeETag(w, r, path, info) {
+		return
+	}
 
 	// Handle symlinks
 	if info.Mode()&fs.ModeSymlink != 0 {
@@ -153,16 +225,24 @@ func (s *Server) handleGet(w http.ResponseWriter, r *http.Request, path string)
 	}
 
 	if info.IsDir() {
-		listing, err := s.formatDirListing(path)
+		cursor, limit, err := s.page(r)
+		if err != nil {
+			http.Error(w, err.Error()+"\n", http.StatusBadRequest)
+			return
+		}
+		l, err := s.listing(path, info)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		lo, hi, next := l.page(cursor, limit)
 
 		w.Header().Del("Content-Length")
-		w.Header().Del("ETag")
+		if next != "" {
+			w.Header().Set("Listing-Cursor", next)
+		}
 		w.WriteHeader(http.StatusOK)
-		w.Write(listing)
+		w.Write(l.body(lo, hi))
 	} else {
 		file, err := s.fs.Open(path)
 		if err != nil {
@@ -171,6 +251,14 @@ func (s *Server) handleGet(w http.ResponseWriter, r *http.Request, path string)
 		}
 		defer file.Close()
 
+		if rs, ok := file.(io.ReadSeeker); ok {
+			// ServeContent answers Range requests with 206. If-Match was
+			// checked already, against the data part of the ETag only.
+			r = r.Clone(r.Context())
+			r.Header.Del("If-Match")
+			http.ServeContent(w, r, "", info.ModTime(), rs)
+			return
+		}
 		w.WriteHeader(http.StatusOK)
 		io.Copy(w, file)
 	}
@@ -191,11 +279,30 @@ func (s *Server) handleHead(w http.ResponseWriter, r *http.Request, path string)
 	}
 
 	s.writeMetadataHeaders(w, info, path)
+	if !s.writeETag(w, r, path, info) {
+		return
+	}
 	w.WriteHeader(http.StatusOK)
 }
 
+// writeETag sets the ETag header for path and evaluates conditional
+// headers against it, returning false if the response has been written.
+func (s *Server) writeETag(w http.ResponseWriter, r *http.Request, path string, info fs.FileInfo) bool {
+	etag, err := s.etag(path, info)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	w.Header().Set("ETag", etag)
+	return checkETag(w, r, etag)
+}
+
 // handlePut handles PUT requests for creating/updating files and directories
 func (s *Server) handlePut(w http.ResponseWriter, r *http.Request, path string) {
+	if !s.checkPreconditions(w, r, path) {
+		return
+	}
+
 	// Check if this is a directory creation
 	contentType := r.Header.Get("Content-Type")
 	isDir := contentType == "application/x-directory" || strings.HasSuffix(path, "/")
@@ -312,6 +419,9 @@ func (s *Server) writeFileContent(path string, content []byte, mode fs.FileMode)
 
 // handleDelete handles DELETE requests
 func (s *Server) handleDelete(w http.ResponseWriter, r *http.Request, path string) {
+	if !s.checkPreconditions(w, r, path) {
+		return
+	}
 	if _, err := fs.Stat(s.fs, path); err != nil {
 		if errors.Is(err, fs.ErrNotExist) {
 			writeNotFound(w)
@@ -331,6 +441,9 @@ func (s *Server) handleDelete(w http.ResponseWriter, r *http.Request, path strin
 
 // handlePatch handles PATCH requests for metadata updates
 func (s *Server) handlePatch(w http.ResponseWriter, r *http.Request, path string) {
+	if !s.checkPreconditions(w, r, path) {
+		return
+	}
 	if strings.Contains(r.Header.Get("Content-Type"), "application/x-tar") {
 		s.handleTarPatch(w, r, path)
 		return
@@ -339,6 +452,10 @@ func (s *Server) handlePatch(w http.ResponseWriter, r *http.Request, path string
 }
 
 func (s *Server) handleMoveCopy(w http.ResponseWriter, r *http.Request, path string, move bool) {
+	if !s.checkPreconditions(w, r, path) {
+		return
+	}
+
 	destFS, destHTTP, err := s.parseDestination(r.Header.Get("Destination"))
 	if err != nil {
 		http.Error(w, err.Error()+"\n", http.StatusBadRequest)
@@ -390,6 +507,7 @@ func (s *Server) handleMoveCopy(w http.ResponseWriter, r *http.Request, path str
 
 // handleDirStream handles directory metadata streaming with multipart response.
 // maxPartDepth controls how deep child parts go (1 = direct children only, 2 = r2fs Accept behavior).
+// Paged requests get the page of the listing and the parts below it.
 func (s *Server) handleDirStream(w http.ResponseWriter, r *http.Request, path string, maxPartDepth int) {
 	info, err := fs.Stat(s.fs, path)
 	if err != nil {
@@ -406,59 +524,45 @@ func (s *Server) handleDirStream(w http.ResponseWriter, r *http.Request, path st
 		return
 	}
 
-	childPaths, err := s.pathsWithinDepth(path, maxPartDepth)
+	cursor, limit, err := s.page(r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error()+"\n", http.StatusBadRequest)
 		return
 	}
-
-	var buf bytes.Buffer
-	mw := multipart.NewWriter(&buf)
-
-	dirListing, err := s.formatDirListing(path)
+	l, err := s.listing(path, info)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	lo, hi, next := l.page(cursor, limit)
 
-	part, err := mw.CreatePart(s.createPartHeaders(info, path, int64(len(dirListing))))
+	childPaths, err := s.pathsWithinDepth(path, l, lo, hi, maxPartDepth)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	part.Write(dirListing)
 
-	for _, childPath := range childPaths {
-		childInfo, err := fs.Stat(s.fs, childPath)
-		if err != nil {
-			continue
-		}
-
-		var partBody []byte
-		if childInfo.IsDir() {
-			partBody, err = s.formatDirListing(childPath)
-			if err != nil {
-				continue
-			}
-		}
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
 
-		part, err := mw.CreatePart(s.createPartHeaders(childInfo, childPath, int64(len(partBody))))
-		if err != nil {
-			continue
-		}
-		if len(partBody) > 0 {
-			part.Write(partBody)
-		}
+	if err := s.writePart(mw, path, info, l.body(lo, hi)); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, childPath := range childPaths {
+		s.writeChildPart(mw, childPath)
 	}
 
 	mw.Close()
-
-	w.Header().Set("Content-Type", "multipart/mixed; boundary="+mw.Boundary())
-	w.WriteHeader(http.StatusOK)
-	w.Write(buf.Bytes())
+	if next != "" {
+		w.Header().Set("Listing-Cursor", next)
+	}
+	s.writeMultipart(w, r, &buf, mw.Boundary())
 }
 
-// handleRecursiveStream handles recursive directory tree streaming
+// handleRecursiveStream handles recursive directory tree streaming. Parts
+// are written as the tree is walked, down to the depth in the depth query
+// parameter if there is one.
 func (s *Server) handleRecursiveStream(w http.ResponseWriter, r *http.Request, path string) {
 	info, err := fs.Stat(s.fs, path)
 	if err != nil {
@@ -475,54 +579,107 @@ func (s *Server) handleRecursiveStream(w http.ResponseWriter, r *http.Request, p
 		return
 	}
 
-	childPaths, err := s.pathsWithinDepth(path, -1)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	maxDepth := -1
+	if d := r.URL.Query().Get("depth"); d != "" {
+		maxDepth, err = strconv.Atoi(d)
+		if err != nil || maxDepth < 0 {
+			http.Error(w, fmt.Sprintf("invalid depth %q\n", d), http.StatusBadRequest)
+			return
+		}
 	}
 
-	var buf bytes.Buffer
-	mw := multipart.NewWriter(&buf)
-
-	dirListing, err := s.formatDirListing(path)
+	l, err := s.listing(path, info)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	part, err := mw.CreatePart(s.createPartHeaders(info, path, int64(len(dirListing))))
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	mw := multipart.NewWriter(w)
+	w.Header().Set("Content-Type", "multipart/mixed; boundary="+mw.Boundary())
+	w.WriteHeader(http.StatusOK)
+	if err := s.writePart(mw, path, info, l.data); err != nil {
 		return
 	}
-	part.Write(dirListing)
 
-	for _, childPath := range childPaths {
-		childInfo, err := fs.Stat(s.fs, childPath)
+	flusher, _ := w.(http.Flusher)
+	var walk func(dir string, depth int) error
+	walk = func(dir string, depth int) error {
+		if err := r.Context().Err(); err != nil {
+			return err
+		}
+		entries, err := fs.ReadDir(s.fs, dir)
 		if err != nil {
-			continue
+			return err
 		}
-
-		var partBody []byte
-		if childInfo.IsDir() {
-			partBody, err = s.formatDirListing(childPath)
-			if err != nil {
-				continue
+		sort.Slice(entries, func(i, j int) bool {
+			return entries[i].Name() < entries[j].Name()
+		})
+		for _, entry := range entries {
+			child := filepath.Join(dir, entry.Name())
+			if err := s.writeChildPart(mw, child); err != nil {
+				return err
 			}
+			if entry.IsDir() && (maxDepth < 0 || depth < maxDepth) {
+				if err := walk(child, depth+1); err != nil {
+					return err
+				}
+			}
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	}
+	if maxDepth != 0 {
+		if err := walk(path, 1); err != nil {
+			// the client sees a truncated multipart body
+			return
 		}
+	}
+	mw.Close()
+}
 
-		part, err := mw.CreatePart(s.createPartHeaders(childInfo, childPath, int64(len(partBody))))
+// writePart writes a multipart part for path with body.
+func (s *Server) writePart(mw *multipart.Writer, path string, info fs.FileInfo, body []byte) error {
+	part, err := mw.CreatePart(s.createPartHeaders(info, path, int64(len(body))))
+	if err != nil {
+		return err
+	}
+	_, err = part.Write(body)
+	return err
+}
+
+// writeChildPart writes a part for a descendant of a streamed directory,
+// skipping it if it can't be read.
+func (s *Server) writeChildPart(mw *multipart.Writer, childPath string) error {
+	childInfo, err := fs.Stat(s.fs, childPath)
+	if err != nil {
+		return nil
+	}
+	var partBody []byte
+	if childInfo.IsDir() {
+		l, err := s.listing(childPath, childInfo)
 		if err != nil {
-			continue
-		}
-		if len(partBody) > 0 {
-			part.Write(partBody)
+			return nil
 		}
+		partBody = l.data
 	}
+	return s.writePart(mw, childPath, childInfo, partBody)
+}
 
-	mw.Close()
+// writeMultipart writes a multipart response built in buf. Its ETag is a
+// hash of the parts, so clients can revalidate listings with If-None-Match.
+func (s *Server) writeMultipart(w http.ResponseWriter, r *http.Request, buf *bytes.Buffer, boundary string) {
+	h := sha256.New()
+	h.Write(bytes.ReplaceAll(buf.Bytes(), []byte(boundary), nil))
+	etag := strongETag(h)
 
-	w.Header().Set("Content-Type", "multipart/mixed; boundary="+mw.Boundary())
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Vary", "Accept")
+	if !checkETag(w, r, etag) {
+		return
+	}
+	w.Header().Set("Content-Type", "multipart/mixed; boundary="+boundary)
 	w.WriteHeader(http.StatusOK)
 	w.Write(buf.Bytes())
 }
@@ -551,16 +708,7 @@ func (s *Server) writeMetadataHeaders(w http.ResponseWriter, info fs.FileInfo, p
 	w.Header().Set("Content-Modified", strconv.FormatInt(info.ModTime().Unix(), 10))
 
 	// Content-Ownership (default to 0:0)
-	uid, gid := 0, 0
-	if sys := info.Sys(); sys != nil {
-		if stat, ok := sys.(interface {
-			Uid() int
-			Gid() int
-		}); ok {
-			uid = stat.Uid()
-			gid = stat.Gid()
-		}
-	}
+	uid, gid := ownership(info)
 	w.Header().Set("Content-Ownership", fmt.Sprintf("%d:%d", uid, gid))
 }
 
@@ -588,16 +736,7 @@ func (s *Server) createPartHeaders(info fs.FileInfo, path string, bodyLen int64)
 	headers["Content-Modified"] = []string{strconv.FormatInt(info.ModTime().Unix(), 10)}
 
 	// Content-Ownership
-	uid, gid := 0, 0
-	if sys := info.Sys(); sys != nil {
-		if stat, ok := sys.(interface {
-			Uid() int
-			Gid() int
-		}); ok {
-			uid = stat.Uid()
-			gid = stat.Gid()
-		}
-	}
+	uid, gid := ownership(info)
 	headers["Content-Ownership"] = []string{fmt.Sprintf("%d:%d", uid, gid)}
 
 	if bodyLen > 0 {
@@ -706,59 +845,46 @@ func (s *Server) parseDestination(dest string) (fsPath string, httpPath string,
 	return s.fsPath(dest), httpPath, nil
 }
 
-func (s *Server) formatDirListing(path string) ([]byte, error) {
-	entries, err := fs.ReadDir(s.fs, path)
-	if err != nil {
-		return nil, err
-	}
-
-	sort.Slice(entries, func(i, j int) bool {
-		return entries[i].Name() < entries[j].Name()
-	})
-
-	var buf bytes.Buffer
-	for _, entry := range entries {
-		unixMode := pstat.FileModeToUnixMode(entryMode(entry))
-		fmt.Fprintf(&buf, "%s %d\n", entry.Name(), unixMode)
-	}
-	return buf.Bytes(), nil
-}
-
-// pathsWithinDepth returns descendant paths relative to dir, using r2fs depth rules.
-func (s *Server) pathsWithinDepth(dir string, maxDepth int) ([]string, error) {
+// pathsWithinDepth returns the paths of the entries of dir's listing l
+// from lo to hi and their descendants, using r2fs depth rules.
+func (s *Server) pathsWithinDepth(dir string, l *listing, lo, hi, maxDepth int) ([]string, error) {
 	if maxDepth == 0 {
 		return nil, nil
 	}
+	limit := maxDepth
+	if dir == "." {
+		limit = 1
+	}
 
-	isRoot := dir == "."
 	var paths []string
-	var walk func(string) error
-	walk = func(current string) error {
+	var walk func(current string, depth int) error
+	walk = func(current string, depth int) error {
 		entries, err := fs.ReadDir(s.fs, current)
 		if err != nil {
 			return err
 		}
 		for _, entry := range entries {
 			child := filepath.Join(current, entry.Name())
-			depth := pathPartDepth(dir, child)
-			limit := maxDepth
-			if isRoot {
-				limit = 1
-			}
 			if maxDepth > 0 && depth > limit {
 				continue
 			}
 			paths = append(paths, child)
 			if entry.IsDir() && (maxDepth < 0 || depth < limit) {
-				if err := walk(child); err != nil {
+				if err := walk(child, depth+1); err != nil {
 					return err
 				}
 			}
 		}
 		return nil
 	}
-	if err := walk(dir); err != nil {
-		return nil, err
+	for i := lo; i < hi; i++ {
+		child := filepath.Join(dir, l.names[i])
+		paths = append(paths, child)
+		if l.dirs[i] && (maxDepth < 0 || 1 < limit) {
+			if err := walk(child, 2); err != nil {
+				return nil, err
+			}
+		}
 	}
 	sort.Strings(paths)
 	return paths, nil
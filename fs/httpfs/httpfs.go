@@ -42,13 +42,18 @@ func Patch(ctx context.Context, fsys fs.FS, name string, tarBuf bytes.Buffer) er
 	return fs.ErrNotSupported
 }
 
+// DefaultPageSize is the number of directory entries requested at a time.
+const DefaultPageSize = 1000
+
 // FS implements an HTTP-backed filesystem following the design specification
 type FS struct {
-	baseURL   string
-	client    *http.Client
-	log       *slog.Logger
-	ignores   []string
-	ignoresMu sync.Mutex
+	baseURL    string
+	client     *http.Client
+	log        *slog.Logger
+	ignores    []string
+	ignoresMu  sync.Mutex
+	pageSize   int
+	indexDepth int
 }
 
 func (fsys *FS) unwrap() *FS {
@@ -61,10 +66,21 @@ func New(baseURL string, client *http.Client) *FS {
 		client = &http.Client{}
 	}
 	return &FS{
-		baseURL: strings.TrimSuffix(baseURL, "/"),
-		client:  client,
-		log:     slog.Default(), // for now
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		client:     client,
+		log:        slog.Default(), // for now
+		pageSize:   DefaultPageSize,
+		indexDepth: DefaultIndexDepth,
+	}
+}
+
+// SetPageSize sets the number of directory entries requested at a time.
+// Servers may send fewer.
+func (fsys *FS) SetPageSize(n int) {
+	if n <= 0 {
+		n = DefaultPageSize
 	}
+	fsys.pageSize = n
 }
 
 // SetLogger sets the logger for the filesystem
@@ -122,6 +138,20 @@ func (fsys *FS) buildURL(name string) string {
 	return u.String()
 }
 
+// withQuery returns rawURL with params added to its query.
+func withQuery(rawURL string, params url.Values) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	q := u.Query()
+	for k, v := range params {
+		q[k] = v
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
 // doRequest logs and executes an HTTP request
 func (fsys *FS) doRequest(req *http.Request) (*http.Response, error) {
 	fsys.log.Debug(req.Method, "url", req.URL)
@@ -140,6 +170,11 @@ func responseError(resp *http.Response) error {
 	switch resp.StatusCode {
 	case http.StatusNotFound:
 		return fs.ErrNotExist
+	case http.StatusPreconditionFailed:
+		if strings.Contains(msg, "Destination Exists") {
+			return fs.ErrExist
+		}
+		return ErrConflict
 	}
 	if strings.Contains(msg, fs.ErrExist.Error()) {
 		return fs.ErrExist
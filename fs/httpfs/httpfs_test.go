@@ -10,6 +10,8 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -625,7 +627,7 @@ func TestMoveCopyOverwriteFails(t *testing.T) {
 		t.Run(method, func(t *testing.T) {
 			resp := doRequest(t, server, method, "/a.txt", nil, http.Header{
 				"Destination": {"/b.txt"},
-				"Overwrite":     {"F"},
+				"Overwrite":   {"F"},
 			})
 			defer resp.Body.Close()
 			if resp.StatusCode != http.StatusPreconditionFailed {
@@ -718,3 +720,757 @@ func readBody(resp *http.Response) string {
 	b, _ := io.ReadAll(resp.Body)
 	return string(b)
 }
+
+// countingHandler records how many response bytes a handler writes.
+type countingHandler struct {
+	h       http.Handler
+	written int64
+}
+
+func (c *countingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.h.ServeHTTP(&countingWriter{ResponseWriter: w, n: &c.written}, r)
+}
+
+type countingWriter struct {
+	http.ResponseWriter
+	n *int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	*w.n += int64(len(p))
+	return w.ResponseWriter.Write(p)
+}
+
+func TestRangeRead(t *testing.T) {
+	memFS := memfs.New()
+	data := make([]byte, 4<<20)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	if err := fs.WriteFile(memFS, "disk.img", data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	counter := &countingHandler{h: NewServer(memFS)}
+	server := httptest.NewServer(counter)
+	defer server.Close()
+	client := New(server.URL, nil)
+
+	f, err := client.Open("disk.img")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size() != int64(len(data)) {
+		t.Fatalf("size = %d, want %d", fi.Size(), len(data))
+	}
+
+	ra, ok := f.(io.ReaderAt)
+	if !ok {
+		t.Fatal("file does not implement io.ReaderAt")
+	}
+	p := make([]byte, 100)
+	off := int64(3<<20 + 12345)
+	if _, err := ra.ReadAt(p, off); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(p, data[off:off+100]) {
+		t.Fatal("ReadAt returned wrong data")
+	}
+	if counter.written > 4*BlockSize {
+		t.Fatalf("transferred %d bytes for a 100 byte read", counter.written)
+	}
+
+	// a read spanning a block boundary
+	off = BlockSize*10 - 50
+	if _, err := ra.ReadAt(p, off); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(p, data[off:off+100]) {
+		t.Fatal("ReadAt across blocks returned wrong data")
+	}
+
+	// reading at the end
+	n, err := ra.ReadAt(p, int64(len(data)-10))
+	if n != 10 || err != io.EOF {
+		t.Fatalf("ReadAt at end = %d, %v", n, err)
+	}
+
+	// sequential reads after seeking stream the rest
+	if _, err := f.(io.Seeker).Seek(1<<20, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	rest, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rest, data[1<<20:]) {
+		t.Fatal("sequential read returned wrong data")
+	}
+
+	// reads are made with the open's context
+	ctx, cancel := context.WithCancel(context.Background())
+	g, err := client.OpenContext(ctx, "disk.img")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.Close()
+	cancel()
+	if _, err := g.(io.ReaderAt).ReadAt(p, 2<<20); !errors.Is(err, context.Canceled) {
+		t.Fatalf("ReadAt after cancel = %v, want context.Canceled", err)
+	}
+}
+
+func TestRangeReadWrite(t *testing.T) {
+	memFS, server, client := newTestServer()
+	defer server.Close()
+
+	if err := fs.WriteFile(memFS, "file.txt", []byte("hello world"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.WriteFile(memFS, "empty.txt", nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := fs.OpenFile(client, "file.txt", os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.(io.Seeker).Seek(6, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.(io.Writer).Write([]byte("there")); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	b, err := fs.ReadFile(memFS, "file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "hello there" {
+		t.Fatalf("got %q", b)
+	}
+
+	f, err = client.Open("empty.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	b, err = io.ReadAll(f)
+	if err != nil || len(b) != 0 {
+		t.Fatalf("reading empty file = %q, %v", b, err)
+	}
+}
+
+func TestServeRange(t *testing.T) {
+	memFS, server, _ := newTestServer()
+	defer server.Close()
+
+	if err := fs.WriteFile(memFS, "file.txt", []byte("0123456789"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest("GET", server.URL+"/file.txt", nil)
+	req.Header.Set("Range", "bytes=2-5")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent {
+		t.Fatalf("status = %d", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Content-Range"); got != "bytes 2-5/10" {
+		t.Fatalf("Content-Range = %q", got)
+	}
+	if resp.Header.Get("Content-Mode") == "" {
+		t.Fatal("missing metadata headers")
+	}
+	if body := readBody(resp); body != "2345" {
+		t.Fatalf("body = %q", body)
+	}
+}
+
+func TestETags(t *testing.T) {
+	memFS, server, _ := newTestServer()
+	defer server.Close()
+
+	if err := memFS.Mkdir("dir", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.WriteFile(memFS, "dir/file.txt", []byte("v1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, p := range []string{"/dir/file.txt", "/dir"} {
+		resp := doRequest(t, server, "GET", p, nil, nil)
+		resp.Body.Close()
+		etag := resp.Header.Get("ETag")
+		if etag == "" {
+			t.Fatalf("%s: missing ETag", p)
+		}
+		resp = doRequest(t, server, "HEAD", p, nil, nil)
+		resp.Body.Close()
+		if resp.Header.Get("ETag") != etag {
+			t.Fatalf("%s: HEAD ETag %q != GET ETag %q", p, resp.Header.Get("ETag"), etag)
+		}
+		resp = doRequest(t, server, "GET", p, nil, http.Header{"If-None-Match": {etag}})
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotModified {
+			t.Fatalf("%s: If-None-Match status = %d", p, resp.StatusCode)
+		}
+	}
+
+	resp := doRequest(t, server, "GET", "/dir/file.txt", nil, nil)
+	resp.Body.Close()
+	etag := resp.Header.Get("ETag")
+
+	// a stale ETag fails, a current one succeeds
+	resp = doRequest(t, server, "PUT", "/dir/file.txt", bytes.NewBufferString("v2"), http.Header{"If-Match": {`"stale"`}})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusPreconditionFailed {
+		t.Fatalf("stale If-Match status = %d", resp.StatusCode)
+	}
+	resp = doRequest(t, server, "PUT", "/dir/file.txt", bytes.NewBufferString("v2"), http.Header{"If-Match": {etag}})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("If-Match status = %d", resp.StatusCode)
+	}
+	resp = doRequest(t, server, "DELETE", "/dir/file.txt", nil, http.Header{"If-Match": {etag}})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusPreconditionFailed {
+		t.Fatalf("DELETE with old ETag status = %d", resp.StatusCode)
+	}
+
+	// If-None-Match: * only creates
+	resp = doRequest(t, server, "PUT", "/dir/file.txt", bytes.NewBufferString("v3"), http.Header{"If-None-Match": {"*"}})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusPreconditionFailed {
+		t.Fatalf("If-None-Match * on existing file status = %d", resp.StatusCode)
+	}
+	b, _ := fs.ReadFile(memFS, "dir/file.txt")
+	if string(b) != "v2" {
+		t.Fatalf("got %q", b)
+	}
+}
+
+func TestWriteConflict(t *testing.T) {
+	memFS, server, client := newTestServer()
+	defer server.Close()
+	other := New(server.URL, nil)
+
+	if err := fs.WriteFile(memFS, "shared.txt", []byte("base"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	f1, err := fs.OpenFile(client, "shared.txt", os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f2, err := fs.OpenFile(other, "shared.txt", os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f1.(io.Writer).Write([]byte("tab1")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f2.(io.Writer).Write([]byte("tab2")); err != nil {
+		t.Fatal(err)
+	}
+	if err := f1.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f2.Close(); !errors.Is(err, ErrConflict) {
+		t.Fatalf("expected ErrConflict, got %v", err)
+	}
+	b, _ := fs.ReadFile(memFS, "shared.txt")
+	if string(b) != "tab1" {
+		t.Fatalf("got %q", b)
+	}
+
+	// conditional writes through the context
+	f, err := client.Open("shared.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	etag := f.(*Node).ETag()
+	f.Close()
+	if err := fs.WriteFile(memFS, "shared.txt", []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	err = client.RemoveContext(WithIfMatch(context.Background(), etag), "shared.txt")
+	if !errors.Is(err, ErrConflict) {
+		t.Fatalf("expected ErrConflict, got %v", err)
+	}
+}
+
+func TestETagMetadata(t *testing.T) {
+	memFS, server, client := newTestServer()
+	defer server.Close()
+
+	if err := fs.WriteFile(memFS, "file.txt", []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	resp := doRequest(t, server, "GET", "/file.txt", nil, nil)
+	resp.Body.Close()
+	etag := resp.Header.Get("ETag")
+
+	// a chmod is seen by revalidation but doesn't fail a write that
+	// started before it
+	f, err := fs.OpenFile(client, "file.txt", os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Chmod("file.txt", 0600); err != nil {
+		t.Fatal(err)
+	}
+	resp = doRequest(t, server, "GET", "/file.txt", nil, http.Header{"If-None-Match": {etag}})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("If-None-Match after chmod status = %d", resp.StatusCode)
+	}
+	resp = doRequest(t, server, "GET", "/file.txt", nil, http.Header{"If-Match": {etag}, "Range": {"bytes=1-2"}})
+	b, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent || string(b) != "at" {
+		t.Fatalf("range with If-Match after chmod: %d %q", resp.StatusCode, b)
+	}
+	if _, err := f.(io.Writer).Write([]byte("mine")); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("close after chmod: %v", err)
+	}
+	info, err := fs.Stat(memFS, "file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("mode = %v", info.Mode())
+	}
+	b, _ = fs.ReadFile(memFS, "file.txt")
+	if string(b) != "mine" {
+		t.Fatalf("got %q", b)
+	}
+}
+
+func TestETagVersions(t *testing.T) {
+	memFS, server, _ := newTestServer()
+	defer server.Close()
+
+	mtime := time.Unix(1000, 0)
+	for name, data := range map[string]string{"a.txt": "aa", "b.txt": "bb", "p.txt": "pp"} {
+		if err := fs.WriteFile(memFS, name, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := memFS.Chtimes(name, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	etag := func(p string) string {
+		resp := doRequest(t, server, "HEAD", p, nil, nil)
+		resp.Body.Close()
+		return resp.Header.Get("ETag")
+	}
+
+	// copying onto an existing file of the same size and modification
+	// time changes its ETag
+	before := etag("/a.txt")
+	resp := doRequest(t, server, "COPY", "/b.txt", nil, http.Header{"Destination": {"/a.txt"}})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("COPY status = %d", resp.StatusCode)
+	}
+	if err := memFS.Chtimes("a.txt", mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	if etag("/a.txt") == before {
+		t.Fatal("COPY onto an existing file kept its ETag")
+	}
+
+	// so does a PATCH setting the modification time back after a write
+	before = etag("/p.txt")
+	if err := fs.WriteFile(memFS, "p.txt", []byte("qq"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	resp = doRequest(t, server, "PATCH", "/p.txt", nil, http.Header{"Content-Modified": {"1000"}})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("PATCH status = %d", resp.StatusCode)
+	}
+	if etag("/p.txt") == before {
+		t.Fatal("PATCH kept the ETag of the old contents")
+	}
+
+	// while a chmod leaves the data part alone
+	before = etag("/p.txt")
+	resp = doRequest(t, server, "PATCH", "/p.txt", nil, http.Header{"Content-Mode": {"33152"}})
+	resp.Body.Close()
+	if after := etag("/p.txt"); after == before || dataETag(after) != dataETag(before) {
+		t.Fatalf("chmod ETags %q -> %q", before, after)
+	}
+}
+
+func nextEvent(t *testing.T, events <-chan fs.Event) fs.Event {
+	t.Helper()
+	select {
+	case e, ok := <-events:
+		if !ok {
+			t.Fatal("watch closed")
+		}
+		return e
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for change")
+	}
+	return fs.Event{}
+}
+
+func TestChangeFeed(t *testing.T) {
+	memFS, server, client := newTestServer()
+	defer server.Close()
+
+	if err := memFS.Mkdir("dir", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := memFS.Mkdir("dir/sub", 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	all, err := client.Watch(ctx, "dir/...", "*.tmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	shallow, err := client.Watch(ctx, "dir", "*.tmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := fs.WriteFile(client, "dir/sub/deep.txt", []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.WriteFile(client, "dir/skip.tmp", []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.WriteFile(client, "outside.txt", []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.WriteFile(client, "dir/file.txt", []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Rename(client, "dir/file.txt", "dir/moved.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []fs.Event{
+		{Path: "dir/sub/deep.txt", Op: OpWrite},
+		{Path: "dir/file.txt", Op: OpWrite},
+		{Path: "dir/file.txt", Op: OpRemove},
+		{Path: "dir/moved.txt", Op: OpWrite},
+	}
+	for _, w := range want {
+		if e := nextEvent(t, all); e != w {
+			t.Fatalf("recursive watch got %+v, want %+v", e, w)
+		}
+	}
+	for _, w := range want[1:] {
+		if e := nextEvent(t, shallow); e != w {
+			t.Fatalf("watch got %+v, want %+v", e, w)
+		}
+	}
+
+	// an ID from another run of the server gets a reset
+	resp := doRequest(t, server, "GET", "/dir/.../changes", nil, http.Header{"Last-Event-ID": {"earlier-3"}})
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+	var ids []string
+	var got []change
+	readChanges(resp.Body, func(id string, c change) bool {
+		ids = append(ids, id)
+		got = append(got, c)
+		return false
+	})
+	if len(got) != 1 || got[0].Op != OpReset {
+		t.Fatalf("resume from another run got %v %v", ids, got)
+	}
+	epoch, seq, _ := strings.Cut(ids[0], "-")
+	if epoch == "earlier" || seq != "6" {
+		t.Fatalf("reset id %q", ids[0])
+	}
+
+	// resuming replays what was missed
+	resp = doRequest(t, server, "GET", "/dir/.../changes", nil, http.Header{"Last-Event-ID": {epoch + "-3"}})
+	defer resp.Body.Close()
+	ids = nil
+	readChanges(resp.Body, func(id string, c change) bool {
+		ids = append(ids, id)
+		return id != epoch+"-6"
+	})
+	if want := epoch + "-4," + epoch + "-5," + epoch + "-6"; strings.Join(ids, ",") != want {
+		t.Fatalf("replayed ids %v", ids)
+	}
+
+	// as does an ID ahead of the feed
+	resp = doRequest(t, server, "GET", "/dir/.../changes", nil, http.Header{"Last-Event-ID": {epoch + "-100"}})
+	defer resp.Body.Close()
+	got = nil
+	readChanges(resp.Body, func(id string, c change) bool {
+		got = append(got, c)
+		return false
+	})
+	if len(got) != 1 || got[0].Op != OpReset {
+		t.Fatalf("resume ahead of feed got %v", got)
+	}
+
+	cancel()
+	for range all {
+	}
+}
+
+func TestListingPagination(t *testing.T) {
+	memFS, server, client := newTestServer()
+	defer server.Close()
+
+	if err := memFS.Mkdir("dir", 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		if err := fs.WriteFile(memFS, "dir/"+name, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var pages []string
+	cursor := ""
+	for {
+		p := "/dir?limit=2"
+		if cursor != "" {
+			p += "&cursor=" + cursor
+		}
+		resp := doRequest(t, server, "GET", p, nil, nil)
+		pages = append(pages, strings.Join(strings.Fields(readBody(resp)), " "))
+		resp.Body.Close()
+		cursor = resp.Header.Get("Listing-Cursor")
+		if cursor == "" {
+			break
+		}
+	}
+	want := []string{"a 33188 b 33188", "c 33188 d 33188", "e 33188"}
+	if strings.Join(pages, "|") != strings.Join(want, "|") {
+		t.Fatalf("pages = %q, want %q", pages, want)
+	}
+
+	// the multipart listing pages the same way
+	resp := doRequest(t, server, "GET", "/dir/:?limit=2&cursor=b", nil, nil)
+	defer resp.Body.Close()
+	if resp.Header.Get("Listing-Cursor") != "d" {
+		t.Fatalf("multipart cursor = %q", resp.Header.Get("Listing-Cursor"))
+	}
+	body := readBody(resp)
+	if !strings.Contains(body, "/dir/c") || !strings.Contains(body, "/dir/d") || strings.Contains(body, "/dir/e") {
+		t.Fatalf("multipart page:\n%s", body)
+	}
+
+	client.SetPageSize(2)
+	entries, err := fs.ReadDir(client, "dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 5 || entries[4].Name() != "e" {
+		t.Fatalf("ReadDir got %d entries", len(entries))
+	}
+}
+
+// readDirCounter counts directory reads of a memfs.
+type readDirCounter struct {
+	*memfs.FS
+	reads atomic.Int32
+}
+
+func (c *readDirCounter) ReadDir(name string) ([]fs.DirEntry, error) {
+	c.reads.Add(1)
+	return fs.ReadDir(c.FS, name)
+}
+
+func TestListingCache(t *testing.T) {
+	fsys := &readDirCounter{FS: memfs.New()}
+	server := httptest.NewServer(NewServer(fsys))
+	defer server.Close()
+
+	if err := fsys.Mkdir("dir", 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		if err := fs.WriteFile(fsys.FS, "dir/"+name, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// paging through the listing and its ETag read the directory once
+	for _, p := range []string{"/dir?limit=2", "/dir?limit=2&cursor=b", "/dir?limit=2&cursor=d"} {
+		resp := doRequest(t, server, "GET", p, nil, nil)
+		resp.Body.Close()
+	}
+	resp := doRequest(t, server, "HEAD", "/dir", nil, nil)
+	resp.Body.Close()
+	etag := resp.Header.Get("ETag")
+	if n := fsys.reads.Load(); n != 1 {
+		t.Errorf("expected 1 directory read, got %d", n)
+	}
+
+	// a change made through the server is seen
+	resp = doRequest(t, server, "PATCH", "/dir/a", nil, http.Header{"Content-Mode": {"33152"}})
+	resp.Body.Close()
+	resp = doRequest(t, server, "GET", "/dir", nil, nil)
+	body := readBody(resp)
+	resp.Body.Close()
+	if !strings.HasPrefix(body, "a 33152\n") {
+		t.Errorf("expected chmod in listing, got %q", body)
+	}
+	if resp.Header.Get("ETag") == etag {
+		t.Error("expected ETag to change")
+	}
+
+	// and so is one made directly that changes the directory
+	etag = resp.Header.Get("ETag")
+	time.Sleep(time.Millisecond)
+	if err := fs.WriteFile(fsys.FS, "dir/f", []byte("f"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	resp = doRequest(t, server, "GET", "/dir", nil, nil)
+	body = readBody(resp)
+	resp.Body.Close()
+	if !strings.Contains(body, "f 33188") || resp.Header.Get("ETag") == etag {
+		t.Errorf("expected new file in listing, got %q", body)
+	}
+}
+
+func TestTreeDepth(t *testing.T) {
+	memFS, server, _ := newTestServer()
+	defer server.Close()
+
+	if err := fs.MkdirAll(memFS, "a/b/c", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.WriteFile(memFS, "a/b/c/file", []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		path string
+		want []string
+	}{
+		{"/...", []string{"/", "/a", "/a/b", "/a/b/c", "/a/b/c/file"}},
+		{"/...?depth=2", []string{"/", "/a", "/a/b"}},
+		{"/a/...?depth=1", []string{"/a", "/a/b"}},
+		{"/a/...?depth=0", []string{"/a"}},
+	} {
+		resp := doRequest(t, server, "GET", tt.path, nil, nil)
+		body := readBody(resp)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s: status %d", tt.path, resp.StatusCode)
+		}
+		var got []string
+		for _, line := range strings.Split(body, "\r\n") {
+			if loc, ok := strings.CutPrefix(line, "Content-Location: "); ok {
+				got = append(got, loc)
+			}
+		}
+		if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+			t.Fatalf("%s: parts %v, want %v", tt.path, got, tt.want)
+		}
+	}
+
+	resp := doRequest(t, server, "GET", "/a/...?depth=x", nil, nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("invalid depth status = %d", resp.StatusCode)
+	}
+}
+
+// treeCounter counts tree stream requests.
+type treeCounter struct {
+	h     http.Handler
+	trees atomic.Int32
+}
+
+func (c *treeCounter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasSuffix(r.URL.Path, "...") {
+		c.trees.Add(1)
+	}
+	c.h.ServeHTTP(w, r)
+}
+
+func TestIncrementalIndex(t *testing.T) {
+	memFS := memfs.New()
+	counter := &treeCounter{h: NewServer(memFS)}
+	server := httptest.NewServer(counter)
+	defer server.Close()
+	client := New(server.URL, nil)
+	client.SetIndexDepth(1)
+
+	if err := fs.MkdirAll(memFS, "a/b/c", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.MkdirAll(memFS, "x", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.WriteFile(memFS, "a/b/c/file", []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// later loads don't use the context of the Index call
+	ctx, cancel := context.WithCancel(context.Background())
+	index, err := client.Index(ctx, ".")
+	cancel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := counter.trees.Load(); n != 1 {
+		t.Fatalf("Index made %d tree requests, want 1", n)
+	}
+
+	// a stat deep in the tree loads only the directories on the way
+	info, err := fs.Stat(index, "a/b/c/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != 1 {
+		t.Fatalf("size = %d", info.Size())
+	}
+	if n := counter.trees.Load(); n != 4 {
+		t.Fatalf("stat made %d tree requests, want 4", n)
+	}
+	if _, err := fs.Stat(index, "a/missing/file"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("stat missing = %v", err)
+	}
+
+	var paths []string
+	err = fs.WalkDir(index, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		paths = append(paths, p)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ". a a/b a/b/c a/b/c/file x"
+	if strings.Join(paths, " ") != want {
+		t.Fatalf("walk = %v, want %s", paths, want)
+	}
+	// only the unvisited directory x was fetched
+	if n := counter.trees.Load(); n != 5 {
+		t.Fatalf("walk made %d tree requests in all, want 5", n)
+	}
+}
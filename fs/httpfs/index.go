@@ -0,0 +1,155 @@
+package httpfs
+
+import (
+	"context"
+	"path"
+	"strings"
+	"sync"
+
+	"tractor.dev/wanix/fs"
+	"tractor.dev/wanix/fs/memfs"
+)
+
+// DefaultIndexDepth is how many levels below a directory an index fetches
+// when the directory is first visited.
+const DefaultIndexDepth = 2
+
+// SetIndexDepth sets how many levels below a directory an index fetches
+// when the directory is first visited.
+func (fsys *FS) SetIndexDepth(depth int) {
+	if depth <= 0 {
+		depth = DefaultIndexDepth
+	}
+	fsys.indexDepth = depth
+}
+
+// Index returns the metadata of the tree at name for syncing. Only the top
+// of the tree is fetched up front. The rest is fetched a subtree at a time
+// as directories are visited, so walking a large tree never waits on a
+// single response for all of it. Later fetches use the context of the
+// call that needs them, or a background one.
+func (fsys *FS) Index(ctx context.Context, name string) (fs.FS, error) {
+	idx := &index{
+		fsys:   fsys,
+		root:   cleanIndexPath(name),
+		nodes:  memfs.New(),
+		loaded: make(map[string]bool),
+	}
+	if err := idx.load(ctx, idx.root); err != nil {
+		return nil, err
+	}
+	return idx, nil
+}
+
+// index is a lazily filled copy of a remote tree's metadata. Directories
+// in loaded have all their children in nodes.
+type index struct {
+	fsys *FS
+	root string
+
+	mu     sync.Mutex
+	nodes  *memfs.FS
+	loaded map[string]bool
+}
+
+// load fetches the subtree at dir unless it has been.
+func (idx *index) load(ctx context.Context, dir string) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if idx.loaded[dir] {
+		return nil
+	}
+	depth := idx.fsys.indexDepth
+	for n, err := range idx.fsys.streamTree(ctx, dir, depth) {
+		if err != nil {
+			return err
+		}
+		p := cleanIndexPath(n.Path())
+		idx.nodes.SetNode(p, n.ToNode())
+		// directories at the depth limit come without their children
+		if n.IsDir() && pathPartDepth(dir, p) < depth {
+			idx.loaded[p] = true
+		}
+	}
+	idx.loaded[dir] = true
+	return nil
+}
+
+// ensure loads the directories from the root down to name's parent, and
+// name itself if it is a directory, stopping early if name can't exist.
+func (idx *index) ensure(ctx context.Context, name string) error {
+	name = cleanIndexPath(name)
+	if !inTree(idx.root, name) {
+		return nil
+	}
+	dirs := []string{idx.root}
+	if name != idx.root {
+		rel := name
+		if idx.root != "." {
+			rel = strings.TrimPrefix(name, idx.root+"/")
+		}
+		elems := strings.Split(rel, "/")
+		for i := range elems {
+			dirs = append(dirs, path.Join(idx.root, strings.Join(elems[:i+1], "/")))
+		}
+	}
+	for _, dir := range dirs {
+		idx.mu.Lock()
+		loaded := idx.loaded[dir]
+		idx.mu.Unlock()
+		if loaded {
+			continue
+		}
+		info, err := idx.nodes.Stat(dir)
+		if err != nil || !info.IsDir() {
+			// the rest of the path isn't in the tree
+			return nil
+		}
+		if err := idx.load(ctx, dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (idx *index) Open(name string) (fs.File, error) {
+	return idx.OpenContext(context.Background(), name)
+}
+
+func (idx *index) OpenContext(ctx context.Context, name string) (fs.File, error) {
+	if err := idx.ensure(ctx, name); err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return idx.nodes.OpenContext(ctx, name)
+}
+
+func (idx *index) Stat(name string) (fs.FileInfo, error) {
+	return idx.StatContext(context.Background(), name)
+}
+
+func (idx *index) StatContext(ctx context.Context, name string) (fs.FileInfo, error) {
+	if err := idx.ensure(ctx, path.Dir(cleanIndexPath(name))); err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: err}
+	}
+	return idx.nodes.StatContext(ctx, name)
+}
+
+func (idx *index) ReadDir(name string) ([]fs.DirEntry, error) {
+	if err := idx.ensure(context.Background(), name); err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	return fs.ReadDir(idx.nodes, name)
+}
+
+// inTree reports whether name is root or below it.
+func inTree(root, name string) bool {
+	return root == "." || name == root || strings.HasPrefix(name, root+"/")
+}
+
+func cleanIndexPath(name string) string {
+	name = strings.Trim(path.Clean("/"+name), "/")
+	if name == "" {
+		return "."
+	}
+	return name
+}
@@ -0,0 +1,125 @@
+package httpfs
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"tractor.dev/wanix/fs"
+	"tractor.dev/wanix/fs/pstat"
+)
+
+// maxListings bounds the number of directory listings the server keeps.
+const maxListings = 256
+
+// listing is a sorted, formatted directory listing. Pages of it and the
+// directory's ETag are served from it without reading the directory again.
+type listing struct {
+	modTime time.Time
+	size    int64
+	gen     uint64
+
+	names []string
+	dirs  []bool
+	data  []byte
+	offs  []int // start of each entry's line in data, then len(data)
+	sum   [sha256.Size]byte
+}
+
+// listings caches the listings of recently read directories. An entry is
+// used while the directory's modification time and size are unchanged and
+// no request has changed anything through the server since it was read.
+// Changes made to the filesystem directly that leave the directory's
+// metadata as it was, like a chmod of a child, are only seen once it
+// changes.
+type listings struct {
+	mu   sync.Mutex
+	dirs map[string]*listing
+	gen  atomic.Uint64
+}
+
+// listing returns the listing of the directory at path, whose info is
+// info, reading it if the cached one is out of date.
+func (s *Server) listing(path string, info fs.FileInfo) (*listing, error) {
+	gen := s.listings.gen.Load()
+	s.listings.mu.Lock()
+	l := s.listings.dirs[path]
+	s.listings.mu.Unlock()
+	if l != nil && l.gen == gen && l.size == info.Size() && l.modTime.Equal(info.ModTime()) {
+		return l, nil
+	}
+
+	entries, err := fs.ReadDir(s.fs, path)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	l = &listing{
+		modTime: info.ModTime(),
+		size:    info.Size(),
+		gen:     gen,
+		names:   make([]string, len(entries)),
+		dirs:    make([]bool, len(entries)),
+		offs:    make([]int, 0, len(entries)+1),
+	}
+	for i, entry := range entries {
+		l.names[i] = entry.Name()
+		l.dirs[i] = entry.IsDir()
+		l.offs = append(l.offs, len(l.data))
+		unixMode := pstat.FileModeToUnixMode(entryMode(entry))
+		l.data = fmt.Appendf(l.data, "%s %d\n", entry.Name(), unixMode)
+	}
+	l.offs = append(l.offs, len(l.data))
+	l.sum = sha256.Sum256(l.data)
+
+	if info.ModTime().IsZero() {
+		// nothing to tell when it changes
+		return l, nil
+	}
+	s.listings.mu.Lock()
+	if s.listings.dirs == nil {
+		s.listings.dirs = make(map[string]*listing)
+	}
+	if _, ok := s.listings.dirs[path]; !ok && len(s.listings.dirs) >= maxListings {
+		for p := range s.listings.dirs {
+			delete(s.listings.dirs, p)
+			break
+		}
+	}
+	s.listings.dirs[path] = l
+	s.listings.mu.Unlock()
+	return l, nil
+}
+
+// forgetListings makes every cached listing out of date. It is called
+// after requests that change the filesystem.
+func (s *Server) forgetListings() {
+	s.listings.gen.Add(1)
+}
+
+// page returns the range of entries after cursor, at most limit of them if
+// limit is positive, and the cursor of the next page if there is one. The
+// cursor is the name of the last entry returned.
+func (l *listing) page(cursor string, limit int) (lo, hi int, next string) {
+	if cursor != "" {
+		lo = sort.Search(len(l.names), func(i int) bool {
+			return l.names[i] > cursor
+		})
+	}
+	hi = len(l.names)
+	if limit > 0 && hi-lo > limit {
+		hi = lo + limit
+		next = l.names[hi-1]
+	}
+	return lo, hi, next
+}
+
+// body returns the formatted lines of the entries from lo to hi.
+func (l *listing) body(lo, hi int) []byte {
+	return l.data[l.offs[lo]:l.offs[hi]]
+}
@@ -0,0 +1,195 @@
+package httpfs
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"tractor.dev/wanix/fs"
+)
+
+const maxReconnectDelay = 5 * time.Second
+
+// Watch subscribes to the server's change stream for name, which can end
+// with /... to include everything below it. Otherwise only changes to name
+// and its direct children are reported. Changes to paths matching an
+// exclude glob are skipped. The stream is resumed if the connection drops,
+// and the channel is closed when ctx is done.
+func (fsys *FS) Watch(ctx context.Context, name string, exclude ...string) (<-chan fs.Event, error) {
+	recursive := name == "..." || strings.HasSuffix(name, "/...")
+	if recursive {
+		name = strings.TrimSuffix(strings.TrimSuffix(name, "..."), "/")
+	}
+	name = strings.Trim(path.Clean("/"+name), "/")
+	if name == "" {
+		name = "."
+	}
+
+	resp, err := fsys.openChanges(ctx, name, "")
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan fs.Event)
+	go func() {
+		defer close(ch)
+		var lastID string
+		delay := 100 * time.Millisecond
+		for {
+			err := readChanges(resp.Body, func(id string, c change) bool {
+				lastID = id
+				if !watched(name, c, recursive, exclude) {
+					return true
+				}
+				select {
+				case ch <- fs.Event{Path: c.Path, Op: c.Op}:
+					return true
+				case <-ctx.Done():
+					return false
+				}
+			})
+			resp.Body.Close()
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				fsys.log.Debug("change stream", "name", name, "err", err)
+			}
+			for {
+				select {
+				case <-time.After(delay):
+				case <-ctx.Done():
+					return
+				}
+				resp, err = fsys.openChanges(ctx, name, lastID)
+				if err == nil {
+					delay = 100 * time.Millisecond
+					break
+				}
+				if ctx.Err() != nil {
+					return
+				}
+				fsys.log.Debug("change stream reconnect", "name", name, "err", err)
+				delay = min(delay*2, maxReconnectDelay)
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// openChanges requests the change stream for dir, resuming after lastID if
+// it is set.
+func (fsys *FS) openChanges(ctx context.Context, dir, lastID string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", fsys.buildURL(path.Join(dir, ".../changes")), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	if lastID != "" {
+		req.Header.Set("Last-Event-ID", lastID)
+	}
+	resp, err := fsys.doRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, pathError("watch", dir, resp)
+	}
+	return resp, nil
+}
+
+// readChanges parses change events from a Server-Sent Events stream,
+// calling fn for each until it returns false or the stream ends.
+func readChanges(r io.Reader, fn func(id string, c change) bool) error {
+	scanner := bufio.NewScanner(r)
+	var id, event, data string
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			if event == "change" && data != "" {
+				var c change
+				if err := json.Unmarshal([]byte(data), &c); err == nil && !fn(id, c) {
+					return nil
+				}
+			}
+			event, data = "", ""
+			continue
+		}
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "id":
+			id = value
+		case "event":
+			event = value
+		case "data":
+			data += value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return io.ErrUnexpectedEOF
+}
+
+// watched reports whether a change should be reported to a watch of dir.
+func watched(dir string, c change, recursive bool, exclude []string) bool {
+	name := c.Path
+	if !within(dir, name) {
+		// a reset of a parent covers dir too
+		return c.Op == OpReset && within(name, dir)
+	}
+	if !recursive && name != dir && path.Dir(name) != dir {
+		return false
+	}
+	for _, pattern := range exclude {
+		if ok, _ := path.Match(pattern, name); ok {
+			return false
+		}
+		if ok, _ := path.Match(pattern, path.Base(name)); ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Watch subscribes to the server's change stream. See FS.Watch.
+func (fsys *Cacher) Watch(ctx context.Context, name string, exclude ...string) (<-chan fs.Event, error) {
+	return fsys.fs.Watch(ctx, name, exclude...)
+}
+
+// Subscribe invalidates cached nodes as the server reports changes to
+// them, until ctx is done. It returns once subscribed, or with an error if
+// the server has no change stream.
+func (fsys *Cacher) Subscribe(ctx context.Context) error {
+	events, err := fsys.fs.Watch(ctx, "./...")
+	if err != nil {
+		return err
+	}
+	go func() {
+		for e := range events {
+			fsys.invalidateChange(e)
+		}
+	}()
+	return nil
+}
+
+// invalidateChange drops the cache entries a change makes stale: the
+// changed node, and its parent whose listing may include it.
+func (fsys *Cacher) invalidateChange(e fs.Event) {
+	switch e.Op {
+	case OpReset, OpRemove:
+		fsys.InvalidateAll(e.Path)
+	default:
+		fsys.InvalidateNode(e.Path, false, false)
+	}
+	if e.Path != "." {
+		fsys.InvalidateNode(path.Dir(e.Path), false, false)
+	}
+}
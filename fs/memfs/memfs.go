@@ -6,24 +6,45 @@ import (
 	"io"
 	"log"
 	"log/slog"
+	"os"
 	"path"
 	"slices"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
+	"weak"
 
 	"tractor.dev/wanix/fs"
 	"tractor.dev/wanix/fs/fskit"
+	"tractor.dev/wanix/fs/pipe"
 )
 
 type FS struct {
 	nodes map[string]*fskit.Node
-	mu    sync.Mutex
+	mu    *sync.Mutex // shared with snapshots
 	log   *slog.Logger
+
+	// After a snapshot the nodes map and the nodes in it are shared.
+	// shared is set until the map has been copied. owned holds the nodes
+	// this tree may modify in place, with the number of snapshots taken
+	// of it (gen) when it got them. Snapshots taken since still share the
+	// node, so they are given their own copy before it is modified (see
+	// detach). Nodes missing from owned belong to the tree this one is a
+	// snapshot of if forked is set, and otherwise date from before the
+	// first snapshot.
+	shared bool
+	forked bool
+	owned  map[*fskit.Node]int
+	gen    int
+	snaps  []snapshot
+
+	// fifos holds the state of named pipe nodes, created on first open.
+	fifos map[*fskit.Node]*pipe.FIFO
 }
 
 func New() *FS {
-	fsys := &FS{nodes: make(map[string]*fskit.Node), log: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	fsys := &FS{nodes: make(map[string]*fskit.Node), mu: new(sync.Mutex), log: slog.New(slog.NewTextHandler(io.Discard, nil))}
 	// Always ensure "." exists as the root directory
 	root := fskit.RawNode(".", fs.ModeDir|0755, time.Now())
 	fskit.SetSize(root, 2) // "." and ".."
@@ -75,6 +96,201 @@ func From(m fskit.MapFS) *FS {
 	return fsys
 }
 
+// snapshot is a snapshot taken of a tree, and the number of snapshots
+// taken of the tree before it.
+type snapshot struct {
+	gen  int
+	fsys weak.Pointer[FS]
+}
+
+// Snapshot returns an independent copy of the filesystem in constant time.
+// The two trees share their nodes and file data until either side changes
+// them, copying only what is modified. Files that are already open belong
+// to the original: writes through them are not seen by the snapshot.
+func (fsys *FS) Snapshot() *FS {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+	fsys.shared = true
+	// named pipes stay connected across the snapshot
+	fifos := make(map[*fskit.Node]*pipe.FIFO, len(fsys.fifos))
+	for n, f := range fsys.fifos {
+		fifos[n] = f
+	}
+	snap := &FS{
+		nodes:  fsys.nodes,
+		mu:     fsys.mu,
+		log:    fsys.log,
+		shared: true,
+		forked: true,
+		fifos:  fifos,
+	}
+	snaps := fsys.snaps[:0]
+	for _, s := range fsys.snaps {
+		if s.fsys.Value() != nil {
+			snaps = append(snaps, s)
+		}
+	}
+	fsys.snaps = append(snaps, snapshot{gen: fsys.gen, fsys: weak.Make(snap)})
+	fsys.gen++
+	return snap
+}
+
+// unshare copies the nodes map if it is shared with a snapshot.
+// Must be called with fsys.mu held.
+func (fsys *FS) unshare() {
+	if !fsys.shared {
+		return
+	}
+	nodes := make(map[string]*fskit.Node, len(fsys.nodes))
+	for name, n := range fsys.nodes {
+		nodes[name] = n
+	}
+	fsys.nodes = nodes
+	fsys.shared = false
+}
+
+// since returns the number of snapshots taken of the tree when it got n,
+// and whether n is its own to modify in place. Must be called with
+// fsys.mu held.
+func (fsys *FS) since(n *fskit.Node) (int, bool) {
+	if gen, ok := fsys.owned[n]; ok {
+		return gen, true
+	}
+	return 0, !fsys.forked
+}
+
+// shares reports whether n may be shared with another tree.
+// Must be called with fsys.mu held.
+func (fsys *FS) shares(n *fskit.Node) bool {
+	gen, mine := fsys.since(n)
+	return !mine || gen < fsys.gen
+}
+
+// claim records n as the tree's own, unshared with any snapshot.
+// Must be called with fsys.mu held.
+func (fsys *FS) claim(n *fskit.Node) {
+	if fsys.gen == 0 && !fsys.forked {
+		return
+	}
+	if fsys.owned == nil {
+		fsys.owned = make(map[*fskit.Node]int)
+	}
+	fsys.owned[n] = fsys.gen
+}
+
+// own returns the node at name, first replacing it with a copy if it
+// belongs to the tree this is a snapshot of, or giving snapshots that
+// share it their own copy. Must be called with fsys.mu held.
+func (fsys *FS) own(name string) *fskit.Node {
+	n, ok := fsys.nodes[name]
+	if !ok {
+		return nil
+	}
+	if gen, mine := fsys.since(n); mine {
+		if gen < fsys.gen {
+			fsys.detach(name, n, gen)
+		}
+		return n
+	}
+	fsys.unshare()
+	old := n
+	n = n.Clone()
+	fsys.nodes[name] = n
+	fsys.claim(n)
+	if f, ok := fsys.fifos[old]; ok {
+		fsys.fifos[n] = f
+	}
+	return n
+}
+
+// detach gives the snapshots taken since the tree got n, and theirs, a
+// copy of n in its place so the tree can modify it. name is where n is
+// expected to be found. Must be called with fsys.mu held.
+func (fsys *FS) detach(name string, n *fskit.Node, since int) {
+	var c *fskit.Node
+	for _, s := range fsys.snaps {
+		snap := s.fsys.Value()
+		if snap == nil || s.gen < since {
+			continue
+		}
+		if c == nil {
+			c = n.Clone()
+		}
+		snap.replace(name, n, c)
+	}
+	fsys.claim(n)
+}
+
+// replace puts c in place of old in the tree and its snapshots. c is
+// left unowned, so every tree that shares it copies it before changing
+// it. Must be called with fsys.mu held.
+func (fsys *FS) replace(name string, old, c *fskit.Node) {
+	if fsys.nodes[name] != old {
+		// renamed since
+		name = ""
+		for p, n := range fsys.nodes {
+			if n == old {
+				name = p
+				break
+			}
+		}
+	}
+	if name != "" {
+		fsys.unshare()
+		fsys.nodes[name] = c
+		if f, ok := fsys.fifos[old]; ok {
+			fsys.fifos[c] = f
+			delete(fsys.fifos, old)
+		}
+	}
+	for _, s := range fsys.snaps {
+		if snap := s.fsys.Value(); snap != nil {
+			snap.replace(name, old, c)
+		}
+	}
+}
+
+// release prepares n, which a handle opened for writing is about to sync
+// its changes to, to be modified in place. Must be called with fsys.mu
+// held.
+func (fsys *FS) release(name string, n *fskit.Node) {
+	// a node removed since it was opened is no longer in owned, so it is
+	// detached from every snapshot that may still have it
+	gen, _ := fsys.since(n)
+	if gen < fsys.gen {
+		fsys.detach(name, n, gen)
+	}
+}
+
+// put sets the node at name. Must be called with fsys.mu held.
+func (fsys *FS) put(name string, n *fskit.Node) {
+	fsys.unshare()
+	fsys.nodes[name] = n
+	fsys.claim(n)
+}
+
+// del removes the node at name. Must be called with fsys.mu held.
+func (fsys *FS) del(name string) {
+	fsys.unshare()
+	delete(fsys.owned, fsys.nodes[name])
+	delete(fsys.fifos, fsys.nodes[name])
+	delete(fsys.nodes, name)
+}
+
+// fifo returns the state of the named pipe node n.
+// Must be called with fsys.mu held.
+func (fsys *FS) fifo(n *fskit.Node) *pipe.FIFO {
+	if fsys.fifos == nil {
+		fsys.fifos = make(map[*fskit.Node]*pipe.FIFO)
+	}
+	f, ok := fsys.fifos[n]
+	if !ok {
+		f = pipe.NewFIFO()
+		fsys.fifos[n] = f
+	}
+	return f
+}
+
 func (fsys *FS) SetLogger(logger *slog.Logger) {
 	fsys.log = logger
 }
@@ -83,8 +299,11 @@ func (fsys *FS) Clear() {
 	fsys.mu.Lock()
 	defer fsys.mu.Unlock()
 	fsys.nodes = make(map[string]*fskit.Node)
+	fsys.shared = false
+	fsys.owned = nil
+	fsys.fifos = nil
 	// Always ensure "." exists as the root directory
-	fsys.nodes["."] = fskit.RawNode(".", fs.ModeDir|0755)
+	fsys.put(".", fskit.RawNode(".", fs.ModeDir|0755))
 	fskit.SetSize(fsys.nodes["."], 2) // "." and ".."
 }
 
@@ -92,22 +311,22 @@ func (fsys *FS) SetNode(name string, node *fskit.Node) {
 	name = path.Clean(name)
 	fsys.mu.Lock()
 	defer fsys.mu.Unlock()
-	fsys.nodes[name] = node
+	fsys.put(name, node)
 }
 
 func (fsys *FS) Node(name string) (*fskit.Node, bool) {
 	name = path.Clean(name)
 	fsys.mu.Lock()
 	defer fsys.mu.Unlock()
-	node, ok := fsys.nodes[name]
-	return node, ok
+	node := fsys.own(name)
+	return node, node != nil
 }
 
 // updateDirSize updates the size of a directory based on its children.
 // Must be called with fsys.mu held.
 func (fsys *FS) updateDirSize(dir string) {
-	node, ok := fsys.nodes[dir]
-	if !ok {
+	node := fsys.own(dir)
+	if node == nil {
 		return
 	}
 
@@ -159,7 +378,12 @@ func (fsys *FS) StatContext(ctx context.Context, name string) (fi fs.FileInfo, e
 	defer func() {
 		fsys.log.Debug("stat", "name", name, "err", err)
 	}()
-	f, err := fsys.OpenContext(fs.WithNoFollow(ctx), name)
+	// opening a named pipe waits for the other end, so special
+	// nodes are stat'd without opening them
+	if _, n := fsys.special(name, false); n != nil {
+		return n.Clone(), nil
+	}
+	f, err := fsys.OpenContext(fs.WithReadOnly(fs.WithNoFollow(ctx)), name)
 	if err != nil {
 		return nil, err
 	}
@@ -215,6 +439,7 @@ func (fsys *FS) OpenContext(ctx context.Context, name string) (f fs.File, err er
 
 	fsys.mu.Lock()
 	n := fsys.nodes[name]
+	shared := n != nil && fsys.shares(n)
 	fsys.mu.Unlock()
 
 	if n != nil {
@@ -252,9 +477,21 @@ func (fsys *FS) OpenContext(ctx context.Context, name string) (f fs.File, err er
 				}
 			}
 		}
+		if isSpecial(n.Mode()) {
+			return fsys.openSpecial(ctx, name, n, os.O_RDONLY)
+		}
 		if !n.IsDir() {
 			// Ordinary file
-			return fs.OpenContext(ctx, n, ".")
+			if fs.IsReadOnly(ctx) {
+				return fs.OpenContext(ctx, n, ".")
+			}
+			if shared {
+				// the handle may be written to, so it needs our own copy
+				fsys.mu.Lock()
+				n = fsys.own(name)
+				fsys.mu.Unlock()
+			}
+			return fsys.openNode(name, n)
 		}
 	}
 
@@ -311,6 +548,8 @@ func (fsys *FS) OpenContext(ctx context.Context, name string) (f fs.File, err er
 
 	if n == nil {
 		n = fskit.RawNode(name, fs.ModeDir|0755)
+	} else if shared {
+		n = fskit.RawNode(n)
 	}
 	var entries []fs.DirEntry
 	for _, n := range list {
@@ -322,6 +561,158 @@ func (fsys *FS) OpenContext(ctx context.Context, name string) (f fs.File, err er
 	return fskit.DirFile(n, entries...), nil
 }
 
+// OpenFileContext opens name with flag. Named pipes are opened for the
+// access mode in flag; other files get fs.OpenFile's generic handling.
+func (fsys *FS) OpenFileContext(ctx context.Context, name string, flag int, perm fs.FileMode) (fs.File, error) {
+	if target, n := fsys.special(name, fs.FollowSymlinks(ctx)); n != nil {
+		if flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrExist}
+		}
+		return fsys.openSpecial(ctx, target, n, flag)
+	}
+	return fs.OpenFile(plainFS{fsys}, name, flag, perm)
+}
+
+func (fsys *FS) OpenFile(name string, flag int, perm fs.FileMode) (fs.File, error) {
+	return fsys.OpenFileContext(context.Background(), name, flag, perm)
+}
+
+// plainFS hides the OpenFile methods of FS so fs.OpenFile falls back to
+// opening, creating and truncating files with the other methods.
+type plainFS struct {
+	fsys *FS
+}
+
+func (p plainFS) Open(name string) (fs.File, error) { return p.fsys.Open(name) }
+
+func (p plainFS) Create(name string) (fs.File, error) { return p.fsys.Create(name) }
+
+func (p plainFS) Chmod(name string, mode fs.FileMode) error { return p.fsys.Chmod(name, mode) }
+
+// openNode opens the ordinary file node n at name for writing.
+func (fsys *FS) openNode(name string, n *fskit.Node) (fs.File, error) {
+	f, err := n.Open(".")
+	if err != nil {
+		return nil, err
+	}
+	h := &handle{File: f, fsys: fsys, name: name, node: n}
+	if _, ok := f.(interface{ Truncate(int64) error }); ok {
+		return &truncHandle{h}, nil
+	}
+	return h, nil
+}
+
+// handle wraps a file opened for writing. Handles sync their changes to
+// the node when closed, so snapshots taken since it was opened are given
+// their own copy of the node first.
+type handle struct {
+	fs.File
+	fsys *FS
+	name string
+	node *fskit.Node
+}
+
+// Close syncs the handle's changes to the node.
+func (h *handle) Close() error {
+	h.fsys.mu.Lock()
+	h.fsys.release(h.name, h.node)
+	h.fsys.mu.Unlock()
+	return h.File.Close()
+}
+
+// Read delegates to the underlying file.
+func (h *handle) Read(p []byte) (int, error) {
+	return h.File.Read(p)
+}
+
+// ReadAt delegates to the underlying file.
+func (h *handle) ReadAt(p []byte, off int64) (int, error) {
+	return h.File.(io.ReaderAt).ReadAt(p, off)
+}
+
+// Write delegates to the underlying file.
+func (h *handle) Write(p []byte) (int, error) {
+	return h.File.(io.Writer).Write(p)
+}
+
+// WriteAt delegates to the underlying file.
+func (h *handle) WriteAt(p []byte, off int64) (int, error) {
+	return h.File.(io.WriterAt).WriteAt(p, off)
+}
+
+// Seek delegates to the underlying file.
+func (h *handle) Seek(offset int64, whence int) (int64, error) {
+	return h.File.(io.Seeker).Seek(offset, whence)
+}
+
+// truncHandle is a handle on a file that can be truncated and synced.
+type truncHandle struct {
+	*handle
+}
+
+// Truncate delegates to the underlying file.
+func (h *truncHandle) Truncate(size int64) error {
+	return h.File.(interface{ Truncate(int64) error }).Truncate(size)
+}
+
+// Sync delegates to the underlying file.
+func (h *truncHandle) Sync() error {
+	return h.File.(interface{ Sync() error }).Sync()
+}
+
+// isSpecial reports whether mode is a named pipe or socket.
+func isSpecial(mode fs.FileMode) bool {
+	return mode&(fs.ModeNamedPipe|fs.ModeSocket) != 0
+}
+
+// maxSymlinks is how many symlinks special follows before giving up.
+const maxSymlinks = 40
+
+// special returns the node at name and its path if it is a named pipe or
+// socket. If follow is set, relative symlinks are followed to find it.
+func (fsys *FS) special(name string, follow bool) (string, *fskit.Node) {
+	if !fs.ValidPath(name) {
+		return "", nil
+	}
+	name = path.Clean(name)
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+	for i := 0; i <= maxSymlinks; i++ {
+		n := fsys.nodes[name]
+		if n == nil {
+			return "", nil
+		}
+		if isSpecial(n.Mode()) {
+			return name, n
+		}
+		target := string(n.Data())
+		if !follow || !fs.IsSymlink(n.Mode()) || path.IsAbs(target) {
+			// absolute targets are resolved against the origin by
+			// OpenContext
+			return "", nil
+		}
+		name = path.Join(path.Dir(name), target)
+		if !fs.ValidPath(name) {
+			return "", nil
+		}
+	}
+	return "", nil
+}
+
+// openSpecial opens the named pipe or socket node n. Sockets have nothing
+// listening on them, so they can't be opened.
+func (fsys *FS) openSpecial(ctx context.Context, name string, n *fskit.Node, flag int) (fs.File, error) {
+	if n.Mode()&fs.ModeSocket != 0 {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: syscall.ENXIO}
+	}
+	fsys.mu.Lock()
+	f := fsys.fifo(n)
+	fsys.mu.Unlock()
+	info := n.Clone()
+	fskit.SetName(info, name)
+	return f.Open(ctx, info, flag)
+}
+
 func (fsys *FS) Create(name string) (f fs.File, err error) {
 	defer func() {
 		fsys.log.Debug("create", "name", name, "err", err)
@@ -344,15 +735,15 @@ func (fsys *FS) Create(name string) (f fs.File, err error) {
 	}
 
 	fsys.mu.Lock()
-	node := fskit.Entry(name, fs.FileMode(0644), time.Now())
+	node := fskit.Entry(name, fs.FileMode(0644), time.Now(), fskit.NewChunks())
 	fskit.SetLogger(node, fsys.log)
-	fsys.nodes[name] = node
+	fsys.put(name, node)
 	// Update parent directory size
 	fsys.updateDirSize(dir)
 	fsys.mu.Unlock()
 
 	// Open the file AFTER releasing fsys.mu to avoid deadlock
-	return node.Open(".")
+	return fsys.openNode(name, node)
 }
 
 func (fsys *FS) Mkdir(name string, perm fs.FileMode) (err error) {
@@ -386,7 +777,7 @@ func (fsys *FS) Mkdir(name string, perm fs.FileMode) (err error) {
 	node := fskit.Entry(name, perm|fs.ModeDir, time.Now())
 	fskit.SetSize(node, 2) // Set initial size to 2 for "." and ".." entries
 	fskit.SetLogger(node, fsys.log)
-	fsys.nodes[name] = node
+	fsys.put(name, node)
 	// Update parent directory size, mtime, and nlink
 	fsys.updateDirSize(dir)
 	return nil
@@ -410,7 +801,7 @@ func (fsys *FS) Chmod(name string, mode fs.FileMode) (err error) {
 	}
 
 	fsys.mu.Lock()
-	node := fsys.nodes[name]
+	node := fsys.own(name)
 	fsys.mu.Unlock()
 
 	if node == nil {
@@ -441,7 +832,7 @@ func (fsys *FS) Chown(name string, uid, gid int) (err error) {
 	}
 
 	fsys.mu.Lock()
-	node := fsys.nodes[name]
+	node := fsys.own(name)
 	fsys.mu.Unlock()
 
 	if node == nil {
@@ -472,7 +863,7 @@ func (fsys *FS) Chtimes(name string, atime, mtime time.Time) (err error) {
 	}
 
 	fsys.mu.Lock()
-	node := fsys.nodes[name]
+	node := fsys.own(name)
 	fsys.mu.Unlock()
 
 	if node == nil {
@@ -494,20 +885,26 @@ func (fsys *FS) Truncate(name string, size int64) (err error) {
 	}
 
 	fsys.mu.Lock()
-	node := fsys.nodes[name]
+	node := fsys.own(name)
 	fsys.mu.Unlock()
 
 	if node == nil {
 		return &fs.PathError{Op: "truncate", Path: name, Err: fs.ErrNotExist}
 	}
 
-	// Get current data and resize it
-	data := node.Data()
-
 	if size < 0 {
 		return &fs.PathError{Op: "truncate", Path: name, Err: fs.ErrInvalid}
 	}
 
+	if c := node.Chunks(); c != nil {
+		c.Truncate(size)
+		fskit.SetModTime(node, time.Now())
+		return nil
+	}
+
+	// Get current data and resize it
+	data := node.Data()
+
 	if size == int64(len(data)) {
 		// No change needed
 		return nil
@@ -567,7 +964,7 @@ func (fsys *FS) Remove(name string) (err error) {
 	dir := path.Dir(name)
 	fsys.mu.Lock()
 	defer fsys.mu.Unlock()
-	delete(fsys.nodes, name)
+	fsys.del(name)
 	// Update parent directory size
 	fsys.updateDirSize(dir)
 	return nil
@@ -595,6 +992,7 @@ func (fsys *FS) Rename(oldpath, newpath string) (err error) {
 	if !exists {
 		return &fs.PathError{Op: "rename", Path: oldpath, Err: fs.ErrNotExist}
 	}
+	fsys.unshare()
 
 	// Check if parent directory of newpath exists
 	if newDir := path.Dir(newpath); newDir != "." {
@@ -615,10 +1013,10 @@ func (fsys *FS) Rename(oldpath, newpath string) (err error) {
 				}
 			}
 			// Empty directory: remove it and all its descendants (should be none)
-			delete(fsys.nodes, newpath)
+			fsys.del(newpath)
 		} else {
 			// Destination is a file: remove it
-			delete(fsys.nodes, newpath)
+			fsys.del(newpath)
 		}
 	}
 
@@ -686,12 +1084,60 @@ func (fsys *FS) Symlink(oldname, newname string) (err error) {
 	fsys.mu.Lock()
 	defer fsys.mu.Unlock()
 	// symlinks don't care if target exists so we can just create it
-	fsys.nodes[newname] = fskit.RawNode([]byte(oldname), fs.FileMode(0777)|fs.ModeSymlink)
+	fsys.put(newname, fskit.RawNode([]byte(oldname), fs.FileMode(0777)|fs.ModeSymlink))
 	// Update parent directory size
 	fsys.updateDirSize(dir)
 	return nil
 }
 
+// Mknod creates a named pipe, socket or empty regular file node, as given
+// by the type bits of mode. Device nodes are not supported.
+func (fsys *FS) Mknod(name string, mode fs.FileMode, dev int) (err error) {
+	defer func() {
+		fsys.log.Debug("mknod", "name", name, "mode", mode, "err", err)
+	}()
+	name = path.Clean(name)
+	if !fs.ValidPath(name) {
+		return &fs.PathError{Op: "mknod", Path: name, Err: fs.ErrNotExist}
+	}
+
+	var node *fskit.Node
+	switch mode.Type() {
+	case fs.ModeNamedPipe, fs.ModeSocket:
+		node = fskit.Entry(name, mode, time.Now())
+	case 0:
+		node = fskit.Entry(name, mode, time.Now(), fskit.NewChunks())
+	default:
+		return &fs.PathError{Op: "mknod", Path: name, Err: fs.ErrNotSupported}
+	}
+
+	ok, err := fs.Exists(fsys, name)
+	if err != nil {
+		return err
+	}
+	if ok {
+		return &fs.PathError{Op: "mknod", Path: name, Err: fs.ErrExist}
+	}
+
+	dir := path.Dir(name)
+	if dir != "." {
+		ok, err := fs.Exists(fsys, dir)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return &fs.PathError{Op: "mknod", Path: name, Err: fs.ErrNotExist}
+		}
+	}
+
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+	fskit.SetLogger(node, fsys.log)
+	fsys.put(name, node)
+	fsys.updateDirSize(dir)
+	return nil
+}
+
 func (fsys *FS) Readlink(name string) (link string, err error) {
 	defer func() {
 		fsys.log.Debug("readlink", "name", name, "err", err)
@@ -3,8 +3,12 @@ package memfs
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"os"
 	"strings"
+	"syscall"
 	"testing"
 	"testing/fstest"
 	"time"
@@ -615,3 +619,396 @@ func TestTruncate(t *testing.T) {
 		}
 	})
 }
+
+func TestMemFSSparse(t *testing.T) {
+	m := New()
+	f, err := fs.Create(m, "disk.img")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, ok := f.(io.WriterAt)
+	if !ok {
+		t.Fatal("file does not implement io.WriterAt")
+	}
+	off := int64(512 << 20)
+	if _, err := w.WriteAt([]byte("boot"), off); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := fs.Stat(m, "disk.img")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size() != off+4 {
+		t.Fatalf("size = %d, want %d", fi.Size(), off+4)
+	}
+	node, _ := m.Node("disk.img")
+	if alloc := node.Chunks().Allocated(); alloc > fskit.ChunkSize {
+		t.Fatalf("allocated %d bytes for a single write", alloc)
+	}
+
+	g, err := m.Open("disk.img")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.Close()
+	p := make([]byte, 4)
+	if _, err := g.(io.ReaderAt).ReadAt(p, off); err != nil {
+		t.Fatal(err)
+	}
+	if string(p) != "boot" {
+		t.Fatalf("unexpected data: %q", p)
+	}
+
+	if err := fs.Truncate(m, "disk.img", 0); err != nil {
+		t.Fatal(err)
+	}
+	if node.Chunks().Allocated() != 0 {
+		t.Fatal("truncate did not release pages")
+	}
+}
+
+func TestMemFSSnapshot(t *testing.T) {
+	m := New()
+	if err := fs.MkdirAll(m, "src", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.WriteFile(m, "src/main.go", []byte("package main"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.WriteFile(m, "README", []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	snap := m.Snapshot()
+
+	// changes to the original don't show up in the snapshot
+	if err := fs.WriteFile(m, "src/main.go", []byte("package other"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.WriteFile(m, "src/new.go", []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Chmod("README", 0600); err != nil {
+		t.Fatal(err)
+	}
+	b, err := fs.ReadFile(snap, "src/main.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "package main" {
+		t.Fatalf("snapshot saw write: %q", b)
+	}
+	if ok, _ := fs.Exists(snap, "src/new.go"); ok {
+		t.Fatal("snapshot saw new file")
+	}
+	fi, err := fs.Stat(snap, "README")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0644 {
+		t.Fatalf("snapshot saw chmod: %v", fi.Mode())
+	}
+
+	// and the other way around
+	if err := snap.Remove("README"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.WriteFile(snap, "src/main.go", []byte("package snap"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	b, err = fs.ReadFile(m, "README")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "hello" {
+		t.Fatalf("unexpected data: %q", b)
+	}
+	b, err = fs.ReadFile(m, "src/main.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "package other" {
+		t.Fatalf("original saw snapshot write: %q", b)
+	}
+
+	// snapshots of snapshots
+	snap2 := snap.Snapshot()
+	if err := snap.Rename("src", "lib"); err != nil {
+		t.Fatal(err)
+	}
+	b, err = fs.ReadFile(snap2, "src/main.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "package snap" {
+		t.Fatalf("unexpected data: %q", b)
+	}
+}
+
+func TestMemFSSnapshotSparse(t *testing.T) {
+	m := New()
+	f, err := fs.Create(m, "disk.img")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.(io.WriterAt).WriteAt([]byte("aaaa"), 0)
+	f.(io.WriterAt).WriteAt([]byte("bbbb"), 1<<20)
+	f.Close()
+
+	snap := m.Snapshot()
+	f, err = snap.Open("disk.img")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.(io.WriterAt).WriteAt([]byte("cccc"), 0)
+	f.Close()
+
+	node, _ := m.Node("disk.img")
+	p := make([]byte, 4)
+	node.Chunks().ReadAt(p, 0)
+	if string(p) != "aaaa" {
+		t.Fatalf("original saw snapshot write: %q", p)
+	}
+	snode, _ := snap.Node("disk.img")
+	snode.Chunks().ReadAt(p, 1<<20)
+	if string(p) != "bbbb" {
+		t.Fatalf("unexpected data: %q", p)
+	}
+}
+
+func TestMemFSSnapshotTruncate(t *testing.T) {
+	m := New()
+	if err := fs.WriteFile(m, "file", []byte("hello world"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	snap := m.Snapshot()
+	if err := snap.Truncate("file", 5); err != nil {
+		t.Fatal(err)
+	}
+	if err := snap.Truncate("file", 11); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := fs.ReadFile(m, "file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "hello world" {
+		t.Fatalf("original saw snapshot truncate: %q", b)
+	}
+	b, err = fs.ReadFile(snap, "file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "hello\x00\x00\x00\x00\x00\x00" {
+		t.Fatalf("unexpected snapshot data: %q", b)
+	}
+}
+
+func TestMemFSSnapshotOpenFile(t *testing.T) {
+	m := New()
+	f, err := fs.Create(m, "log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := f.(io.Writer)
+	if _, err := w.Write([]byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a handle opened before the snapshot keeps writing to the original
+	f, err = m.OpenFile("log", os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	snap := m.Snapshot()
+	snap2 := snap.Snapshot()
+	if err := snap2.Rename("log", "log.old"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.(io.Writer).Write([]byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		fsys *FS
+		name string
+		want string
+	}{
+		{m, "log", "ab"},
+		{snap, "log", "a"},
+		{snap2, "log.old", "a"},
+	} {
+		b, err := fs.ReadFile(tt.fsys, tt.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != tt.want {
+			t.Fatalf("%s = %q, want %q", tt.name, b, tt.want)
+		}
+	}
+
+	// later writes in the original don't reach the snapshots either
+	if err := fs.WriteFile(m, "log", []byte("c"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := fs.ReadFile(snap, "log"); string(b) != "a" {
+		t.Fatalf("snapshot saw write: %q", b)
+	}
+}
+
+func TestMemFSNamedPipe(t *testing.T) {
+	m := New()
+	if err := fs.Mknod(m, "fifo", fs.ModeNamedPipe|0644, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Mknod(m, "fifo", fs.ModeNamedPipe|0644, 0); !errors.Is(err, fs.ErrExist) {
+		t.Fatalf("expected ErrExist, got %v", err)
+	}
+
+	// stat doesn't wait for a writer
+	fi, err := fs.Stat(m, "fifo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Type() != fs.ModeNamedPipe {
+		t.Fatalf("unexpected mode: %v", fi.Mode())
+	}
+
+	// opening for reading waits for a writer
+	opened := make(chan fs.File)
+	go func() {
+		r, err := fs.OpenFile(m, "fifo", os.O_RDONLY, 0)
+		if err != nil {
+			t.Error(err)
+		}
+		opened <- r
+	}()
+	select {
+	case <-opened:
+		t.Fatal("reader opened without a writer")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	w, err := fs.OpenFile(m, "fifo", os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := <-opened
+	if _, err := fs.Write(w, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	// the reader gets what was written, then EOF once the writer closes
+	b, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "hello" {
+		t.Fatalf("unexpected data: %q", b)
+	}
+	r.Close()
+
+	// writing with no readers is a broken pipe
+	rw, err := fs.OpenFile(m, "fifo", os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err = fs.OpenFile(m, "fifo", os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rw.Close()
+	if _, err := fs.Write(w, []byte("x")); !errors.Is(err, syscall.EPIPE) {
+		t.Fatalf("expected EPIPE, got %v", err)
+	}
+	w.Close()
+
+	// a waiting open gives up with its context
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := m.OpenFileContext(ctx, "fifo", os.O_WRONLY, 0); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+}
+
+func TestMemFSNamedPipeSymlink(t *testing.T) {
+	m := New()
+	if err := fs.MkdirAll(m, "dir", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Mknod(m, "fifo", fs.ModeNamedPipe|0644, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Symlink(m, "../fifo", "dir/link"); err != nil {
+		t.Fatal(err)
+	}
+
+	// a writer opened through the link is a writer on the pipe
+	opened := make(chan fs.File)
+	go func() {
+		r, err := fs.OpenFile(m, "fifo", os.O_RDONLY, 0)
+		if err != nil {
+			t.Error(err)
+		}
+		opened <- r
+	}()
+	w, err := fs.OpenFile(m, "dir/link", os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := <-opened
+	if _, err := fs.Write(w, []byte("hello")); err != nil {
+		t.Fatalf("write through link: %v", err)
+	}
+	w.Close()
+	b, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "hello" {
+		t.Fatalf("unexpected data: %q", b)
+	}
+	r.Close()
+}
+
+func TestMemFSSocketNode(t *testing.T) {
+	m := New()
+	if err := fs.Mknod(m, "sock", fs.ModeSocket|0755, 0); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := fs.Stat(m, "sock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Type() != fs.ModeSocket {
+		t.Fatalf("unexpected mode: %v", fi.Mode())
+	}
+	if _, err := m.Open("sock"); !errors.Is(err, syscall.ENXIO) {
+		t.Fatalf("expected ENXIO, got %v", err)
+	}
+	if err := fs.Mknod(m, "dev", fs.ModeDevice|0644, 1); !errors.Is(err, fs.ErrNotSupported) {
+		t.Fatalf("expected ErrNotSupported, got %v", err)
+	}
+
+	// special nodes are copied without their contents
+	if err := fs.CopyAll(m, "sock", "sock2"); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := fs.Stat(m, "sock2"); err != nil || fi.Mode().Type() != fs.ModeSocket {
+		t.Fatalf("unexpected copy: %v %v", fi, err)
+	}
+}
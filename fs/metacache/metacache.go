@@ -1,6 +1,7 @@
 package metacache
 
 import (
+	"container/list"
 	"context"
 	"errors"
 	"io"
@@ -8,6 +9,7 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"tractor.dev/wanix/fs"
@@ -15,6 +17,12 @@ import (
 
 const (
 	DefaultTTL = 30 * time.Second
+
+	// DefaultMaxEntries bounds the cache so walking a large tree doesn't
+	// grow memory without limit. Least recently used entries are evicted,
+	// approximately: hits mark an entry and eviction gives marked entries
+	// a second chance.
+	DefaultMaxEntries = 10000
 )
 
 // cacheEntry represents a cached metadata result
@@ -25,17 +33,25 @@ type cacheEntry struct {
 	cachedAt  time.Time     // When the entry was cached
 	renewsAt  time.Time     // Halfway point - trigger refresh-ahead
 	expiresAt time.Time     // Hard expiry - must refetch
+
+	key  string        // Cache key, for eviction
+	elem *list.Element // Position in the LRU list
+	used atomic.Bool   // Hit since last considered for eviction
 }
 
 // FS wraps a filesystem and caches metadata (FileInfo/DirEntry) with
-// configurable TTL, refresh-ahead at halfway through expiry, and
-// explicit invalidation methods.
+// configurable TTL, refresh-ahead at halfway through expiry, a bound on
+// the number of entries, and explicit invalidation methods.
 type FS struct {
 	*fs.DefaultFS
-	cache   map[string]*cacheEntry
-	cacheMu sync.RWMutex
-	ttl     time.Duration
-	log     *slog.Logger
+	cache      map[string]*cacheEntry
+	lru        *list.List // front is most recently used
+	cacheMu    sync.RWMutex
+	ttl        time.Duration
+	negTTL     time.Duration // 0 means half of ttl
+	maxEntries int           // 0 means unbounded
+	stats      counters
+	log        *slog.Logger
 }
 
 // New wraps an fs.FS with metadata caching using the default TTL.
@@ -46,10 +62,12 @@ func New(fsys fs.FS) *FS {
 // NewWithTTL wraps an fs.FS with metadata caching using the specified TTL.
 func NewWithTTL(fsys fs.FS, ttl time.Duration) *FS {
 	return &FS{
-		DefaultFS: fs.NewDefault(fsys),
-		cache:     make(map[string]*cacheEntry),
-		ttl:       ttl,
-		log:       slog.Default(),
+		DefaultFS:  fs.NewDefault(fsys),
+		cache:      make(map[string]*cacheEntry),
+		lru:        list.New(),
+		ttl:        ttl,
+		maxEntries: DefaultMaxEntries,
+		log:        slog.Default(),
 	}
 }
 
@@ -76,6 +94,44 @@ func (f *FS) GetTTL() time.Duration {
 	return f.ttl
 }
 
+// SetNegativeTTL sets how long errors such as fs.ErrNotExist are cached.
+// Zero, the default, uses half the TTL. Only affects new entries.
+func (f *FS) SetNegativeTTL(ttl time.Duration) {
+	f.cacheMu.Lock()
+	defer f.cacheMu.Unlock()
+	f.negTTL = ttl
+}
+
+// GetNegativeTTL returns how long errors are cached.
+func (f *FS) GetNegativeTTL() time.Duration {
+	f.cacheMu.RLock()
+	defer f.cacheMu.RUnlock()
+	return f.negativeTTL()
+}
+
+func (f *FS) negativeTTL() time.Duration {
+	if f.negTTL == 0 {
+		return f.ttl / 2
+	}
+	return f.negTTL
+}
+
+// SetMaxEntries sets the maximum number of cached entries, evicting the
+// least recently used entries over the new bound. Zero means unbounded.
+func (f *FS) SetMaxEntries(n int) {
+	f.cacheMu.Lock()
+	defer f.cacheMu.Unlock()
+	f.maxEntries = n
+	f.evictLocked()
+}
+
+// GetMaxEntries returns the maximum number of cached entries.
+func (f *FS) GetMaxEntries() int {
+	f.cacheMu.RLock()
+	defer f.cacheMu.RUnlock()
+	return f.maxEntries
+}
+
 // ============================================================================
 // Cache Invalidation
 // ============================================================================
@@ -88,9 +144,10 @@ func (f *FS) Invalidate(path string) {
 	f.cacheMu.Lock()
 	defer f.cacheMu.Unlock()
 
-	delete(f.cache, path)
-	delete(f.cache, path+":lstat")
-	delete(f.cache, path+":readdir")
+	f.stats.invalidations.Add(1)
+	f.removeLocked(path)
+	f.removeLocked(path + ":lstat")
+	f.removeLocked(path + ":readdir")
 }
 
 // InvalidateDir removes a directory and all its children from the cache.
@@ -101,10 +158,12 @@ func (f *FS) InvalidateDir(path string) {
 	f.cacheMu.Lock()
 	defer f.cacheMu.Unlock()
 
+	f.stats.invalidations.Add(1)
+
 	// Delete the directory itself
-	delete(f.cache, path)
-	delete(f.cache, path+":lstat")
-	delete(f.cache, path+":readdir")
+	f.removeLocked(path)
+	f.removeLocked(path + ":lstat")
+	f.removeLocked(path + ":readdir")
 
 	// Delete all children
 	prefix := path + "/"
@@ -113,7 +172,7 @@ func (f *FS) InvalidateDir(path string) {
 	}
 	for key := range f.cache {
 		if prefix != "" && strings.HasPrefix(key, prefix) {
-			delete(f.cache, key)
+			f.removeLocked(key)
 		}
 	}
 }
@@ -124,7 +183,41 @@ func (f *FS) InvalidateAll() {
 
 	f.cacheMu.Lock()
 	defer f.cacheMu.Unlock()
+	f.stats.invalidations.Add(1)
 	f.cache = make(map[string]*cacheEntry)
+	f.lru.Init()
+}
+
+// Subscribe invalidates cached entries as the wrapped filesystem reports
+// changes through fs.Watch, until ctx is done. It returns once subscribed,
+// or with an error if the wrapped filesystem can't be watched.
+func (f *FS) Subscribe(ctx context.Context) error {
+	events, err := fs.Watch(f.DefaultFS.FS, ctx, "./...")
+	if err != nil {
+		return err
+	}
+	go func() {
+		for e := range events {
+			if e.Err != nil {
+				continue
+			}
+			f.invalidateEvent(e)
+		}
+	}()
+	return nil
+}
+
+// invalidateEvent drops the entries a watch event makes stale: the changed
+// path, everything below it if it was removed or reset, and its parent's
+// listing.
+func (f *FS) invalidateEvent(e fs.Event) {
+	switch e.Op {
+	case "remove", "reset":
+		f.InvalidateDir(e.Path)
+	default:
+		f.Invalidate(e.Path)
+	}
+	f.invalidateParent(e.Path)
 }
 
 // ============================================================================
@@ -142,35 +235,50 @@ func normalizePath(p string) string {
 
 // getCached retrieves a cached entry if it exists and is not expired.
 // Returns the entry, whether a refresh-ahead should be triggered, and whether found.
+// Hits only take the read lock; recency is recorded on the entry and
+// applied by evictLocked.
 func (f *FS) getCached(key string) (*cacheEntry, bool, bool) {
 	f.cacheMu.RLock()
 	entry, exists := f.cache[key]
-	f.cacheMu.RUnlock()
-
 	if !exists {
+		f.cacheMu.RUnlock()
+		f.stats.misses.Add(1)
 		return nil, false, false
 	}
 
 	now := time.Now()
-
-	// Expired - remove and return not found
 	if now.After(entry.expiresAt) {
-		f.cacheMu.Lock()
-		delete(f.cache, key)
-		f.cacheMu.Unlock()
-		f.log.Debug("metacache.expired", "key", key, "expired", time.Since(entry.expiresAt))
+		f.cacheMu.RUnlock()
+		f.expire(key, entry, now)
 		return nil, false, false
 	}
 
+	entry.used.Store(true)
 	// Check if refresh-ahead should be triggered
 	needsRefresh := !entry.renewsAt.IsZero() && now.After(entry.renewsAt)
+	f.cacheMu.RUnlock()
+
+	f.stats.hits.Add(1)
 	if needsRefresh {
+		f.stats.refreshes.Add(1)
 		f.log.Debug("metacache.refresh-ahead", "key", key)
 	}
-
 	return entry, needsRefresh, true
 }
 
+// expire removes an entry getCached found past its expiry, unless it was
+// replaced or extended after the read lock was dropped.
+func (f *FS) expire(key string, entry *cacheEntry, now time.Time) {
+	f.cacheMu.Lock()
+	if f.cache[key] == entry && now.After(entry.expiresAt) {
+		f.removeLocked(key)
+		f.stats.expirations.Add(1)
+	}
+	f.cacheMu.Unlock()
+	f.stats.misses.Add(1)
+	f.log.Debug("metacache.expired", "key", key)
+}
+
 // setCached stores an entry in the cache.
 func (f *FS) setCached(key string, info fs.FileInfo, entries []fs.DirEntry, err error) {
 	f.cacheMu.Lock()
@@ -182,21 +290,49 @@ func (f *FS) setCached(key string, info fs.FileInfo, entries []fs.DirEntry, err
 		entries:  entries,
 		err:      err,
 		cachedAt: now,
+		key:      key,
 	}
 
 	if err != nil {
-		// Errors get shorter TTL and no refresh-ahead
-		entry.expiresAt = now.Add(f.ttl / 2)
+		// Errors get their own TTL and no refresh-ahead
+		entry.expiresAt = now.Add(f.negativeTTL())
 		// renewsAt stays zero - no refresh-ahead for errors
 	} else {
 		entry.renewsAt = now.Add(f.ttl / 2)
 		entry.expiresAt = now.Add(f.ttl)
 	}
 
+	f.removeLocked(key)
+	entry.elem = f.lru.PushFront(entry)
 	f.cache[key] = entry
+	f.evictLocked()
 	f.log.Debug("metacache.cached", "key", key, "err", err != nil)
 }
 
+// removeLocked deletes an entry from the cache. Caller must hold cacheMu.
+func (f *FS) removeLocked(key string) {
+	if entry, exists := f.cache[key]; exists {
+		f.lru.Remove(entry.elem)
+		delete(f.cache, key)
+	}
+}
+
+// evictLocked removes least recently used entries until the cache is
+// within maxEntries. Entries hit since they were last considered are moved
+// to the front instead, once. Caller must hold cacheMu.
+func (f *FS) evictLocked() {
+	for f.maxEntries > 0 && f.lru.Len() > f.maxEntries {
+		entry := f.lru.Back().Value.(*cacheEntry)
+		if entry.used.Swap(false) {
+			f.lru.MoveToFront(entry.elem)
+			continue
+		}
+		f.removeLocked(entry.key)
+		f.stats.evictions.Add(1)
+		f.log.Debug("metacache.evicted", "key", entry.key)
+	}
+}
+
 // invalidateParent removes the parent directory's readdir cache.
 func (f *FS) invalidateParent(path string) {
 	path = normalizePath(path)
@@ -205,7 +341,7 @@ func (f *FS) invalidateParent(path string) {
 		parent = "."
 	}
 	f.cacheMu.Lock()
-	delete(f.cache, parent+":readdir")
+	f.removeLocked(parent + ":readdir")
 	f.cacheMu.Unlock()
 }
 
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"io"
+	"strings"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -965,3 +966,153 @@ func TestFileReadDirIterative(t *testing.T) {
 		t.Errorf("expected EOF, got %v", err)
 	}
 }
+
+func TestMaxEntriesEvictsLRU(t *testing.T) {
+	tracker, cached := newTestFS()
+	cached.SetMaxEntries(2)
+
+	for _, name := range []string{"a", "b", "c"} {
+		f, _ := tracker.Create(name)
+		f.Close()
+	}
+
+	cached.Stat("a")
+	cached.Stat("b")
+	cached.Stat("a") // a is now most recently used
+	cached.Stat("c") // evicts b
+
+	if stats := cached.Stats(); stats.Entries != 2 || stats.Evictions != 1 {
+		t.Errorf("expected 2 entries and 1 eviction, got %d and %d", stats.Entries, stats.Evictions)
+	}
+
+	calls := tracker.statCalls.Load()
+	cached.Stat("a")
+	if tracker.statCalls.Load() != calls {
+		t.Error("expected a to still be cached")
+	}
+	cached.Stat("b")
+	if tracker.statCalls.Load() != calls+1 {
+		t.Error("expected b to have been evicted")
+	}
+
+	// shrinking the bound evicts immediately
+	cached.SetMaxEntries(1)
+	if stats := cached.Stats(); stats.Entries != 1 {
+		t.Errorf("expected 1 entry after shrinking, got %d", stats.Entries)
+	}
+}
+
+func TestNegativeTTL(t *testing.T) {
+	tracker, cached := newTestFS()
+	cached.SetTTL(time.Minute)
+
+	if cached.GetNegativeTTL() != 30*time.Second {
+		t.Errorf("expected negative TTL to default to half the TTL, got %v", cached.GetNegativeTTL())
+	}
+
+	cached.SetNegativeTTL(50 * time.Millisecond)
+	if _, err := cached.Stat("missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected ErrNotExist, got %v", err)
+	}
+	f, _ := tracker.Create("missing")
+	f.Close()
+
+	// still cached as missing
+	if _, err := cached.Stat("missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected cached ErrNotExist, got %v", err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if _, err := cached.Stat("missing"); err != nil {
+		t.Fatalf("expected negative entry to expire, got %v", err)
+	}
+}
+
+func TestStats(t *testing.T) {
+	tracker, cached := newTestFS()
+	f, _ := tracker.Create("test.txt")
+	f.Close()
+
+	cached.Stat("test.txt")
+	cached.Stat("test.txt")
+	cached.Stat("test.txt")
+	cached.Invalidate("test.txt")
+
+	stats := cached.Stats()
+	if stats.Hits != 2 || stats.Misses != 1 || stats.Invalidations != 1 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+	if stats.Entries != 0 {
+		t.Errorf("expected no entries after invalidate, got %d", stats.Entries)
+	}
+}
+
+func TestStatsFS(t *testing.T) {
+	tracker, cached := newTestFS()
+	f, _ := tracker.Create("test.txt")
+	f.Close()
+	cached.Stat("test.txt")
+
+	view := cached.StatsFS()
+	data, err := fs.ReadFile(view, "stats")
+	if err != nil {
+		t.Fatalf("failed to read stats: %v", err)
+	}
+	if !strings.Contains(string(data), "entries 1\n") || !strings.Contains(string(data), "misses 1\n") {
+		t.Errorf("unexpected stats:\n%s", data)
+	}
+
+	if err := fs.WriteFile(view, "ctl", []byte("invalidate test.txt\n"), 0); err != nil {
+		t.Fatalf("failed to write ctl: %v", err)
+	}
+	if cached.Stats().Entries != 0 {
+		t.Error("expected ctl invalidate to drop the entry")
+	}
+
+	if err := fs.WriteFile(view, "ctl", []byte("bogus\n"), 0); err == nil {
+		t.Error("expected error for unknown command")
+	}
+}
+
+// watchingFS is a trackingFS that reports changes sent on events.
+type watchingFS struct {
+	*trackingFS
+	events chan fs.Event
+}
+
+func (w *watchingFS) Watch(ctx context.Context, name string, exclude ...string) (<-chan fs.Event, error) {
+	return w.events, nil
+}
+
+func TestSubscribe(t *testing.T) {
+	tracker := &watchingFS{trackingFS: newTrackingFS(), events: make(chan fs.Event)}
+	cached := New(tracker)
+	if err := cached.Subscribe(context.Background()); err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+	defer close(tracker.events)
+
+	fs.MkdirAll(tracker, "dir", 0755)
+	f, _ := tracker.Create("dir/test.txt")
+	f.Close()
+
+	cached.Stat("dir/test.txt")
+	cached.ReadDirContext(context.Background(), "dir")
+	if cached.Stats().Entries != 2 {
+		t.Fatalf("expected 2 entries, got %d", cached.Stats().Entries)
+	}
+
+	tracker.events <- fs.Event{Path: "dir/test.txt", Op: "write"}
+	// a second event ensures the first was handled
+	tracker.events <- fs.Event{Path: "other", Op: "write"}
+	if n := cached.Stats().Entries; n != 0 {
+		t.Errorf("expected watch event to invalidate entry and parent listing, got %d entries", n)
+	}
+
+	cached.Stat("dir/test.txt")
+	tracker.events <- fs.Event{Path: "dir", Op: "remove"}
+	tracker.events <- fs.Event{Path: "other", Op: "write"}
+	if n := cached.Stats().Entries; n != 0 {
+		t.Errorf("expected removed dir to invalidate children, got %d entries", n)
+	}
+}
@@ -0,0 +1,118 @@
+package metacache
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"tractor.dev/wanix/fs"
+	"tractor.dev/wanix/fs/fskit"
+	"tractor.dev/wanix/misc/shlex"
+)
+
+// counters are atomic: hits are counted under the read lock, and Stats
+// loads them without cacheMu.
+type counters struct {
+	hits          atomic.Uint64
+	misses        atomic.Uint64
+	refreshes     atomic.Uint64
+	evictions     atomic.Uint64
+	expirations   atomic.Uint64
+	invalidations atomic.Uint64
+}
+
+// Stats is a snapshot of cache counters.
+type Stats struct {
+	Entries       int
+	MaxEntries    int
+	Hits          uint64
+	Misses        uint64
+	Refreshes     uint64 // refresh-aheads triggered
+	Evictions     uint64 // entries dropped to stay within MaxEntries
+	Expirations   uint64 // entries found past their hard expiry
+	Invalidations uint64
+}
+
+// String formats the stats as one "name value" pair per line.
+func (s Stats) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "entries %d\n", s.Entries)
+	fmt.Fprintf(&b, "max_entries %d\n", s.MaxEntries)
+	fmt.Fprintf(&b, "hits %d\n", s.Hits)
+	fmt.Fprintf(&b, "misses %d\n", s.Misses)
+	fmt.Fprintf(&b, "refreshes %d\n", s.Refreshes)
+	fmt.Fprintf(&b, "evictions %d\n", s.Evictions)
+	fmt.Fprintf(&b, "expirations %d\n", s.Expirations)
+	fmt.Fprintf(&b, "invalidations %d\n", s.Invalidations)
+	return b.String()
+}
+
+// Stats returns a snapshot of the cache counters.
+func (f *FS) Stats() Stats {
+	f.cacheMu.RLock()
+	entries, maxEntries := len(f.cache), f.maxEntries
+	f.cacheMu.RUnlock()
+	return Stats{
+		Entries:       entries,
+		MaxEntries:    maxEntries,
+		Hits:          f.stats.hits.Load(),
+		Misses:        f.stats.misses.Load(),
+		Refreshes:     f.stats.refreshes.Load(),
+		Evictions:     f.stats.evictions.Load(),
+		Expirations:   f.stats.expirations.Load(),
+		Invalidations: f.stats.invalidations.Load(),
+	}
+}
+
+// StatsFS returns a filesystem for inspecting and controlling the cache.
+// Reading stats returns the counters. Writing to ctl runs a command:
+//
+//	invalidate <path>      drop path and its parent's listing
+//	invalidate-dir <path>  drop path and everything below it
+//	invalidate-all         drop everything
+func (f *FS) StatsFS() fs.FS {
+	return fskit.MapFS{
+		"stats": fskit.OpenFunc(func(ctx context.Context, name string) (fs.File, error) {
+			return &fskit.FuncFile{
+				Node: fskit.Entry(name, 0444),
+				ReadFunc: func(n *fskit.Node) error {
+					fskit.SetData(n, []byte(f.Stats().String()))
+					return nil
+				},
+			}, nil
+		}),
+		"ctl": fskit.OpenFunc(func(ctx context.Context, name string) (fs.File, error) {
+			return &fskit.FuncFile{
+				Node: fskit.Entry(name, 0222),
+				CloseFunc: func(n *fskit.Node) error {
+					return f.control(string(n.Data()))
+				},
+			}, nil
+		}),
+	}
+}
+
+// control runs a ctl command.
+func (f *FS) control(cmd string) error {
+	args, err := shlex.Split(strings.TrimSpace(cmd), true)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return nil
+	}
+	switch {
+	case args[0] == "invalidate" && len(args) == 2:
+		f.Invalidate(args[1])
+		f.invalidateParent(args[1])
+	case args[0] == "invalidate-dir" && len(args) == 2:
+		f.InvalidateDir(args[1])
+		f.invalidateParent(args[1])
+	case args[0] == "invalidate-all" && len(args) == 1:
+		f.InvalidateAll()
+	default:
+		return fmt.Errorf("metacache: unknown command: %s", strings.Join(args, " "))
+	}
+	return nil
+}
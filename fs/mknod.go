@@ -0,0 +1,22 @@
+package fs
+
+type MknodFS interface {
+	FS
+	Mknod(name string, mode FileMode, dev int) error
+}
+
+// Mknod creates a special file node. The type bits of mode say what kind,
+// such as ModeNamedPipe or ModeSocket, and dev is the device number for
+// device nodes.
+func Mknod(fsys FS, name string, mode FileMode, dev int) error {
+	if c, ok := fsys.(MknodFS); ok {
+		return c.Mknod(name, mode, dev)
+	}
+
+	ctx := WithOrigin(ContextFor(fsys), fsys, name, "mknod")
+	rfsys, rname, err := ResolveTo[MknodFS](fsys, ctx, name)
+	if err == nil {
+		return rfsys.Mknod(rname, mode, dev)
+	}
+	return opErr(fsys, name, "mknod", err)
+}
@@ -0,0 +1,253 @@
+// Package cs provides Plan 9 style connection server and DNS files.
+//
+// Each open of a cs or dns file is a session: writing a query replaces
+// the session's answer, and reading returns the answer one line per read
+// until EOF. A query that can't be answered fails the write.
+//
+// The connection server translates "net!host!service" into dial strings
+// for the tcp and udp services, one per line as "<net> <host>:<port>".
+// The net may be tcp, udp or net (both) and defaults to net; a host of *
+// means any address, for announce. For example:
+//
+//	tcp!example.com!http  ->  tcp 93.184.216.34:80
+//
+// The dns file answers "<name> [type]" queries with lines of the form
+// "<name> <type> <value>". Types are ip (the default), ipv6, cname, mx,
+// txt, ns and ptr.
+package cs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"tractor.dev/wanix/fs"
+	"tractor.dev/wanix/fs/fskit"
+)
+
+// Resolver looks up names. *net.Resolver implements it.
+type Resolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+	LookupPort(ctx context.Context, network, service string) (int, error)
+	LookupCNAME(ctx context.Context, host string) (string, error)
+	LookupMX(ctx context.Context, name string) ([]*net.MX, error)
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+	LookupNS(ctx context.Context, name string) ([]*net.NS, error)
+	LookupAddr(ctx context.Context, addr string) ([]string, error)
+}
+
+// NewCS returns a connection server file at ".". A nil r uses
+// net.DefaultResolver.
+func NewCS(r Resolver) fs.FS {
+	return queryFS("cs", r, translate)
+}
+
+// NewDNS returns a DNS query file at ".". A nil r uses net.DefaultResolver.
+func NewDNS(r Resolver) fs.FS {
+	return queryFS("dns", r, lookup)
+}
+
+func queryFS(name string, r Resolver, query func(context.Context, Resolver, string) ([]string, error)) fs.FS {
+	if r == nil {
+		r = net.DefaultResolver
+	}
+	return fskit.OpenFunc(func(ctx context.Context, n string) (fs.File, error) {
+		if n != "." {
+			return nil, fs.ErrNotExist
+		}
+		return &queryFile{
+			name: name,
+			query: func(q string) ([]string, error) {
+				return query(ctx, r, q)
+			},
+		}, nil
+	})
+}
+
+// translate answers a connection server query.
+func translate(ctx context.Context, r Resolver, q string) ([]string, error) {
+	parts := strings.Split(q, "!")
+	if len(parts) == 2 {
+		parts = append([]string{"net"}, parts...)
+	}
+	if len(parts) != 3 || parts[1] == "" || parts[2] == "" {
+		return nil, fmt.Errorf("cs: bad query %q: %w", q, fs.ErrInvalid)
+	}
+	network, host, service := parts[0], parts[1], parts[2]
+
+	var nets []string
+	switch network {
+	case "tcp", "udp":
+		nets = []string{network}
+	case "net":
+		nets = []string{"tcp", "udp"}
+	default:
+		return nil, fmt.Errorf("cs: unknown network %q: %w", network, fs.ErrNotSupported)
+	}
+
+	var hosts []string
+	switch {
+	case host == "*":
+		hosts = []string{""}
+	case net.ParseIP(host) != nil:
+		hosts = []string{host}
+	default:
+		addrs, err := r.LookupHost(ctx, host)
+		if err != nil {
+			return nil, fmt.Errorf("cs: can't translate %s: %w", host, err)
+		}
+		hosts = addrs
+	}
+
+	var lines []string
+	for _, n := range nets {
+		port, err := strconv.Atoi(service)
+		if err != nil {
+			port, err = r.LookupPort(ctx, n, service)
+			if err != nil {
+				if len(nets) > 1 {
+					// the service may only be known for the other network
+					continue
+				}
+				return nil, fmt.Errorf("cs: can't translate service %s: %w", service, err)
+			}
+		}
+		for _, h := range hosts {
+			lines = append(lines, n+" "+net.JoinHostPort(h, strconv.Itoa(port)))
+		}
+	}
+	if len(lines) == 0 {
+		return nil, fmt.Errorf("cs: can't translate service %s: %w", service, fs.ErrNotExist)
+	}
+	return lines, nil
+}
+
+// lookup answers a DNS query.
+func lookup(ctx context.Context, r Resolver, q string) ([]string, error) {
+	fields := strings.Fields(q)
+	if len(fields) == 0 || len(fields) > 2 {
+		return nil, fmt.Errorf("dns: bad query %q: %w", q, fs.ErrInvalid)
+	}
+	name, typ := fields[0], "ip"
+	if len(fields) == 2 {
+		typ = fields[1]
+	}
+
+	var values []string
+	switch typ {
+	case "ip", "ipv6":
+		addrs, err := r.LookupHost(ctx, name)
+		if err != nil {
+			return nil, fmt.Errorf("dns: %s: %w", name, err)
+		}
+		for _, a := range addrs {
+			ip := net.ParseIP(a)
+			if ip == nil {
+				continue
+			}
+			if (ip.To4() != nil) == (typ == "ip") {
+				values = append(values, a)
+			}
+		}
+	case "cname":
+		cname, err := r.LookupCNAME(ctx, name)
+		if err != nil {
+			return nil, fmt.Errorf("dns: %s: %w", name, err)
+		}
+		values = append(values, cname)
+	case "mx":
+		mxs, err := r.LookupMX(ctx, name)
+		if err != nil {
+			return nil, fmt.Errorf("dns: %s: %w", name, err)
+		}
+		for _, mx := range mxs {
+			values = append(values, fmt.Sprintf("%d %s", mx.Pref, mx.Host))
+		}
+	case "txt":
+		txts, err := r.LookupTXT(ctx, name)
+		if err != nil {
+			return nil, fmt.Errorf("dns: %s: %w", name, err)
+		}
+		values = append(values, txts...)
+	case "ns":
+		nss, err := r.LookupNS(ctx, name)
+		if err != nil {
+			return nil, fmt.Errorf("dns: %s: %w", name, err)
+		}
+		for _, ns := range nss {
+			values = append(values, ns.Host)
+		}
+	case "ptr":
+		names, err := r.LookupAddr(ctx, name)
+		if err != nil {
+			return nil, fmt.Errorf("dns: %s: %w", name, err)
+		}
+		values = append(values, names...)
+	default:
+		return nil, fmt.Errorf("dns: unknown type %q: %w", typ, fs.ErrNotSupported)
+	}
+	if len(values) == 0 {
+		return nil, fmt.Errorf("dns: %s %s: %w", name, typ, fs.ErrNotExist)
+	}
+
+	lines := make([]string, len(values))
+	for i, v := range values {
+		lines[i] = name + " " + typ + " " + v
+	}
+	return lines, nil
+}
+
+// queryFile is one session on a cs or dns file.
+type queryFile struct {
+	name  string
+	query func(string) ([]string, error)
+
+	mu    sync.Mutex
+	lines []string
+}
+
+func (f *queryFile) Write(p []byte) (int, error) {
+	lines, err := f.query(strings.TrimSpace(string(p)))
+	if err != nil {
+		return 0, err
+	}
+	f.mu.Lock()
+	f.lines = lines
+	f.mu.Unlock()
+	return len(p), nil
+}
+
+func (f *queryFile) WriteAt(p []byte, off int64) (int, error) {
+	return f.Write(p)
+}
+
+// Read returns the next line of the answer.
+func (f *queryFile) Read(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.lines) == 0 {
+		return 0, io.EOF
+	}
+	line := f.lines[0] + "\n"
+	n := copy(p, line)
+	if n < len(line) {
+		f.lines[0] = line[n : len(line)-1]
+	} else {
+		f.lines = f.lines[1:]
+	}
+	return n, nil
+}
+
+func (f *queryFile) ReadAt(p []byte, off int64) (int, error) {
+	return f.Read(p)
+}
+
+func (f *queryFile) Close() error { return nil }
+
+func (f *queryFile) Stat() (fs.FileInfo, error) {
+	return fskit.Entry(f.name, fs.FileMode(0666)), nil
+}
@@ -0,0 +1,156 @@
+package cs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"tractor.dev/wanix/fs"
+)
+
+type fakeResolver struct{}
+
+func (fakeResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	switch host {
+	case "example.com":
+		return []string{"93.184.216.34", "2606:2800:220:1::1"}, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+func (fakeResolver) LookupPort(ctx context.Context, network, service string) (int, error) {
+	switch {
+	case service == "http":
+		return 80, nil
+	case service == "domain":
+		return 53, nil
+	case service == "ssh" && network == "tcp":
+		return 22, nil
+	}
+	return 0, &net.DNSError{Err: "unknown port", Name: network + "/" + service, IsNotFound: true}
+}
+
+func (fakeResolver) LookupCNAME(ctx context.Context, host string) (string, error) {
+	return "alias.example.com.", nil
+}
+
+func (fakeResolver) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	return []*net.MX{{Host: "mail.example.com.", Pref: 10}}, nil
+}
+
+func (fakeResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	return []string{"v=spf1 -all"}, nil
+}
+
+func (fakeResolver) LookupNS(ctx context.Context, name string) ([]*net.NS, error) {
+	return []*net.NS{{Host: "a.iana-servers.net."}}, nil
+}
+
+func (fakeResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	return []string{"example.com."}, nil
+}
+
+// query writes q to a new session on fsys and returns the lines read back.
+func query(t *testing.T, fsys fs.FS, q string) ([]string, error) {
+	t.Helper()
+	f, err := fsys.Open(".")
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer f.Close()
+	if _, err := f.(io.Writer).Write([]byte(q + "\n")); err != nil {
+		return nil, err
+	}
+	var lines []string
+	buf := make([]byte, 512)
+	for {
+		n, err := f.Read(buf)
+		if err == io.EOF {
+			return lines, nil
+		}
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		lines = append(lines, strings.TrimSuffix(string(buf[:n]), "\n"))
+	}
+}
+
+func TestCS(t *testing.T) {
+	fsys := NewCS(fakeResolver{})
+	for _, tt := range []struct {
+		query string
+		want  []string
+	}{
+		{"tcp!example.com!http", []string{"tcp 93.184.216.34:80", "tcp [2606:2800:220:1::1]:80"}},
+		{"udp!10.0.0.1!domain", []string{"udp 10.0.0.1:53"}},
+		{"tcp!*!8080", []string{"tcp :8080"}},
+		{"10.0.0.1!53", []string{"tcp 10.0.0.1:53", "udp 10.0.0.1:53"}},
+		{"net!10.0.0.1!ssh", []string{"tcp 10.0.0.1:22"}},
+	} {
+		got, err := query(t, fsys, tt.query)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.query, err)
+		}
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Fatalf("%s: got %q, want %q", tt.query, got, tt.want)
+		}
+	}
+
+	for _, q := range []string{"example.com", "il!example.com!80", "tcp!nowhere.invalid!80", "udp!10.0.0.1!ssh"} {
+		if _, err := query(t, fsys, q); err == nil {
+			t.Fatalf("%s: expected error", q)
+		}
+	}
+}
+
+func TestDNS(t *testing.T) {
+	fsys := NewDNS(fakeResolver{})
+	for _, tt := range []struct {
+		query string
+		want  []string
+	}{
+		{"example.com", []string{"example.com ip 93.184.216.34"}},
+		{"example.com ipv6", []string{"example.com ipv6 2606:2800:220:1::1"}},
+		{"example.com cname", []string{"example.com cname alias.example.com."}},
+		{"example.com mx", []string{"example.com mx 10 mail.example.com."}},
+		{"example.com txt", []string{"example.com txt v=spf1 -all"}},
+		{"example.com ns", []string{"example.com ns a.iana-servers.net."}},
+		{"93.184.216.34 ptr", []string{"93.184.216.34 ptr example.com."}},
+	} {
+		got, err := query(t, fsys, tt.query)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.query, err)
+		}
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Fatalf("%s: got %q, want %q", tt.query, got, tt.want)
+		}
+	}
+
+	if _, err := query(t, fsys, "example.com soa"); !errors.Is(err, fs.ErrNotSupported) {
+		t.Fatalf("soa: got %v, want ErrNotSupported", err)
+	}
+	if _, err := query(t, fsys, "nowhere.invalid"); err == nil {
+		t.Fatal("expected error for unknown host")
+	}
+}
+
+func TestSessionReadsInPieces(t *testing.T) {
+	f, err := NewDNS(fakeResolver{}).Open(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.(io.Writer).Write([]byte("example.com mx")); err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "example.com mx 10 mail.example.com.\n" {
+		t.Fatalf("got %q", b)
+	}
+}
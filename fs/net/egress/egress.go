@@ -0,0 +1,506 @@
+// Package egress decides where tasks may connect.
+//
+// A Policy is a list of rules, one per line:
+//
+//	allow|deny [tcp|udp|unix] <dest>
+//	default allow|deny
+//
+// For tcp and udp the dest is a host with optional ports: a host name
+// pattern like *.example.com, an IP address, a CIDR like 10.0.0.0/8 or *,
+// followed by :<port>, :<low>-<high> or :*. IPv6 hosts with ports are
+// bracketed. For unix the dest is a socket path pattern. Without a
+// network a rule applies to all of them. Names are resolved to check IP
+// and CIDR rules, and a name that can't be resolved is treated as
+// matching deny rules and not allow rules.
+//
+// The first matching rule decides. If none match, the default decides,
+// which is allow unless set otherwise. Lines starting with # are ignored.
+//
+// Policies are attached to namespaces. A namespace cloned from another
+// gets a policy layered on the original's: a connection has to be
+// allowed by both, so a child can narrow what it inherits but not widen
+// it. A policy's own rules can likewise be narrowed by more rules that
+// must also allow a connection. Each policy keeps an audit log of the
+// denials of connections checked against it.
+package egress
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"tractor.dev/wanix/fs"
+	"tractor.dev/wanix/fs/vfs"
+)
+
+// AuditSize is how many denials an audit log keeps.
+const AuditSize = 256
+
+// maxNarrowed is how many times a policy can be narrowed.
+const maxNarrowed = 32
+
+// Rule is one line of a policy.
+type Rule struct {
+	Allow bool
+	// Net is tcp, udp, unix or empty for all.
+	Net string
+	// Host is a name pattern, IP, CIDR or *. For unix it's a path pattern.
+	Host string
+	// Ports is the inclusive port range. The zero value means any port.
+	Ports [2]int
+}
+
+// ParseRule parses an allow or deny line.
+func ParseRule(line string) (Rule, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 2 || len(fields) > 3 || (fields[0] != "allow" && fields[0] != "deny") {
+		return Rule{}, fmt.Errorf("egress: bad rule %q: %w", line, fs.ErrInvalid)
+	}
+	r := Rule{Allow: fields[0] == "allow"}
+	dest := fields[len(fields)-1]
+	if len(fields) == 3 {
+		r.Net = fields[1]
+		switch r.Net {
+		case "tcp", "udp", "unix":
+		default:
+			return Rule{}, fmt.Errorf("egress: unknown network %q: %w", r.Net, fs.ErrInvalid)
+		}
+	}
+	if r.Net == "unix" {
+		r.Host = dest
+		return r, nil
+	}
+
+	host, ports := dest, ""
+	if strings.HasPrefix(dest, "[") {
+		end := strings.Index(dest, "]")
+		if end < 0 {
+			return Rule{}, fmt.Errorf("egress: bad host %q: %w", dest, fs.ErrInvalid)
+		}
+		host, ports = dest[1:end], strings.TrimPrefix(dest[end+1:], ":")
+	} else if strings.Count(dest, ":") == 1 {
+		host, ports, _ = strings.Cut(dest, ":")
+	}
+	if host == "" {
+		return Rule{}, fmt.Errorf("egress: missing host in %q: %w", dest, fs.ErrInvalid)
+	}
+	if strings.Contains(host, "/") {
+		if _, err := netip.ParsePrefix(host); err != nil {
+			return Rule{}, fmt.Errorf("egress: bad cidr %q: %w", host, fs.ErrInvalid)
+		}
+	}
+	r.Host = strings.ToLower(host)
+	if ports != "" && ports != "*" {
+		lo, hi, isRange := strings.Cut(ports, "-")
+		if !isRange {
+			hi = lo
+		}
+		var err1, err2 error
+		r.Ports[0], err1 = strconv.Atoi(lo)
+		r.Ports[1], err2 = strconv.Atoi(hi)
+		if err1 != nil || err2 != nil || r.Ports[0] < 0 || r.Ports[0] > r.Ports[1] || r.Ports[1] > 65535 {
+			return Rule{}, fmt.Errorf("egress: bad ports %q: %w", ports, fs.ErrInvalid)
+		}
+	}
+	return r, nil
+}
+
+func (r Rule) String() string {
+	var b strings.Builder
+	if r.Allow {
+		b.WriteString("allow ")
+	} else {
+		b.WriteString("deny ")
+	}
+	if r.Net != "" {
+		b.WriteString(r.Net + " ")
+	}
+	if r.Net == "unix" || r.Ports == [2]int{} {
+		b.WriteString(r.Host)
+		return b.String()
+	}
+	if strings.Contains(r.Host, ":") {
+		b.WriteString("[" + r.Host + "]")
+	} else {
+		b.WriteString(r.Host)
+	}
+	if r.Ports[0] == r.Ports[1] {
+		fmt.Fprintf(&b, ":%d", r.Ports[0])
+	} else {
+		fmt.Fprintf(&b, ":%d-%d", r.Ports[0], r.Ports[1])
+	}
+	return b.String()
+}
+
+// dest is an address being checked.
+type dest struct {
+	net  string
+	host string
+	port int // -1 if unknown
+	ips  []netip.Addr
+	err  error // resolving host
+
+	resolved bool
+}
+
+func (d *dest) resolve(ctx context.Context) ([]netip.Addr, error) {
+	if d.resolved {
+		return d.ips, d.err
+	}
+	d.resolved = true
+	if ip, err := netip.ParseAddr(d.host); err == nil {
+		d.ips = []netip.Addr{ip.Unmap()}
+		return d.ips, nil
+	}
+	addrs, err := lookupHost(ctx, d.host)
+	if err != nil {
+		d.err = err
+		return nil, err
+	}
+	for _, a := range addrs {
+		if ip, err := netip.ParseAddr(a); err == nil {
+			d.ips = append(d.ips, ip.Unmap())
+		}
+	}
+	return d.ips, nil
+}
+
+// matches reports whether r applies to d.
+func (r Rule) matches(ctx context.Context, d *dest) bool {
+	if r.Net != "" && r.Net != d.net {
+		return false
+	}
+	if d.net == "unix" {
+		ok, _ := path.Match(r.Host, d.host)
+		return r.Host == "*" || ok
+	}
+	if r.Ports != [2]int{} && (d.port < r.Ports[0] || d.port > r.Ports[1]) {
+		return false
+	}
+	if r.Host == "*" {
+		return true
+	}
+	prefix, err := netip.ParsePrefix(r.Host)
+	if ip, ipErr := netip.ParseAddr(r.Host); ipErr == nil {
+		prefix, err = ip.Unmap().Prefix(ip.Unmap().BitLen())
+	}
+	if err == nil {
+		ips, err := d.resolve(ctx)
+		if err != nil {
+			// fail closed
+			return !r.Allow
+		}
+		for _, ip := range ips {
+			if prefix.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+	ok, _ := path.Match(r.Host, strings.ToLower(d.host))
+	return ok
+}
+
+// Policy is an allow/deny list for connections.
+type Policy struct {
+	parent *Policy
+	audit  *Audit
+
+	mu       sync.RWMutex
+	rules    ruleSet
+	narrowed []ruleSet
+}
+
+// ruleSet is a list of rules and the default for what none match.
+type ruleSet struct {
+	rules []Rule
+	deny  bool
+}
+
+// New returns a policy that allows everything.
+func New() *Policy {
+	return &Policy{audit: &Audit{}}
+}
+
+// Clone returns a policy layered on p with its own audit log. It
+// implements vfs.Cloner.
+func (p *Policy) Clone() any {
+	return &Policy{parent: p, audit: &Audit{}}
+}
+
+// SetRules replaces the rules of this layer with the lines of text. Rules
+// added by Narrow are kept.
+func (p *Policy) SetRules(text string) error {
+	rs, err := parseRules(text)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	p.rules = rs
+	p.mu.Unlock()
+	return nil
+}
+
+// Narrow adds the lines of text as rules that must allow a connection as
+// well as the rules already in this layer, so it can only take away what
+// the layer allows.
+func (p *Policy) Narrow(text string) error {
+	rs, err := parseRules(text)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.narrowed) >= maxNarrowed {
+		return fmt.Errorf("egress: policy narrowed too many times: %w", fs.ErrInvalid)
+	}
+	p.narrowed = append(p.narrowed, rs)
+	return nil
+}
+
+func parseRules(text string) (ruleSet, error) {
+	var rs ruleSet
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if def, ok := strings.CutPrefix(line, "default "); ok {
+			switch strings.TrimSpace(def) {
+			case "allow":
+				rs.deny = false
+			case "deny":
+				rs.deny = true
+			default:
+				return rs, fmt.Errorf("egress: bad default %q: %w", line, fs.ErrInvalid)
+			}
+			continue
+		}
+		r, err := ParseRule(line)
+		if err != nil {
+			return rs, err
+		}
+		rs.rules = append(rs.rules, r)
+	}
+	return rs, nil
+}
+
+// String returns the rules of this layer, one per line. The rules of each
+// Narrow follow, after a comment.
+func (p *Policy) String() string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	var b strings.Builder
+	p.rules.write(&b)
+	for _, rs := range p.narrowed {
+		b.WriteString("# narrowed\n")
+		rs.write(&b)
+	}
+	return b.String()
+}
+
+func (rs ruleSet) write(b *strings.Builder) {
+	for _, r := range rs.rules {
+		b.WriteString(r.String() + "\n")
+	}
+	if rs.deny {
+		b.WriteString("default deny\n")
+	}
+}
+
+// Audit returns the log of denials of connections checked against p.
+func (p *Policy) Audit() *Audit {
+	return p.audit
+}
+
+// Check returns a *DeniedError if a connection for op to addr on network
+// isn't allowed, and records it in the audit log. A nil policy allows
+// everything.
+func (p *Policy) Check(ctx context.Context, op, network, addr string) error {
+	_, err := Check(ctx, op, network, addr, p)
+	return err
+}
+
+// Check returns a *DeniedError if a connection for op to addr on network
+// isn't allowed by all of ps, and records it in the audit log of the
+// policy that denied it. Nil policies allow everything and a policy given
+// twice is checked once.
+//
+// For tcp and udp it returns the IPs addr resolved to, which are what
+// the policies were checked against, so callers can connect to one of
+// them instead of resolving addr again and maybe getting another answer.
+// It returns none if there are no policies, and addr can be used as is.
+func Check(ctx context.Context, op, network, addr string, ps ...*Policy) ([]netip.Addr, error) {
+	var policies []*Policy
+	for _, p := range ps {
+		if p != nil && !slices.Contains(policies, p) {
+			policies = append(policies, p)
+		}
+	}
+	if len(policies) == 0 {
+		return nil, nil
+	}
+
+	d := &dest{net: strings.TrimRight(network, "46"), host: addr, port: -1}
+	if d.net != "unix" {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		if host == "" {
+			host = "0.0.0.0"
+		}
+		d.host = host
+		if n, err := strconv.Atoi(port); err == nil {
+			d.port = n
+		} else if n, err := net.LookupPort(d.net, port); err == nil {
+			d.port = n
+		}
+	}
+
+	for _, p := range policies {
+		for l := p; l != nil; l = l.parent {
+			if reason, ok := l.allows(ctx, d); !ok {
+				err := &DeniedError{Op: op, Net: network, Addr: addr, Reason: reason}
+				p.audit.record(err)
+				return nil, err
+			}
+		}
+	}
+	if d.net == "unix" {
+		return nil, nil
+	}
+	ips, err := d.resolve(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(ips) == 0 {
+		return nil, &net.DNSError{Err: "no such host", Name: d.host, IsNotFound: true}
+	}
+	return ips, nil
+}
+
+// Addrs returns what to connect to for addr given the IPs Check returned
+// for it: addr with its host replaced by each of them, or addr itself if
+// there are none.
+func Addrs(addr string, ips []netip.Addr) []string {
+	if len(ips) == 0 {
+		return []string{addr}
+	}
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return []string{addr}
+	}
+	addrs := make([]string, len(ips))
+	for i, ip := range ips {
+		addrs[i] = net.JoinHostPort(ip.String(), port)
+	}
+	return addrs
+}
+
+// allows checks d against this layer only, returning what denied it.
+func (p *Policy) allows(ctx context.Context, d *dest) (string, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if reason, ok := p.rules.allows(ctx, d); !ok {
+		return reason, false
+	}
+	for _, rs := range p.narrowed {
+		if reason, ok := rs.allows(ctx, d); !ok {
+			return reason, false
+		}
+	}
+	return "", true
+}
+
+func (rs ruleSet) allows(ctx context.Context, d *dest) (string, bool) {
+	for _, r := range rs.rules {
+		if r.matches(ctx, d) {
+			return r.String(), r.Allow
+		}
+	}
+	return "default deny", !rs.deny
+}
+
+// DeniedError is returned for connections a policy doesn't allow.
+type DeniedError struct {
+	Op     string
+	Net    string
+	Addr   string
+	Reason string // the rule that denied it
+}
+
+func (e *DeniedError) Error() string {
+	return fmt.Sprintf("%s %s %s: denied by %q", e.Op, e.Net, e.Addr, e.Reason)
+}
+
+func (e *DeniedError) Unwrap() error { return fs.ErrPermission }
+
+// Audit is a log of the most recent denials.
+type Audit struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (a *Audit) record(err *DeniedError) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.lines = append(a.lines, time.Now().UTC().Format(time.RFC3339)+" "+err.Error())
+	if len(a.lines) > AuditSize {
+		a.lines = a.lines[len(a.lines)-AuditSize:]
+	}
+}
+
+// String returns the denials, oldest first, one per line.
+func (a *Audit) String() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.lines) == 0 {
+		return ""
+	}
+	return strings.Join(a.lines, "\n") + "\n"
+}
+
+// nsKey is the namespace value key for policies.
+type nsKey struct{}
+
+var attachMu sync.Mutex
+
+// For returns the policy of ns, attaching an empty one if it has none.
+func For(ns *vfs.NS) *Policy {
+	attachMu.Lock()
+	defer attachMu.Unlock()
+	if p := Of(ns); p != nil {
+		return p
+	}
+	p := New()
+	ns.SetValue(nsKey{}, p)
+	return p
+}
+
+// Of returns the policy of ns, or nil if it has none.
+func Of(ns *vfs.NS) *Policy {
+	p, _ := ns.Value(nsKey{}).(*Policy)
+	return p
+}
+
+// FromContext returns the policy of the namespace an operation started
+// in, or nil if there isn't one.
+func FromContext(ctx context.Context) *Policy {
+	origin, _, ok := fs.Origin(ctx)
+	if !ok {
+		return nil
+	}
+	ns, ok := origin.(*vfs.NS)
+	if !ok {
+		return nil
+	}
+	return Of(ns)
+}
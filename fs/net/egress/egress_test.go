@@ -0,0 +1,245 @@
+package egress
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	"tractor.dev/wanix/fs"
+	"tractor.dev/wanix/fs/vfs"
+)
+
+func init() {
+	lookupHost = func(ctx context.Context, host string) ([]string, error) {
+		switch host {
+		case "intranet.example.com":
+			return []string{"10.1.2.3"}, nil
+		case "www.example.com":
+			return []string{"93.184.216.34"}, nil
+		}
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+}
+
+func TestParseRule(t *testing.T) {
+	for _, line := range []string{
+		"allow *",
+		"deny tcp 10.0.0.0/8",
+		"allow tcp *.example.com:443",
+		"allow udp 8.8.8.8:53",
+		"deny [::1]:8000-9000",
+		"deny fd00::/8",
+		"allow unix /run/*.sock",
+	} {
+		r, err := ParseRule(line)
+		if err != nil {
+			t.Fatalf("%s: %v", line, err)
+		}
+		if r.String() != line {
+			t.Fatalf("round trip: got %q, want %q", r.String(), line)
+		}
+	}
+	for _, line := range []string{
+		"permit *",
+		"allow",
+		"allow sctp *",
+		"allow *:http",
+		"allow *:90-80",
+		"deny 10.0.0.0/33",
+	} {
+		if _, err := ParseRule(line); !errors.Is(err, fs.ErrInvalid) {
+			t.Fatalf("%s: got %v, want ErrInvalid", line, err)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	p := New()
+	if err := p.SetRules(`
+# internal services
+deny 10.0.0.0/8
+allow tcp *.example.com:443
+allow udp 1.1.1.1:53
+allow unix /run/*.sock
+default deny
+`); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	for _, tt := range []struct {
+		network, addr string
+		allowed       bool
+	}{
+		{"tcp", "www.example.com:443", true},
+		{"tcp", "www.example.com:80", false},
+		{"tcp", "intranet.example.com:443", false}, // resolves into 10/8
+		{"tcp", "10.9.9.9:443", false},
+		{"udp", "1.1.1.1:53", true},
+		{"tcp", "1.1.1.1:53", false},
+		{"unix", "/run/app.sock", true},
+		{"unix", "/tmp/app.sock", false},
+		{"tcp", "nowhere.invalid:443", false},
+	} {
+		err := p.Check(ctx, "dial", tt.network, tt.addr)
+		if tt.allowed && err != nil {
+			t.Fatalf("%s %s: %v", tt.network, tt.addr, err)
+		}
+		if !tt.allowed && !errors.Is(err, fs.ErrPermission) {
+			t.Fatalf("%s %s: got %v, want denied", tt.network, tt.addr, err)
+		}
+	}
+
+	audit := p.Audit().String()
+	if n := strings.Count(audit, "\n"); n != 6 {
+		t.Fatalf("expected 6 audit lines, got %d:\n%s", n, audit)
+	}
+	if !strings.Contains(audit, `dial tcp 10.9.9.9:443: denied by "deny 10.0.0.0/8"`) {
+		t.Fatalf("audit missing rule:\n%s", audit)
+	}
+
+	var nilPolicy *Policy
+	if err := nilPolicy.Check(ctx, "dial", "tcp", "10.0.0.1:22"); err != nil {
+		t.Fatalf("nil policy: %v", err)
+	}
+}
+
+func TestCheckReturnsIPs(t *testing.T) {
+	orig := lookupHost
+	defer func() { lookupHost = orig }()
+	// a name that resolves somewhere else each time
+	var lookups int
+	lookupHost = func(ctx context.Context, host string) ([]string, error) {
+		lookups++
+		if lookups == 1 {
+			return []string{"93.184.216.34"}, nil
+		}
+		return []string{"10.0.0.1"}, nil
+	}
+
+	conn, ns := New(), New()
+	if err := conn.SetRules("deny 10.0.0.0/8"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ns.SetRules("deny 127.0.0.0/8"); err != nil {
+		t.Fatal(err)
+	}
+	ips, err := Check(context.Background(), "dial", "tcp", "rebind.example.com:80", conn, ns, nil, conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lookups != 1 {
+		t.Fatalf("expected 1 lookup for both policies, got %d", lookups)
+	}
+	addrs := Addrs("rebind.example.com:80", ips)
+	if len(addrs) != 1 || addrs[0] != "93.184.216.34:80" {
+		t.Fatalf("expected the checked address, got %v", addrs)
+	}
+
+	ips, err = Check(context.Background(), "dial", "tcp", "rebind.example.com:80")
+	if err != nil || ips != nil {
+		t.Fatalf("expected nothing resolved without a policy, got %v %v", ips, err)
+	}
+	if addrs := Addrs("rebind.example.com:80", ips); addrs[0] != "rebind.example.com:80" {
+		t.Fatalf("expected addr as is, got %v", addrs)
+	}
+}
+
+func TestLayers(t *testing.T) {
+	parent := New()
+	if err := parent.SetRules("deny tcp *:22"); err != nil {
+		t.Fatal(err)
+	}
+	child := parent.Clone().(*Policy)
+	if err := child.SetRules("allow *\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	if err := child.Check(ctx, "dial", "tcp", "10.0.0.1:22"); !errors.Is(err, fs.ErrPermission) {
+		t.Fatalf("child widened the parent's policy: %v", err)
+	}
+	if err := child.SetRules("deny tcp *:80"); err != nil {
+		t.Fatal(err)
+	}
+	if err := child.Check(ctx, "announce", "tcp", ":80"); !errors.Is(err, fs.ErrPermission) {
+		t.Fatalf("child rule not enforced: %v", err)
+	}
+	if err := parent.Check(ctx, "announce", "tcp", ":80"); err != nil {
+		t.Fatalf("child rule leaked to parent: %v", err)
+	}
+	if parent.String() != "deny tcp *:22\n" || child.String() != "deny tcp *:80\n" {
+		t.Fatalf("unexpected rules %q %q", parent.String(), child.String())
+	}
+	if !strings.Contains(child.Audit().String(), "announce tcp :80") {
+		t.Fatal("expected the denial in the child's audit log")
+	}
+	if parent.Audit().String() != "" {
+		t.Fatalf("child denial in the parent's audit log: %q", parent.Audit().String())
+	}
+}
+
+func TestNarrow(t *testing.T) {
+	parent := New()
+	child := parent.Clone().(*Policy)
+	if err := child.SetRules("allow tcp *:443\ndefault deny"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the child tries to widen its own policy
+	if err := child.Narrow("default allow"); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := child.Check(ctx, "dial", "tcp", "10.0.0.1:80"); !errors.Is(err, fs.ErrPermission) {
+		t.Fatalf("narrowing widened the policy: %v", err)
+	}
+	if err := child.Narrow("deny 10.0.0.0/8"); err != nil {
+		t.Fatal(err)
+	}
+	if err := child.Check(ctx, "dial", "tcp", "10.0.0.1:443"); !errors.Is(err, fs.ErrPermission) {
+		t.Fatalf("narrowed rule not enforced: %v", err)
+	}
+	if err := child.Check(ctx, "dial", "tcp", "192.168.0.1:443"); err != nil {
+		t.Fatal(err)
+	}
+
+	// replacing the layer keeps what it was narrowed by
+	if err := child.SetRules("default allow"); err != nil {
+		t.Fatal(err)
+	}
+	if err := child.Check(ctx, "dial", "tcp", "10.0.0.1:443"); !errors.Is(err, fs.ErrPermission) {
+		t.Fatalf("narrowed rule lost: %v", err)
+	}
+	want := "# narrowed\n# narrowed\ndeny 10.0.0.0/8\n"
+	if got := child.String(); got != want {
+		t.Fatalf("rules %q, want %q", got, want)
+	}
+}
+
+func TestNamespaceInheritance(t *testing.T) {
+	ns := vfs.New(context.Background())
+	if Of(ns) != nil {
+		t.Fatal("expected no policy")
+	}
+	if err := For(ns).SetRules("deny *"); err != nil {
+		t.Fatal(err)
+	}
+	child := ns.Clone(context.Background())
+	if Of(child) == nil || Of(child) == Of(ns) {
+		t.Fatal("expected the clone to get a layered policy")
+	}
+	if err := Of(child).Check(context.Background(), "dial", "tcp", "1.2.3.4:80"); err == nil {
+		t.Fatal("expected inherited deny")
+	}
+
+	ctx := fs.WithOrigin(context.Background(), child, "net/ctl", "open")
+	if FromContext(ctx) != Of(child) {
+		t.Fatal("expected the policy of the origin namespace")
+	}
+	if FromContext(context.Background()) != nil {
+		t.Fatal("expected no policy without an origin")
+	}
+}
@@ -0,0 +1,20 @@
+//go:build js && wasm
+
+package egress
+
+import (
+	"context"
+
+	"tractor.dev/wanix/fs/net/netstack"
+)
+
+// lookupHost resolves names for IP and CIDR rules. The browser has no
+// resolver of its own, so names are looked up through the in-process
+// network stack that connections go out over.
+var lookupHost = func(ctx context.Context, host string) ([]string, error) {
+	s, err := netstack.Default()
+	if err != nil {
+		return nil, err
+	}
+	return s.LookupHost(ctx, host)
+}
@@ -0,0 +1,8 @@
+//go:build !js || !wasm
+
+package egress
+
+import "net"
+
+// lookupHost resolves names for IP and CIDR rules.
+var lookupHost = net.DefaultResolver.LookupHost
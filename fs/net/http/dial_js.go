@@ -0,0 +1,17 @@
+//go:build js && wasm
+
+package http
+
+import nethttp "net/http"
+
+// defaultTransport is net/http's default transport, which makes
+// requests with fetch. The browser resolves names itself, so requests
+// can't be pinned to the addresses that were checked.
+var defaultTransport = nethttp.DefaultTransport
+
+// stopRedirects makes fetch fail on a redirect rather than follow it
+// unchecked. A "manual" redirect would come back as an opaque response
+// without its location, so it couldn't be checked and followed either.
+func stopRedirects(req *nethttp.Request) {
+	req.Header.Set("js.fetch:redirect", "error")
+}
@@ -0,0 +1,36 @@
+//go:build !js || !wasm
+
+package http
+
+import (
+	"context"
+	"net"
+	nethttp "net/http"
+)
+
+// defaultTransport is net/http's default transport, dialing the
+// addresses a request's host passed the egress check with rather than
+// resolving it again.
+var defaultTransport nethttp.RoundTripper = func() *nethttp.Transport {
+	t := nethttp.DefaultTransport.(*nethttp.Transport).Clone()
+	t.DialContext = dialPinned
+	return t
+}()
+
+func dialPinned(ctx context.Context, network, addr string) (net.Conn, error) {
+	var (
+		d    net.Dialer
+		conn net.Conn
+		err  error
+	)
+	for _, a := range pinnedAddrs(ctx, addr) {
+		if conn, err = d.DialContext(ctx, network, a); err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+// stopRedirects does nothing: the transport leaves redirects to the
+// client, which checks them.
+func stopRedirects(req *nethttp.Request) {}
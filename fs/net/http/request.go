@@ -0,0 +1,426 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	nethttp "net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+
+	"tractor.dev/toolkit-go/engine/cli"
+	"tractor.dev/wanix/fs"
+	"tractor.dev/wanix/fs/fskit"
+	"tractor.dev/wanix/fs/net/egress"
+	"tractor.dev/wanix/misc"
+)
+
+// maxRedirects is how many redirects a request follows, as net/http does.
+const maxRedirects = 10
+
+type Request struct {
+	id  string
+	svc *Service
+
+	mu sync.Mutex
+
+	method string
+	url    *url.URL
+	header nethttp.Header
+	body   bytes.Buffer
+
+	policy *egress.Policy
+
+	sent   *result // nil until the request is sent
+	cancel context.CancelFunc
+}
+
+// result is the outcome of sending a request. done is closed once resp
+// or err is set.
+type result struct {
+	done     chan struct{}
+	finished bool
+	resp     *nethttp.Response
+	err      error
+}
+
+func (res *result) finish(resp *nethttp.Response, err error) {
+	res.resp, res.err = resp, err
+	res.finished = true
+	close(res.done)
+}
+
+func newRequest(id string, svc *Service) *Request {
+	return &Request{
+		id:     id,
+		svc:    svc,
+		method: nethttp.MethodGet,
+		header: make(nethttp.Header),
+	}
+}
+
+func (r *Request) shutdown() {
+	_ = r.hangup()
+}
+
+func (r *Request) Open(name string) (fs.File, error) {
+	return r.OpenContext(context.Background(), name)
+}
+
+func (r *Request) OpenContext(ctx context.Context, name string) (fs.File, error) {
+	return fs.OpenContext(ctx, r.rootFS(ctx), name)
+}
+
+// rootFS returns the request's files. The request is checked against
+// the egress policy of the namespace ctx came from when it's sent.
+func (r *Request) rootFS(ctx context.Context) fskit.MapFS {
+	return fskit.MapFS{
+		"ctl": misc.ControlFile(&cli.Command{
+			Usage: "ctl",
+			Short: "control the request",
+			Run: func(_ *cli.Context, args []string) {
+				if len(args) == 0 {
+					return
+				}
+				switch args[0] {
+				case "method":
+					if len(args) != 2 {
+						panic(fmt.Errorf("usage: method <method>"))
+					}
+					if err := r.setMethod(args[1]); err != nil {
+						panic(err)
+					}
+				case "url":
+					if len(args) != 2 {
+						panic(fmt.Errorf("usage: url <url>"))
+					}
+					if err := r.setURL(args[1]); err != nil {
+						panic(err)
+					}
+				case "header":
+					if len(args) < 3 {
+						panic(fmt.Errorf("usage: header <name> <value>"))
+					}
+					if err := r.addHeader(args[1], strings.Join(args[2:], " ")); err != nil {
+						panic(err)
+					}
+				case "hangup":
+					if err := r.hangup(); err != nil {
+						panic(err)
+					}
+				default:
+					panic(fs.ErrNotSupported)
+				}
+			},
+		}),
+		"body": fskit.OpenFunc(func(_ context.Context, name string) (fs.File, error) {
+			if name != "." {
+				return nil, fs.ErrNotExist
+			}
+			return &fskit.FuncFile{
+				Node: fskit.Entry("body", 0222),
+				CloseFunc: func(n *fskit.Node) error {
+					return r.writeBody(n.Data())
+				},
+			}, nil
+		}),
+		"status": misc.FieldFile(func() (string, error) {
+			resp, err := r.send(ctx)
+			if err != nil {
+				return "", err
+			}
+			return resp.Status, nil
+		}),
+		"headers": misc.FieldFile(func() (string, error) {
+			resp, err := r.send(ctx)
+			if err != nil {
+				return "", err
+			}
+			return formatHeader(resp.Header), nil
+		}),
+		"response": fskit.OpenFunc(func(_ context.Context, name string) (fs.File, error) {
+			if name != "." {
+				return nil, fs.ErrNotExist
+			}
+			return newResponseFile(r, ctx), nil
+		}),
+	}
+}
+
+func (r *Request) Route(ctx context.Context, name string) (fs.FS, string, error) {
+	return r.rootFS(ctx).Route(ctx, name)
+}
+
+// SetPolicy sets the egress policy the request and its redirects are
+// checked against, in addition to the policy of the namespace sending it.
+func (r *Request) SetPolicy(p *egress.Policy) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.policy = p
+}
+
+// hostPort returns the address a request to u connects to.
+func hostPort(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// check returns an error if the request's policy or the policy of the
+// namespace ctx came from denies connecting to u. Otherwise it notes the
+// addresses that were checked in pins, for the transport to dial.
+func (r *Request) check(ctx context.Context, u *url.URL, pins *pinned) error {
+	addr := hostPort(u)
+	r.mu.Lock()
+	p := r.policy
+	r.mu.Unlock()
+	ips, err := egress.Check(ctx, "dial", "tcp", addr, p, egress.FromContext(ctx))
+	if err != nil {
+		return err
+	}
+	pins.set(addr, egress.Addrs(addr, ips))
+	return nil
+}
+
+// pinned is the addresses each host:port of a request and its redirects
+// passed the egress check with. It's kept in the request's context so
+// the transport dials those instead of resolving the name again.
+type pinned struct {
+	mu    sync.Mutex
+	addrs map[string][]string
+}
+
+type pinnedKey struct{}
+
+func (p *pinned) set(addr string, addrs []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.addrs[addr] = addrs
+}
+
+// pinnedAddrs returns the addresses to dial for addr: the checked ones
+// if the request in ctx was checked for it, otherwise addr.
+func pinnedAddrs(ctx context.Context, addr string) []string {
+	p, ok := ctx.Value(pinnedKey{}).(*pinned)
+	if !ok {
+		return []string{addr}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if addrs, ok := p.addrs[addr]; ok {
+		return addrs
+	}
+	return []string{addr}
+}
+
+func (r *Request) setMethod(method string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.sent != nil {
+		return fmt.Errorf("method: %w", fs.ErrPermission)
+	}
+	r.method = strings.ToUpper(method)
+	return nil
+}
+
+func (r *Request) setURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("url %q: %w", rawURL, fs.ErrInvalid)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.sent != nil {
+		return fmt.Errorf("url: %w", fs.ErrPermission)
+	}
+	r.url = u
+	return nil
+}
+
+func (r *Request) addHeader(name, value string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.sent != nil {
+		return fmt.Errorf("header: %w", fs.ErrPermission)
+	}
+	r.header.Add(strings.TrimSuffix(name, ":"), value)
+	return nil
+}
+
+func (r *Request) writeBody(p []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.sent != nil {
+		return fmt.Errorf("body: %w", fs.ErrPermission)
+	}
+	r.body.Write(p)
+	return nil
+}
+
+// send makes the request the first time it's called and returns its
+// response, waiting for it if another call is making it.
+func (r *Request) send(ctx context.Context) (*nethttp.Response, error) {
+	r.mu.Lock()
+	if res := r.sent; res != nil {
+		r.mu.Unlock()
+		<-res.done
+		return res.resp, res.err
+	}
+	if r.url == nil {
+		r.mu.Unlock()
+		return nil, fmt.Errorf("url not set: %w", fs.ErrInvalid)
+	}
+	res := &result{done: make(chan struct{})}
+	r.sent = res
+	// the response body is read after this returns, so the request
+	// lives until hangup rather than for ctx
+	reqCtx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+	req, err := nethttp.NewRequestWithContext(reqCtx, r.method, r.url.String(), bytes.NewReader(bytes.Clone(r.body.Bytes())))
+	if err == nil {
+		req.Header = r.header.Clone()
+	}
+	r.mu.Unlock()
+
+	var resp *nethttp.Response
+	if err == nil {
+		resp, err = r.do(ctx, req)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if res.finished {
+		// hung up while sending
+		if resp != nil {
+			resp.Body.Close()
+		}
+		return nil, res.err
+	}
+	res.finish(resp, err)
+	return resp, err
+}
+
+func (r *Request) do(ctx context.Context, req *nethttp.Request) (*nethttp.Response, error) {
+	pins := &pinned{addrs: make(map[string][]string)}
+	if err := r.check(ctx, req.URL, pins); err != nil {
+		return nil, err
+	}
+	// redirects are made with the same context
+	req = req.WithContext(context.WithValue(req.Context(), pinnedKey{}, pins))
+	client := &nethttp.Client{
+		Transport: r.svc.transport(),
+		// fetch follows redirects itself without calling this, so in
+		// js/wasm builds it's told not to when there's a policy
+		CheckRedirect: func(next *nethttp.Request, via []*nethttp.Request) error {
+			if len(via) >= maxRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxRedirects)
+			}
+			return r.check(ctx, next.URL, pins)
+		},
+	}
+	r.mu.Lock()
+	p := r.policy
+	r.mu.Unlock()
+	if client.Transport == defaultTransport && (p != nil || egress.FromContext(ctx) != nil) {
+		stopRedirects(req)
+	}
+	return client.Do(req)
+}
+
+// hangup cancels the request and discards its response, so it can be
+// set up and sent again.
+func (r *Request) hangup() error {
+	r.mu.Lock()
+	cancel := r.cancel
+	res := r.sent
+	var resp *nethttp.Response
+	if res != nil {
+		if res.finished {
+			resp = res.resp
+		} else {
+			res.finish(nil, context.Canceled)
+		}
+	}
+	r.sent = nil
+	r.cancel = nil
+	r.method = nethttp.MethodGet
+	r.url = nil
+	r.header = make(nethttp.Header)
+	r.body.Reset()
+	r.mu.Unlock()
+
+	if cancel != nil {
+		cancel()
+	}
+	if resp != nil {
+		_ = resp.Body.Close()
+	}
+	return nil
+}
+
+// formatHeader returns h as Name: value lines sorted by name.
+func formatHeader(h nethttp.Header) string {
+	var b strings.Builder
+	for _, name := range slices.Sorted(maps.Keys(h)) {
+		for _, v := range h[name] {
+			fmt.Fprintf(&b, "%s: %s\n", name, v)
+		}
+	}
+	return b.String()
+}
+
+// responseFile streams the response body, sending the request on the
+// first read so errors are returned from Read rather than Open. The body
+// is closed on hangup, not when the file is, so it can be read across
+// several opens.
+type responseFile struct {
+	r   *Request
+	ctx context.Context
+
+	once sync.Once
+	body io.Reader
+	err  error
+	node *fskit.Node
+}
+
+func newResponseFile(r *Request, ctx context.Context) *responseFile {
+	return &responseFile{
+		r:    r,
+		ctx:  ctx,
+		node: fskit.Entry("response", 0444, -1),
+	}
+}
+
+func (f *responseFile) Stat() (fs.FileInfo, error) { return f.node, nil }
+func (f *responseFile) Close() error               { return nil }
+
+func (f *responseFile) Read(p []byte) (int, error) {
+	f.once.Do(func() {
+		resp, err := f.r.send(f.ctx)
+		if err != nil {
+			f.err = err
+			return
+		}
+		f.body = resp.Body
+	})
+	if f.err != nil {
+		return 0, f.err
+	}
+	return f.body.Read(p)
+}
+
+// Ensure responseFile implements fs.File
+var _ fs.File = (*responseFile)(nil)
@@ -0,0 +1,130 @@
+// Package http is an HTTP client as a file service, for tasks that can't
+// link one. Reading new allocates a request directory. Its method, URL and
+// headers are set by writing to ctl and its body by writing to body. The
+// request is sent when status, headers or response is first read.
+package http
+
+import (
+	"context"
+	nethttp "net/http"
+	"strconv"
+	"sync"
+
+	"tractor.dev/wanix/fs"
+	"tractor.dev/wanix/fs/fskit"
+	"tractor.dev/wanix/fs/net/egress"
+)
+
+type Service struct {
+	mu        sync.RWMutex
+	resources map[string]fs.FS
+	nextID    int
+
+	// Transport sends the requests. If nil, a copy of
+	// http.DefaultTransport is used that dials the addresses requests
+	// were checked with. In js/wasm builds it's http.DefaultTransport,
+	// which makes them with the browser's fetch.
+	Transport nethttp.RoundTripper
+
+	// AllocHook is called for each request allocated by reading new,
+	// after it gets the egress policy of the reader's namespace. It can
+	// set a different one with Request.SetPolicy.
+	AllocHook func(s *Service, rid string) error
+}
+
+func New() *Service {
+	return &Service{
+		resources: make(map[string]fs.FS),
+		nextID:    0,
+	}
+}
+
+func (s *Service) Open(name string) (fs.File, error) {
+	return s.OpenContext(context.Background(), name)
+}
+
+func (s *Service) OpenContext(ctx context.Context, name string) (fs.File, error) {
+	return fs.OpenContext(ctx, s.rootFS(), name)
+}
+
+func (s *Service) Stat(name string) (fs.FileInfo, error) {
+	return s.StatContext(context.Background(), name)
+}
+
+func (s *Service) StatContext(ctx context.Context, name string) (fs.FileInfo, error) {
+	return fs.StatContext(ctx, s.rootFS(), name)
+}
+
+func (s *Service) rootFS() fskit.UnionFS {
+	root := fskit.MapFS{
+		"new": fskit.OpenFunc(func(ctx context.Context, name string) (fs.File, error) {
+			if name != "." {
+				return nil, fs.ErrNotExist
+			}
+			return &fskit.FuncFile{
+				Node: fskit.Entry("new", 0555),
+				ReadFunc: func(n *fskit.Node) error {
+					rid, err := s.Alloc()
+					if err != nil {
+						return err
+					}
+					if r, err := s.Get(rid); err == nil {
+						r.SetPolicy(egress.FromContext(ctx))
+					}
+					if s.AllocHook != nil {
+						if err := s.AllocHook(s, rid); err != nil {
+							return err
+						}
+					}
+					fskit.SetData(n, []byte(rid+"\n"))
+					return nil
+				},
+			}, nil
+		}),
+	}
+	return fskit.UnionFS{root, fskit.MapFS(s.resources)}
+}
+
+func (s *Service) Route(ctx context.Context, name string) (fs.FS, string, error) {
+	return s.rootFS().Route(ctx, name)
+}
+
+func (s *Service) Get(rid string) (*Request, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	res, ok := s.resources[rid]
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+	return res.(*Request), nil
+}
+
+func (s *Service) Alloc() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextID++
+	rid := strconv.Itoa(s.nextID)
+	s.resources[rid] = newRequest(rid, s)
+	return rid, nil
+}
+
+func (s *Service) remove(rid string) {
+	s.mu.Lock()
+	res, ok := s.resources[rid]
+	if ok {
+		delete(s.resources, rid)
+	}
+	s.mu.Unlock()
+
+	if ok {
+		res.(*Request).shutdown()
+	}
+}
+
+func (s *Service) transport() nethttp.RoundTripper {
+	if s.Transport != nil {
+		return s.Transport
+	}
+	return defaultTransport
+}
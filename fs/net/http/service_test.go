@@ -0,0 +1,185 @@
+//go:build !js || !wasm
+
+package http
+
+import (
+	"context"
+	"errors"
+	"io"
+	nethttp "net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"tractor.dev/wanix/fs"
+	"tractor.dev/wanix/fs/net/egress"
+	"tractor.dev/wanix/fs/vfs"
+)
+
+func readText(t *testing.T, fsys fs.FS, name string) string {
+	t.Helper()
+	b, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		t.Fatalf("read %s: %v", name, err)
+	}
+	return string(b)
+}
+
+func writeText(t *testing.T, fsys fs.FS, name, data string) {
+	t.Helper()
+	if err := fs.WriteFile(fsys, name, []byte(data), 0644); err != nil {
+		t.Fatalf("write %s: %v", name, err)
+	}
+}
+
+func alloc(t *testing.T, fsys fs.FS, name string) string {
+	t.Helper()
+	return strings.TrimSpace(readText(t, fsys, name))
+}
+
+func echoServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	ts := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		w.Header().Set("X-Method", r.Method)
+		w.Header().Set("X-Echo", r.Header.Get("X-Test"))
+		w.WriteHeader(nethttp.StatusCreated)
+		io.Copy(w, r.Body)
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestRequest(t *testing.T) {
+	ts := echoServer(t)
+	s := New()
+	id := alloc(t, s, "new")
+
+	writeText(t, s, id+"/ctl", "method post\n")
+	writeText(t, s, id+"/ctl", "url "+ts.URL+"/echo\n")
+	writeText(t, s, id+"/ctl", "header X-Test: a b\n")
+	writeText(t, s, id+"/body", "hello ")
+	writeText(t, s, id+"/body", "world")
+
+	if got := readText(t, s, id+"/status"); got != "201 Created\n" {
+		t.Fatalf("status: %q", got)
+	}
+	headers := readText(t, s, id+"/headers")
+	if !strings.Contains(headers, "X-Echo: a b\n") || !strings.Contains(headers, "X-Method: POST\n") {
+		t.Fatalf("headers: %q", headers)
+	}
+	if got := readText(t, s, id+"/response"); got != "hello world" {
+		t.Fatalf("response: %q", got)
+	}
+
+	// the request can't change once sent
+	if err := fs.WriteFile(s, id+"/ctl", []byte("url "+ts.URL+"\n"), 0644); err == nil {
+		t.Fatal("expected url after send to fail")
+	}
+	if err := fs.WriteFile(s, id+"/body", []byte("more"), 0644); err == nil {
+		t.Fatal("expected body after send to fail")
+	}
+
+	// until it's hung up
+	writeText(t, s, id+"/ctl", "hangup\n")
+	writeText(t, s, id+"/ctl", "url "+ts.URL+"\n")
+	if got := readText(t, s, id+"/headers"); !strings.Contains(got, "X-Method: GET\n") {
+		t.Fatalf("headers after hangup: %q", got)
+	}
+}
+
+func TestRequestErrors(t *testing.T) {
+	s := New()
+	id := alloc(t, s, "new")
+
+	if _, err := fs.ReadFile(s, id+"/status"); !errors.Is(err, fs.ErrInvalid) {
+		t.Fatalf("status without url: got %v, want ErrInvalid", err)
+	}
+	for _, cmd := range []string{"url ftp://example.com/\n", "url /relative\n", "bogus\n", "header X-Only\n"} {
+		if err := fs.WriteFile(s, id+"/ctl", []byte(cmd), 0644); err == nil {
+			t.Fatalf("expected %q to fail", cmd)
+		}
+	}
+}
+
+func TestStreamingResponse(t *testing.T) {
+	release := make(chan struct{})
+	ts := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		io.WriteString(w, "first\n")
+		w.(nethttp.Flusher).Flush()
+		<-release
+		io.WriteString(w, "second\n")
+	}))
+	defer ts.Close()
+	defer close(release)
+
+	s := New()
+	id := alloc(t, s, "new")
+	writeText(t, s, id+"/ctl", "url "+ts.URL+"\n")
+
+	f, err := fs.OpenContext(context.Background(), s, id+"/response")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	buf := make([]byte, 6)
+	if _, err := io.ReadFull(f, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "first\n" {
+		t.Fatalf("got %q", buf)
+	}
+
+	// hanging up closes the response before the server finishes it
+	writeText(t, s, id+"/ctl", "hangup\n")
+	if _, err := io.ReadAll(f); err == nil {
+		t.Fatal("expected read after hangup to fail")
+	}
+}
+
+func TestEgressPolicy(t *testing.T) {
+	ts := echoServer(t)
+
+	ns := vfs.New(context.Background())
+	if err := egress.For(ns).SetRules("deny tcp 127.0.0.0/8\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ns.Bind(New(), ".", "http"); err != nil {
+		t.Fatal(err)
+	}
+
+	id := alloc(t, ns, "http/new")
+	writeText(t, ns, "http/"+id+"/ctl", "url "+ts.URL+"\n")
+	if _, err := fs.ReadFile(ns, "http/"+id+"/status"); !errors.Is(err, fs.ErrPermission) {
+		t.Fatalf("got %v, want denied", err)
+	}
+	addr := strings.TrimPrefix(ts.URL, "http://")
+	if !strings.Contains(egress.For(ns).Audit().String(), "dial tcp "+addr) {
+		t.Fatalf("denial not audited: %q", egress.For(ns).Audit().String())
+	}
+}
+
+func TestRedirectPolicy(t *testing.T) {
+	target := echoServer(t)
+	ts := httptest.NewServer(nethttp.RedirectHandler(target.URL, nethttp.StatusFound))
+	defer ts.Close()
+
+	p := egress.New()
+	if err := p.SetRules("allow tcp *:" + ts.URL[strings.LastIndex(ts.URL, ":")+1:] + "\ndefault deny"); err != nil {
+		t.Fatal(err)
+	}
+	s := New()
+	s.AllocHook = func(s *Service, rid string) error {
+		r, err := s.Get(rid)
+		if err != nil {
+			return err
+		}
+		r.SetPolicy(p)
+		return nil
+	}
+
+	id := alloc(t, s, "new")
+	writeText(t, s, id+"/ctl", "url "+ts.URL+"\n")
+	if _, err := fs.ReadFile(s, id+"/status"); !errors.Is(err, fs.ErrPermission) {
+		t.Fatalf("got %v, want redirect denied", err)
+	}
+}
@@ -0,0 +1,26 @@
+// Package net puts the network services together as one device:
+// tcp and udp connection directories, an http client, and the cs
+// and dns files to resolve names into their dial strings.
+package net
+
+import (
+	"tractor.dev/wanix/fs"
+	"tractor.dev/wanix/fs/fskit"
+	"tractor.dev/wanix/fs/net/cs"
+	"tractor.dev/wanix/fs/net/http"
+	"tractor.dev/wanix/fs/net/tcp"
+	"tractor.dev/wanix/fs/net/udp"
+)
+
+// New returns a filesystem with tcp, udp, http, cs and dns at its root,
+// resolving names with the host resolver, or in the browser through the
+// in-process network stack.
+func New() fs.FS {
+	return fskit.MapFS{
+		"tcp":  tcp.New(),
+		"udp":  udp.New(),
+		"http": http.New(),
+		"cs":   cs.NewCS(resolver()),
+		"dns":  cs.NewDNS(resolver()),
+	}
+}
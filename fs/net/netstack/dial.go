@@ -0,0 +1,219 @@
+package netstack
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/progrium/go-netstack/gvisor/pkg/tcpip"
+	"github.com/progrium/go-netstack/gvisor/pkg/tcpip/adapters/gonet"
+	"github.com/progrium/go-netstack/gvisor/pkg/tcpip/network/ipv4"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// dnsTimeout bounds each DNS attempt when ctx has no sooner deadline.
+const dnsTimeout = 2 * time.Second
+
+// DialContext connects to addr on network, which is tcp or udp. Host
+// names are resolved with LookupHost.
+func (s *Stack) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return s.DialFrom(ctx, network, "", addr)
+}
+
+// DialFrom is like DialContext but binds the local end to laddr first
+// unless it is empty.
+func (s *Stack) DialFrom(ctx context.Context, network, laddr, addr string) (net.Conn, error) {
+	switch network {
+	case "tcp", "tcp4", "udp", "udp4":
+	default:
+		return nil, &net.OpError{Op: "dial", Net: network, Err: net.UnknownNetworkError(network)}
+	}
+	raddr, err := s.resolve(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	var local tcpip.FullAddress
+	if laddr != "" {
+		local, err = s.resolve(ctx, network, laddr)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if strings.HasPrefix(network, "udp") {
+		var lp *tcpip.FullAddress
+		if laddr != "" {
+			lp = &local
+		}
+		return gonet.DialUDP(s.stack, lp, &raddr, ipv4.ProtocolNumber)
+	}
+	return gonet.DialTCPWithBind(ctx, s.stack, local, raddr, ipv4.ProtocolNumber)
+}
+
+// Listen announces on addr for tcp connections. An empty host listens
+// on the interface's address.
+func (s *Stack) Listen(network, addr string) (net.Listener, error) {
+	if network != "tcp" && network != "tcp4" {
+		return nil, &net.OpError{Op: "listen", Net: network, Err: net.UnknownNetworkError(network)}
+	}
+	la, err := s.resolve(context.Background(), network, addr)
+	if err != nil {
+		return nil, err
+	}
+	return gonet.ListenTCP(s.stack, la, ipv4.ProtocolNumber)
+}
+
+// resolve turns a host:port into a stack address.
+func (s *Stack) resolve(ctx context.Context, network, addr string) (tcpip.FullAddress, error) {
+	host, service, err := net.SplitHostPort(addr)
+	if err != nil {
+		return tcpip.FullAddress{}, err
+	}
+	port, err := strconv.ParseUint(service, 10, 16)
+	if err != nil {
+		p, err := net.LookupPort(network, service)
+		if err != nil {
+			return tcpip.FullAddress{}, err
+		}
+		port = uint64(p)
+	}
+	fa := tcpip.FullAddress{NIC: nicID, Port: uint16(port)}
+	if host == "" {
+		return fa, nil
+	}
+	addrs, err := s.LookupHost(ctx, host)
+	if err != nil {
+		return tcpip.FullAddress{}, err
+	}
+	ip, err := netip.ParseAddr(addrs[0])
+	if err != nil || !ip.Unmap().Is4() {
+		return tcpip.FullAddress{}, &net.AddrError{Err: "only ipv4 is supported", Addr: host}
+	}
+	if !ip.Unmap().IsUnspecified() {
+		fa.Addr = tcpip.AddrFrom4(ip.Unmap().As4())
+	}
+	return fa, nil
+}
+
+// LookupHost returns the IPv4 addresses of host, asking the configured
+// DNS server over the stack. IP addresses are returned as is.
+func (s *Stack) LookupHost(ctx context.Context, host string) ([]string, error) {
+	if ip, err := netip.ParseAddr(host); err == nil {
+		return []string{ip.String()}, nil
+	}
+	if host == "localhost" {
+		return []string{s.Addr().String()}, nil
+	}
+
+	fqdn := host
+	if !strings.HasSuffix(fqdn, ".") {
+		fqdn += "."
+	}
+	name, err := dnsmessage.NewName(fqdn)
+	if err != nil {
+		return nil, &net.DNSError{Err: err.Error(), Name: host}
+	}
+	var idb [2]byte
+	if _, err := rand.Read(idb[:]); err != nil {
+		return nil, err
+	}
+	id := binary.BigEndian.Uint16(idb[:])
+	query, err := (&dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET}},
+	}).Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	server := tcpip.FullAddress{NIC: nicID, Addr: tcpip.AddrFrom4(s.cfg.DNS.As4()), Port: 53}
+	conn, err := gonet.DialUDP(s.stack, nil, &server, ipv4.ProtocolNumber)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	buf := make([]byte, 1500)
+	for attempt := 0; attempt < 3; attempt++ {
+		deadline := time.Now().Add(dnsTimeout)
+		if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+			deadline = d
+		}
+		conn.SetDeadline(deadline)
+		if _, err := conn.Write(query); err != nil {
+			return nil, err
+		}
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil, ctx.Err()
+				}
+				var ne net.Error
+				if errors.As(err, &ne) && ne.Timeout() {
+					break
+				}
+				return nil, err
+			}
+			addrs, ok, err := parseAnswer(buf[:n], id, host)
+			if !ok {
+				continue
+			}
+			return addrs, err
+		}
+	}
+	return nil, &net.DNSError{Err: "i/o timeout", Name: host, Server: server.Addr.String(), IsTimeout: true}
+}
+
+// parseAnswer reads the A records of a DNS response. It reports false if
+// msg isn't the response to query id.
+func parseAnswer(msg []byte, id uint16, host string) ([]string, bool, error) {
+	var p dnsmessage.Parser
+	h, err := p.Start(msg)
+	if err != nil || h.ID != id || !h.Response {
+		return nil, false, nil
+	}
+	switch h.RCode {
+	case dnsmessage.RCodeSuccess:
+	case dnsmessage.RCodeNameError:
+		return nil, true, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	default:
+		return nil, true, &net.DNSError{Err: fmt.Sprintf("server misbehaving: %s", h.RCode), Name: host}
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return nil, true, &net.DNSError{Err: err.Error(), Name: host}
+	}
+	var addrs []string
+	for {
+		ah, err := p.AnswerHeader()
+		if err == dnsmessage.ErrSectionDone {
+			break
+		}
+		if err != nil {
+			return nil, true, &net.DNSError{Err: err.Error(), Name: host}
+		}
+		if ah.Type != dnsmessage.TypeA {
+			if err := p.SkipAnswer(); err != nil {
+				return nil, true, &net.DNSError{Err: err.Error(), Name: host}
+			}
+			continue
+		}
+		a, err := p.AResource()
+		if err != nil {
+			return nil, true, &net.DNSError{Err: err.Error(), Name: host}
+		}
+		addrs = append(addrs, netip.AddrFrom4(a.A).String())
+	}
+	if len(addrs) == 0 {
+		return nil, true, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return addrs, true, nil
+}
@@ -0,0 +1,218 @@
+// Package netstack runs a userspace TCP/IP stack over an ethernet link.
+//
+// It gives builds without host sockets, like js/wasm, a way onto the
+// network: frames go over a Link, such as the websocket served at
+// /.well-known/ethernet by wanix serve, to a virtual network that
+// routes them out.
+package netstack
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+
+	"github.com/progrium/go-netstack/gvisor/pkg/buffer"
+	"github.com/progrium/go-netstack/gvisor/pkg/tcpip"
+	"github.com/progrium/go-netstack/gvisor/pkg/tcpip/header"
+	"github.com/progrium/go-netstack/gvisor/pkg/tcpip/link/channel"
+	"github.com/progrium/go-netstack/gvisor/pkg/tcpip/link/ethernet"
+	"github.com/progrium/go-netstack/gvisor/pkg/tcpip/network/arp"
+	"github.com/progrium/go-netstack/gvisor/pkg/tcpip/network/ipv4"
+	"github.com/progrium/go-netstack/gvisor/pkg/tcpip/stack"
+	"github.com/progrium/go-netstack/gvisor/pkg/tcpip/transport/icmp"
+	"github.com/progrium/go-netstack/gvisor/pkg/tcpip/transport/tcp"
+	"github.com/progrium/go-netstack/gvisor/pkg/tcpip/transport/udp"
+)
+
+const nicID = 1
+
+// Defaults match the virtual network of wanix serve.
+var (
+	DefaultSubnet  = netip.MustParsePrefix("192.168.127.0/24")
+	DefaultGateway = netip.MustParseAddr("192.168.127.1")
+)
+
+// DefaultMTU is the MTU used when Config.MTU is zero.
+const DefaultMTU = 1500
+
+// Link carries ethernet frames to and from the network.
+type Link interface {
+	ReadFrame() ([]byte, error)
+	WriteFrame(frame []byte) error
+	Close() error
+}
+
+// Config configures a Stack. Zero fields get defaults.
+type Config struct {
+	// MAC is the interface's hardware address. The default is a random
+	// locally administered address.
+	MAC net.HardwareAddr
+	// Addr is the interface's address and subnet. The default is a random
+	// host in the upper half of DefaultSubnet, which the virtual network's
+	// DHCP server leaves alone until it has handed out 125 leases.
+	Addr netip.Prefix
+	// Gateway is the default route. The default is DefaultGateway.
+	Gateway netip.Addr
+	// DNS is the name server used by LookupHost. The default is Gateway.
+	DNS netip.Addr
+	// MTU is the largest IP packet sent. The default is DefaultMTU.
+	MTU uint32
+}
+
+// Stack is a TCP/IP stack with one ethernet interface.
+type Stack struct {
+	stack *stack.Stack
+	ep    *channel.Endpoint
+	link  Link
+	cfg   Config
+
+	cancel context.CancelFunc
+	once   sync.Once
+	wg     sync.WaitGroup
+	done   chan struct{} // closed when the link stops delivering frames
+}
+
+// New starts a stack on link configured by cfg.
+func New(link Link, cfg Config) (*Stack, error) {
+	if err := cfg.setDefaults(); err != nil {
+		return nil, err
+	}
+
+	ep := channel.New(256, cfg.MTU+header.EthernetMinimumSize, tcpip.LinkAddress(cfg.MAC))
+	s := stack.New(stack.Options{
+		NetworkProtocols:   []stack.NetworkProtocolFactory{ipv4.NewProtocol, arp.NewProtocol},
+		TransportProtocols: []stack.TransportProtocolFactory{tcp.NewProtocol, udp.NewProtocol, icmp.NewProtocol4},
+	})
+	if err := s.CreateNIC(nicID, ethernet.New(ep)); err != nil {
+		return nil, fmt.Errorf("netstack: create nic: %s", err)
+	}
+	if err := s.AddProtocolAddress(nicID, tcpip.ProtocolAddress{
+		Protocol: ipv4.ProtocolNumber,
+		AddressWithPrefix: tcpip.AddressWithPrefix{
+			Address:   tcpip.AddrFrom4(cfg.Addr.Addr().As4()),
+			PrefixLen: cfg.Addr.Bits(),
+		},
+	}, stack.AddressProperties{}); err != nil {
+		return nil, fmt.Errorf("netstack: add address: %s", err)
+	}
+	onlink, err := tcpip.NewSubnet(
+		tcpip.AddrFrom4(cfg.Addr.Masked().Addr().As4()),
+		tcpip.MaskFromBytes(net.CIDRMask(cfg.Addr.Bits(), 32)),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("netstack: %w", err)
+	}
+	s.SetRouteTable([]tcpip.Route{
+		{Destination: onlink, NIC: nicID},
+		{Destination: header.IPv4EmptySubnet, Gateway: tcpip.AddrFrom4(cfg.Gateway.As4()), NIC: nicID},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ns := &Stack{stack: s, ep: ep, link: link, cfg: cfg, cancel: cancel, done: make(chan struct{})}
+	ns.wg.Add(2)
+	go ns.inbound()
+	go ns.outbound(ctx)
+	return ns, nil
+}
+
+func (cfg *Config) setDefaults() error {
+	if cfg.MAC == nil {
+		mac := make(net.HardwareAddr, 6)
+		if _, err := rand.Read(mac); err != nil {
+			return err
+		}
+		// unicast, locally administered
+		mac[0] = mac[0]&^0x01 | 0x02
+		cfg.MAC = mac
+	}
+	if len(cfg.MAC) != 6 {
+		return fmt.Errorf("netstack: bad mac %s", cfg.MAC)
+	}
+	if !cfg.Addr.IsValid() {
+		var b [1]byte
+		if _, err := rand.Read(b[:]); err != nil {
+			return err
+		}
+		ip := DefaultSubnet.Addr().As4()
+		ip[3] = 128 + b[0]%126
+		cfg.Addr = netip.PrefixFrom(netip.AddrFrom4(ip), DefaultSubnet.Bits())
+	}
+	if !cfg.Addr.Addr().Is4() {
+		return fmt.Errorf("netstack: only ipv4 is supported, not %s", cfg.Addr)
+	}
+	if !cfg.Gateway.IsValid() {
+		cfg.Gateway = DefaultGateway
+	}
+	if !cfg.DNS.IsValid() {
+		cfg.DNS = cfg.Gateway
+	}
+	if cfg.MTU == 0 {
+		cfg.MTU = DefaultMTU
+	}
+	return nil
+}
+
+// Addr returns the interface's address.
+func (s *Stack) Addr() netip.Addr {
+	return s.cfg.Addr.Addr()
+}
+
+// Done returns a channel that is closed once the link fails or the stack
+// is closed, after which the stack can't reach the network.
+func (s *Stack) Done() <-chan struct{} {
+	return s.done
+}
+
+// inbound delivers frames from the link to the stack until the link fails.
+func (s *Stack) inbound() {
+	defer s.wg.Done()
+	defer close(s.done)
+	for {
+		frame, err := s.link.ReadFrame()
+		if err != nil {
+			return
+		}
+		if len(frame) < header.EthernetMinimumSize {
+			continue
+		}
+		pkt := stack.NewPacketBuffer(stack.PacketBufferOptions{
+			Payload: buffer.MakeWithData(frame),
+		})
+		s.ep.InjectInbound(0, pkt)
+		pkt.DecRef()
+	}
+}
+
+// outbound writes frames from the stack to the link until ctx is done.
+func (s *Stack) outbound(ctx context.Context) {
+	defer s.wg.Done()
+	for {
+		pkt := s.ep.ReadContext(ctx)
+		if pkt.IsNil() {
+			return
+		}
+		v := pkt.ToView()
+		frame := append([]byte(nil), v.AsSlice()...)
+		v.Release()
+		pkt.DecRef()
+		if err := s.link.WriteFrame(frame); err != nil {
+			return
+		}
+	}
+}
+
+// Close shuts down the stack and its link.
+func (s *Stack) Close() error {
+	var err error
+	s.once.Do(func() {
+		s.cancel()
+		err = s.link.Close()
+		s.stack.Close()
+		s.ep.Close()
+		s.wg.Wait()
+	})
+	return err
+}
@@ -0,0 +1,205 @@
+package netstack
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/progrium/go-netstack/gvisor/pkg/tcpip"
+	"github.com/progrium/go-netstack/gvisor/pkg/tcpip/adapters/gonet"
+	"github.com/progrium/go-netstack/gvisor/pkg/tcpip/network/ipv4"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// pipeLink is one end of an in-memory ethernet cable.
+type pipeLink struct {
+	in, out chan []byte
+	done    chan struct{}
+	once    *sync.Once
+}
+
+func cable() (*pipeLink, *pipeLink) {
+	a, b := make(chan []byte, 256), make(chan []byte, 256)
+	done, once := make(chan struct{}), &sync.Once{}
+	return &pipeLink{in: a, out: b, done: done, once: once}, &pipeLink{in: b, out: a, done: done, once: once}
+}
+
+func (l *pipeLink) ReadFrame() ([]byte, error) {
+	select {
+	case f := <-l.in:
+		return f, nil
+	case <-l.done:
+		return nil, io.EOF
+	}
+}
+
+func (l *pipeLink) WriteFrame(f []byte) error {
+	select {
+	case l.out <- f:
+		return nil
+	case <-l.done:
+		return io.ErrClosedPipe
+	}
+}
+
+func (l *pipeLink) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return nil
+}
+
+func pair(t *testing.T) (*Stack, *Stack) {
+	t.Helper()
+	la, lb := cable()
+	a, err := New(la, Config{Addr: netip.MustParsePrefix("192.168.127.2/24"), DNS: netip.MustParseAddr("192.168.127.3")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := New(lb, Config{Addr: netip.MustParsePrefix("192.168.127.3/24")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		a.Close()
+		b.Close()
+	})
+	return a, b
+}
+
+func TestDone(t *testing.T) {
+	la, lb := cable()
+	s, err := New(la, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	select {
+	case <-s.Done():
+		t.Fatal("done before the link failed")
+	default:
+	}
+	// the other end hanging up fails the link
+	lb.Close()
+	select {
+	case <-s.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("not done after the link failed")
+	}
+}
+
+func TestDialListen(t *testing.T) {
+	a, b := pair(t)
+
+	ln, err := b.Listen("tcp", ":8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(conn, conn)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := a.DialContext(ctx, "tcp", "192.168.127.3:8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if got := conn.RemoteAddr().String(); got != "192.168.127.3:8080" {
+		t.Fatalf("remote addr: %s", got)
+	}
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "hello" {
+		t.Fatalf("got %q", buf)
+	}
+}
+
+// serveDNS answers A queries for example.test on s.
+func serveDNS(t *testing.T, s *Stack) {
+	t.Helper()
+	conn, err := gonet.DialUDP(s.stack, &tcpip.FullAddress{NIC: nicID, Port: 53}, nil, ipv4.ProtocolNumber)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var msg dnsmessage.Message
+			if err := msg.Unpack(buf[:n]); err != nil || len(msg.Questions) != 1 {
+				continue
+			}
+			q := msg.Questions[0]
+			msg.Header.Response = true
+			if q.Name.String() == "example.test." {
+				msg.Answers = []dnsmessage.Resource{{
+					Header: dnsmessage.ResourceHeader{Name: q.Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET},
+					Body:   &dnsmessage.AResource{A: [4]byte{192, 168, 127, 3}},
+				}}
+			} else {
+				msg.Header.RCode = dnsmessage.RCodeNameError
+			}
+			resp, err := msg.Pack()
+			if err != nil {
+				continue
+			}
+			conn.WriteTo(resp, addr)
+		}
+	}()
+}
+
+func TestLookupHost(t *testing.T) {
+	a, b := pair(t)
+	serveDNS(t, b)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	addrs, err := a.LookupHost(ctx, "example.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addrs) != 1 || addrs[0] != "192.168.127.3" {
+		t.Fatalf("got %v", addrs)
+	}
+
+	_, err = a.LookupHost(ctx, "missing.test")
+	dnsErr, ok := err.(*net.DNSError)
+	if !ok || !dnsErr.IsNotFound {
+		t.Fatalf("got %v, want not found", err)
+	}
+
+	ln, err := b.Listen("tcp", ":80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		if conn, err := ln.Accept(); err == nil {
+			conn.Close()
+		}
+	}()
+	conn, err := a.DialContext(ctx, "tcp", "example.test:http")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
@@ -0,0 +1,155 @@
+//go:build js && wasm
+
+package netstack
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"syscall/js"
+)
+
+// EthernetPath is where wanix serve accepts ethernet over websocket.
+const EthernetPath = "/.well-known/ethernet"
+
+var (
+	defaultMu    sync.Mutex
+	defaultStack *Stack
+)
+
+// Default returns the stack attached to EthernetPath on the server the
+// page was loaded from, connecting on first use. Failed connects are
+// retried on the next call, as is connecting again once the websocket
+// of the last stack has closed.
+func Default() (*Stack, error) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	if defaultStack != nil {
+		select {
+		case <-defaultStack.Done():
+			defaultStack.Close()
+			defaultStack = nil
+		default:
+			return defaultStack, nil
+		}
+	}
+	loc := js.Global().Get("location")
+	scheme := "ws:"
+	if loc.Get("protocol").String() == "https:" {
+		scheme = "wss:"
+	}
+	link, err := DialWebSocket(scheme + "//" + loc.Get("host").String() + EthernetPath)
+	if err != nil {
+		return nil, err
+	}
+	s, err := New(link, Config{})
+	if err != nil {
+		link.Close()
+		return nil, err
+	}
+	defaultStack = s
+	return s, nil
+}
+
+// wsLink sends each frame as one binary websocket message.
+type wsLink struct {
+	ws     js.Value
+	frames chan []byte
+	done   chan struct{}
+	once   sync.Once
+	funcs  map[string]js.Func
+}
+
+// DialWebSocket opens a websocket to url and returns it as a Link.
+func DialWebSocket(url string) (Link, error) {
+	l := &wsLink{
+		ws:     js.Global().Get("WebSocket").New(url),
+		frames: make(chan []byte, 1024),
+		done:   make(chan struct{}),
+		funcs:  make(map[string]js.Func),
+	}
+	l.ws.Set("binaryType", "arraybuffer")
+
+	opened := make(chan error, 1)
+	l.on("open", func(js.Value) {
+		select {
+		case opened <- nil:
+		default:
+		}
+	})
+	l.on("error", func(js.Value) {
+		select {
+		case opened <- errors.New("netstack: websocket error connecting to " + url):
+		default:
+		}
+	})
+	l.on("close", func(js.Value) {
+		select {
+		case opened <- errors.New("netstack: websocket closed connecting to " + url):
+		default:
+		}
+		l.shutdown()
+	})
+	l.on("message", func(evt js.Value) {
+		data := js.Global().Get("Uint8Array").New(evt.Get("data"))
+		frame := make([]byte, data.Length())
+		js.CopyBytesToGo(frame, data)
+		// this runs on the event loop, so drop the frame like a full
+		// NIC would rather than block it
+		select {
+		case l.frames <- frame:
+		default:
+		}
+	})
+
+	if err := <-opened; err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *wsLink) on(event string, fn func(js.Value)) {
+	f := js.FuncOf(func(this js.Value, args []js.Value) any {
+		fn(args[0])
+		return nil
+	})
+	l.funcs[event] = f
+	l.ws.Call("addEventListener", event, f)
+}
+
+func (l *wsLink) ReadFrame() ([]byte, error) {
+	select {
+	case frame := <-l.frames:
+		return frame, nil
+	case <-l.done:
+		return nil, io.EOF
+	}
+}
+
+func (l *wsLink) WriteFrame(frame []byte) error {
+	select {
+	case <-l.done:
+		return io.ErrClosedPipe
+	default:
+	}
+	buf := js.Global().Get("Uint8Array").New(len(frame))
+	js.CopyBytesToJS(buf, frame)
+	l.ws.Call("send", buf)
+	return nil
+}
+
+func (l *wsLink) shutdown() {
+	l.once.Do(func() { close(l.done) })
+}
+
+func (l *wsLink) Close() error {
+	l.shutdown()
+	l.ws.Call("close")
+	for event, f := range l.funcs {
+		l.ws.Call("removeEventListener", event, f)
+		f.Release()
+	}
+	clear(l.funcs)
+	return nil
+}
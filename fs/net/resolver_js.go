@@ -0,0 +1,30 @@
+//go:build js && wasm
+
+package net
+
+import (
+	"context"
+	stdnet "net"
+
+	"tractor.dev/wanix/fs/net/cs"
+	"tractor.dev/wanix/fs/net/netstack"
+)
+
+// resolver returns the resolver for cs and dns. The browser has no
+// resolver of its own, so host names are looked up through the
+// in-process network stack connections go out over.
+func resolver() cs.Resolver {
+	return netstackResolver{stdnet.DefaultResolver}
+}
+
+type netstackResolver struct {
+	*stdnet.Resolver
+}
+
+func (netstackResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	s, err := netstack.Default()
+	if err != nil {
+		return nil, err
+	}
+	return s.LookupHost(ctx, host)
+}
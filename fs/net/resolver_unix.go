@@ -0,0 +1,10 @@
+//go:build !js || !wasm
+
+package net
+
+import "tractor.dev/wanix/fs/net/cs"
+
+// resolver returns the resolver for cs and dns: nil, for the host's.
+func resolver() cs.Resolver {
+	return nil
+}
@@ -1,5 +1,3 @@
-//go:build !js || !wasm
-
 package tcp
 
 import (
@@ -9,7 +7,6 @@ import (
 	"fmt"
 	"io"
 	"net"
-	"os"
 	"path/filepath"
 	"strings"
 	"sync"
@@ -17,6 +14,7 @@ import (
 	"tractor.dev/toolkit-go/engine/cli"
 	"tractor.dev/wanix/fs"
 	"tractor.dev/wanix/fs/fskit"
+	"tractor.dev/wanix/fs/net/egress"
 	"tractor.dev/wanix/misc"
 )
 
@@ -44,6 +42,8 @@ type Conn struct {
 	ln   net.Listener
 	conn net.Conn
 
+	policy *egress.Policy
+
 	lastErr error
 }
 
@@ -76,15 +76,17 @@ func (c *Conn) Open(name string) (fs.File, error) {
 }
 
 func (c *Conn) OpenContext(ctx context.Context, name string) (fs.File, error) {
-	return fs.OpenContext(ctx, c.rootFS(), name)
+	return fs.OpenContext(ctx, c.rootFS(ctx), name)
 }
 
-func (c *Conn) rootFS() fskit.MapFS {
+// rootFS returns the connection's files. Control commands are checked
+// against the egress policy of the namespace ctx came from.
+func (c *Conn) rootFS(ctx context.Context) fskit.MapFS {
 	fsys := fskit.MapFS{
 		"ctl": misc.ControlFile(&cli.Command{
 			Usage: "ctl",
 			Short: "control the connection",
-			Run: func(ctx *cli.Context, args []string) {
+			Run: func(_ *cli.Context, args []string) {
 				if len(args) == 0 {
 					return
 				}
@@ -93,7 +95,7 @@ func (c *Conn) rootFS() fskit.MapFS {
 					if len(args) != 2 {
 						panic(fmt.Errorf("usage: dial <addr>"))
 					}
-					if err := c.dial(args[1]); err != nil {
+					if err := c.dial(ctx, args[1]); err != nil {
 						panic(err)
 					}
 				case "bind":
@@ -107,7 +109,7 @@ func (c *Conn) rootFS() fskit.MapFS {
 					if len(args) != 2 {
 						panic(fmt.Errorf("usage: announce <addr>"))
 					}
-					if err := c.announce(args[1]); err != nil {
+					if err := c.announce(ctx, args[1]); err != nil {
 						panic(err)
 					}
 				case "hangup":
@@ -154,7 +156,29 @@ func (c *Conn) rootFS() fskit.MapFS {
 }
 
 func (c *Conn) Route(ctx context.Context, name string) (fs.FS, string, error) {
-	return c.rootFS().Route(ctx, name)
+	return c.rootFS(ctx).Route(ctx, name)
+}
+
+// SetPolicy sets the egress policy dial and announce are checked
+// against, in addition to the policy of the namespace issuing them.
+func (c *Conn) SetPolicy(p *egress.Policy) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.policy = p
+}
+
+// check returns an error if the connection's policy or the policy of
+// the namespace ctx came from denies op. Otherwise it returns the
+// addresses that were checked, which are the ones to connect to.
+func (c *Conn) check(ctx context.Context, op, network, addr string) ([]string, error) {
+	c.mu.RLock()
+	p := c.policy
+	c.mu.RUnlock()
+	ips, err := egress.Check(ctx, op, network, addr, p, egress.FromContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return egress.Addrs(addr, ips), nil
 }
 
 func isUnixPathAddr(addr string) bool {
@@ -190,7 +214,7 @@ func networkForAddr(addr string) string {
 	return "tcp"
 }
 
-func (c *Conn) dial(addr string) error {
+func (c *Conn) dial(ctx context.Context, addr string) error {
 	network := networkForAddr(addr)
 
 	c.mu.Lock()
@@ -201,20 +225,19 @@ func (c *Conn) dial(addr string) error {
 	bindAddr := c.bindAddr
 	c.mu.Unlock()
 
-	var d net.Dialer
-	if bindAddr != "" {
-		if network == "tcp" && !isUnixPathAddr(bindAddr) {
-			la, err := net.ResolveTCPAddr("tcp", bindAddr)
-			if err != nil {
-				return err
-			}
-			d.LocalAddr = la
-		} else if network == "unix" && isUnixPathAddr(bindAddr) {
-			d.LocalAddr = &net.UnixAddr{Name: bindAddr, Net: "unix"}
+	addrs, err := c.check(ctx, "dial", network, addr)
+	if err != nil {
+		c.mu.Lock()
+		c.lastErr = err
+		c.mu.Unlock()
+		return err
+	}
+	var conn net.Conn
+	for _, a := range addrs {
+		if conn, err = dialNet(context.Background(), network, bindAddr, a); err == nil {
+			break
 		}
 	}
-
-	conn, err := d.Dial(network, addr)
 	if err != nil {
 		c.mu.Lock()
 		c.lastErr = err
@@ -232,7 +255,7 @@ func (c *Conn) dial(addr string) error {
 	return nil
 }
 
-func (c *Conn) announce(addr string) error {
+func (c *Conn) announce(ctx context.Context, addr string) error {
 	network := networkForAddr(addr)
 
 	c.mu.Lock()
@@ -242,11 +265,13 @@ func (c *Conn) announce(addr string) error {
 	}
 	c.mu.Unlock()
 
-	if network == "unix" {
-		// best-effort cleanup; if the path doesn't exist that's fine
-		_ = os.Remove(addr)
+	if _, err := c.check(ctx, "announce", network, addr); err != nil {
+		c.mu.Lock()
+		c.lastErr = err
+		c.mu.Unlock()
+		return err
 	}
-	ln, err := net.Listen(network, addr)
+	ln, err := listenNet(network, addr)
 	if err != nil {
 		c.mu.Lock()
 		c.lastErr = err
@@ -430,6 +455,7 @@ func (c *Conn) acceptOne(ctx context.Context) (string, error) {
 		cc.ln = nil
 		cc.bindAddr = ""
 		cc.network = c.network
+		cc.policy = c.policy
 		cc.state = stateConnected
 		cc.lastErr = nil
 		cc.mu.Unlock()
@@ -0,0 +1,40 @@
+//go:build js && wasm
+
+package tcp
+
+import (
+	"context"
+	"net"
+
+	"tractor.dev/wanix/fs"
+	"tractor.dev/wanix/fs/net/netstack"
+)
+
+// dialNet connects to addr through the in-process network stack, which
+// reaches the network over the ethernet endpoint of wanix serve. There
+// are no unix sockets in the browser.
+func dialNet(ctx context.Context, network, laddr, addr string) (net.Conn, error) {
+	if network != "tcp" {
+		return nil, fs.ErrNotSupported
+	}
+	s, err := netstack.Default()
+	if err != nil {
+		return nil, err
+	}
+	if isUnixPathAddr(laddr) {
+		laddr = ""
+	}
+	return s.DialFrom(ctx, network, laddr, addr)
+}
+
+// listenNet announces on addr through the in-process network stack.
+func listenNet(network, addr string) (net.Listener, error) {
+	if network != "tcp" {
+		return nil, fs.ErrNotSupported
+	}
+	s, err := netstack.Default()
+	if err != nil {
+		return nil, err
+	}
+	return s.Listen(network, addr)
+}
@@ -0,0 +1,36 @@
+//go:build !js || !wasm
+
+package tcp
+
+import (
+	"context"
+	"net"
+	"os"
+)
+
+// dialNet connects to addr with the host network, binding the local end
+// to laddr when it's an address of the same kind.
+func dialNet(ctx context.Context, network, laddr, addr string) (net.Conn, error) {
+	var d net.Dialer
+	if laddr != "" {
+		if network == "tcp" && !isUnixPathAddr(laddr) {
+			la, err := net.ResolveTCPAddr("tcp", laddr)
+			if err != nil {
+				return nil, err
+			}
+			d.LocalAddr = la
+		} else if network == "unix" && isUnixPathAddr(laddr) {
+			d.LocalAddr = &net.UnixAddr{Name: laddr, Net: "unix"}
+		}
+	}
+	return d.DialContext(ctx, network, addr)
+}
+
+// listenNet announces on addr with the host network.
+func listenNet(network, addr string) (net.Listener, error) {
+	if network == "unix" {
+		// best-effort cleanup; if the path doesn't exist that's fine
+		_ = os.Remove(addr)
+	}
+	return net.Listen(network, addr)
+}
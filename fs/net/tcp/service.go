@@ -7,6 +7,7 @@ import (
 
 	"tractor.dev/wanix/fs"
 	"tractor.dev/wanix/fs/fskit"
+	"tractor.dev/wanix/fs/net/egress"
 )
 
 type Service struct {
@@ -14,6 +15,9 @@ type Service struct {
 	resources map[string]fs.FS
 	nextID    int
 
+	// AllocHook is called for each connection allocated by reading new,
+	// after it gets the egress policy of the reader's namespace. It can
+	// set a different one with Conn.SetPolicy.
 	AllocHook func(s *Service, rid string) error
 }
 
@@ -53,6 +57,9 @@ func (s *Service) rootFS() fskit.UnionFS {
 					if err != nil {
 						return err
 					}
+					if c, err := s.Get(rid); err == nil {
+						c.SetPolicy(egress.FromContext(ctx))
+					}
 					if s.AllocHook != nil {
 						if err := s.AllocHook(s, rid); err != nil {
 							return err
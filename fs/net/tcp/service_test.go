@@ -13,6 +13,8 @@ import (
 	"testing"
 
 	"tractor.dev/wanix/fs"
+	"tractor.dev/wanix/fs/net/egress"
+	"tractor.dev/wanix/fs/vfs"
 )
 
 func readText(t *testing.T, f fs.File) string {
@@ -220,3 +222,58 @@ func mustOpen(t *testing.T, fsys fs.FS, name string) fs.File {
 	return f
 }
 
+func TestEgressPolicy(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+
+	ns := vfs.New(context.Background())
+	if err := egress.For(ns).SetRules("deny tcp 127.0.0.0/8\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ns.Bind(New(), ".", "tcp"); err != nil {
+		t.Fatal(err)
+	}
+
+	id := strings.TrimSpace(readText(t, mustOpen(t, ns, "tcp/new")))
+	err = fs.WriteFile(ns, "tcp/"+id+"/ctl", []byte("dial "+ln.Addr().String()+"\n"), 0644)
+	if err == nil {
+		t.Fatal("expected dial to be denied")
+	}
+	if !strings.Contains(egress.For(ns).Audit().String(), "dial tcp "+ln.Addr().String()) {
+		t.Fatalf("denial not audited: %q", egress.For(ns).Audit().String())
+	}
+}
+
+func TestAllocHookPolicy(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+
+	p := egress.New()
+	if err := p.SetRules("default deny"); err != nil {
+		t.Fatal(err)
+	}
+	s := New()
+	s.AllocHook = func(s *Service, rid string) error {
+		c, err := s.Get(rid)
+		if err != nil {
+			return err
+		}
+		c.SetPolicy(p)
+		return nil
+	}
+
+	id := strings.TrimSpace(readText(t, mustOpen(t, s, "new")))
+	err = fs.WriteFile(s, id+"/ctl", []byte("announce 127.0.0.1:0\n"), 0644)
+	if err == nil {
+		t.Fatal("expected announce to be denied")
+	}
+	if !strings.Contains(p.Audit().String(), "announce tcp 127.0.0.1:0") {
+		t.Fatalf("denial not audited: %q", p.Audit().String())
+	}
+}
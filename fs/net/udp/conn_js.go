@@ -1,11 +1,12 @@
 //go:build js && wasm
 
-package tcp
+package udp
 
 import (
 	"context"
 
 	"tractor.dev/wanix/fs"
+	"tractor.dev/wanix/fs/net/egress"
 )
 
 // Conn is not supported in js/wasm builds (no Go net stack available).
@@ -13,7 +14,9 @@ import (
 type Conn struct{}
 
 func newConn(_ string, _ *Service) *Conn { return &Conn{} }
-func (c *Conn) shutdown()               {}
+func (c *Conn) shutdown()                {}
+
+func (c *Conn) SetPolicy(p *egress.Policy) {}
 
 func (c *Conn) Open(name string) (fs.File, error) {
 	return c.OpenContext(context.Background(), name)
@@ -26,4 +29,3 @@ func (c *Conn) OpenContext(ctx context.Context, name string) (fs.File, error) {
 func (c *Conn) Route(ctx context.Context, name string) (fs.FS, string, error) {
 	return nil, name, fs.ErrNotSupported
 }
-
@@ -0,0 +1,380 @@
+//go:build !js || !wasm
+
+package udp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"tractor.dev/toolkit-go/engine/cli"
+	"tractor.dev/wanix/fs"
+	"tractor.dev/wanix/fs/fskit"
+	"tractor.dev/wanix/fs/net/egress"
+	"tractor.dev/wanix/misc"
+)
+
+// MaxDatagram is the largest datagram data reads return.
+const MaxDatagram = 65535
+
+type connState string
+
+const (
+	stateIdle      connState = "idle"
+	stateBound     connState = "bound"
+	stateAnnounced connState = "announced"
+	stateConnected connState = "connected"
+	stateClosed    connState = "closed"
+)
+
+type Conn struct {
+	id  string
+	svc *Service
+
+	mu sync.RWMutex
+
+	state connState
+
+	bindAddr string
+
+	conn *net.UDPConn
+
+	policy *egress.Policy
+
+	lastErr error
+}
+
+func newConn(id string, svc *Service) *Conn {
+	return &Conn{
+		id:    id,
+		svc:   svc,
+		state: stateIdle,
+	}
+}
+
+func (c *Conn) shutdown() {
+	c.mu.Lock()
+	conn := c.conn
+	c.conn = nil
+	c.state = stateClosed
+	c.mu.Unlock()
+	if conn != nil {
+		_ = conn.Close()
+	}
+}
+
+func (c *Conn) Open(name string) (fs.File, error) {
+	return c.OpenContext(context.Background(), name)
+}
+
+func (c *Conn) OpenContext(ctx context.Context, name string) (fs.File, error) {
+	return fs.OpenContext(ctx, c.rootFS(ctx), name)
+}
+
+// rootFS returns the connection's files. Control commands are checked
+// against the egress policy of the namespace ctx came from.
+func (c *Conn) rootFS(ctx context.Context) fskit.MapFS {
+	return fskit.MapFS{
+		"ctl": misc.ControlFile(&cli.Command{
+			Usage: "ctl",
+			Short: "control the connection",
+			Run: func(_ *cli.Context, args []string) {
+				if len(args) == 0 {
+					return
+				}
+				switch args[0] {
+				case "connect", "dial":
+					if len(args) != 2 {
+						panic(fmt.Errorf("usage: %s <addr>", args[0]))
+					}
+					if err := c.connect(ctx, args[1]); err != nil {
+						panic(err)
+					}
+				case "bind":
+					if len(args) != 2 {
+						panic(fmt.Errorf("usage: bind <addr>"))
+					}
+					if err := c.bind(args[1]); err != nil {
+						panic(err)
+					}
+				case "announce":
+					if len(args) != 2 {
+						panic(fmt.Errorf("usage: announce <addr>"))
+					}
+					if err := c.announce(ctx, args[1]); err != nil {
+						panic(err)
+					}
+				case "hangup":
+					if err := c.hangup(); err != nil {
+						panic(err)
+					}
+				default:
+					panic(fs.ErrNotSupported)
+				}
+			},
+		}),
+		"status": misc.FieldFile(func() (string, error) { return c.status(), nil }),
+		"local":  misc.FieldFile(func() (string, error) { return c.local(), nil }),
+		"remote": misc.FieldFile(func() (string, error) { return c.remote(), nil }),
+		"data": fskit.OpenFunc(func(ctx context.Context, name string) (fs.File, error) {
+			if name != "." {
+				return nil, fs.ErrNotExist
+			}
+			c.mu.RLock()
+			conn := c.conn
+			state := c.state
+			c.mu.RUnlock()
+			if conn == nil || (state != stateConnected && state != stateAnnounced) {
+				return nil, fs.ErrPermission
+			}
+			return &dataFile{c: c, ctx: ctx, conn: conn, headers: state == stateAnnounced}, nil
+		}),
+	}
+}
+
+func (c *Conn) Route(ctx context.Context, name string) (fs.FS, string, error) {
+	return c.rootFS(ctx).Route(ctx, name)
+}
+
+// SetPolicy sets the egress policy connect and announce are checked
+// against, in addition to the policy of the namespace issuing them.
+func (c *Conn) SetPolicy(p *egress.Policy) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.policy = p
+}
+
+// check returns an error if the connection's policy or the policy of
+// the namespace ctx came from denies op. Otherwise it returns the
+// addresses that were checked, which are the ones to send to.
+func (c *Conn) check(ctx context.Context, op, addr string) ([]string, error) {
+	c.mu.RLock()
+	p := c.policy
+	c.mu.RUnlock()
+	ips, err := egress.Check(ctx, op, "udp", addr, p, egress.FromContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return egress.Addrs(addr, ips), nil
+}
+
+func (c *Conn) bind(addr string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.state == stateConnected || c.state == stateAnnounced {
+		return fmt.Errorf("bind: %w", fs.ErrPermission)
+	}
+	c.bindAddr = addr
+	c.state = stateBound
+	return nil
+}
+
+func (c *Conn) connect(ctx context.Context, addr string) error {
+	c.mu.Lock()
+	if c.state == stateConnected || c.state == stateAnnounced {
+		c.mu.Unlock()
+		return fmt.Errorf("connect: %w", fs.ErrPermission)
+	}
+	bindAddr := c.bindAddr
+	c.mu.Unlock()
+
+	addrs, err := c.check(ctx, "connect", addr)
+	if err != nil {
+		c.mu.Lock()
+		c.lastErr = err
+		c.mu.Unlock()
+		return err
+	}
+
+	var la *net.UDPAddr
+	if bindAddr != "" {
+		la, err = net.ResolveUDPAddr("udp", bindAddr)
+		if err != nil {
+			return err
+		}
+	}
+	ra, err := net.ResolveUDPAddr("udp", addrs[0])
+	if err != nil {
+		return err
+	}
+	conn, err := net.DialUDP("udp", la, ra)
+	if err != nil {
+		c.mu.Lock()
+		c.lastErr = err
+		c.mu.Unlock()
+		return err
+	}
+
+	c.mu.Lock()
+	c.conn = conn
+	c.state = stateConnected
+	c.lastErr = nil
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *Conn) announce(ctx context.Context, addr string) error {
+	c.mu.Lock()
+	if c.state == stateConnected || c.state == stateAnnounced {
+		c.mu.Unlock()
+		return fmt.Errorf("announce: %w", fs.ErrPermission)
+	}
+	c.mu.Unlock()
+
+	if _, err := c.check(ctx, "announce", addr); err != nil {
+		c.mu.Lock()
+		c.lastErr = err
+		c.mu.Unlock()
+		return err
+	}
+
+	la, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return err
+	}
+	conn, err := net.ListenUDP("udp", la)
+	if err != nil {
+		c.mu.Lock()
+		c.lastErr = err
+		c.mu.Unlock()
+		return err
+	}
+
+	c.mu.Lock()
+	c.conn = conn
+	c.state = stateAnnounced
+	c.lastErr = nil
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *Conn) hangup() error {
+	c.mu.Lock()
+	conn := c.conn
+	c.conn = nil
+	c.bindAddr = ""
+	c.lastErr = nil
+	c.state = stateClosed
+	c.mu.Unlock()
+
+	if conn != nil {
+		_ = conn.Close()
+	}
+	return nil
+}
+
+func (c *Conn) status() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var b strings.Builder
+	b.WriteString(string(c.state))
+	if c.conn != nil {
+		b.WriteString(" local=")
+		b.WriteString(addrString(c.conn.LocalAddr()))
+		if c.state == stateConnected {
+			b.WriteString(" remote=")
+			b.WriteString(addrString(c.conn.RemoteAddr()))
+		}
+	}
+	if c.lastErr != nil {
+		b.WriteString(" err=")
+		b.WriteString(c.lastErr.Error())
+	}
+	return b.String()
+}
+
+func (c *Conn) local() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.conn != nil {
+		return addrString(c.conn.LocalAddr())
+	}
+	return c.bindAddr
+}
+
+func (c *Conn) remote() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.conn != nil && c.state == stateConnected {
+		return addrString(c.conn.RemoteAddr())
+	}
+	return ""
+}
+
+func addrString(a net.Addr) string {
+	if a == nil {
+		return ""
+	}
+	return a.String()
+}
+
+// dataFile reads and writes one datagram per call. A read returns the
+// next datagram, truncated to the buffer, like recv(2). On an announced
+// connection each datagram is preceded by a line with the peer address,
+// and writes must start with such a line to say where to send, which
+// is checked like a connect to it.
+type dataFile struct {
+	c       *Conn
+	ctx     context.Context // the open, for the namespace policy
+	conn    *net.UDPConn
+	headers bool
+}
+
+func (f *dataFile) Read(p []byte) (int, error) {
+	buf := make([]byte, MaxDatagram)
+	if !f.headers {
+		n, err := f.conn.Read(buf)
+		if err != nil {
+			return 0, err
+		}
+		return copy(p, buf[:n]), nil
+	}
+	n, addr, err := f.conn.ReadFromUDP(buf)
+	if err != nil {
+		return 0, err
+	}
+	msg := append([]byte(addr.String()+"\n"), buf[:n]...)
+	return copy(p, msg), nil
+}
+
+func (f *dataFile) ReadAt(p []byte, off int64) (int, error) {
+	return f.Read(p)
+}
+
+func (f *dataFile) Write(p []byte) (int, error) {
+	if !f.headers {
+		return f.conn.Write(p)
+	}
+	i := bytes.IndexByte(p, '\n')
+	if i < 0 {
+		return 0, fmt.Errorf("udp: write without address header: %w", fs.ErrInvalid)
+	}
+	addrs, err := f.c.check(f.ctx, "send", string(p[:i]))
+	if err != nil {
+		return 0, err
+	}
+	addr, err := net.ResolveUDPAddr("udp", addrs[0])
+	if err != nil {
+		return 0, err
+	}
+	if _, err := f.conn.WriteToUDP(p[i+1:], addr); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (f *dataFile) WriteAt(p []byte, off int64) (int, error) {
+	return f.Write(p)
+}
+
+func (f *dataFile) Close() error { return nil }
+
+func (f *dataFile) Stat() (fs.FileInfo, error) {
+	return fskit.Entry("data", fs.FileMode(0644)), nil
+}
+
+var _ fs.File = (*dataFile)(nil)
@@ -0,0 +1,112 @@
+package udp
+
+import (
+	"context"
+	"strconv"
+	"sync"
+
+	"tractor.dev/wanix/fs"
+	"tractor.dev/wanix/fs/fskit"
+	"tractor.dev/wanix/fs/net/egress"
+)
+
+type Service struct {
+	mu        sync.RWMutex
+	resources map[string]fs.FS
+	nextID    int
+
+	// AllocHook is called for each connection allocated by reading new,
+	// after it gets the egress policy of the reader's namespace. It can
+	// set a different one with Conn.SetPolicy.
+	AllocHook func(s *Service, rid string) error
+}
+
+func New() *Service {
+	return &Service{
+		resources: make(map[string]fs.FS),
+		nextID:    0,
+	}
+}
+
+func (s *Service) Open(name string) (fs.File, error) {
+	return s.OpenContext(context.Background(), name)
+}
+
+func (s *Service) OpenContext(ctx context.Context, name string) (fs.File, error) {
+	return fs.OpenContext(ctx, s.rootFS(), name)
+}
+
+func (s *Service) Stat(name string) (fs.FileInfo, error) {
+	return s.StatContext(context.Background(), name)
+}
+
+func (s *Service) StatContext(ctx context.Context, name string) (fs.FileInfo, error) {
+	return fs.StatContext(ctx, s.rootFS(), name)
+}
+
+func (s *Service) rootFS() fskit.UnionFS {
+	root := fskit.MapFS{
+		"new": fskit.OpenFunc(func(ctx context.Context, name string) (fs.File, error) {
+			if name != "." {
+				return nil, fs.ErrNotExist
+			}
+			return &fskit.FuncFile{
+				Node: fskit.Entry("new", 0555),
+				ReadFunc: func(n *fskit.Node) error {
+					rid, err := s.Alloc()
+					if err != nil {
+						return err
+					}
+					if c, err := s.Get(rid); err == nil {
+						c.SetPolicy(egress.FromContext(ctx))
+					}
+					if s.AllocHook != nil {
+						if err := s.AllocHook(s, rid); err != nil {
+							return err
+						}
+					}
+					fskit.SetData(n, []byte(rid+"\n"))
+					return nil
+				},
+			}, nil
+		}),
+	}
+	return fskit.UnionFS{root, fskit.MapFS(s.resources)}
+}
+
+func (s *Service) Route(ctx context.Context, name string) (fs.FS, string, error) {
+	return s.rootFS().Route(ctx, name)
+}
+
+func (s *Service) Get(rid string) (*Conn, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	res, ok := s.resources[rid]
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+	return res.(*Conn), nil
+}
+
+func (s *Service) Alloc() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextID++
+	rid := strconv.Itoa(s.nextID)
+	s.resources[rid] = newConn(rid, s)
+	return rid, nil
+}
+
+func (s *Service) remove(rid string) {
+	s.mu.Lock()
+	res, ok := s.resources[rid]
+	if ok {
+		delete(s.resources, rid)
+	}
+	s.mu.Unlock()
+
+	if ok {
+		res.(*Conn).shutdown()
+	}
+}
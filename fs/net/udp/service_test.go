@@ -0,0 +1,203 @@
+//go:build !js || !wasm
+
+package udp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"tractor.dev/wanix/fs"
+	"tractor.dev/wanix/fs/net/egress"
+	"tractor.dev/wanix/fs/vfs"
+)
+
+func readText(t *testing.T, f fs.File) string {
+	t.Helper()
+	defer f.Close()
+	b, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	return string(b)
+}
+
+func writeText(t *testing.T, fsys fs.FS, name, data string) {
+	t.Helper()
+	if err := fs.WriteFile(fsys, name, []byte(data), 0644); err != nil {
+		t.Fatalf("write %s: %v", name, err)
+	}
+}
+
+func TestConnectPreservesDatagrams(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer pc.Close()
+
+	// echo server
+	go func() {
+		buf := make([]byte, MaxDatagram)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			_, _ = pc.WriteTo(buf[:n], addr)
+		}
+	}()
+
+	s := New()
+	id := strings.TrimSpace(readText(t, mustOpen(t, s, "new")))
+	writeText(t, s, id+"/ctl", "connect "+pc.LocalAddr().String()+"\n")
+
+	df, err := s.Open(id + "/data")
+	if err != nil {
+		t.Fatalf("open data: %v", err)
+	}
+	defer df.Close()
+
+	for _, msg := range []string{"one", "two"} {
+		if _, err := fs.Write(df, []byte(msg)); err != nil {
+			t.Fatalf("write data: %v", err)
+		}
+	}
+	buf := make([]byte, 64)
+	for _, want := range []string{"one", "two"} {
+		n, err := df.Read(buf)
+		if err != nil {
+			t.Fatalf("read data: %v", err)
+		}
+		if got := string(buf[:n]); got != want {
+			t.Fatalf("got %q want %q", got, want)
+		}
+	}
+
+	remote := strings.TrimSpace(readText(t, mustOpen(t, s, id+"/remote")))
+	if remote != pc.LocalAddr().String() {
+		t.Fatalf("remote %q want %q", remote, pc.LocalAddr())
+	}
+}
+
+func TestAnnounceHeaders(t *testing.T) {
+	s := New()
+	id := strings.TrimSpace(readText(t, mustOpen(t, s, "new")))
+	writeText(t, s, id+"/ctl", "announce 127.0.0.1:0\n")
+	local := strings.TrimSpace(readText(t, mustOpen(t, s, id+"/local")))
+
+	client, err := net.Dial("udp", local)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer client.Close()
+	if _, err := client.Write([]byte("ping")); err != nil {
+		t.Fatalf("client write: %v", err)
+	}
+
+	df, err := s.Open(id + "/data")
+	if err != nil {
+		t.Fatalf("open data: %v", err)
+	}
+	defer df.Close()
+	buf := make([]byte, 128)
+	n, err := df.Read(buf)
+	if err != nil {
+		t.Fatalf("read data: %v", err)
+	}
+	want := client.LocalAddr().String() + "\nping"
+	if got := string(buf[:n]); got != want {
+		t.Fatalf("got %q want %q", got, want)
+	}
+
+	// reply to the peer named in the header
+	if _, err := fs.Write(df, []byte(client.LocalAddr().String()+"\npong")); err != nil {
+		t.Fatalf("write data: %v", err)
+	}
+	n, err = client.Read(buf)
+	if err != nil {
+		t.Fatalf("client read: %v", err)
+	}
+	if got := string(buf[:n]); got != "pong" {
+		t.Fatalf("got %q", got)
+	}
+
+	if _, err := fs.Write(df, []byte("no header")); err == nil {
+		t.Fatal("expected error writing without a header")
+	}
+}
+
+func mustOpen(t *testing.T, fsys fs.FS, name string) fs.File {
+	t.Helper()
+	f, err := fs.OpenContext(context.Background(), fsys, name)
+	if err != nil {
+		t.Fatalf("open %s: %v", name, err)
+	}
+	return f
+}
+
+func TestEgressPolicy(t *testing.T) {
+	ns := vfs.New(context.Background())
+	if err := egress.For(ns).SetRules("allow udp 127.0.0.1:53\ndefault deny\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ns.Bind(New(), ".", "udp"); err != nil {
+		t.Fatal(err)
+	}
+
+	id := strings.TrimSpace(readText(t, mustOpen(t, ns, "udp/new")))
+	if err := fs.WriteFile(ns, "udp/"+id+"/ctl", []byte("connect 127.0.0.1:9\n"), 0644); err == nil {
+		t.Fatal("expected connect to be denied")
+	}
+	writeText(t, ns, "udp/"+id+"/ctl", "connect 127.0.0.1:53\n")
+	if !strings.Contains(egress.For(ns).Audit().String(), "connect udp 127.0.0.1:9") {
+		t.Fatalf("denial not audited: %q", egress.For(ns).Audit().String())
+	}
+}
+
+func TestEgressSend(t *testing.T) {
+	ns := vfs.New(context.Background())
+	if err := egress.For(ns).SetRules("deny udp 127.0.0.1:9\nallow udp 127.0.0.1:*\ndefault deny\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ns.Bind(New(), ".", "udp"); err != nil {
+		t.Fatal(err)
+	}
+	client, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer client.Close()
+
+	id := strings.TrimSpace(readText(t, mustOpen(t, ns, "udp/new")))
+	writeText(t, ns, "udp/"+id+"/ctl", "announce 127.0.0.1:0\n")
+	df, err := fs.OpenContext(context.Background(), ns, "udp/"+id+"/data")
+	if err != nil {
+		t.Fatalf("open data: %v", err)
+	}
+	defer df.Close()
+
+	for _, addr := range []string{"127.0.0.1:9", "10.0.0.1:9"} {
+		if _, err := fs.Write(df, []byte(addr+"\nping")); !errors.Is(err, fs.ErrPermission) {
+			t.Fatalf("send to %s: got %v, want denied", addr, err)
+		}
+		if !strings.Contains(egress.For(ns).Audit().String(), "send udp "+addr) {
+			t.Fatalf("denial not audited: %q", egress.For(ns).Audit().String())
+		}
+	}
+
+	if _, err := fs.Write(df, []byte(client.LocalAddr().String()+"\nping")); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	buf := make([]byte, 64)
+	n, _, err := client.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("client read: %v", err)
+	}
+	if got := string(buf[:n]); got != "ping" {
+		t.Fatalf("got %q", got)
+	}
+}
@@ -263,6 +263,31 @@ func (l *p9file) Mkdir(name string, permissions p9.FileMode, uid p9.UID, gid p9.
 	return qid, err
 }
 
+// Mknod implements p9.File.Mknod.
+func (l *p9file) Mknod(name string, mode p9.FileMode, major uint32, minor uint32, uid p9.UID, gid p9.GID) (p9.QID, error) {
+	newPath := path.Join(l.path, name)
+	dev := int(major<<8 | minor&0xff)
+	if err := fs.Mknod(l.fsys, newPath, mode.OSMode(), dev); err != nil {
+		return p9.QID{}, err
+	}
+
+	if uid.Ok() || gid != p9.NoGID {
+		u := -1
+		g := -1
+		if uid.Ok() {
+			u = int(uid)
+		}
+		if gid != p9.NoGID {
+			g = int(gid)
+		}
+		fs.Chown(l.fsys, newPath, u, g) // best-effort
+	}
+
+	child := &p9file{path: newPath, fsys: l.fsys, vattrs: l.vattrs}
+	qid, _, err := child.info()
+	return qid, err
+}
+
 // Symlink implements p9.File.Symlink.
 func (l *p9file) Symlink(oldname string, newname string, _ p9.UID, _ p9.GID) (p9.QID, error) {
 	if err := fs.Symlink(l.fsys, oldname, path.Join(l.path, newname)); err != nil {
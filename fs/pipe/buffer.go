@@ -2,44 +2,131 @@ package pipe
 
 import (
 	"bytes"
+	"context"
 	"io"
+	"os"
 	"sync"
+	"syscall"
+	"time"
 )
 
+// ErrWouldBlock is returned by a write to a full non-blocking buffer.
+var ErrWouldBlock = syscall.EAGAIN
+
+// Options configure a Buffer.
+type Options struct {
+	// Block makes reads wait for data and writes wait for space. Otherwise
+	// a write that doesn't fit returns ErrWouldBlock.
+	Block bool
+	// Capacity is how many bytes the buffer holds before writers block.
+	// Zero means unbounded.
+	Capacity int
+	// Message preserves write boundaries: each read returns at most one
+	// write, like a Plan 9 pipe.
+	Message bool
+}
+
 type Buffer struct {
 	buffer   bytes.Buffer
+	msgs     []int // lengths of the messages in buffer in message mode
 	mu       sync.Mutex
 	dataCond *sync.Cond
 	closed   bool
 	block    bool
+	capacity int
+	message  bool
+
+	readDeadline  time.Time
+	writeDeadline time.Time
+	readTimer     *time.Timer
+	writeTimer    *time.Timer
 }
 
 func NewBuffer(block bool) *Buffer {
-	bp := &Buffer{block: block}
+	return NewBufferWithOptions(Options{Block: block})
+}
+
+// NewBufferWithOptions returns a Buffer configured by opts.
+func NewBufferWithOptions(opts Options) *Buffer {
+	bp := &Buffer{block: opts.Block, capacity: opts.Capacity, message: opts.Message}
 	bp.dataCond = sync.NewCond(&bp.mu)
 	return bp
 }
 
 func (bp *Buffer) Write(data []byte) (int, error) {
+	return bp.WriteContext(context.Background(), data)
+}
+
+// WriteContext writes data, waiting for space while the buffer is full
+// until ctx is done or the write deadline passes.
+func (bp *Buffer) WriteContext(ctx context.Context, data []byte) (int, error) {
+	defer bp.wakeOn(ctx)()
 	bp.mu.Lock()
 	defer bp.mu.Unlock()
 
-	if bp.closed {
-		return 0, io.ErrClosedPipe
+	if bp.message {
+		if len(data) == 0 {
+			return 0, nil
+		}
+		// a message is written whole; one bigger than the buffer is
+		// written once the buffer is empty
+		for !bp.closed && !bp.fits(len(data)) && bp.buffer.Len() > 0 {
+			if err := bp.wait(ctx, bp.writeDeadline); err != nil {
+				return 0, err
+			}
+		}
+		if bp.closed {
+			return 0, io.ErrClosedPipe
+		}
+		bp.buffer.Write(data)
+		bp.msgs = append(bp.msgs, len(data))
+		bp.dataCond.Broadcast()
+		return len(data), nil
 	}
 
-	n, err := bp.buffer.Write(data)
-	bp.dataCond.Signal()
-	return n, err
+	n := 0
+	for n < len(data) {
+		if bp.closed {
+			return n, io.ErrClosedPipe
+		}
+		space := len(data) - n
+		if bp.capacity > 0 {
+			space = min(space, bp.capacity-bp.buffer.Len())
+		}
+		if space <= 0 {
+			if err := bp.wait(ctx, bp.writeDeadline); err != nil {
+				return n, err
+			}
+			continue
+		}
+		bp.buffer.Write(data[n : n+space])
+		n += space
+		bp.dataCond.Broadcast()
+	}
+	return n, nil
+}
+
+// fits reports whether n more bytes fit in the buffer.
+func (bp *Buffer) fits(n int) bool {
+	return bp.capacity <= 0 || bp.buffer.Len()+n <= bp.capacity
 }
 
 func (bp *Buffer) Read(p []byte) (int, error) {
+	return bp.ReadContext(context.Background(), p)
+}
+
+// ReadContext reads into p, waiting for data in blocking mode until ctx
+// is done or the read deadline passes.
+func (bp *Buffer) ReadContext(ctx context.Context, p []byte) (int, error) {
+	defer bp.wakeOn(ctx)()
 	bp.mu.Lock()
 	defer bp.mu.Unlock()
 
 	if bp.block {
 		for bp.buffer.Len() == 0 && !bp.closed {
-			bp.dataCond.Wait()
+			if err := bp.wait(ctx, bp.readDeadline); err != nil {
+				return 0, err
+			}
 		}
 	}
 
@@ -47,7 +134,83 @@ func (bp *Buffer) Read(p []byte) (int, error) {
 		return 0, io.EOF
 	}
 
-	return bp.buffer.Read(p)
+	if bp.message && len(bp.msgs) > 0 {
+		n, err := bp.buffer.Read(p[:min(len(p), bp.msgs[0])])
+		if bp.msgs[0] -= n; bp.msgs[0] == 0 {
+			bp.msgs = bp.msgs[1:]
+		}
+		bp.dataCond.Broadcast()
+		return n, err
+	}
+	n, err := bp.buffer.Read(p)
+	bp.dataCond.Broadcast()
+	return n, err
+}
+
+// wait waits for the buffer to change. It returns an error if ctx is done
+// or deadline has passed, or ErrWouldBlock if the buffer doesn't block.
+// Caller must hold mu.
+func (bp *Buffer) wait(ctx context.Context, deadline time.Time) error {
+	if !bp.block {
+		return ErrWouldBlock
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if !deadline.IsZero() && !time.Now().Before(deadline) {
+		return os.ErrDeadlineExceeded
+	}
+	bp.dataCond.Wait()
+	return nil
+}
+
+// wakeOn wakes waiters when ctx is done. The returned func stops it.
+func (bp *Buffer) wakeOn(ctx context.Context) func() {
+	if ctx.Done() == nil {
+		return func() {}
+	}
+	stop := context.AfterFunc(ctx, bp.wake)
+	return func() { stop() }
+}
+
+func (bp *Buffer) wake() {
+	bp.mu.Lock()
+	bp.dataCond.Broadcast()
+	bp.mu.Unlock()
+}
+
+// SetReadDeadline sets when blocked reads give up with
+// os.ErrDeadlineExceeded. A zero t means no deadline.
+func (bp *Buffer) SetReadDeadline(t time.Time) error {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+	bp.readDeadline = t
+	bp.readTimer = bp.resetTimer(bp.readTimer, t)
+	return nil
+}
+
+// SetWriteDeadline sets when blocked writes give up with
+// os.ErrDeadlineExceeded. A zero t means no deadline.
+func (bp *Buffer) SetWriteDeadline(t time.Time) error {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+	bp.writeDeadline = t
+	bp.writeTimer = bp.resetTimer(bp.writeTimer, t)
+	return nil
+}
+
+// resetTimer replaces timer with one that wakes waiters at t, and wakes
+// them now so they see the new deadline. Caller must hold mu.
+func (bp *Buffer) resetTimer(timer *time.Timer, t time.Time) *time.Timer {
+	if timer != nil {
+		timer.Stop()
+		timer = nil
+	}
+	if !t.IsZero() {
+		timer = time.AfterFunc(time.Until(t), bp.wake)
+	}
+	bp.dataCond.Broadcast()
+	return timer
 }
 
 func (bp *Buffer) Close() error {
@@ -59,6 +222,12 @@ func (bp *Buffer) Close() error {
 	return nil
 }
 
+func (bp *Buffer) isClosed() bool {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+	return bp.closed
+}
+
 func (bp *Buffer) Size() int {
 	bp.mu.Lock()
 	defer bp.mu.Unlock()
@@ -0,0 +1,171 @@
+package pipe
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+
+	"tractor.dev/wanix/fs"
+)
+
+// FIFOCapacity is how many bytes a FIFO holds before writers block.
+const FIFOCapacity = 64 * 1024
+
+// FIFO is the shared state of a named pipe. Opens rendezvous like they do
+// on Unix: opening for reading waits for a writer and opening for writing
+// waits for a reader, unless the open is for both. Readers get EOF once
+// every writer has closed, and writes with no readers fail with EPIPE.
+type FIFO struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	buf     *Buffer
+	readers int
+	writers int
+}
+
+func NewFIFO() *FIFO {
+	f := &FIFO{}
+	f.cond = sync.NewCond(&f.mu)
+	return f
+}
+
+// Open opens the FIFO for the access mode in flag, waiting for the other
+// end until ctx is done. The returned file's Stat returns info.
+func (f *FIFO) Open(ctx context.Context, info fs.FileInfo, flag int) (fs.File, error) {
+	var read, write bool
+	switch flag & (os.O_RDONLY | os.O_WRONLY | os.O_RDWR) {
+	case os.O_RDONLY:
+		read = true
+	case os.O_WRONLY:
+		write = true
+	default:
+		read, write = true, true
+	}
+
+	if ctx.Done() != nil {
+		stop := context.AfterFunc(ctx, func() {
+			f.mu.Lock()
+			f.cond.Broadcast()
+			f.mu.Unlock()
+		})
+		defer stop()
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if (read && f.readers == 0) || (write && f.writers == 0) {
+		f.renew()
+	}
+	if read {
+		f.readers++
+	}
+	if write {
+		f.writers++
+	}
+	f.cond.Broadcast()
+
+	for (read && !write && f.writers == 0) || (write && !read && f.readers == 0) {
+		if err := ctx.Err(); err != nil {
+			f.release(read, write)
+			return nil, err
+		}
+		f.cond.Wait()
+	}
+	return &fifoFile{fifo: f, info: info, read: read, write: write}, nil
+}
+
+// renew replaces a buffer closed by the last reader or writer with a new
+// one. Unread data is kept while the FIFO is still open elsewhere.
+// Caller must hold mu.
+func (f *FIFO) renew() {
+	if f.buf != nil && !f.buf.isClosed() {
+		return
+	}
+	old := f.buf
+	f.buf = NewBufferWithOptions(Options{Block: true, Capacity: FIFOCapacity})
+	if old != nil && f.readers+f.writers > 0 {
+		io.Copy(f.buf, old)
+	}
+}
+
+// release drops an open. The buffer is closed when the last writer goes
+// so readers see EOF, or when the last reader goes so writers see EPIPE.
+// Caller must hold mu.
+func (f *FIFO) release(read, write bool) {
+	if read {
+		f.readers--
+	}
+	if write {
+		f.writers--
+	}
+	if (read && f.readers == 0) || (write && f.writers == 0) {
+		f.buf.Close()
+	}
+	f.cond.Broadcast()
+}
+
+func (f *FIFO) buffer() *Buffer {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.buf
+}
+
+func (f *FIFO) hasReaders() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.readers > 0
+}
+
+// fifoFile is one open of a FIFO.
+type fifoFile struct {
+	fifo  *FIFO
+	info  fs.FileInfo
+	read  bool
+	write bool
+	once  sync.Once
+}
+
+func (ff *fifoFile) Read(p []byte) (int, error) {
+	if !ff.read {
+		return 0, fs.ErrPermission
+	}
+	return ff.fifo.buffer().Read(p)
+}
+
+func (ff *fifoFile) ReadAt(p []byte, off int64) (int, error) {
+	return ff.Read(p)
+}
+
+func (ff *fifoFile) Write(p []byte) (int, error) {
+	if !ff.write {
+		return 0, fs.ErrPermission
+	}
+	if !ff.fifo.hasReaders() {
+		return 0, syscall.EPIPE
+	}
+	n, err := ff.fifo.buffer().Write(p)
+	if errors.Is(err, io.ErrClosedPipe) {
+		err = syscall.EPIPE
+	}
+	return n, err
+}
+
+func (ff *fifoFile) WriteAt(p []byte, off int64) (int, error) {
+	return ff.Write(p)
+}
+
+func (ff *fifoFile) Stat() (fs.FileInfo, error) {
+	return ff.info, nil
+}
+
+func (ff *fifoFile) Close() error {
+	ff.once.Do(func() {
+		ff.fifo.mu.Lock()
+		ff.fifo.release(ff.read, ff.write)
+		ff.fifo.mu.Unlock()
+	})
+	return nil
+}
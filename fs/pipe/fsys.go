@@ -2,11 +2,13 @@ package pipe
 
 import (
 	"context"
+	"fmt"
 	iofs "io/fs"
+	"strconv"
 
 	"tractor.dev/wanix/fs"
+	"tractor.dev/wanix/fs/bind"
 	"tractor.dev/wanix/fs/fskit"
-	"tractor.dev/wanix/fs/vfs"
 )
 
 const (
@@ -32,7 +34,13 @@ type PipeFS struct {
 // NewFS constructs a PipeFS and returns it along with both PortFiles for
 // direct access to each end of the pipe.
 func NewFS(block bool) (iofs.FS, *PortFile, *PortFile) {
-	p1, p2 := New(block)
+	return NewFSWithOptions(Options{Block: block})
+}
+
+// NewFSWithOptions is NewFS with both directions of the pipe configured
+// by opts.
+func NewFSWithOptions(opts Options) (iofs.FS, *PortFile, *PortFile) {
+	p1, p2 := NewWithOptions(opts)
 	pf1 := &PortFile{Port: p1, Name: DataFile}
 	pf2 := &PortFile{Port: p2, Name: Data1File}
 	return &PipeFS{pf1: pf1, pf2: pf2}, pf1, pf2
@@ -67,7 +75,12 @@ func (p *PipeFS) OpenContext(ctx context.Context, name string) (iofs.File, error
 	}
 }
 
-// Allocator allows binding a fresh PipeFS per bind operation.
+// Allocator allows binding a fresh PipeFS per bind operation. Bind
+// options configure the pipe:
+//
+//	cap=<bytes>  capacity in each direction; writers block when it's full
+//	msg          preserve write boundaries (message mode)
+//	nonblock     return EAGAIN instead of blocking
 type Allocator struct {
 	Buffered bool
 }
@@ -80,9 +93,34 @@ func (a *Allocator) OpenContext(ctx context.Context, name string) (iofs.File, er
 	return fskit.RawNode(name, 0644).OpenContext(ctx, name)
 }
 
-func (a *Allocator) BindAllocFS(name string) (iofs.FS, error) {
-	fsys, _, _ := NewFS(!a.Buffered)
-	return fsys, nil
+func (a *Allocator) BindAlloc(ctx context.Context, src, dst string, opts map[string]string) (iofs.FS, string, error) {
+	o, err := parseOptions(opts)
+	if err != nil {
+		return nil, "", err
+	}
+	if a.Buffered {
+		o.Block = false
+	}
+	fsys, _, _ := NewFSWithOptions(o)
+	return fsys, ".", nil
 }
 
-var _ vfs.BindAllocator = (*Allocator)(nil)
+var _ bind.Allocator = (*Allocator)(nil)
+
+func parseOptions(opts map[string]string) (Options, error) {
+	o := Options{Block: true}
+	if v, ok := opts["cap"]; ok {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return o, fmt.Errorf("pipe: bad cap %q: %w", v, fs.ErrInvalid)
+		}
+		o.Capacity = n
+	}
+	if _, ok := opts["msg"]; ok {
+		o.Message = true
+	}
+	if _, ok := opts["nonblock"]; ok {
+		o.Block = false
+	}
+	return o, nil
+}
@@ -1,9 +1,20 @@
 package pipe
 
+import (
+	"context"
+	"time"
+)
+
 // New creates a synchronous, in-memory, full duplex network connection.
 func New(block bool) (*Port, *Port) {
-	p1 := NewBuffer(block)
-	p2 := NewBuffer(block)
+	return NewWithOptions(Options{Block: block})
+}
+
+// NewWithOptions creates a full duplex connection whose buffers in each
+// direction are configured by opts.
+func NewWithOptions(opts Options) (*Port, *Port) {
+	p1 := NewBufferWithOptions(opts)
+	p2 := NewBufferWithOptions(opts)
 
 	c1 := &Port{
 		reader: p1,
@@ -30,6 +41,30 @@ func (p *Port) Write(b []byte) (n int, err error) {
 	return p.writer.Write(b)
 }
 
+// ReadContext reads, giving up when ctx is done.
+func (p *Port) ReadContext(ctx context.Context, b []byte) (n int, err error) {
+	return p.reader.ReadContext(ctx, b)
+}
+
+// WriteContext writes, giving up when ctx is done.
+func (p *Port) WriteContext(ctx context.Context, b []byte) (n int, err error) {
+	return p.writer.WriteContext(ctx, b)
+}
+
+// SetDeadline sets the read and write deadlines.
+func (p *Port) SetDeadline(t time.Time) error {
+	p.reader.SetReadDeadline(t)
+	return p.writer.SetWriteDeadline(t)
+}
+
+func (p *Port) SetReadDeadline(t time.Time) error {
+	return p.reader.SetReadDeadline(t)
+}
+
+func (p *Port) SetWriteDeadline(t time.Time) error {
+	return p.writer.SetWriteDeadline(t)
+}
+
 func (p *Port) Close() error {
 	err1 := p.reader.Close()
 	err2 := p.writer.Close()
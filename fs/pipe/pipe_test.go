@@ -2,8 +2,12 @@ package pipe
 
 import (
 	"context"
+	"errors"
 	"io"
+	"os"
+	"syscall"
 	"testing"
+	"time"
 
 	"tractor.dev/wanix/fs"
 )
@@ -311,3 +315,114 @@ func TestPortFileSize(t *testing.T) {
 		}
 	})
 }
+
+func TestCapacity(t *testing.T) {
+	t.Run("writers block until there is space", func(t *testing.T) {
+		b := NewBufferWithOptions(Options{Block: true, Capacity: 4})
+		done := make(chan int)
+		go func() {
+			n, _ := b.Write([]byte("abcdefgh"))
+			done <- n
+		}()
+		buf := make([]byte, 8)
+		var got []byte
+		for len(got) < 8 {
+			if size := b.Size(); size > 4 {
+				t.Fatalf("buffer holds %d bytes, capacity 4", size)
+			}
+			n, err := b.Read(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, buf[:n]...)
+		}
+		if n := <-done; n != 8 || string(got) != "abcdefgh" {
+			t.Fatalf("wrote %d, read %q", n, got)
+		}
+	})
+
+	t.Run("non-blocking writes return EAGAIN", func(t *testing.T) {
+		b := NewBufferWithOptions(Options{Capacity: 4})
+		n, err := b.Write([]byte("abcdef"))
+		if n != 4 || !errors.Is(err, ErrWouldBlock) {
+			t.Fatalf("Write: %d, %v; want 4, EAGAIN", n, err)
+		}
+		if _, err := b.Write([]byte("x")); !errors.Is(err, syscall.EAGAIN) {
+			t.Fatalf("Write to full buffer: %v", err)
+		}
+	})
+}
+
+func TestMessageMode(t *testing.T) {
+	p1, p2 := NewWithOptions(Options{Block: true, Message: true})
+	p1.Write([]byte("one"))
+	p1.Write([]byte("three"))
+	buf := make([]byte, 64)
+	for _, want := range []string{"one", "thr", "ee"} {
+		size := len(buf)
+		if want == "thr" {
+			size = 3
+		}
+		n, err := p2.Read(buf[:size])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(buf[:n]) != want {
+			t.Fatalf("Read %q, want %q", buf[:n], want)
+		}
+	}
+}
+
+func TestDeadlines(t *testing.T) {
+	t.Run("read deadline", func(t *testing.T) {
+		p1, _ := New(true)
+		p1.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+		if _, err := p1.Read(make([]byte, 1)); !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Fatalf("Read: %v, want deadline exceeded", err)
+		}
+	})
+
+	t.Run("write deadline set while blocked", func(t *testing.T) {
+		p1, _ := NewWithOptions(Options{Block: true, Capacity: 1})
+		errc := make(chan error)
+		go func() {
+			_, err := p1.Write([]byte("ab"))
+			errc <- err
+		}()
+		time.Sleep(10 * time.Millisecond)
+		p1.SetWriteDeadline(time.Now())
+		if err := <-errc; !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Fatalf("Write: %v, want deadline exceeded", err)
+		}
+	})
+
+	t.Run("context", func(t *testing.T) {
+		p1, _ := New(true)
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(10*time.Millisecond, cancel)
+		if _, err := p1.ReadContext(ctx, make([]byte, 1)); !errors.Is(err, context.Canceled) {
+			t.Fatalf("ReadContext: %v, want canceled", err)
+		}
+	})
+}
+
+func TestAllocatorOptions(t *testing.T) {
+	a := &Allocator{}
+	if _, _, err := a.BindAlloc(context.Background(), ".", "p", map[string]string{"cap": "x"}); !errors.Is(err, fs.ErrInvalid) {
+		t.Fatalf("bad cap: %v", err)
+	}
+	fsys, _, err := a.BindAlloc(context.Background(), ".", "p", map[string]string{"cap": "2", "msg": "", "nonblock": ""})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := fsys.Open(DataFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.(io.Writer).Write([]byte("ab")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.(io.Writer).Write([]byte("c")); !errors.Is(err, ErrWouldBlock) {
+		t.Fatalf("Write past cap: %v", err)
+	}
+}
@@ -1,6 +1,8 @@
 package pipe
 
 import (
+	"time"
+
 	"tractor.dev/wanix/fs"
 	"tractor.dev/wanix/fs/fskit"
 )
@@ -14,6 +16,7 @@ import (
 // - WriteAt ignores offset and defers to Write
 // - Close is a no-op; callers must close the underlying Port explicitly
 // - Stat returns a FileInfo identifying this as a named pipe
+// - Deadlines are set on the underlying Port
 //
 // Note: fs.File does not require Write, ReadAt, or WriteAt, but providing them
 // keeps this consistent with other stream wrappers in this repo.
@@ -43,3 +46,9 @@ func (pf *PortFile) ReadAt(b []byte, off int64) (int, error) {
 func (pf *PortFile) Write(b []byte) (int, error) { return pf.Port.Write(b) }
 
 func (pf *PortFile) WriteAt(b []byte, off int64) (int, error) { return pf.Write(b) }
+
+func (pf *PortFile) SetDeadline(t time.Time) error { return pf.Port.SetDeadline(t) }
+
+func (pf *PortFile) SetReadDeadline(t time.Time) error { return pf.Port.SetReadDeadline(t) }
+
+func (pf *PortFile) SetWriteDeadline(t time.Time) error { return pf.Port.SetWriteDeadline(t) }
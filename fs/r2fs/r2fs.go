@@ -15,8 +15,6 @@ import (
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 )
 
@@ -32,7 +30,8 @@ type headCacheEntry struct {
 	expiresAt time.Time
 }
 
-// FS implements an R2-backed filesystem following the R2 design specification
+// FS implements a filesystem on R2 or any other S3-compatible object store,
+// following the R2 design specification
 type FS struct {
 	client     *s3.Client
 	bucketName string
@@ -40,6 +39,7 @@ type FS struct {
 	headCache  map[string]*headCacheEntry
 	cacheTTL   time.Duration
 	cacheMu    sync.RWMutex
+	partSize   int64
 }
 
 // New creates a new R2 filesystem with the given credentials and bucket
@@ -49,18 +49,9 @@ func New(accountID, accessKeyID, accessKeySecret, bucketName string) (*FS, error
 
 // NewWithBasePath creates a new R2 filesystem with the given credentials, bucket, and base path
 func NewWithBasePath(accountID, accessKeyID, accessKeySecret, bucketName, basePath string) (*FS, error) {
-	client, err := setupR2Client(accountID, accessKeyID, accessKeySecret)
-	if err != nil {
-		return nil, err
-	}
-
-	return &FS{
-		client:     client,
-		bucketName: bucketName,
-		basePath:   basePath,
-		headCache:  make(map[string]*headCacheEntry),
-		cacheTTL:   500 * time.Millisecond,
-	}, nil
+	cfg := R2Config(accountID, accessKeyID, accessKeySecret, bucketName)
+	cfg.BasePath = basePath
+	return NewS3(context.TODO(), cfg)
 }
 
 // NewWithClient creates a new R2 filesystem with a pre-configured S3 client and bucket
@@ -79,37 +70,6 @@ func NewWithClientAndBasePath(client *s3.Client, bucketName, basePath string) *F
 	}
 }
 
-// setupR2Client creates an S3 client configured for Cloudflare R2
-func setupR2Client(accountID, accessKeyID, accessKeySecret string) (*s3.Client, error) {
-	// R2 endpoint URL
-	r2Endpoint := fmt.Sprintf("https://%s.r2.cloudflarestorage.com", accountID)
-
-	// Create custom endpoint resolver
-	customResolver := aws.EndpointResolverWithOptionsFunc(
-		func(service, region string, options ...interface{}) (aws.Endpoint, error) {
-			return aws.Endpoint{
-				URL:           r2Endpoint,
-				SigningRegion: "auto",
-			}, nil
-		})
-
-	// Load the configuration with R2-specific settings
-	cfg, err := config.LoadDefaultConfig(context.TODO(),
-		config.WithEndpointResolverWithOptions(customResolver),
-		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(accessKeyID, accessKeySecret, "")),
-		config.WithRegion("auto"),
-	)
-
-	if err != nil {
-		return nil, fmt.Errorf("unable to load SDK config: %w", err)
-	}
-
-	// Create S3 client with R2 configuration
-	client := s3.NewFromConfig(cfg)
-
-	return client, nil
-}
-
 // r2File represents an R2-backed file
 type r2File struct {
 	fs       *FS
@@ -123,6 +83,13 @@ type r2File struct {
 	closed   bool
 	entries  []fs.DirEntry
 	dirPos   int
+
+	// Regular files opened for reading stream their content from the
+	// object until written to, and created files are uploaded as written.
+	remote  bool
+	body    io.ReadCloser
+	bodyOff int64
+	upload  *upload
 }
 
 // r2FileInfo holds file metadata
@@ -471,6 +438,17 @@ func getMetadataValue(metadata map[string]string, key string) string {
 	return ""
 }
 
+// setMetadataValue sets key in metadata, replacing it under any casing
+// since S3 returns metadata keys lowercased
+func setMetadataValue(metadata map[string]string, key, value string) {
+	for k := range metadata {
+		if strings.EqualFold(k, key) {
+			delete(metadata, k)
+		}
+	}
+	metadata[key] = value
+}
+
 // parseFileInfo extracts file information from R2 metadata
 func (fsys *FS) parseFileInfo(path string, metadata map[string]string, size int64, contentType string) *r2FileInfo {
 	name := filepath.Base(path)
@@ -551,6 +529,17 @@ func (fsys *FS) OpenContext(ctx context.Context, name string) (fs.File, error) {
 		}
 		return nil, err
 	}
+	fileInfo := fsys.parseFileInfo(name, resp.Metadata, *resp.ContentLength, aws.ToString(resp.ContentType))
+	if !fileInfo.isDir {
+		// read the content as it is asked for rather than all up front
+		return &r2File{
+			fs:       fsys,
+			path:     name,
+			fileInfo: fileInfo,
+			remote:   true,
+			body:     resp.Body,
+		}, nil
+	}
 	defer resp.Body.Close()
 
 	content, err := io.ReadAll(resp.Body)
@@ -558,8 +547,6 @@ func (fsys *FS) OpenContext(ctx context.Context, name string) (fs.File, error) {
 		return nil, err
 	}
 
-	fileInfo := fsys.parseFileInfo(name, resp.Metadata, *resp.ContentLength, aws.ToString(resp.ContentType))
-
 	return &r2File{
 		fs:       fsys,
 		path:     name,
@@ -660,7 +647,7 @@ func (fsys *FS) createFile(ctx context.Context, name string, content []byte, mod
 		path:     name,
 		isDir:    false,
 		fileInfo: fileInfo,
-		content:  fileContent,
+		upload:   &upload{buf: fileContent},
 		pos:      0,
 		closed:   false,
 	}, nil
@@ -674,6 +661,13 @@ func (f *r2File) Read(p []byte) (int, error) {
 		return 0, fs.ErrClosed
 	}
 
+	if f.upload != nil {
+		return f.readUpload(p)
+	}
+	if f.remote {
+		return f.readRemote(p)
+	}
+
 	if f.pos >= int64(len(f.content)) {
 		return 0, io.EOF
 	}
@@ -689,6 +683,24 @@ func (f *r2File) Write(p []byte) (int, error) {
 		return 0, fs.ErrClosed
 	}
 
+	if f.upload != nil {
+		n, err := f.writeUpload(p)
+		if err != nil {
+			return 0, err
+		}
+		f.pos += int64(n)
+		f.isDirty = true
+		f.fileInfo.size = f.upload.size()
+		f.fileInfo.modTime = time.Now()
+		f.updated = time.Now()
+		return n, nil
+	}
+
+	// Modifying a file opened for reading needs all of it
+	if err := f.load(); err != nil {
+		return 0, err
+	}
+
 	// Extend content if necessary
 	newLen := f.pos + int64(len(p))
 	if newLen > int64(len(f.content)) {
@@ -722,7 +734,7 @@ func (f *r2File) Seek(offset int64, whence int) (int64, error) {
 	case io.SeekCurrent:
 		newPos = f.pos + offset
 	case io.SeekEnd:
-		newPos = int64(len(f.content)) + offset
+		newPos = f.size() + offset
 	default:
 		return 0, fmt.Errorf("invalid whence")
 	}
@@ -742,6 +754,7 @@ func (f *r2File) Close() error {
 	}
 
 	f.closed = true
+	f.closeBody()
 
 	// Save the file content back to R2 if dirty
 	if f.isDirty {
@@ -750,18 +763,30 @@ func (f *r2File) Close() error {
 	return nil
 }
 
+// size returns the current size of the file's content.
+func (f *r2File) size() int64 {
+	switch {
+	case f.upload != nil:
+		return f.upload.size()
+	case f.remote:
+		return f.fileInfo.size
+	}
+	return int64(len(f.content))
+}
+
 // save writes the file content back to R2
 func (f *r2File) save() error {
-	objectKey := f.fs.normalizeR2Path(f.path)
-
-	// Prepare metadata
-	metadata := map[string]string{
-		"Content-Mode":      formatFileMode(f.fileInfo.mode),
-		"Content-Modified":  strconv.FormatInt(f.fileInfo.modTime.Unix(), 10),
-		"Content-Ownership": "0:0",
-		"Change-Timestamp":  strconv.FormatInt(f.updated.UnixMicro(), 10),
+	if f.upload != nil {
+		if err := f.finishUpload(); err != nil {
+			return err
+		}
+		f.fs.invalidateCachedHead(f.path)
+		return nil
 	}
 
+	objectKey := f.fs.normalizeR2Path(f.path)
+	metadata := f.metadata()
+
 	var contentType string
 	if f.isDir {
 		contentType = "application/x-directory"
@@ -1043,54 +1068,9 @@ func (fsys *FS) Chmod(name string, mode fs.FileMode) error {
 
 // chmod changes file mode with context
 func (fsys *FS) chmod(ctx context.Context, name string, mode fs.FileMode) error {
-	objectKey := fsys.normalizeR2Path(name)
-
-	// First, get the current object to preserve other metadata
-	getInput := &s3.GetObjectInput{
-		Bucket: aws.String(fsys.bucketName),
-		Key:    aws.String(objectKey),
-	}
-
-	resp, err := fsys.client.GetObject(ctx, getInput)
-	if err != nil {
-		if strings.Contains(err.Error(), "NotFound") || strings.Contains(err.Error(), "NoSuchKey") {
-			return fs.ErrNotExist
-		}
-		return err
-	}
-	defer resp.Body.Close()
-
-	content, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return err
-	}
-
-	// Update metadata with new mode
-	metadata := resp.Metadata
-	if metadata == nil {
-		metadata = make(map[string]string)
-	}
-	metadata["Content-Mode"] = formatFileMode(mode)
-	metadata["Change-Timestamp"] = strconv.FormatInt(time.Now().UnixMicro(), 10)
-
-	// Put the object back with updated metadata
-	putInput := &s3.PutObjectInput{
-		Bucket:      aws.String(fsys.bucketName),
-		Key:         aws.String(objectKey),
-		Body:        bytes.NewReader(content),
-		Metadata:    metadata,
-		ContentType: resp.ContentType,
-	}
-
-	_, err = fsys.client.PutObject(ctx, putInput)
-	if err != nil {
-		return err
-	}
-
-	// Invalidate cache for this path since we changed the mode
-	fsys.invalidateCachedHead(name)
-
-	return nil
+	return fsys.updateMetadata(ctx, name, func(metadata map[string]string) {
+		setMetadataValue(metadata, "Content-Mode", formatFileMode(mode))
+	})
 }
 
 // Chown changes the numeric uid and gid of the named file
@@ -1100,55 +1080,9 @@ func (fsys *FS) Chown(name string, uid, gid int) error {
 
 // chown changes ownership with context
 func (fsys *FS) chown(ctx context.Context, name string, uid, gid int) error {
-	objectKey := fsys.normalizeR2Path(name)
-
-	// First, get the current object to preserve other metadata
-	getInput := &s3.GetObjectInput{
-		Bucket: aws.String(fsys.bucketName),
-		Key:    aws.String(objectKey),
-	}
-
-	resp, err := fsys.client.GetObject(ctx, getInput)
-	if err != nil {
-		if strings.Contains(err.Error(), "NotFound") || strings.Contains(err.Error(), "NoSuchKey") {
-			return fs.ErrNotExist
-		}
-		return err
-	}
-	defer resp.Body.Close()
-
-	content, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return err
-	}
-
-	// Update metadata with new ownership
-	metadata := resp.Metadata
-	if metadata == nil {
-		metadata = make(map[string]string)
-	}
-	ownership := fmt.Sprintf("%d:%d", uid, gid)
-	metadata["Content-Ownership"] = ownership
-	metadata["Change-Timestamp"] = strconv.FormatInt(time.Now().UnixMicro(), 10)
-
-	// Put the object back with updated metadata
-	putInput := &s3.PutObjectInput{
-		Bucket:      aws.String(fsys.bucketName),
-		Key:         aws.String(objectKey),
-		Body:        bytes.NewReader(content),
-		Metadata:    metadata,
-		ContentType: resp.ContentType,
-	}
-
-	_, err = fsys.client.PutObject(ctx, putInput)
-	if err != nil {
-		return err
-	}
-
-	// Invalidate cache for this path since we changed the ownership
-	fsys.invalidateCachedHead(name)
-
-	return nil
+	return fsys.updateMetadata(ctx, name, func(metadata map[string]string) {
+		setMetadataValue(metadata, "Content-Ownership", fmt.Sprintf("%d:%d", uid, gid))
+	})
 }
 
 // Chtimes changes the access and modification times of the named file
@@ -1158,54 +1092,10 @@ func (fsys *FS) Chtimes(name string, atime time.Time, mtime time.Time) error {
 
 // chtimes changes times with context
 func (fsys *FS) chtimes(ctx context.Context, name string, atime time.Time, mtime time.Time) error {
-	objectKey := fsys.normalizeR2Path(name)
-
-	// First, get the current object to preserve other metadata
-	getInput := &s3.GetObjectInput{
-		Bucket: aws.String(fsys.bucketName),
-		Key:    aws.String(objectKey),
-	}
-
-	resp, err := fsys.client.GetObject(ctx, getInput)
-	if err != nil {
-		if strings.Contains(err.Error(), "NotFound") || strings.Contains(err.Error(), "NoSuchKey") {
-			return fs.ErrNotExist
-		}
-		return err
-	}
-	defer resp.Body.Close()
-
-	content, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return err
-	}
-
-	// Update metadata with new modification time (R2 filesystem design only supports mtime)
-	metadata := resp.Metadata
-	if metadata == nil {
-		metadata = make(map[string]string)
-	}
-	metadata["Content-Modified"] = strconv.FormatInt(mtime.Unix(), 10)
-	metadata["Change-Timestamp"] = strconv.FormatInt(time.Now().UnixMicro(), 10)
-
-	// Put the object back with updated metadata
-	putInput := &s3.PutObjectInput{
-		Bucket:      aws.String(fsys.bucketName),
-		Key:         aws.String(objectKey),
-		Body:        bytes.NewReader(content),
-		Metadata:    metadata,
-		ContentType: resp.ContentType,
-	}
-
-	_, err = fsys.client.PutObject(ctx, putInput)
-	if err != nil {
-		return err
-	}
-
-	// Invalidate cache for this path since we changed the modification time
-	fsys.invalidateCachedHead(name)
-
-	return nil
+	// R2 filesystem design only supports mtime
+	return fsys.updateMetadata(ctx, name, func(metadata map[string]string) {
+		setMetadataValue(metadata, "Content-Modified", strconv.FormatInt(mtime.Unix(), 10))
+	})
 }
 
 // Symlink creates a symbolic link
@@ -1303,32 +1193,15 @@ func (fsys *FS) rename(ctx context.Context, oldname, newname string) error {
 	oldObjectKey := fsys.normalizeR2Path(oldname)
 	newObjectKey := fsys.normalizeR2Path(newname)
 
-	// First, get the current object
-	getInput := &s3.GetObjectInput{
-		Bucket: aws.String(fsys.bucketName),
-		Key:    aws.String(oldObjectKey),
-	}
-
-	resp, err := fsys.client.GetObject(ctx, getInput)
-	if err != nil {
-		if strings.Contains(err.Error(), "NotFound") || strings.Contains(err.Error(), "NoSuchKey") {
-			return fs.ErrNotExist
-		}
-		return err
-	}
-	defer resp.Body.Close()
-
-	content, err := io.ReadAll(resp.Body)
+	// Look up the current object without fetching its content
+	head, err := fsys.headObject(ctx, oldObjectKey)
 	if err != nil {
 		return err
 	}
 
 	// Update metadata with new change timestamp
-	metadata := resp.Metadata
-	if metadata == nil {
-		metadata = make(map[string]string)
-	}
-	metadata["Change-Timestamp"] = strconv.FormatInt(time.Now().UnixMicro(), 10)
+	metadata := head.Metadata
+	setMetadataValue(metadata, "Change-Timestamp", strconv.FormatInt(time.Now().UnixMicro(), 10))
 
 	// Get the file mode for directory listing updates
 	fileMode := getMetadataValue(metadata, "Content-Mode")
@@ -1342,25 +1215,20 @@ func (fsys *FS) rename(ctx context.Context, oldname, newname string) error {
 		return err
 	}
 
-	// Create the object at the new location
-	putInput := &s3.PutObjectInput{
-		Bucket:      aws.String(fsys.bucketName),
-		Key:         aws.String(newObjectKey),
-		Body:        bytes.NewReader(content),
-		Metadata:    metadata,
-		ContentType: resp.ContentType,
-	}
-
-	_, err = fsys.client.PutObject(ctx, putInput)
+	// Copy the object to the new location on the server
+	err = fsys.copyObject(ctx, oldObjectKey, newObjectKey, aws.ToInt64(head.ContentLength), metadata, head.ContentType)
 	if err != nil {
-		// If put fails, try to clean up the destination directory listing
+		// If copy fails, try to clean up the destination directory listing
 		fsys.updateParentDirectoryListing(ctx, newname, "", true)
 		return err
 	}
 
 	// If the source is a directory, recursively move its contents
-	if resp.ContentType != nil && *resp.ContentType == "application/x-directory" {
-		entries := parseDirectoryEntries(string(content))
+	if aws.ToString(head.ContentType) == "application/x-directory" {
+		entries, err := fsys.readListing(ctx, newObjectKey)
+		if err != nil {
+			return err
+		}
 		for entryName := range entries {
 			// Compose child source and destination paths (fs interface format)
 			var childSrc, childDest string
@@ -1482,28 +1350,14 @@ func (fsys *FS) copy(ctx context.Context, oldname, newname string, overwrite boo
 		}
 	}
 
-	// Get source object
-	getInput := &s3.GetObjectInput{
-		Bucket: aws.String(fsys.bucketName),
-		Key:    aws.String(oldObjectKey),
-	}
-
-	resp, err := fsys.client.GetObject(ctx, getInput)
-	if err != nil {
-		if strings.Contains(err.Error(), "NotFound") || strings.Contains(err.Error(), "NoSuchKey") {
-			return fs.ErrNotExist
-		}
-		return err
-	}
-	defer resp.Body.Close()
-
-	content, err := io.ReadAll(resp.Body)
+	// Look up the source object without fetching its content
+	head, err := fsys.headObject(ctx, oldObjectKey)
 	if err != nil {
 		return err
 	}
 
 	// Get the file mode for directory listing updates
-	fileMode := getMetadataValue(resp.Metadata, "Content-Mode")
+	fileMode := getMetadataValue(head.Metadata, "Content-Mode")
 	if fileMode == "" {
 		fileMode = "33188" // Default file mode
 	}
@@ -1514,25 +1368,20 @@ func (fsys *FS) copy(ctx context.Context, oldname, newname string, overwrite boo
 		return err
 	}
 
-	// Create the object at the new location
-	putInput := &s3.PutObjectInput{
-		Bucket:      aws.String(fsys.bucketName),
-		Key:         aws.String(newObjectKey),
-		Body:        bytes.NewReader(content),
-		Metadata:    resp.Metadata,
-		ContentType: resp.ContentType,
-	}
-
-	_, err = fsys.client.PutObject(ctx, putInput)
+	// Copy the object to the new location on the server
+	err = fsys.copyObject(ctx, oldObjectKey, newObjectKey, aws.ToInt64(head.ContentLength), head.Metadata, head.ContentType)
 	if err != nil {
-		// If put fails, try to clean up the destination directory listing
+		// If copy fails, try to clean up the destination directory listing
 		fsys.updateParentDirectoryListing(ctx, newname, "", true)
 		return err
 	}
 
 	// If the source is a directory, recursively copy its contents
-	if resp.ContentType != nil && *resp.ContentType == "application/x-directory" {
-		entries := parseDirectoryEntries(string(content))
+	if aws.ToString(head.ContentType) == "application/x-directory" {
+		entries, err := fsys.readListing(ctx, newObjectKey)
+		if err != nil {
+			return err
+		}
 		for entryName := range entries {
 			// Compose child source and destination paths (fs interface format)
 			var childSrc, childDest string
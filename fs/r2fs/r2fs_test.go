@@ -1,8 +1,23 @@
 package r2fs
 
 import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 )
 
@@ -145,3 +160,482 @@ func TestParseFileInfoDirectory(t *testing.T) {
 }
 
 // Removed TestRootDirectoryMode - it was causing panics with mock client
+
+// s3Stub is an in-process stand-in for the parts of the S3 API used by FS.
+type s3Stub struct {
+	bucket string
+
+	mu      sync.Mutex
+	objects map[string]*s3Object
+	uploads map[string]*s3Upload
+	nextID  int
+	ops     []string // operation and key of each request
+}
+
+type s3Object struct {
+	data        []byte
+	meta        map[string]string
+	contentType string
+	etag        string
+}
+
+type s3Upload struct {
+	key         string
+	meta        map[string]string
+	contentType string
+	parts       map[int][]byte
+}
+
+func newS3Stub(bucket string) *s3Stub {
+	return &s3Stub{
+		bucket: bucket,
+		objects: map[string]*s3Object{
+			"/": {meta: map[string]string{"Content-Mode": "16877"}, contentType: "application/x-directory", etag: `"root"`},
+		},
+		uploads: make(map[string]*s3Upload),
+	}
+}
+
+func newTestFS(t *testing.T) (*FS, *s3Stub) {
+	stub := newS3Stub("test-bucket")
+	server := httptest.NewServer(stub)
+	t.Cleanup(server.Close)
+	client := s3.New(s3.Options{
+		BaseEndpoint:               aws.String(server.URL),
+		UsePathStyle:               true,
+		Region:                     "auto",
+		Credentials:                credentials.NewStaticCredentialsProvider("key", "secret", ""),
+		RequestChecksumCalculation: aws.RequestChecksumCalculationWhenRequired,
+		ResponseChecksumValidation: aws.ResponseChecksumValidationWhenRequired,
+	})
+	return NewWithClient(client, "test-bucket"), stub
+}
+
+// count returns how many requests were made for op, and key if given.
+func (s *s3Stub) count(op, key string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, o := range s.ops {
+		if o == op+" "+key || (key == "" && strings.HasPrefix(o, op+" ")) {
+			n++
+		}
+	}
+	return n
+}
+
+func (s *s3Stub) object(key string) *s3Object {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.objects[key]
+}
+
+func (s *s3Stub) newETag(data []byte) string {
+	s.nextID++
+	return fmt.Sprintf(`"%d-%d"`, s.nextID, len(data))
+}
+
+func s3Error(w http.ResponseWriter, status int, code string) {
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, code)
+}
+
+func requestMeta(r *http.Request) map[string]string {
+	meta := make(map[string]string)
+	for k, v := range r.Header {
+		if name, ok := strings.CutPrefix(strings.ToLower(k), "x-amz-meta-"); ok {
+			meta[name] = v[0]
+		}
+	}
+	return meta
+}
+
+func (s *s3Stub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := strings.TrimPrefix(r.URL.Path, "/"+s.bucket+"/")
+	q := r.URL.Query()
+	body, _ := io.ReadAll(r.Body)
+
+	op := r.Method
+	switch {
+	case r.Method == "POST" && q.Has("uploads"):
+		op = "CreateMultipartUpload"
+	case r.Method == "PUT" && q.Has("uploadId") && r.Header.Get("X-Amz-Copy-Source") != "":
+		op = "UploadPartCopy"
+	case r.Method == "PUT" && q.Has("uploadId"):
+		op = "UploadPart"
+	case r.Method == "POST" && q.Has("uploadId"):
+		op = "CompleteMultipartUpload"
+	case r.Method == "DELETE" && q.Has("uploadId"):
+		op = "AbortMultipartUpload"
+	case r.Method == "PUT" && r.Header.Get("X-Amz-Copy-Source") != "":
+		op = "CopyObject"
+	}
+	s.ops = append(s.ops, op+" "+key)
+
+	source := func() *s3Object {
+		src, _ := url.PathUnescape(r.Header.Get("X-Amz-Copy-Source"))
+		src = strings.TrimPrefix(strings.TrimPrefix(src, "/"), s.bucket+"/")
+		return s.objects[src]
+	}
+
+	switch op {
+	case "CreateMultipartUpload":
+		s.nextID++
+		id := strconv.Itoa(s.nextID)
+		s.uploads[id] = &s3Upload{key: key, meta: requestMeta(r), contentType: r.Header.Get("Content-Type"), parts: make(map[int][]byte)}
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><Bucket>%s</Bucket><Key>%s</Key><UploadId>%s</UploadId></InitiateMultipartUploadResult>", s.bucket, key, id)
+
+	case "UploadPart", "UploadPartCopy":
+		u := s.uploads[q.Get("uploadId")]
+		if u == nil {
+			s3Error(w, http.StatusNotFound, "NoSuchUpload")
+			return
+		}
+		num, _ := strconv.Atoi(q.Get("partNumber"))
+		data := body
+		if op == "UploadPartCopy" {
+			src := source()
+			if src == nil {
+				s3Error(w, http.StatusNotFound, "NoSuchKey")
+				return
+			}
+			var start, end int
+			fmt.Sscanf(r.Header.Get("X-Amz-Copy-Source-Range"), "bytes=%d-%d", &start, &end)
+			data = src.data[start : end+1]
+		}
+		u.parts[num] = bytes.Clone(data)
+		etag := fmt.Sprintf(`"part-%d"`, num)
+		if op == "UploadPartCopy" {
+			fmt.Fprintf(w, "<CopyPartResult><ETag>%s</ETag><LastModified>2025-01-01T00:00:00Z</LastModified></CopyPartResult>", etag)
+			return
+		}
+		w.Header().Set("ETag", etag)
+
+	case "CompleteMultipartUpload":
+		u := s.uploads[q.Get("uploadId")]
+		if u == nil {
+			s3Error(w, http.StatusNotFound, "NoSuchUpload")
+			return
+		}
+		var complete struct {
+			Parts []struct {
+				PartNumber int
+			} `xml:"Part"`
+		}
+		if err := xml.Unmarshal(body, &complete); err != nil {
+			s3Error(w, http.StatusBadRequest, "MalformedXML")
+			return
+		}
+		var data []byte
+		for _, p := range complete.Parts {
+			data = append(data, u.parts[p.PartNumber]...)
+		}
+		delete(s.uploads, q.Get("uploadId"))
+		obj := &s3Object{data: data, meta: u.meta, contentType: u.contentType, etag: s.newETag(data)}
+		s.objects[u.key] = obj
+		fmt.Fprintf(w, "<CompleteMultipartUploadResult><Key>%s</Key><ETag>%s</ETag></CompleteMultipartUploadResult>", key, obj.etag)
+
+	case "AbortMultipartUpload":
+		delete(s.uploads, q.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+
+	case "CopyObject":
+		src := source()
+		if src == nil {
+			s3Error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		obj := &s3Object{data: src.data, meta: src.meta, contentType: src.contentType}
+		if r.Header.Get("X-Amz-Metadata-Directive") == "REPLACE" {
+			obj.meta = requestMeta(r)
+			obj.contentType = r.Header.Get("Content-Type")
+		}
+		obj.etag = s.newETag(obj.data)
+		s.objects[key] = obj
+		fmt.Fprintf(w, "<CopyObjectResult><ETag>%s</ETag><LastModified>2025-01-01T00:00:00Z</LastModified></CopyObjectResult>", obj.etag)
+
+	case "PUT":
+		if im := r.Header.Get("If-Match"); im != "" && (s.objects[key] == nil || s.objects[key].etag != im) {
+			s3Error(w, http.StatusPreconditionFailed, "PreconditionFailed")
+			return
+		}
+		obj := &s3Object{data: body, meta: requestMeta(r), contentType: r.Header.Get("Content-Type"), etag: s.newETag(body)}
+		s.objects[key] = obj
+		w.Header().Set("ETag", obj.etag)
+
+	case "GET", "HEAD":
+		obj := s.objects[key]
+		if obj == nil {
+			s3Error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		for k, v := range obj.meta {
+			w.Header().Set("X-Amz-Meta-"+k, v)
+		}
+		w.Header().Set("Content-Type", obj.contentType)
+		w.Header().Set("ETag", obj.etag)
+		data := obj.data
+		status := http.StatusOK
+		if rng := r.Header.Get("Range"); rng != "" {
+			var start int
+			fmt.Sscanf(rng, "bytes=%d-", &start)
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, len(data)-1, len(data)))
+			data = data[start:]
+			status = http.StatusPartialContent
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.WriteHeader(status)
+		if r.Method == "GET" {
+			w.Write(data)
+		}
+
+	case "DELETE":
+		delete(s.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func writeTestFile(t *testing.T, fsys *FS, name string, data []byte, chunk int) {
+	t.Helper()
+	f, err := fsys.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := f.(io.Writer)
+	for off := 0; off < len(data); off += chunk {
+		if _, err := w.Write(data[off:min(off+chunk, len(data))]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMultipartUpload(t *testing.T) {
+	fsys, stub := newTestFS(t)
+	fsys.SetPartSize(4)
+
+	writeTestFile(t, fsys, "small.txt", []byte("abc"), 2)
+	if n := stub.count("CreateMultipartUpload", ""); n != 0 {
+		t.Fatalf("small file started %d multipart uploads", n)
+	}
+	if got := string(stub.object("/small.txt").data); got != "abc" {
+		t.Fatalf("small.txt = %q", got)
+	}
+
+	writeTestFile(t, fsys, "big.bin", []byte("0123456789"), 3)
+	if n := stub.count("UploadPart", "/big.bin"); n != 3 {
+		t.Fatalf("uploaded %d parts, want 3", n)
+	}
+	obj := stub.object("/big.bin")
+	if string(obj.data) != "0123456789" {
+		t.Fatalf("big.bin = %q", obj.data)
+	}
+	if getMetadataValue(obj.meta, "Content-Mode") == "" {
+		t.Fatalf("multipart upload lost metadata: %v", obj.meta)
+	}
+
+	data, err := fs.ReadFile(fsys, "big.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "0123456789" {
+		t.Fatalf("read back %q", data)
+	}
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	sort.Strings(names)
+	if strings.Join(names, ",") != "big.bin,small.txt" {
+		t.Fatalf("listing = %v", names)
+	}
+}
+
+func TestUploadSeekBack(t *testing.T) {
+	fsys, stub := newTestFS(t)
+	fsys.SetPartSize(4)
+
+	f, err := fsys.Create("file.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rf := f.(*r2File)
+	rf.Write([]byte("abcdef"))
+	// still in memory, so it can be rewritten
+	rf.Seek(5, io.SeekStart)
+	if _, err := rf.Write([]byte("F")); err != nil {
+		t.Fatal(err)
+	}
+	// already uploaded
+	rf.Seek(0, io.SeekStart)
+	if _, err := rf.Write([]byte("A")); err == nil {
+		t.Fatal("expected error writing to uploaded part")
+	}
+	if err := rf.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(stub.object("/file.bin").data); got != "abcdeF" {
+		t.Fatalf("file.bin = %q", got)
+	}
+}
+
+func TestStreamingRead(t *testing.T) {
+	fsys, stub := newTestFS(t)
+	writeTestFile(t, fsys, "file.txt", []byte("hello world"), 64)
+
+	gets := stub.count("GET", "/file.txt")
+	f, err := fsys.Open("file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	buf := make([]byte, 3)
+	var got []byte
+	for {
+		n, err := f.Read(buf)
+		got = append(got, buf[:n]...)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if string(got) != "hello world" {
+		t.Fatalf("read %q", got)
+	}
+	if n := stub.count("GET", "/file.txt") - gets; n != 1 {
+		t.Fatalf("sequential read made %d requests", n)
+	}
+
+	f.(io.Seeker).Seek(6, io.SeekStart)
+	n, _ := f.Read(buf)
+	if string(buf[:n]) != "wor" {
+		t.Fatalf("read after seek %q", buf[:n])
+	}
+}
+
+func TestRenameCopyObject(t *testing.T) {
+	fsys, stub := newTestFS(t)
+	writeTestFile(t, fsys, "a.txt", []byte("content"), 64)
+	if err := fsys.Mkdir("dir", 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, fsys, "dir/b.txt", []byte("nested"), 64)
+
+	gets := stub.count("GET", "/a.txt")
+	if err := fsys.Rename("a.txt", "c.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if n := stub.count("GET", "/a.txt"); n != gets {
+		t.Fatal("rename downloaded the object")
+	}
+	if n := stub.count("CopyObject", "/c.txt"); n != 1 {
+		t.Fatalf("CopyObject called %d times", n)
+	}
+	if stub.object("/a.txt") != nil {
+		t.Fatal("a.txt still exists")
+	}
+	if got := string(stub.object("/c.txt").data); got != "content" {
+		t.Fatalf("c.txt = %q", got)
+	}
+
+	if err := fsys.Rename("dir", "moved"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := fs.ReadFile(fsys, "moved/b.txt")
+	if err != nil || string(data) != "nested" {
+		t.Fatalf("moved/b.txt = %q, %v", data, err)
+	}
+
+	// objects too large for CopyObject are copied in parts
+	defer func(size, part int64) { maxCopySize, copyPartSize = size, part }(maxCopySize, copyPartSize)
+	maxCopySize, copyPartSize = 4, 3
+	if err := fsys.Copy("c.txt", "d.txt", false); err != nil {
+		t.Fatal(err)
+	}
+	if n := stub.count("UploadPartCopy", "/d.txt"); n != 3 {
+		t.Fatalf("UploadPartCopy called %d times", n)
+	}
+	if got := string(stub.object("/d.txt").data); got != "content" {
+		t.Fatalf("d.txt = %q", got)
+	}
+}
+
+func TestChmodKeepsContent(t *testing.T) {
+	fsys, stub := newTestFS(t)
+	writeTestFile(t, fsys, "a.txt", []byte("content"), 64)
+
+	if err := fsys.Chmod("a.txt", 0600); err != nil {
+		t.Fatal(err)
+	}
+	obj := stub.object("/a.txt")
+	if string(obj.data) != "content" {
+		t.Fatalf("content = %q", obj.data)
+	}
+	if got := getMetadataValue(obj.meta, "Content-Mode"); got != formatFileMode(0600) {
+		t.Fatalf("Content-Mode = %q", got)
+	}
+	if n := stub.count("CopyObject", "/a.txt"); n != 1 {
+		t.Fatalf("CopyObject called %d times", n)
+	}
+}
+
+func TestParseConfig(t *testing.T) {
+	if _, err := ParseConfig(map[string]string{"prefix": "p"}); err == nil {
+		t.Fatal("expected error without bucket")
+	}
+	if _, err := ParseConfig(map[string]string{"bucket": "b", "key": "k"}); err == nil {
+		t.Fatal("expected error for key without secret")
+	}
+	cfg, err := ParseConfig(map[string]string{
+		"bucket":    "b",
+		"prefix":    "data",
+		"endpoint":  "http://localhost:9000",
+		"pathstyle": "",
+		"anonymous": "false",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Bucket != "b" || cfg.BasePath != "data" || cfg.Endpoint != "http://localhost:9000" || !cfg.PathStyle || cfg.Anonymous {
+		t.Fatalf("unexpected config %+v", cfg)
+	}
+}
+
+func TestNewS3(t *testing.T) {
+	stub := newS3Stub("bucket")
+	server := httptest.NewServer(stub)
+	defer server.Close()
+
+	fsys, err := NewS3(context.Background(), Config{
+		Bucket:          "bucket",
+		Endpoint:        server.URL,
+		PathStyle:       true,
+		AccessKeyID:     "key",
+		SecretAccessKey: "secret",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, fsys, "hello.txt", []byte("hello"), 64)
+	data, err := fs.ReadFile(fsys, "hello.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "hello" {
+		t.Fatalf("read %q", data)
+	}
+	if got := string(stub.object("/hello.txt").data); got != "hello" {
+		t.Fatalf("stored %q", got)
+	}
+}
@@ -0,0 +1,125 @@
+package r2fs
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// Config describes a bucket on an S3-compatible object store, such as
+// AWS S3, Cloudflare R2 or MinIO.
+type Config struct {
+	Bucket   string
+	BasePath string // key prefix the filesystem is rooted at
+
+	// Endpoint is the URL of the service, or empty for AWS S3.
+	Endpoint string
+	// Region defaults to the SDK's configured region, or "auto", which R2
+	// and MinIO accept. AWS S3 needs the bucket's region.
+	Region string
+	// PathStyle addresses buckets as endpoint/bucket instead of
+	// bucket.endpoint, which MinIO and most self-hosted stores need.
+	PathStyle bool
+
+	// Credentials are static keys if AccessKeyID is set, a shared config
+	// profile if Profile is set, none if Anonymous is set, and otherwise
+	// the SDK default chain (environment, shared config, instance roles).
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	Profile         string
+	Anonymous       bool
+}
+
+// R2Config returns the Config for a Cloudflare R2 bucket.
+func R2Config(accountID, accessKeyID, accessKeySecret, bucketName string) Config {
+	return Config{
+		Bucket:          bucketName,
+		Endpoint:        fmt.Sprintf("https://%s.r2.cloudflarestorage.com", accountID),
+		Region:          "auto",
+		AccessKeyID:     accessKeyID,
+		SecretAccessKey: accessKeySecret,
+	}
+}
+
+// ParseConfig builds a Config from allocator options: bucket (required),
+// prefix, endpoint, region, pathstyle, key, secret, token, profile and
+// anonymous.
+func ParseConfig(opts map[string]string) (Config, error) {
+	cfg := Config{
+		Bucket:          opts["bucket"],
+		BasePath:        opts["prefix"],
+		Endpoint:        opts["endpoint"],
+		Region:          opts["region"],
+		AccessKeyID:     opts["key"],
+		SecretAccessKey: opts["secret"],
+		SessionToken:    opts["token"],
+		Profile:         opts["profile"],
+	}
+	if cfg.Bucket == "" {
+		return cfg, fmt.Errorf("bucket is required")
+	}
+	for name, flag := range map[string]*bool{"pathstyle": &cfg.PathStyle, "anonymous": &cfg.Anonymous} {
+		v, ok := opts[name]
+		if !ok {
+			continue
+		}
+		b, err := strconv.ParseBool(v)
+		if err != nil && v != "" {
+			return cfg, fmt.Errorf("%s: %w", name, err)
+		}
+		// a bare option counts as set
+		*flag = b || v == ""
+	}
+	if cfg.AccessKeyID != "" && cfg.SecretAccessKey == "" {
+		return cfg, fmt.Errorf("secret is required with key")
+	}
+	return cfg, nil
+}
+
+// NewS3 creates a filesystem on the bucket described by cfg.
+func NewS3(ctx context.Context, cfg Config) (*FS, error) {
+	client, err := cfg.client(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return NewWithClientAndBasePath(client, cfg.Bucket, cfg.BasePath), nil
+}
+
+// client creates an S3 client for cfg.
+func (cfg Config) client(ctx context.Context) (*s3.Client, error) {
+	var loadOpts []func(*config.LoadOptions) error
+	if cfg.Region != "" {
+		loadOpts = append(loadOpts, config.WithRegion(cfg.Region))
+	}
+	switch {
+	case cfg.AccessKeyID != "":
+		loadOpts = append(loadOpts, config.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider(cfg.AccessKeyID, cfg.SecretAccessKey, cfg.SessionToken)))
+	case cfg.Anonymous:
+		loadOpts = append(loadOpts, config.WithCredentialsProvider(aws.AnonymousCredentials{}))
+	case cfg.Profile != "":
+		loadOpts = append(loadOpts, config.WithSharedConfigProfile(cfg.Profile))
+	}
+
+	awsCfg, err := config.LoadDefaultConfig(ctx, loadOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load SDK config: %w", err)
+	}
+	if awsCfg.Region == "" {
+		awsCfg.Region = "auto"
+	}
+
+	return s3.NewFromConfig(awsCfg, func(o *s3.Options) {
+		if cfg.Endpoint != "" {
+			o.BaseEndpoint = aws.String(strings.TrimSuffix(cfg.Endpoint, "/"))
+		}
+		o.UsePathStyle = cfg.PathStyle
+	}), nil
+}
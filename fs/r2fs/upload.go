@@ -0,0 +1,380 @@
+package r2fs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// DefaultPartSize is the size of the parts files are uploaded in once they
+// outgrow a single part. A file being written holds at most about one part
+// in memory.
+const DefaultPartSize = 8 << 20
+
+// MinPartSize is the smallest part size S3 accepts for all but the last
+// part of a multipart upload.
+const MinPartSize = 5 << 20
+
+var (
+	// maxCopySize is the largest object copied with a single CopyObject
+	// request. Larger objects are copied in parts of copyPartSize.
+	maxCopySize  int64 = 5 << 30
+	copyPartSize int64 = 1 << 30
+)
+
+// errUploaded is returned when seeking back into data of a file being
+// written that has already been uploaded as a part.
+var errUploaded = errors.New("r2fs: data already uploaded")
+
+// upload holds the state of a file being streamed to an object. Content
+// that fits in one part is written with PutObject when the file is closed,
+// anything larger with a multipart upload started when the first part
+// fills up.
+type upload struct {
+	id      string // multipart upload ID, once started
+	parts   []types.CompletedPart
+	flushed int64  // bytes already uploaded as parts
+	buf     []byte // content after flushed
+}
+
+func (u *upload) size() int64 {
+	return u.flushed + int64(len(u.buf))
+}
+
+// SetPartSize sets the size of the parts files are uploaded in. S3 rejects
+// parts smaller than MinPartSize other than the last.
+func (fsys *FS) SetPartSize(size int64) {
+	fsys.partSize = size
+}
+
+// GetPartSize returns the size of the parts files are uploaded in.
+func (fsys *FS) GetPartSize() int64 {
+	if fsys.partSize <= 0 {
+		return DefaultPartSize
+	}
+	return fsys.partSize
+}
+
+// metadata returns the object metadata for the file's current state.
+func (f *r2File) metadata() map[string]string {
+	return map[string]string{
+		"Content-Mode":      formatFileMode(f.fileInfo.mode),
+		"Content-Modified":  strconv.FormatInt(f.fileInfo.modTime.Unix(), 10),
+		"Content-Ownership": "0:0",
+		"Change-Timestamp":  strconv.FormatInt(f.updated.UnixMicro(), 10),
+	}
+}
+
+// writeUpload writes p at the current position of a file being uploaded,
+// sending any full parts on their way.
+func (f *r2File) writeUpload(p []byte) (int, error) {
+	u := f.upload
+	if f.pos < u.flushed {
+		return 0, &fs.PathError{Op: "write", Path: f.path, Err: errUploaded}
+	}
+	off := f.pos - u.flushed
+	if end := off + int64(len(p)); end > int64(len(u.buf)) {
+		u.buf = append(u.buf, make([]byte, end-int64(len(u.buf)))...)
+	}
+	n := copy(u.buf[off:], p)
+
+	// keep at least a byte back so the last part is never empty
+	partSize := f.fs.GetPartSize()
+	for int64(len(u.buf)) > partSize {
+		if err := f.uploadPart(context.Background(), u.buf[:partSize]); err != nil {
+			f.abortUpload()
+			return 0, err
+		}
+		u.buf = append(u.buf[:0:0], u.buf[partSize:]...)
+	}
+	return n, nil
+}
+
+// readUpload reads from the part of a file being uploaded that is still
+// in memory.
+func (f *r2File) readUpload(p []byte) (int, error) {
+	u := f.upload
+	if f.pos < u.flushed {
+		return 0, &fs.PathError{Op: "read", Path: f.path, Err: errUploaded}
+	}
+	off := f.pos - u.flushed
+	if off >= int64(len(u.buf)) {
+		return 0, io.EOF
+	}
+	n := copy(p, u.buf[off:])
+	f.pos += int64(n)
+	return n, nil
+}
+
+// uploadPart uploads data as the next part, starting the multipart upload
+// if this is the first. The metadata of multipart uploads is set when they
+// start.
+func (f *r2File) uploadPart(ctx context.Context, data []byte) error {
+	u := f.upload
+	key := aws.String(f.fs.normalizeR2Path(f.path))
+	if u.id == "" {
+		resp, err := f.fs.client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+			Bucket:      aws.String(f.fs.bucketName),
+			Key:         key,
+			Metadata:    f.metadata(),
+			ContentType: aws.String("application/octet-stream"),
+		})
+		if err != nil {
+			return err
+		}
+		u.id = aws.ToString(resp.UploadId)
+	}
+	num := aws.Int32(int32(len(u.parts) + 1))
+	resp, err := f.fs.client.UploadPart(ctx, &s3.UploadPartInput{
+		Bucket:     aws.String(f.fs.bucketName),
+		Key:        key,
+		UploadId:   aws.String(u.id),
+		PartNumber: num,
+		Body:       bytes.NewReader(data),
+	})
+	if err != nil {
+		return err
+	}
+	u.parts = append(u.parts, types.CompletedPart{ETag: resp.ETag, PartNumber: num})
+	u.flushed += int64(len(data))
+	return nil
+}
+
+// finishUpload writes what is left of a file being uploaded, completing
+// the multipart upload if one was started.
+func (f *r2File) finishUpload() error {
+	ctx := context.Background()
+	u := f.upload
+	if u.id == "" {
+		_, err := f.fs.client.PutObject(ctx, &s3.PutObjectInput{
+			Bucket:      aws.String(f.fs.bucketName),
+			Key:         aws.String(f.fs.normalizeR2Path(f.path)),
+			Body:        bytes.NewReader(u.buf),
+			Metadata:    f.metadata(),
+			ContentType: aws.String("application/octet-stream"),
+		})
+		return err
+	}
+	if err := f.uploadPart(ctx, u.buf); err != nil {
+		f.abortUpload()
+		return err
+	}
+	_, err := f.fs.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(f.fs.bucketName),
+		Key:             aws.String(f.fs.normalizeR2Path(f.path)),
+		UploadId:        aws.String(u.id),
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: u.parts},
+	})
+	if err != nil {
+		f.abortUpload()
+	}
+	return err
+}
+
+// abortUpload abandons a multipart upload so its parts don't linger in the
+// bucket.
+func (f *r2File) abortUpload() {
+	u := f.upload
+	if u.id == "" {
+		return
+	}
+	f.fs.client.AbortMultipartUpload(context.Background(), &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(f.fs.bucketName),
+		Key:      aws.String(f.fs.normalizeR2Path(f.path)),
+		UploadId: aws.String(u.id),
+	})
+	u.id = ""
+	u.parts = nil
+}
+
+// readRemote reads from the object a file was opened on, keeping a single
+// streaming request open for as long as reads stay sequential.
+func (f *r2File) readRemote(p []byte) (int, error) {
+	if f.pos >= f.fileInfo.size {
+		return 0, io.EOF
+	}
+	if f.body == nil || f.bodyOff != f.pos {
+		f.closeBody()
+		resp, err := f.fs.client.GetObject(context.Background(), &s3.GetObjectInput{
+			Bucket: aws.String(f.fs.bucketName),
+			Key:    aws.String(f.fs.normalizeR2Path(f.path)),
+			Range:  aws.String(fmt.Sprintf("bytes=%d-", f.pos)),
+		})
+		if err != nil {
+			return 0, err
+		}
+		f.body = resp.Body
+		f.bodyOff = f.pos
+	}
+	n, err := f.body.Read(p)
+	f.pos += int64(n)
+	f.bodyOff += int64(n)
+	if err != nil {
+		f.closeBody()
+		if err == io.EOF && n > 0 {
+			err = nil
+		}
+	}
+	return n, err
+}
+
+// load reads the whole object into memory so the file can be modified in
+// place.
+func (f *r2File) load() error {
+	if !f.remote {
+		return nil
+	}
+	f.closeBody()
+	resp, err := f.fs.client.GetObject(context.Background(), &s3.GetObjectInput{
+		Bucket: aws.String(f.fs.bucketName),
+		Key:    aws.String(f.fs.normalizeR2Path(f.path)),
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	f.content = content
+	f.remote = false
+	return nil
+}
+
+func (f *r2File) closeBody() {
+	if f.body != nil {
+		f.body.Close()
+		f.body = nil
+	}
+}
+
+// copySource formats a CopySource for key.
+func (fsys *FS) copySource(key string) *string {
+	return aws.String((&url.URL{Path: fsys.bucketName + "/" + key}).EscapedPath())
+}
+
+// copyObject copies srcKey to dstKey on the server, replacing its metadata.
+// Objects larger than a single CopyObject request allows are copied with a
+// multipart upload of server-side part copies.
+func (fsys *FS) copyObject(ctx context.Context, srcKey, dstKey string, size int64, metadata map[string]string, contentType *string) error {
+	if size <= maxCopySize {
+		_, err := fsys.client.CopyObject(ctx, &s3.CopyObjectInput{
+			Bucket:            aws.String(fsys.bucketName),
+			Key:               aws.String(dstKey),
+			CopySource:        fsys.copySource(srcKey),
+			Metadata:          metadata,
+			MetadataDirective: types.MetadataDirectiveReplace,
+			ContentType:       contentType,
+		})
+		return err
+	}
+
+	created, err := fsys.client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:      aws.String(fsys.bucketName),
+		Key:         aws.String(dstKey),
+		Metadata:    metadata,
+		ContentType: contentType,
+	})
+	if err != nil {
+		return err
+	}
+	abort := func() {
+		fsys.client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(fsys.bucketName),
+			Key:      aws.String(dstKey),
+			UploadId: created.UploadId,
+		})
+	}
+	var parts []types.CompletedPart
+	for off := int64(0); off < size; off += copyPartSize {
+		num := aws.Int32(int32(len(parts) + 1))
+		resp, err := fsys.client.UploadPartCopy(ctx, &s3.UploadPartCopyInput{
+			Bucket:          aws.String(fsys.bucketName),
+			Key:             aws.String(dstKey),
+			UploadId:        created.UploadId,
+			PartNumber:      num,
+			CopySource:      fsys.copySource(srcKey),
+			CopySourceRange: aws.String(fmt.Sprintf("bytes=%d-%d", off, min(off+copyPartSize, size)-1)),
+		})
+		if err != nil {
+			abort()
+			return err
+		}
+		parts = append(parts, types.CompletedPart{ETag: resp.CopyPartResult.ETag, PartNumber: num})
+	}
+	_, err = fsys.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(fsys.bucketName),
+		Key:             aws.String(dstKey),
+		UploadId:        created.UploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+	})
+	if err != nil {
+		abort()
+	}
+	return err
+}
+
+// headObject returns the metadata of the object at key, bypassing the
+// HEAD cache.
+func (fsys *FS) headObject(ctx context.Context, key string) (*s3.HeadObjectOutput, error) {
+	resp, err := fsys.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(fsys.bucketName),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		if strings.Contains(err.Error(), "NotFound") || strings.Contains(err.Error(), "NoSuchKey") {
+			return nil, fs.ErrNotExist
+		}
+		return nil, err
+	}
+	if resp.Metadata == nil {
+		resp.Metadata = make(map[string]string)
+	}
+	return resp, nil
+}
+
+// updateMetadata changes the metadata of the object at name in place with
+// a server-side copy, leaving its content alone.
+func (fsys *FS) updateMetadata(ctx context.Context, name string, update func(metadata map[string]string)) error {
+	objectKey := fsys.normalizeR2Path(name)
+	head, err := fsys.headObject(ctx, objectKey)
+	if err != nil {
+		return err
+	}
+	update(head.Metadata)
+	setMetadataValue(head.Metadata, "Change-Timestamp", strconv.FormatInt(time.Now().UnixMicro(), 10))
+	if err := fsys.copyObject(ctx, objectKey, objectKey, aws.ToInt64(head.ContentLength), head.Metadata, head.ContentType); err != nil {
+		return err
+	}
+	fsys.invalidateCachedHead(name)
+	return nil
+}
+
+// readListing returns the directory listing stored in the object at key.
+func (fsys *FS) readListing(ctx context.Context, key string) (map[string]string, error) {
+	resp, err := fsys.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(fsys.bucketName),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return parseDirectoryEntries(string(content)), nil
+}
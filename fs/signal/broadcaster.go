@@ -5,66 +5,200 @@ import "sync"
 // NoExclude is the exclude id for Broadcast when every subscriber should receive the message.
 const NoExclude int64 = -1
 
+// DefaultBuffer is how many messages a subscriber holds by default.
+const DefaultBuffer = 64
+
+// Policy says what Broadcast does when a subscriber's buffer is full.
+type Policy int
+
+const (
+	// Block makes Broadcast wait until the subscriber has room. No
+	// message is lost, but a stuck subscriber holds up the writer.
+	Block Policy = iota
+	// DropOldest discards the subscriber's oldest pending message.
+	DropOldest
+	// Latest replaces the subscriber's pending messages with the new one,
+	// so a slow subscriber only sees the most recent value.
+	Latest
+)
+
+// Options configure a Broadcaster.
+type Options struct {
+	// Policy is the delivery policy of subscribers added with AddReader.
+	Policy Policy
+	// Buffer is how many messages each subscriber holds. Zero means
+	// DefaultBuffer.
+	Buffer int
+	// Replay sends the last message broadcast to subscribers added with
+	// AddReader as soon as they subscribe.
+	Replay bool
+}
+
 // Broadcaster fans out byte frames to multiple subscribers. Each subscriber
-// has a buffered channel; writers block on full buffers. It is a general
+// has a buffered channel and a policy for when it is full. It is a general
 // primitive for pub/sub style signals (for example terminal SIGWINCH payloads).
 type Broadcaster struct {
+	// sendMu serializes Broadcast, so a broadcast waiting on a Block
+	// subscriber can't be overtaken by the next one
+	sendMu sync.Mutex
+
 	mu      sync.Mutex
-	readers map[int64]chan []byte
+	opts    Options
+	readers map[int64]*subscriber
 	nextID  int64
+	last    []byte
 }
 
-// NewBroadcaster returns an empty broadcaster.
+type subscriber struct {
+	ch     chan []byte
+	policy Policy
+	done   chan struct{}
+	sends  sync.WaitGroup // blocking sends in progress
+}
+
+// NewBroadcaster returns an empty broadcaster whose subscribers block.
 func NewBroadcaster() *Broadcaster {
-	return &Broadcaster{readers: make(map[int64]chan []byte)}
+	return NewBroadcasterWithOptions(Options{})
+}
+
+// NewBroadcasterWithOptions returns an empty broadcaster configured by opts.
+func NewBroadcasterWithOptions(opts Options) *Broadcaster {
+	if opts.Buffer <= 0 {
+		opts.Buffer = DefaultBuffer
+	}
+	return &Broadcaster{opts: opts, readers: make(map[int64]*subscriber)}
 }
 
-// AddReader registers a subscriber and returns an id and delivery channel.
+// AddReader registers a subscriber with the broadcaster's policy and
+// returns an id and delivery channel.
 func (b *Broadcaster) AddReader() (id int64, ch chan []byte) {
+	return b.Subscribe(b.opts.Policy, b.opts.Replay)
+}
+
+// Subscribe registers a subscriber with policy and returns an id and
+// delivery channel. If replay is set and a message has been broadcast,
+// the last one is delivered first.
+func (b *Broadcaster) Subscribe(policy Policy, replay bool) (id int64, ch chan []byte) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 	b.nextID++
 	id = b.nextID
-	ch = make(chan []byte, 64)
-	b.readers[id] = ch
-	return id, ch
+	s := &subscriber{
+		ch:     make(chan []byte, b.opts.Buffer),
+		policy: policy,
+		done:   make(chan struct{}),
+	}
+	if replay && b.last != nil {
+		s.ch <- b.last
+	}
+	b.readers[id] = s
+	return id, s.ch
 }
 
 // RemoveReader unsubscribes and closes the subscriber channel.
 func (b *Broadcaster) RemoveReader(id int64) {
 	b.mu.Lock()
-	defer b.mu.Unlock()
-	if ch, ok := b.readers[id]; ok {
+	s, ok := b.readers[id]
+	if ok {
 		delete(b.readers, id)
-		close(ch)
+		close(s.done)
+	}
+	b.mu.Unlock()
+	if ok {
+		s.close()
 	}
 }
 
+// close closes the channel once blocked sends to it have given up.
+func (s *subscriber) close() {
+	s.sends.Wait()
+	close(s.ch)
+}
+
 // Broadcast delivers a copy of data to every subscriber except exclude when exclude >= 0.
+// It waits for Block subscribers with full buffers; others never wait.
+// Concurrent broadcasts are delivered one after another, in the same
+// order to every subscriber.
 func (b *Broadcaster) Broadcast(data []byte, exclude int64) {
+	payload := append([]byte(nil), data...)
+	b.sendMu.Lock()
+	defer b.sendMu.Unlock()
 	b.mu.Lock()
-	var targets []chan []byte
-	for id, ch := range b.readers {
+	b.last = payload
+	var blocked []*subscriber
+	for id, s := range b.readers {
 		if exclude >= 0 && id == exclude {
 			continue
 		}
-		targets = append(targets, ch)
+		if !s.offer(payload) {
+			s.sends.Add(1)
+			blocked = append(blocked, s)
+		}
 	}
 	b.mu.Unlock()
-	payload := append([]byte(nil), data...)
-	for _, ch := range targets {
-		ch <- payload
+	for _, s := range blocked {
+		select {
+		case s.ch <- payload:
+		case <-s.done:
+		}
+		s.sends.Done()
 	}
 }
 
+// offer delivers payload without waiting, making room as the policy
+// allows. It reports false if a Block subscriber is full. Caller must
+// hold the broadcaster's mu.
+func (s *subscriber) offer(payload []byte) bool {
+	if s.policy == Latest {
+		s.drain()
+	}
+	for {
+		select {
+		case s.ch <- payload:
+			return true
+		default:
+		}
+		if s.policy == Block {
+			return false
+		}
+		// the subscriber may have made room itself, so this can't block
+		select {
+		case <-s.ch:
+		default:
+		}
+	}
+}
+
+// drain discards pending messages.
+func (s *subscriber) drain() {
+	for {
+		select {
+		case <-s.ch:
+		default:
+			return
+		}
+	}
+}
+
+// Last returns the last message broadcast, or nil if there hasn't been one.
+func (b *Broadcaster) Last() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]byte(nil), b.last...)
+}
+
 // Close shuts down all subscribers and clears state.
 func (b *Broadcaster) Close() {
 	b.mu.Lock()
-	defer b.mu.Unlock()
-	for _, ch := range b.readers {
-		close(ch)
+	readers := b.readers
+	b.readers = make(map[int64]*subscriber)
+	for _, s := range readers {
+		close(s.done)
+	}
+	b.mu.Unlock()
+	for _, s := range readers {
+		s.close()
 	}
-	b.readers = make(map[int64]chan []byte)
 }
 
 // SubscriberCount returns the number of active reader subscriptions.
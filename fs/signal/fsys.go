@@ -2,21 +2,27 @@ package signal
 
 import (
 	"context"
+	"fmt"
 	"io"
 	iofs "io/fs"
 	"os"
+	"strconv"
 	"sync"
 
 	"tractor.dev/wanix/fs"
+	"tractor.dev/wanix/fs/bind"
 	"tractor.dev/wanix/fs/fskit"
-	"tractor.dev/wanix/fs/vfs"
 )
 
 // FS exposes a single virtual file at ".". O_RDONLY opens a read-only
 // subscriber; O_WRONLY opens a writer that broadcasts to all subscribers;
 // O_RDWR opens a combined end that does not receive its own writes.
+//
+// A state FS instead reads as the last message broadcast, like a regular
+// file, and writes to it broadcast.
 type FS struct {
-	b *Broadcaster
+	b     *Broadcaster
+	state bool
 }
 
 var (
@@ -31,6 +37,12 @@ func NewFS(b *Broadcaster) *FS {
 	return &FS{b: b}
 }
 
+// NewStateFS wraps an existing broadcaster as a file holding its last
+// message. b must be non-nil.
+func NewStateFS(b *Broadcaster) *FS {
+	return &FS{b: b, state: true}
+}
+
 // New returns a new filesystem backed by its own broadcaster, for callers
 // that need both the fs view and direct Broadcast/Close access.
 func New() (fs.FS, *Broadcaster) {
@@ -57,6 +69,9 @@ func (w *FS) OpenFile(name string, flag int, perm fs.FileMode) (fs.File, error)
 	if name != "." {
 		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
 	}
+	if w.state && flag&0x3 != os.O_WRONLY {
+		return &stateFile{b: w.b, data: w.b.Last()}, nil
+	}
 	switch flag & 0x3 {
 	case os.O_WRONLY:
 		return &writer{b: w.b}, nil
@@ -221,7 +236,54 @@ func (f *rdwr) Stat() (fs.FileInfo, error) {
 	return fskit.Entry("signal", 0644), nil
 }
 
+// stateFile reads as the last message broadcast when it was opened.
+type stateFile struct {
+	b    *Broadcaster
+	data []byte
+	off  int
+	mu   sync.Mutex
+}
+
+func (f *stateFile) Read(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.off >= len(f.data) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.data[f.off:])
+	f.off += n
+	return n, nil
+}
+
+func (f *stateFile) ReadAt(p []byte, off int64) (int, error) {
+	if off >= int64(len(f.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *stateFile) Write(b []byte) (int, error) {
+	f.b.Broadcast(b, NoExclude)
+	return len(b), nil
+}
+
+func (f *stateFile) Close() error { return nil }
+
+func (f *stateFile) Stat() (fs.FileInfo, error) {
+	return fskit.Entry("signal", 0644, int64(len(f.data))), nil
+}
+
 // Allocator yields a fresh signal FS (and broadcaster) per bind, like #pipe.
+// Bind options configure it:
+//
+//	policy=block|drop|latest  what happens when a subscriber falls behind
+//	buf=<n>                   messages held per subscriber
+//	replay                    new subscribers first get the last message
+//	state                     reads return the last message instead of subscribing
 type Allocator struct{}
 
 func (a *Allocator) Open(name string) (iofs.File, error) {
@@ -232,9 +294,41 @@ func (a *Allocator) OpenContext(ctx context.Context, name string) (fs.File, erro
 	return fskit.RawNode(name, 0644).OpenContext(ctx, name)
 }
 
-func (a *Allocator) BindAllocFS(name string) (fs.FS, error) {
-	b := NewBroadcaster()
-	return NewFS(b), nil
+func (a *Allocator) BindAlloc(ctx context.Context, src, dst string, opts map[string]string) (fs.FS, string, error) {
+	o, err := parseOptions(opts)
+	if err != nil {
+		return nil, "", err
+	}
+	b := NewBroadcasterWithOptions(o)
+	if _, ok := opts["state"]; ok {
+		return NewStateFS(b), ".", nil
+	}
+	return NewFS(b), ".", nil
 }
 
-var _ vfs.BindAllocator = (*Allocator)(nil)
+var _ bind.Allocator = (*Allocator)(nil)
+
+func parseOptions(opts map[string]string) (Options, error) {
+	var o Options
+	switch v := opts["policy"]; v {
+	case "", "block":
+		o.Policy = Block
+	case "drop":
+		o.Policy = DropOldest
+	case "latest":
+		o.Policy = Latest
+	default:
+		return o, fmt.Errorf("signal: bad policy %q: %w", v, fs.ErrInvalid)
+	}
+	if v, ok := opts["buf"]; ok {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return o, fmt.Errorf("signal: bad buf %q: %w", v, fs.ErrInvalid)
+		}
+		o.Buffer = n
+	}
+	if _, ok := opts["replay"]; ok {
+		o.Replay = true
+	}
+	return o, nil
+}
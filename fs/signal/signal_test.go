@@ -0,0 +1,151 @@
+package signal
+
+import (
+	"context"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"tractor.dev/wanix/fs"
+)
+
+func TestPolicies(t *testing.T) {
+	b := NewBroadcasterWithOptions(Options{Buffer: 2})
+	_, drop := b.Subscribe(DropOldest, false)
+	_, latest := b.Subscribe(Latest, false)
+	blockID, block := b.Subscribe(Block, false)
+
+	b.Broadcast([]byte("1"), NoExclude)
+	b.Broadcast([]byte("2"), NoExclude)
+
+	// the third message would block on the full Block subscriber
+	done := make(chan struct{})
+	go func() {
+		b.Broadcast([]byte("3"), NoExclude)
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("broadcast didn't wait for a full Block subscriber")
+	case <-time.After(20 * time.Millisecond):
+	}
+	if got := string(<-block); got != "1" {
+		t.Fatalf("block got %q", got)
+	}
+	<-done
+
+	if got := string(<-drop) + string(<-drop); got != "23" {
+		t.Fatalf("drop got %q", got)
+	}
+	if got := string(<-latest); got != "3" {
+		t.Fatalf("latest got %q", got)
+	}
+	select {
+	case m := <-latest:
+		t.Fatalf("latest got extra %q", m)
+	default:
+	}
+
+	// removing a subscriber releases a broadcast waiting on it
+	done = make(chan struct{})
+	go func() {
+		b.Broadcast([]byte("4"), NoExclude)
+		close(done)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	b.RemoveReader(blockID)
+	<-done
+	for range block {
+	}
+}
+
+func TestBlockOrder(t *testing.T) {
+	b := NewBroadcasterWithOptions(Options{Buffer: 1})
+	_, x := b.Subscribe(Block, false)
+	_, y := b.Subscribe(Block, false)
+
+	// concurrent broadcasts waiting on full subscribers reach every
+	// subscriber in the same order
+	const n = 100
+	for i := 0; i < n; i++ {
+		go b.Broadcast([]byte{byte(i)}, NoExclude)
+	}
+	recv := func(ch chan []byte) []byte {
+		var got []byte
+		for i := 0; i < n; i++ {
+			got = append(got, (<-ch)[0])
+			if i%10 == 0 {
+				time.Sleep(time.Millisecond)
+			}
+		}
+		return got
+	}
+	gotY := make(chan []byte)
+	go func() { gotY <- recv(y) }()
+	gotX := recv(x)
+	if got := <-gotY; string(got) != string(gotX) {
+		t.Fatalf("subscribers saw different orders:\n%v\n%v", gotX, got)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	b := NewBroadcasterWithOptions(Options{Policy: Latest, Replay: true})
+	_, ch := b.AddReader()
+	select {
+	case m := <-ch:
+		t.Fatalf("replayed %q before anything was broadcast", m)
+	default:
+	}
+	b.Broadcast([]byte("80 24"), NoExclude)
+	b.Broadcast([]byte("100 30"), NoExclude)
+	_, ch = b.AddReader()
+	if got := string(<-ch); got != "100 30" {
+		t.Fatalf("replayed %q", got)
+	}
+	_, ch = b.Subscribe(Latest, false)
+	select {
+	case m := <-ch:
+		t.Fatalf("replayed %q without replay", m)
+	default:
+	}
+}
+
+func TestStateFS(t *testing.T) {
+	b := NewBroadcaster()
+	fsys := NewStateFS(b)
+	if got, err := fs.ReadFile(fsys, "."); err != nil || len(got) != 0 {
+		t.Fatalf("got %q, %v", got, err)
+	}
+
+	w, err := fs.OpenFile(fsys, ".", os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.(io.Writer).Write([]byte("on")); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	got, err := fs.ReadFile(fsys, ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "on" {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestAllocatorOptions(t *testing.T) {
+	a := &Allocator{}
+	if _, _, err := a.BindAlloc(context.Background(), "#signal", "x", map[string]string{"policy": "bogus"}); err == nil {
+		t.Fatal("expected error for bad policy")
+	}
+	fsys, _, err := a.BindAlloc(context.Background(), "#signal", "x", map[string]string{"policy": "latest", "replay": ""})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := fsys.(*FS).b
+	if b.opts.Policy != Latest || !b.opts.Replay || b.opts.Buffer != DefaultBuffer {
+		t.Fatalf("unexpected options: %+v", b.opts)
+	}
+}
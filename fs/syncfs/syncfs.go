@@ -51,6 +51,15 @@ type SyncFS struct {
 	changes   map[string]bool
 	mu        sync.Mutex
 
+	// edits is held for reading while local is changed, and for writing
+	// by pull while it checks for pending changes and replaces a path.
+	edits sync.RWMutex
+	// writing counts the open handles written to, by path.
+	writing map[string]int
+	// etags holds the remote ETag of each path as of when local last
+	// matched it, for remotes that report them.
+	etags map[string]string
+
 	log *slog.Logger
 }
 
@@ -260,8 +269,19 @@ func (sfs *SyncFS) Sync() error {
 				return
 			}
 			sfs.mu.Lock()
+			pushed := sfs.changes
 			sfs.changes = make(map[string]bool)
 			sfs.mu.Unlock()
+			// so the changes coming back from the remote aren't pulled
+			for path, exists := range pushed {
+				var etag string
+				if exists {
+					if info, err := fs.Lstat(sfs.remote, path); err == nil {
+						etag = etagOf(info)
+					}
+				}
+				sfs.setETag(path, etag)
+			}
 			pushSync <- nil
 		}()
 	} else {
@@ -300,6 +320,7 @@ func (sfs *SyncFS) Sync() error {
 					pullSync <- err
 					return
 				}
+				sfs.setETag(path, etagOf(info))
 			}
 		}
 		paths := make(chan string)
@@ -341,6 +362,122 @@ func (sfs *SyncFS) Sync() error {
 	return nil
 }
 
+// Subscribe pulls remote changes as the remote reports them, until ctx is
+// done. It returns an error if the remote doesn't support fs.Watch, in
+// which case changes are only pulled by Sync.
+func (sfs *SyncFS) Subscribe(ctx context.Context) error {
+	events, err := fs.Watch(sfs.remote, ctx, "./...")
+	if err != nil {
+		return err
+	}
+	go func() {
+		for e := range events {
+			if e.Err != nil {
+				continue
+			}
+			if err := sfs.pull(e.Path, e.Op); err != nil {
+				sfs.log.Error("Subscribe:pull", "err", err, "path", e.Path, "op", e.Op)
+			}
+		}
+	}()
+	return nil
+}
+
+// pull applies a single remote change to local. Paths with local changes
+// waiting to be pushed are left alone, and a reset does a full Sync.
+func (sfs *SyncFS) pull(path, op string) error {
+	sfs.wait()
+	path = sfs.clean(path)
+	if op == "reset" || path == "." {
+		return sfs.Sync()
+	}
+
+	// hold off local changes so none are made between checking for them
+	// and replacing the path
+	sfs.edits.Lock()
+	defer sfs.edits.Unlock()
+	if sfs.pending(path) {
+		sfs.log.Debug("Subscribe:pending", "path", path)
+		return nil
+	}
+
+	rinfo, err := fs.Lstat(sfs.remote, path)
+	if errors.Is(err, fs.ErrNotExist) {
+		if err := fs.RemoveAll(sfs.local, path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		sfs.setETag(path, "")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	etag := etagOf(rinfo)
+	if etag != "" && etag == sfs.etag(path) {
+		// already up to date, likely our own push coming back
+		return nil
+	}
+
+	if rinfo.IsDir() {
+		if err := fs.MkdirAll(sfs.local, path, rinfo.Mode().Perm()); err != nil {
+			return err
+		}
+	} else if err := fs.CopyFS(sfs.remote, path, sfs.local, path); err != nil {
+		return err
+	}
+	if err := fs.Chtimes(sfs.local, path, rinfo.ModTime(), rinfo.ModTime()); err != nil {
+		return err
+	}
+	sfs.setETag(path, etag)
+	return nil
+}
+
+// edit holds off pull while local is changed, returning the func that
+// lets it go ahead again.
+func (sfs *SyncFS) edit() func() {
+	sfs.edits.RLock()
+	return sfs.edits.RUnlock
+}
+
+// pending reports whether path has local changes not yet pushed,
+// including writes through handles not yet closed.
+func (sfs *SyncFS) pending(path string) bool {
+	sfs.mu.Lock()
+	defer sfs.mu.Unlock()
+	_, changed := sfs.changes[path]
+	return changed || sfs.writing[path] > 0
+}
+
+// etag returns the remote ETag path had when local last matched it.
+func (sfs *SyncFS) etag(path string) string {
+	sfs.mu.Lock()
+	defer sfs.mu.Unlock()
+	return sfs.etags[path]
+}
+
+// setETag records the remote ETag local now matches for path, or forgets
+// it if etag is empty.
+func (sfs *SyncFS) setETag(path, etag string) {
+	sfs.mu.Lock()
+	defer sfs.mu.Unlock()
+	if etag == "" {
+		delete(sfs.etags, path)
+		return
+	}
+	if sfs.etags == nil {
+		sfs.etags = make(map[string]string)
+	}
+	sfs.etags[path] = etag
+}
+
+// etagOf returns the ETag the remote reported for info, if any.
+func etagOf(info fs.FileInfo) string {
+	if e, ok := info.(interface{ ETag() string }); ok {
+		return e.ETag()
+	}
+	return ""
+}
+
 func (sfs *SyncFS) clean(path string) string {
 	cleanPath := strings.TrimPrefix(filepath.Clean(path), "/")
 	if cleanPath == "" {
@@ -440,6 +577,7 @@ func (sfs *SyncFS) Readlink(name string) (string, error) {
 func (sfs *SyncFS) Create(name string) (fs.File, error) {
 	name = sfs.clean(name)
 	sfs.wait()
+	defer sfs.edit()()
 	defer sfs.changed(name, true)
 	sfs.log.Debug("Create", "name", name)
 
@@ -455,6 +593,7 @@ func (sfs *SyncFS) Create(name string) (fs.File, error) {
 func (sfs *SyncFS) OpenFile(name string, flag int, perm fs.FileMode) (f fs.File, err error) {
 	name = sfs.clean(name)
 	sfs.wait()
+	defer sfs.edit()()
 	defer func() {
 		sfs.log.Debug("OpenFile", "name", name, "flag", flag, "perm", perm, "err", err)
 	}()
@@ -477,6 +616,7 @@ func (sfs *SyncFS) OpenFile(name string, flag int, perm fs.FileMode) (f fs.File,
 func (sfs *SyncFS) Mkdir(name string, perm fs.FileMode) error {
 	name = sfs.clean(name)
 	sfs.wait()
+	defer sfs.edit()()
 	sfs.log.Debug("Mkdir", "name", name, "perm", perm)
 
 	err := fs.Mkdir(sfs.local, name, perm)
@@ -491,6 +631,7 @@ func (sfs *SyncFS) Mkdir(name string, perm fs.FileMode) error {
 func (sfs *SyncFS) Remove(name string) error {
 	name = sfs.clean(name)
 	sfs.wait()
+	defer sfs.edit()()
 	sfs.log.Debug("Remove", "name", name)
 
 	err := fs.Remove(sfs.local, name)
@@ -506,6 +647,7 @@ func (sfs *SyncFS) Rename(oldname, newname string) error {
 	newname = sfs.clean(newname)
 	oldname = sfs.clean(oldname)
 	sfs.wait()
+	defer sfs.edit()()
 	sfs.log.Debug("Rename", "oldname", oldname, "newname", newname)
 
 	isDir, err := fs.IsDir(sfs.local, oldname)
@@ -541,6 +683,7 @@ func (sfs *SyncFS) Rename(oldname, newname string) error {
 func (sfs *SyncFS) Chmod(name string, mode fs.FileMode) error {
 	name = sfs.clean(name)
 	sfs.wait()
+	defer sfs.edit()()
 	sfs.log.Debug("Chmod", "name", name, "mode", mode)
 
 	err := fs.Chmod(sfs.local, name, mode)
@@ -555,6 +698,7 @@ func (sfs *SyncFS) Chmod(name string, mode fs.FileMode) error {
 func (sfs *SyncFS) Chown(name string, uid, gid int) error {
 	name = sfs.clean(name)
 	sfs.wait()
+	defer sfs.edit()()
 	sfs.log.Debug("Chown", "name", name, "uid", uid, "gid", gid)
 
 	err := fs.Chown(sfs.local, name, uid, gid)
@@ -569,6 +713,7 @@ func (sfs *SyncFS) Chown(name string, uid, gid int) error {
 func (sfs *SyncFS) Chtimes(name string, atime, mtime time.Time) error {
 	name = sfs.clean(name)
 	sfs.wait()
+	defer sfs.edit()()
 	sfs.log.Debug("Chtimes", "name", name, "atime", atime, "mtime", mtime)
 
 	err := fs.Chtimes(sfs.local, name, atime, mtime)
@@ -585,6 +730,7 @@ func (sfs *SyncFS) Symlink(oldname, newname string) error {
 	newname = sfs.clean(newname)
 	oldname = sfs.clean(oldname)
 	sfs.wait()
+	defer sfs.edit()()
 	sfs.log.Debug("Symlink", "oldname", oldname, "newname", newname)
 
 	err := fs.Symlink(sfs.local, oldname, newname)
@@ -626,29 +772,41 @@ func (sfs *SyncFS) newSyncFile(f fs.File, path string) (*syncFile, error) {
 
 // Write wraps the underlying Write and marks file as modified
 func (sf *syncFile) Write(p []byte) (int, error) {
+	defer sf.sfs.edit()()
 	n, err := fs.Write(sf.File, p)
 	if err == nil && n > 0 {
-		if !sf.modified {
-			// Capture the time of the first write
-			sf.writeTime = time.Now()
-			sf.modified = true
-		}
+		sf.markModified()
 	}
 	return n, err
 }
 
 // WriteAt wraps the underlying WriteAt and marks file as modified
 func (sf *syncFile) WriteAt(p []byte, off int64) (int, error) {
+	defer sf.sfs.edit()()
 	n, err := fs.WriteAt(sf.File, p, off)
 	if err == nil && n > 0 {
-		if !sf.modified {
-			sf.writeTime = time.Now()
-			sf.modified = true
-		}
+		sf.markModified()
 	}
 	return n, err
 }
 
+// markModified records the first write through the handle, which keeps
+// pull from replacing the file until the handle is closed.
+func (sf *syncFile) markModified() {
+	if sf.modified {
+		return
+	}
+	// Capture the time of the first write
+	sf.writeTime = time.Now()
+	sf.modified = true
+	sf.sfs.mu.Lock()
+	if sf.sfs.writing == nil {
+		sf.sfs.writing = make(map[string]int)
+	}
+	sf.sfs.writing[sf.path]++
+	sf.sfs.mu.Unlock()
+}
+
 func (sf *syncFile) Seek(offset int64, whence int) (int64, error) {
 	return fs.Seek(sf.File, offset, whence)
 }
@@ -673,6 +831,7 @@ func (sf *syncFile) ReadDir(n int) (entries []fs.DirEntry, err error) {
 
 // Close wraps the underlying Close and syncs modified files to remote.
 func (sf *syncFile) Close() error {
+	defer sf.sfs.edit()()
 	// Close the underlying file first
 	err := sf.File.Close()
 
@@ -680,5 +839,13 @@ func (sf *syncFile) Close() error {
 	if sf.modified && !sf.isDir {
 		sf.sfs.changed(sf.path, true)
 	}
+	if sf.modified {
+		sf.modified = false
+		sf.sfs.mu.Lock()
+		if sf.sfs.writing[sf.path]--; sf.sfs.writing[sf.path] <= 0 {
+			delete(sf.sfs.writing, sf.path)
+		}
+		sf.sfs.mu.Unlock()
+	}
 	return err
 }
@@ -4,6 +4,8 @@ import (
 	"archive/tar"
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"fmt"
 	"io"
 	"os"
 	"testing"
@@ -21,14 +23,20 @@ type mockRemoteFS struct {
 	lastPatch  bytes.Buffer
 	indexError error
 	patchError error
+	events     chan fs.Event
 }
 
 func newMockRemoteFS() *mockRemoteFS {
 	return &mockRemoteFS{
-		FS: memfs.New(),
+		FS:     memfs.New(),
+		events: make(chan fs.Event),
 	}
 }
 
+func (m *mockRemoteFS) Watch(ctx context.Context, name string, exclude ...string) (<-chan fs.Event, error) {
+	return m.events, nil
+}
+
 func (m *mockRemoteFS) Index(ctx context.Context, name string) (fs.FS, error) {
 	m.indexCalls++
 	if m.indexError != nil {
@@ -101,6 +109,27 @@ func (m *mockRemoteFS) Patch(ctx context.Context, name string, tarBuf bytes.Buff
 	return nil
 }
 
+// etagInfo is file info with an ETag, as httpfs reports.
+type etagInfo struct {
+	fs.FileInfo
+	etag string
+}
+
+func (i etagInfo) ETag() string { return i.etag }
+
+// StatContext reports ETags made from the contents of files.
+func (m *mockRemoteFS) StatContext(ctx context.Context, name string) (fs.FileInfo, error) {
+	info, err := m.FS.StatContext(ctx, name)
+	if err != nil || !info.Mode().IsRegular() {
+		return info, err
+	}
+	data, err := fs.ReadFile(m.FS, name)
+	if err != nil {
+		return nil, err
+	}
+	return etagInfo{info, fmt.Sprintf(`"%x"`, sha256.Sum256(data))}, nil
+}
+
 // setupTestFS creates a new SyncFS with local and remote filesystems
 func setupTestFS(t *testing.T) (*SyncFS, *memfs.FS, *mockRemoteFS) {
 	local := memfs.New()
@@ -1323,3 +1352,108 @@ func TestSyncFS_MultipleWriteOperationsBlockAndUnblock(t *testing.T) {
 	}
 	t.Logf("Sync took %v (includes %v index delay)", syncDuration, 300*time.Millisecond)
 }
+
+func TestSyncFS_Subscribe(t *testing.T) {
+	sfs, local, remote := setupTestFS(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := sfs.Subscribe(ctx); err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+
+	waitFor := func(cond func() bool) {
+		t.Helper()
+		for i := 0; i < 100; i++ {
+			if cond() {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatal("timed out waiting for change to be pulled")
+	}
+	exists := func(name string) bool {
+		_, err := fs.Stat(local, name)
+		return err == nil
+	}
+
+	writeFile(t, remote.FS, "pushed.txt", "from remote")
+	remote.events <- fs.Event{Path: "pushed.txt", Op: "write"}
+	waitFor(func() bool { return exists("pushed.txt") })
+	assertFileContent(t, local, "pushed.txt", "from remote")
+
+	if err := fs.Remove(remote.FS, "pushed.txt"); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	remote.events <- fs.Event{Path: "pushed.txt", Op: "remove"}
+	waitFor(func() bool { return !exists("pushed.txt") })
+
+	// local changes not yet pushed win over remote ones
+	sfs.changes = map[string]bool{}
+	writeFile(t, sfs, "mine.txt", "local")
+	writeFile(t, remote.FS, "mine.txt", "remote")
+	remote.events <- fs.Event{Path: "mine.txt", Op: "write"}
+	writeFile(t, remote.FS, "other.txt", "remote")
+	remote.events <- fs.Event{Path: "other.txt", Op: "write"}
+	waitFor(func() bool { return exists("other.txt") })
+	assertFileContent(t, local, "mine.txt", "local")
+}
+
+func TestSyncFS_SubscribeVersions(t *testing.T) {
+	sfs, local, remote := setupTestFS(t)
+	sfs.changes = map[string]bool{}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := sfs.Subscribe(ctx); err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+	// events are pulled in order, so once a later one is pulled the
+	// earlier ones have been too
+	n := 0
+	flush := func() {
+		t.Helper()
+		n++
+		name := fmt.Sprintf("flush%d.txt", n)
+		writeFile(t, remote.FS, name, "flush")
+		remote.events <- fs.Event{Path: name, Op: "write"}
+		for i := 0; i < 100; i++ {
+			if ok, _ := fs.Exists(local, name); ok {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatal("timed out waiting for change to be pulled")
+	}
+
+	// an edit leaving size, mode and modification time as they were is
+	// still pulled
+	mtime := time.Unix(1000, 0)
+	writeFile(t, local, "same.txt", "aaaa")
+	local.Chtimes("same.txt", mtime, mtime)
+	writeFile(t, remote.FS, "same.txt", "bbbb")
+	remote.FS.Chtimes("same.txt", mtime, mtime)
+	remote.events <- fs.Event{Path: "same.txt", Op: "write"}
+	flush()
+	assertFileContent(t, local, "same.txt", "bbbb")
+
+	// while a version already pulled is not pulled again
+	writeFile(t, local, "same.txt", "cccc")
+	remote.events <- fs.Event{Path: "same.txt", Op: "meta"}
+	flush()
+	assertFileContent(t, local, "same.txt", "cccc")
+
+	// and files being written to aren't replaced
+	f, err := sfs.OpenFile("same.txt", os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.(io.Writer).Write([]byte("dddd")); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, remote.FS, "same.txt", "eeee")
+	remote.events <- fs.Event{Path: "same.txt", Op: "write"}
+	flush()
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	assertFileContent(t, local, "same.txt", "dddd")
+}
@@ -2,58 +2,197 @@ package tarfs
 
 import (
 	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
 	"io"
-	"io/fs"
-	"os"
+	"path"
+	"reflect"
+	"sort"
+
+	"tractor.dev/wanix/fs"
+	"tractor.dev/wanix/fs/pstat"
 )
 
+// xattrPrefix is the PAX record prefix used by GNU tar and bsdtar
+// for extended attributes.
+const xattrPrefix = "SCHILY.xattr."
+
+// Archive writes the contents of fsys to tw. Everything is read through
+// fsys itself, so symlinks, ownership and extended attributes are
+// preserved for any filesystem, including a namespace made of binds.
+// Entries are written in PAX format to keep sub-second modification times
+// and xattrs. Files sharing an inode are written once followed by hard
+// links, and directories that resolve back to one of their ancestors are
+// written without their contents instead of recursing forever.
 func Archive(fsys fs.FS, tw *tar.Writer) error {
-	return fs.WalkDir(fsys, ".", func(path string, entry fs.DirEntry, err error) error {
-		if err != nil {
-			return err
-		}
+	return ArchiveContext(fs.ContextFor(fsys), fsys, ".", tw)
+}
 
-		info, err := entry.Info()
-		if err != nil {
-			return err
-		}
+// ArchiveContext is like Archive but only writes the tree at dir, with
+// entry names relative to dir.
+func ArchiveContext(ctx context.Context, fsys fs.FS, dir string, tw *tar.Writer) error {
+	a := &archiver{
+		ctx:   ctx,
+		fsys:  fsys,
+		root:  dir,
+		tw:    tw,
+		links: make(map[inode]string),
+		dirs:  make(map[dirKey]bool),
+	}
+	return a.walk(".")
+}
 
-		// Create tar header
-		header, err := tar.FileInfoHeader(info, "")
+type archiver struct {
+	ctx   context.Context
+	fsys  fs.FS
+	root  string
+	tw    *tar.Writer
+	links map[inode]string
+	dirs  map[dirKey]bool // directories on the current path
+}
+
+type dirKey struct {
+	ptr uintptr
+	rel string
+}
+
+func (a *archiver) walk(name string) error {
+	fullname := path.Join(a.root, name)
+	fi, err := fs.LstatContext(a.ctx, a.fsys, fullname)
+	if err != nil {
+		return err
+	}
+	if fi.Mode()&fs.ModeSocket != 0 {
+		// tar has no representation for sockets
+		return nil
+	}
+
+	var link string
+	if fi.Mode()&fs.ModeSymlink != 0 {
+		link, err = fs.Readlink(a.fsys, fullname)
 		if err != nil {
 			return err
 		}
+	}
 
-		// Update the name to maintain directory structure
-		header.Name = path
+	hdr, err := tar.FileInfoHeader(fi, link)
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+	if fi.IsDir() {
+		hdr.Name += "/"
+	}
+	hdr.Format = tar.FormatPAX
+	if p, ok := fi.(pstat.UIDGIDProvider); ok {
+		hdr.Uid = p.GetUID()
+		hdr.Gid = p.GetGID()
+	}
+	if err := a.xattrs(fullname, hdr); err != nil {
+		return err
+	}
 
-		// Handle symlinks
-		if info.Mode()&os.ModeSymlink != 0 {
-			link, err := os.Readlink(path)
-			if err != nil {
-				return err
+	if fi.Mode().IsRegular() {
+		if id, ok := inodeOf(fi); ok {
+			if target, seen := a.links[id]; seen {
+				hdr.Typeflag = tar.TypeLink
+				hdr.Linkname = target
+				hdr.Size = 0
+				return a.tw.WriteHeader(hdr)
 			}
-			header.Linkname = link
+			a.links[id] = name
 		}
+	}
 
-		// Write header
-		if err := tw.WriteHeader(header); err != nil {
-			return err
-		}
+	if err := a.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
 
-		// If it's not a regular file, we're done
-		if !info.Mode().IsRegular() {
+	switch {
+	case fi.Mode().IsRegular():
+		return a.copyFile(fullname, hdr.Size)
+	case fi.IsDir():
+		return a.walkDir(name, fullname)
+	}
+	return nil
+}
+
+func (a *archiver) walkDir(name, fullname string) error {
+	key, ok := a.dirKey(fullname)
+	if ok {
+		if a.dirs[key] {
+			// a bind has made this directory its own descendant
 			return nil
 		}
+		a.dirs[key] = true
+		defer delete(a.dirs, key)
+	}
+
+	entries, err := fs.ReadDirContext(a.ctx, a.fsys, fullname)
+	if err != nil {
+		return err
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	for _, e := range entries {
+		if err := a.walk(path.Join(name, e.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dirKey identifies the directory that fullname resolves to once all
+// binds and other routing layers are followed.
+func (a *archiver) dirKey(fullname string) (dirKey, bool) {
+	loc, err := fs.Walk(a.ctx, a.fsys, fullname)
+	if err != nil {
+		return dirKey{}, false
+	}
+	v := reflect.ValueOf(loc.FS)
+	if v.Kind() != reflect.Pointer || v.IsNil() {
+		return dirKey{}, false
+	}
+	return dirKey{ptr: v.Pointer(), rel: path.Clean(loc.Rel)}, true
+}
 
-		// Open and copy file contents
-		f, err := fsys.Open(path)
+func (a *archiver) xattrs(fullname string, hdr *tar.Header) error {
+	ctx := fs.WithNoFollow(a.ctx)
+	names, err := fs.ListXattrs(ctx, a.fsys, fullname)
+	if errors.Is(err, fs.ErrNotSupported) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, attr := range names {
+		if attr == "" {
+			continue
+		}
+		val, err := fs.GetXattr(ctx, a.fsys, fullname, attr)
 		if err != nil {
 			return err
 		}
-		defer f.Close()
+		if hdr.PAXRecords == nil {
+			hdr.PAXRecords = make(map[string]string)
+		}
+		hdr.PAXRecords[xattrPrefix+attr] = string(val)
+	}
+	return nil
+}
 
-		_, err = io.Copy(tw, f)
+func (a *archiver) copyFile(fullname string, size int64) error {
+	f, err := fs.OpenContext(a.ctx, a.fsys, fullname)
+	if err != nil {
 		return err
-	})
+	}
+	defer f.Close()
+
+	n, err := io.CopyN(a.tw, f, size)
+	if err == io.EOF {
+		return fmt.Errorf("tarfs: %s shrank while archiving (%d of %d bytes)", fullname, n, size)
+	}
+	return err
 }
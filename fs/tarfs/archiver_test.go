@@ -0,0 +1,191 @@
+package tarfs
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"tractor.dev/wanix/fs"
+	"tractor.dev/wanix/fs/localfs"
+	"tractor.dev/wanix/fs/memfs"
+	"tractor.dev/wanix/fs/vfs"
+)
+
+// xattrFS adds fixed extended attributes to a filesystem.
+type xattrFS struct {
+	*memfs.FS
+	attrs map[string]map[string][]byte
+}
+
+func (x *xattrFS) SetXattr(ctx context.Context, name string, attr string, data []byte, flags int) error {
+	return fs.ErrNotSupported
+}
+
+func (x *xattrFS) GetXattr(ctx context.Context, name string, attr string) ([]byte, error) {
+	return x.attrs[name][attr], nil
+}
+
+func (x *xattrFS) ListXattrs(ctx context.Context, name string) ([]string, error) {
+	var names []string
+	for k := range x.attrs[name] {
+		names = append(names, k)
+	}
+	return names, nil
+}
+
+func (x *xattrFS) RemoveXattr(ctx context.Context, name string, attr string) error {
+	return fs.ErrNotSupported
+}
+
+func readEntries(t *testing.T, b []byte) map[string]*tar.Header {
+	t.Helper()
+	hdrs := make(map[string]*tar.Header)
+	tr := tar.NewReader(bytes.NewReader(b))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, dup := hdrs[hdr.Name]; dup {
+			t.Fatalf("duplicate entry %s", hdr.Name)
+		}
+		hdrs[hdr.Name] = hdr
+	}
+	return hdrs
+}
+
+func TestArchiveSymlinksAndXattrs(t *testing.T) {
+	mfs := memfs.New()
+	mtime := time.Date(2024, 1, 2, 3, 4, 5, 123456789, time.UTC)
+	if err := fs.MkdirAll(mfs, "dir", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.WriteFile(mfs, "dir/file.txt", []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Chtimes(mfs, "dir/file.txt", mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Chown(mfs, "dir/file.txt", 1000, 1001); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Symlink(mfs, "dir/file.txt", "link"); err != nil {
+		t.Fatal(err)
+	}
+	fsys := &xattrFS{FS: mfs, attrs: map[string]map[string][]byte{
+		"dir/file.txt": {"user.color": []byte("blue")},
+	}}
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	if err := Archive(fsys, tw); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	hdrs := readEntries(t, buf.Bytes())
+	link := hdrs["link"]
+	if link == nil || link.Typeflag != tar.TypeSymlink || link.Linkname != "dir/file.txt" {
+		t.Fatalf("unexpected symlink entry: %+v", link)
+	}
+	file := hdrs["dir/file.txt"]
+	if file == nil {
+		t.Fatal("missing dir/file.txt")
+	}
+	if !file.ModTime.Equal(mtime) {
+		t.Errorf("mtime = %v, want %v", file.ModTime, mtime)
+	}
+	if file.Uid != 1000 || file.Gid != 1001 {
+		t.Errorf("owner = %d:%d, want 1000:1001", file.Uid, file.Gid)
+	}
+	if got := file.PAXRecords[xattrPrefix+"user.color"]; got != "blue" {
+		t.Errorf("xattr user.color = %q, want %q", got, "blue")
+	}
+	if hdrs["dir/"] == nil || hdrs["dir/"].Typeflag != tar.TypeDir {
+		t.Error("missing dir/ entry")
+	}
+}
+
+func TestArchiveNamespaceLoop(t *testing.T) {
+	mfs := memfs.New()
+	if err := fs.WriteFile(mfs, "file.txt", []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ns := vfs.New(context.Background())
+	if err := ns.Bind(mfs, ".", "."); err != nil {
+		t.Fatal(err)
+	}
+	if err := ns.Bind(ns, ".", "loop"); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	if err := Archive(ns, tw); err != nil {
+		t.Fatal(err)
+	}
+	tw.Close()
+
+	hdrs := readEntries(t, buf.Bytes())
+	if hdrs["file.txt"] == nil {
+		t.Fatal("missing file.txt")
+	}
+	if hdrs["loop/"] == nil {
+		t.Fatal("missing loop/")
+	}
+	if hdrs["loop/file.txt"] != nil {
+		t.Fatal("followed bind loop")
+	}
+}
+
+func TestArchiveHardLinks(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a"), []byte("shared"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(filepath.Join(dir, "a"), filepath.Join(dir, "b")); err != nil {
+		t.Skip("hard links not supported:", err)
+	}
+	lfs, err := localfs.New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	if err := Archive(lfs, tw); err != nil {
+		t.Fatal(err)
+	}
+	tw.Close()
+
+	hdrs := readEntries(t, buf.Bytes())
+	if hdrs["a"] == nil || hdrs["a"].Typeflag != tar.TypeReg {
+		t.Fatalf("unexpected entry for a: %+v", hdrs["a"])
+	}
+	if hdrs["b"] == nil || hdrs["b"].Typeflag != tar.TypeLink || hdrs["b"].Linkname != "a" {
+		t.Fatalf("unexpected entry for b: %+v", hdrs["b"])
+	}
+
+	// round trip through the reader resolves the link
+	fsys, err := Index(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := fs.ReadFile(fsys, "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "shared" {
+		t.Fatalf("unexpected contents of b: %q", b)
+	}
+}
@@ -0,0 +1,487 @@
+package tarfs
+
+import (
+	"compress/gzip"
+	"container/list"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"tractor.dev/wanix/fs"
+)
+
+// MemorySpool is how many bytes of a decoded stream are kept in memory
+// when no temporary file can be created to spool it to, as in js/wasm
+// builds.
+var MemorySpool int64 = 32 << 20
+
+// SpoolFS, if set, is where decoded blocks evicted from memory are
+// written when no temporary file can be created, such as an OPFS
+// directory in the browser. Without it they're decoded again from the
+// start of the stream when read.
+var SpoolFS fs.FS
+
+// spoolBlock is the unit decoded data is kept in memory and spilled in.
+const spoolBlock = 1 << 20
+
+// streamReaderAt provides random access to a stream that can only be
+// decoded forward. Decoded data is kept, so each byte is usually decoded
+// once however the archive is read: reads past what has been decoded
+// continue decoding, and earlier offsets are read back. Where a temporary
+// file can be created everything decoded is spooled to it. Otherwise
+// decoded blocks go to a blockCache of bounded size.
+type streamReaderAt struct {
+	open func() (io.ReadCloser, error)
+
+	mu    sync.Mutex
+	r     io.ReadCloser
+	spool spool       // the temporary file, if there is one
+	cache *blockCache // otherwise
+	pos   int64       // bytes decoded
+	err   error       // why decoding stopped, io.EOF at the end
+}
+
+func newGzipReaderAt(r io.ReaderAt, size int64) *streamReaderAt {
+	return &streamReaderAt{
+		open: func() (io.ReadCloser, error) {
+			return gzip.NewReader(io.NewSectionReader(r, 0, size))
+		},
+	}
+}
+
+func (s *streamReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("tarfs: negative offset")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.r == nil {
+		if err := s.start(); err != nil {
+			return 0, err
+		}
+	}
+	if s.cache != nil {
+		return s.readCached(p, off)
+	}
+	if end := off + int64(len(p)); end > s.pos && s.err == nil {
+		s.err = s.decode(end)
+	}
+	if off >= s.pos {
+		return 0, s.err
+	}
+	n, err := s.spool.ReadAt(p[:min(int64(len(p)), s.pos-off)], off)
+	if err == nil && n < len(p) {
+		err = s.err
+	}
+	return n, err
+}
+
+// start opens the decoder, and the spool or cache if there's neither.
+func (s *streamReaderAt) start() error {
+	r, err := s.open()
+	if err != nil {
+		return err
+	}
+	s.r = r
+	if s.spool == nil && s.cache == nil {
+		if f, err := os.CreateTemp("", "tarfs-*"); err == nil {
+			s.spool = &fileSpool{f}
+		} else {
+			s.cache = newBlockCache(spoolBlock, int(MemorySpool/spoolBlock), SpoolFS)
+		}
+	}
+	return nil
+}
+
+// rewind starts decoding again from the start of the stream.
+func (s *streamReaderAt) rewind() error {
+	r, err := s.open()
+	if err != nil {
+		return err
+	}
+	s.r.Close()
+	s.r = r
+	s.pos, s.err = 0, nil
+	s.cache.cur = s.cache.cur[:0]
+	return nil
+}
+
+// decode continues decoding until end bytes have been decoded.
+func (s *streamReaderAt) decode(end int64) error {
+	buf := make([]byte, 64*1024)
+	for s.pos < end {
+		n, err := s.r.Read(buf[:min(int64(len(buf)), end-s.pos)])
+		if n > 0 {
+			var werr error
+			if s.cache != nil {
+				s.cache.write(s.pos, buf[:n])
+			} else {
+				_, werr = s.spool.WriteAt(buf[:n], s.pos)
+			}
+			if werr != nil {
+				return werr
+			}
+			s.pos += int64(n)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *streamReaderAt) readCached(p []byte, off int64) (int, error) {
+	var n int
+	for n < len(p) {
+		b, err := s.block(off / s.cache.size)
+		i := off % s.cache.size
+		if i >= int64(len(b)) {
+			return n, err
+		}
+		c := copy(p[n:], b[i:])
+		n += c
+		off += int64(c)
+	}
+	return n, nil
+}
+
+// block returns decoded block idx, decoding up to it if needed. The last
+// block of the stream may be short, and comes with why decoding stopped.
+func (s *streamReaderAt) block(idx int64) ([]byte, error) {
+	size := s.cache.size
+	if idx < s.pos/size {
+		if b, ok := s.cache.get(idx); ok {
+			return b, nil
+		}
+		// evicted with nowhere to spill it
+		if err := s.rewind(); err != nil {
+			return nil, err
+		}
+	}
+	if s.err == nil {
+		s.err = s.decode((idx + 1) * size)
+	}
+	switch {
+	case idx < s.pos/size:
+		// decoding stops at the end of idx, so it's the latest block
+		b, _ := s.cache.get(idx)
+		return b, nil
+	case idx == s.pos/size:
+		return s.cache.cur, s.err
+	}
+	return nil, s.err
+}
+
+func (s *streamReaderAt) Size() int64 {
+	return math.MaxInt64
+}
+
+func (s *streamReaderAt) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.r == nil {
+		return nil
+	}
+	err := s.r.Close()
+	if s.spool != nil {
+		s.spool.Close()
+	}
+	if s.cache != nil {
+		s.cache.close()
+	}
+	s.r, s.spool, s.cache = nil, nil, nil
+	s.pos, s.err = 0, nil
+	return err
+}
+
+// spool holds decoded data for streamReaderAt.
+type spool interface {
+	io.ReaderAt
+	io.WriterAt
+	io.Closer
+}
+
+// fileSpool is a temporary file removed when closed.
+type fileSpool struct {
+	*os.File
+}
+
+func (f *fileSpool) Close() error {
+	err := f.File.Close()
+	os.Remove(f.Name())
+	return err
+}
+
+// blockCache keeps the most recently used blocks of a decoded stream in
+// memory. Blocks it evicts are written to spill, one file each, when
+// there's one to write them to.
+type blockCache struct {
+	size  int64 // bytes per block
+	max   int   // blocks kept in memory
+	spill fs.FS
+	dir   string // in spill, created on the first eviction
+
+	lru     *list.List // of *cachedBlock, most recent first
+	blocks  map[int64]*list.Element
+	spilled map[int64]bool
+	cur     []byte // the block being decoded
+}
+
+type cachedBlock struct {
+	idx  int64
+	data []byte
+}
+
+func newBlockCache(size int64, n int, spill fs.FS) *blockCache {
+	return &blockCache{
+		size:    size,
+		max:     max(n, 1),
+		spill:   spill,
+		lru:     list.New(),
+		blocks:  make(map[int64]*list.Element),
+		spilled: make(map[int64]bool),
+		cur:     make([]byte, 0, size),
+	}
+}
+
+// write adds p, decoded at pos, to the block being decoded, caching the
+// blocks it completes.
+func (c *blockCache) write(pos int64, p []byte) {
+	for len(p) > 0 {
+		n := min(int64(len(p)), c.size-int64(len(c.cur)))
+		c.cur = append(c.cur, p[:n]...)
+		p = p[n:]
+		pos += n
+		if int64(len(c.cur)) == c.size {
+			c.put(pos/c.size-1, c.cur)
+			c.cur = make([]byte, 0, c.size)
+		}
+	}
+}
+
+func (c *blockCache) put(idx int64, data []byte) {
+	if e, ok := c.blocks[idx]; ok {
+		c.lru.MoveToFront(e)
+		return
+	}
+	c.blocks[idx] = c.lru.PushFront(&cachedBlock{idx: idx, data: data})
+	for c.lru.Len() > c.max {
+		b := c.lru.Remove(c.lru.Back()).(*cachedBlock)
+		delete(c.blocks, b.idx)
+		if c.spill != nil && !c.spilled[b.idx] && c.spillBlock(b) == nil {
+			c.spilled[b.idx] = true
+		}
+	}
+}
+
+// get returns block idx if it's in memory or was spilled.
+func (c *blockCache) get(idx int64) ([]byte, bool) {
+	if e, ok := c.blocks[idx]; ok {
+		c.lru.MoveToFront(e)
+		return e.Value.(*cachedBlock).data, true
+	}
+	if !c.spilled[idx] {
+		return nil, false
+	}
+	data, err := fs.ReadFile(c.spill, c.blockName(idx))
+	if err != nil || int64(len(data)) != c.size {
+		// decode it again instead
+		delete(c.spilled, idx)
+		return nil, false
+	}
+	c.put(idx, data)
+	return data, true
+}
+
+func (c *blockCache) spillBlock(b *cachedBlock) error {
+	if c.dir == "" {
+		dir, err := fs.TempDir(c.spill, ".", "tarfs-")
+		if err != nil {
+			return err
+		}
+		c.dir = dir
+	}
+	return fs.WriteFile(c.spill, c.blockName(b.idx), b.data, 0600)
+}
+
+func (c *blockCache) blockName(idx int64) string {
+	return path.Join(c.dir, strconv.FormatInt(idx, 10))
+}
+
+func (c *blockCache) close() {
+	if c.dir != "" {
+		fs.RemoveAll(c.spill, c.dir)
+	}
+}
+
+const (
+	zstdSkippableMagic = 0x184D2A5E
+	zstdSeekableMagic  = 0x8F92EAB1
+	zstdSeekFooterSize = 9
+)
+
+// newZstdReaderAt reads a zstd stream, using the seek table of the zstd
+// seekable format when present so that any offset can be reached by
+// decoding a single frame.
+func newZstdReaderAt(r io.ReaderAt, size int64) (DecompressedReaderAt, error) {
+	dec, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	frames, err := readSeekTable(r, size)
+	if err != nil {
+		dec.Close()
+		return nil, err
+	}
+	if frames == nil {
+		dec.Close()
+		return &streamReaderAt{
+			open: func() (io.ReadCloser, error) {
+				d, err := zstd.NewReader(io.NewSectionReader(r, 0, size), zstd.WithDecoderConcurrency(1))
+				if err != nil {
+					return nil, err
+				}
+				return d.IOReadCloser(), nil
+			},
+		}, nil
+	}
+	return &seekableZstd{r: r, dec: dec, frames: frames, cached: -1}, nil
+}
+
+type zstdFrame struct {
+	coff, csize int64 // compressed offset and size
+	doff, dsize int64 // decompressed offset and size
+}
+
+// readSeekTable returns the frames listed in the seek table at the end of
+// r, or nil if r is not in the seekable format.
+func readSeekTable(r io.ReaderAt, size int64) ([]zstdFrame, error) {
+	if size < zstdSeekFooterSize+8 {
+		return nil, nil
+	}
+	footer := make([]byte, zstdSeekFooterSize)
+	if _, err := r.ReadAt(footer, size-zstdSeekFooterSize); err != nil {
+		return nil, err
+	}
+	if binary.LittleEndian.Uint32(footer[5:]) != zstdSeekableMagic {
+		return nil, nil
+	}
+	count := int64(binary.LittleEndian.Uint32(footer[0:]))
+	entrySize := int64(8)
+	if footer[4]&0x80 != 0 {
+		entrySize = 12 // entries carry a checksum
+	}
+	tableSize := count*entrySize + zstdSeekFooterSize
+	start := size - tableSize - 8
+	if start < 0 {
+		return nil, errors.New("tarfs: zstd seek table larger than archive")
+	}
+	table := make([]byte, tableSize+8)
+	if _, err := r.ReadAt(table, start); err != nil {
+		return nil, err
+	}
+	if binary.LittleEndian.Uint32(table[0:]) != zstdSkippableMagic ||
+		int64(binary.LittleEndian.Uint32(table[4:])) != tableSize {
+		return nil, errors.New("tarfs: malformed zstd seek table")
+	}
+
+	frames := make([]zstdFrame, count)
+	var coff, doff int64
+	for i := range frames {
+		e := table[8+int64(i)*entrySize:]
+		f := zstdFrame{
+			coff:  coff,
+			csize: int64(binary.LittleEndian.Uint32(e[0:])),
+			doff:  doff,
+			dsize: int64(binary.LittleEndian.Uint32(e[4:])),
+		}
+		coff += f.csize
+		doff += f.dsize
+		frames[i] = f
+	}
+	if coff > start {
+		return nil, errors.New("tarfs: zstd seek table exceeds archive")
+	}
+	return frames, nil
+}
+
+type seekableZstd struct {
+	r      io.ReaderAt
+	dec    *zstd.Decoder
+	frames []zstdFrame
+
+	mu     sync.Mutex
+	cached int
+	buf    []byte
+}
+
+func (z *seekableZstd) Size() int64 {
+	if len(z.frames) == 0 {
+		return 0
+	}
+	last := z.frames[len(z.frames)-1]
+	return last.doff + last.dsize
+}
+
+func (z *seekableZstd) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("tarfs: negative offset")
+	}
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	var n int
+	for n < len(p) {
+		i := sort.Search(len(z.frames), func(i int) bool {
+			return z.frames[i].doff+z.frames[i].dsize > off
+		})
+		if i == len(z.frames) {
+			return n, io.EOF
+		}
+		data, err := z.frame(i)
+		if err != nil {
+			return n, err
+		}
+		c := copy(p[n:], data[off-z.frames[i].doff:])
+		n += c
+		off += int64(c)
+	}
+	return n, nil
+}
+
+// frame returns the decompressed contents of frame i, keeping the most
+// recent one around since reads tend to be sequential.
+func (z *seekableZstd) frame(i int) ([]byte, error) {
+	if z.cached == i {
+		return z.buf, nil
+	}
+	z.cached = -1
+	f := z.frames[i]
+	src := make([]byte, f.csize)
+	if _, err := z.r.ReadAt(src, f.coff); err != nil {
+		return nil, err
+	}
+	buf, err := z.dec.DecodeAll(src, z.buf[:0])
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(buf)) != f.dsize {
+		return nil, fmt.Errorf("tarfs: zstd frame %d decoded to %d bytes, expected %d", i, len(buf), f.dsize)
+	}
+	z.cached, z.buf = i, buf
+	return buf, nil
+}
+
+func (z *seekableZstd) Close() error {
+	z.dec.Close()
+	return nil
+}
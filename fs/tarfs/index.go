@@ -0,0 +1,119 @@
+package tarfs
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// Index builds a Reader over the archive in r without loading file contents
+// into memory. Headers are scanned once to record where each entry's data
+// starts, and reads are served from r on demand. Gzip and zstd compressed
+// archives are detected by their magic bytes. Zstd archives written in the
+// seekable format are read frame by frame; other compressed archives are
+// decoded front to back into a temporary file that later reads are served
+// from, or where one can't be created, into memory bounded by
+// MemorySpool, spilling to SpoolFS if it's set.
+//
+// r must remain valid for the lifetime of the returned Reader.
+func Index(r io.ReaderAt, size int64) (*Reader, error) {
+	dr, err := Decompress(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	fsys := newReader()
+	if dr != nil {
+		fsys.closers = append(fsys.closers, dr)
+		r, size = dr, dr.Size()
+	}
+	if err := fsys.index(r, size); err != nil {
+		fsys.Close()
+		return nil, err
+	}
+	return fsys, nil
+}
+
+// DecompressedReaderAt is random access to the decompressed contents of
+// an archive. Size returns math.MaxInt64 if the decompressed size isn't
+// known without decoding everything. Close releases the decoder.
+type DecompressedReaderAt interface {
+	io.ReaderAt
+	io.Closer
+	Size() int64
+}
+
+// Decompress returns the decompressed contents of r if it starts with
+// gzip or zstd magic bytes, and nil if it doesn't. It is how Index reads
+// compressed archives, for callers that need to look inside before
+// picking a format.
+func Decompress(r io.ReaderAt, size int64) (DecompressedReaderAt, error) {
+	magic := make([]byte, 4)
+	n, err := r.ReadAt(magic, 0)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	magic = magic[:n]
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return newGzipReaderAt(r, size), nil
+	case bytes.HasPrefix(magic, zstdMagic):
+		return newZstdReaderAt(r, size)
+	}
+	return nil, nil
+}
+
+func (fsys *Reader) index(r io.ReaderAt, size int64) error {
+	sr := io.NewSectionReader(r, 0, size)
+	t := tar.NewReader(sr)
+	for {
+		hdr, err := t.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if isSparse(hdr) {
+			// sparse data isn't laid out contiguously in the archive,
+			// so these (rare) entries are expanded into memory
+			var buf bytes.Buffer
+			if _, err := buf.ReadFrom(t); err != nil {
+				return fmt.Errorf("tarfs: reading %s: %w", hdr.Name, err)
+			}
+			fsys.add(hdr, bytes.NewReader(buf.Bytes()), 0)
+			continue
+		}
+
+		// tar.Reader has consumed exactly the header blocks, so the
+		// current position is where this entry's data begins
+		off, err := sr.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+		fsys.add(hdr, r, off)
+	}
+	fsys.finish()
+	return nil
+}
+
+func isSparse(hdr *tar.Header) bool {
+	if hdr.Typeflag == tar.TypeGNUSparse {
+		return true
+	}
+	for k := range hdr.PAXRecords {
+		if strings.HasPrefix(k, "GNU.sparse.") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,20 @@
+package tarfs
+
+import (
+	"tractor.dev/wanix/fs"
+
+	"tractor.dev/wanix/fs/pstat"
+)
+
+// inode identifies a file across hard links.
+type inode struct {
+	dev, ino uint64
+}
+
+// inodeOf returns the identity of fi if it may have other hard links.
+func inodeOf(fi fs.FileInfo) (inode, bool) {
+	if st, ok := fi.Sys().(*pstat.Stat); ok {
+		return inode{st.Dev, st.Ino}, st.Nlink > 1 && st.Ino != 0
+	}
+	return sysInode(fi.Sys())
+}
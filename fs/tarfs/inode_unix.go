@@ -0,0 +1,13 @@
+//go:build !windows
+
+package tarfs
+
+import "syscall"
+
+func sysInode(sys any) (inode, bool) {
+	st, ok := sys.(*syscall.Stat_t)
+	if !ok {
+		return inode{}, false
+	}
+	return inode{uint64(st.Dev), uint64(st.Ino)}, uint64(st.Nlink) > 1
+}
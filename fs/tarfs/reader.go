@@ -1,9 +1,11 @@
-// tarfs implements a read-only in-memory representation of a tar archive
+// tarfs implements a read-only filesystem view of a tar archive, either held
+// in memory (From) or read on demand from a seekable source (Index).
 package tarfs
 
 import (
 	"archive/tar"
 	"bytes"
+	"fmt"
 	"io"
 	"io/fs"
 	"os"
@@ -14,7 +16,8 @@ import (
 var Separator = "/"
 
 type Reader struct {
-	files map[string]map[string]*File
+	files   map[string]map[string]*File
+	closers []io.Closer
 }
 
 func splitpath(name string) (dir, file string) {
@@ -28,8 +31,11 @@ func splitpath(name string) (dir, file string) {
 	return
 }
 
+// From reads every entry of t into memory. It is meant for archives that
+// are only available as a stream; use Index when the archive can be read
+// at arbitrary offsets.
 func From(t *tar.Reader) (*Reader, error) {
-	fsys := &Reader{files: make(map[string]map[string]*File)}
+	fsys := newReader()
 	for {
 		hdr, err := t.Next()
 		if err == io.EOF {
@@ -39,28 +45,64 @@ func From(t *tar.Reader) (*Reader, error) {
 			return nil, err
 		}
 
-		d, f := splitpath(hdr.Name)
-		if _, ok := fsys.files[d]; !ok {
-			fsys.files[d] = make(map[string]*File)
-		}
-
 		var buf bytes.Buffer
 		size, err := buf.ReadFrom(t)
 		if err != nil {
-			panic("tarfs: reading from tar:" + err.Error())
+			return nil, fmt.Errorf("tarfs: reading %s: %w", hdr.Name, err)
 		}
-
 		if size != hdr.Size {
-			panic("tarfs: size mismatch")
+			return nil, fmt.Errorf("tarfs: reading %s: %w", hdr.Name, io.ErrUnexpectedEOF)
 		}
 
-		file := &File{
-			h:    hdr,
-			data: bytes.NewReader(buf.Bytes()),
-			fs:   fsys,
-		}
-		fsys.files[d][f] = file
+		fsys.add(hdr, bytes.NewReader(buf.Bytes()), 0)
+	}
+	fsys.finish()
+	return fsys, nil
+}
 
+func newReader() *Reader {
+	return &Reader{files: make(map[string]map[string]*File)}
+}
+
+// add records an entry whose content is the hdr.Size bytes of ra
+// starting at off.
+func (fsys *Reader) add(hdr *tar.Header, ra io.ReaderAt, off int64) {
+	d, f := splitpath(hdr.Name)
+	if _, ok := fsys.files[d]; !ok {
+		fsys.files[d] = make(map[string]*File)
+	}
+	fsys.files[d][f] = &File{
+		h:   hdr,
+		ra:  ra,
+		off: off,
+		fs:  fsys,
+	}
+}
+
+// finish resolves hard links and adds the pseudoroot. A hard link takes
+// the type of its target, except that links to directories are dropped.
+func (fsys *Reader) finish() {
+	for _, dir := range fsys.files {
+		for name, file := range dir {
+			if file.h.Typeflag != tar.TypeLink {
+				continue
+			}
+			target := fsys.lookup(file.h.Linkname)
+			if target == nil || target.h.Typeflag == tar.TypeLink {
+				continue
+			}
+			if target.h.Typeflag == tar.TypeDir {
+				delete(dir, name)
+				continue
+			}
+			h := *file.h
+			h.Typeflag = target.h.Typeflag
+			h.Linkname = target.h.Linkname
+			h.Size = target.h.Size
+			file.h = &h
+			file.ra = target.ra
+			file.off = target.off
+		}
 	}
 
 	if fsys.files[Separator] == nil {
@@ -73,11 +115,25 @@ func From(t *tar.Reader) (*Reader, error) {
 			Typeflag: tar.TypeDir,
 			Size:     0,
 		},
-		data: bytes.NewReader(nil),
-		fs:   fsys,
+		fs: fsys,
 	}
+}
 
-	return fsys, nil
+func (fsys *Reader) lookup(name string) *File {
+	d, f := splitpath(name)
+	if _, ok := fsys.files[d]; !ok {
+		return nil
+	}
+	return fsys.files[d][f]
+}
+
+// Close releases any decoders used to read a compressed archive.
+func (fsys *Reader) Close() error {
+	for _, c := range fsys.closers {
+		c.Close()
+	}
+	fsys.closers = nil
+	return nil
 }
 
 func (fsys *Reader) Open(name string) (fs.File, error) {
@@ -92,8 +148,10 @@ func (fsys *Reader) Open(name string) (fs.File, error) {
 	}
 
 	nf := *file
-	br := *nf.data
-	nf.data = &br
+	if nf.ra == nil {
+		nf.ra = bytes.NewReader(nil)
+	}
+	nf.data = io.NewSectionReader(nf.ra, nf.off, nf.h.Size)
 
 	return &nf, nil
 }
@@ -132,7 +190,9 @@ func (fsys *Reader) Readlink(name string) (string, error) {
 
 type File struct {
 	h      *tar.Header
-	data   *bytes.Reader
+	ra     io.ReaderAt
+	off    int64
+	data   *io.SectionReader
 	closed bool
 	fs     *Reader
 }
@@ -144,6 +204,7 @@ func (f *File) Close() error {
 
 	f.closed = true
 	f.h = nil
+	f.ra = nil
 	f.data = nil
 	f.fs = nil
 
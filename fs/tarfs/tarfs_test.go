@@ -0,0 +1,315 @@
+package tarfs
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"io/fs"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"tractor.dev/wanix/fs/memfs"
+)
+
+func buildTar(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	entries := []struct {
+		hdr  tar.Header
+		body string
+	}{
+		{tar.Header{Name: "dir/", Typeflag: tar.TypeDir, Mode: 0755}, ""},
+		{tar.Header{Name: "dir/hello.txt", Typeflag: tar.TypeReg, Mode: 0644}, "hello, world\n"},
+		{tar.Header{Name: "dir/big.bin", Typeflag: tar.TypeReg, Mode: 0644}, strings.Repeat("0123456789", 10000)},
+		{tar.Header{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "dir/hello.txt", Mode: 0777}, ""},
+		{tar.Header{Name: "hard.txt", Typeflag: tar.TypeLink, Linkname: "dir/hello.txt", Mode: 0644}, ""},
+		{tar.Header{Name: "last.txt", Typeflag: tar.TypeReg, Mode: 0600}, "the end"},
+	}
+	for _, e := range entries {
+		hdr := e.hdr
+		hdr.Size = int64(len(e.body))
+		if err := tw.WriteHeader(&hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(tw, e.body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func gzipBytes(t *testing.T, b []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(b)
+	zw.Close()
+	return buf.Bytes()
+}
+
+func zstdBytes(t *testing.T, b []byte) []byte {
+	t.Helper()
+	enc, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer enc.Close()
+	return enc.EncodeAll(b, nil)
+}
+
+// seekableZstdBytes writes b in the zstd seekable format, one frame per
+// frameSize bytes of input.
+func seekableZstdBytes(t *testing.T, b []byte, frameSize int) []byte {
+	t.Helper()
+	enc, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer enc.Close()
+
+	var out, table bytes.Buffer
+	var count uint32
+	for len(b) > 0 {
+		n := min(frameSize, len(b))
+		frame := enc.EncodeAll(b[:n], nil)
+		out.Write(frame)
+		binary.Write(&table, binary.LittleEndian, uint32(len(frame)))
+		binary.Write(&table, binary.LittleEndian, uint32(n))
+		b = b[n:]
+		count++
+	}
+	binary.Write(&table, binary.LittleEndian, count)
+	table.WriteByte(0)
+	binary.Write(&table, binary.LittleEndian, uint32(zstdSeekableMagic))
+
+	binary.Write(&out, binary.LittleEndian, uint32(zstdSkippableMagic))
+	binary.Write(&out, binary.LittleEndian, uint32(table.Len()))
+	out.Write(table.Bytes())
+	return out.Bytes()
+}
+
+func checkArchive(t *testing.T, fsys *Reader) {
+	t.Helper()
+
+	b, err := fs.ReadFile(fsys, "dir/hello.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "hello, world\n" {
+		t.Fatalf("unexpected hello.txt: %q", b)
+	}
+
+	b, err = fs.ReadFile(fsys, "last.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "the end" {
+		t.Fatalf("unexpected last.txt: %q", b)
+	}
+
+	// read backwards into a file after reading a later one
+	f, err := fsys.Open("dir/big.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	p := make([]byte, 10)
+	if _, err := f.(io.ReaderAt).ReadAt(p, 50005); err != nil {
+		t.Fatal(err)
+	}
+	if string(p) != "5678901234" {
+		t.Fatalf("unexpected ReadAt: %q", p)
+	}
+	b, err = io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) != 100000 || !strings.HasPrefix(string(b), "0123456789") {
+		t.Fatalf("unexpected big.bin: %d bytes", len(b))
+	}
+
+	b, err = fs.ReadFile(fsys, "hard.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "hello, world\n" {
+		t.Fatalf("unexpected hard link contents: %q", b)
+	}
+
+	link, err := fsys.Readlink("link")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if link != "dir/hello.txt" {
+		t.Fatalf("unexpected link target: %q", link)
+	}
+
+	entries, err := fs.ReadDir(fsys, "dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries in dir, got %d", len(entries))
+	}
+}
+
+func TestIndex(t *testing.T) {
+	archive := buildTar(t)
+	for _, tt := range []struct {
+		name string
+		data []byte
+	}{
+		{"plain", archive},
+		{"gzip", gzipBytes(t, archive)},
+		{"zstd", zstdBytes(t, archive)},
+		{"zstd-seekable", seekableZstdBytes(t, archive, 4096)},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys, err := Index(bytes.NewReader(tt.data), int64(len(tt.data)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer fsys.Close()
+			checkArchive(t, fsys)
+		})
+	}
+}
+
+func TestFrom(t *testing.T) {
+	fsys, err := From(tar.NewReader(bytes.NewReader(buildTar(t))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkArchive(t, fsys)
+}
+
+func TestTruncatedArchive(t *testing.T) {
+	archive := buildTar(t)
+	truncated := archive[:len(archive)/2]
+
+	if _, err := From(tar.NewReader(bytes.NewReader(truncated))); err == nil {
+		t.Fatal("expected error from From")
+	}
+	if _, err := Index(bytes.NewReader(truncated), int64(len(truncated))); err == nil {
+		t.Fatal("expected error from Index")
+	}
+}
+
+func TestStreamDecodesOnce(t *testing.T) {
+	data := gzipBytes(t, []byte(strings.Repeat("0123456789", 100000)))
+	opens := 0
+	s := &streamReaderAt{
+		open: func() (io.ReadCloser, error) {
+			opens++
+			return gzip.NewReader(bytes.NewReader(data))
+		},
+	}
+	defer s.Close()
+
+	p := make([]byte, 10)
+	for off := int64(999990); off >= 0; off -= 99999 {
+		if _, err := s.ReadAt(p, off); err != nil {
+			t.Fatal(err)
+		}
+		if want := strings.Repeat("0123456789", 2)[off%10 : off%10+10]; string(p) != want {
+			t.Fatalf("at %d: got %q, want %q", off, p, want)
+		}
+	}
+	if n, err := s.ReadAt(p, 999995); n != 5 || err != io.EOF {
+		t.Fatalf("read at end: %d, %v", n, err)
+	}
+	if opens != 1 {
+		t.Fatalf("stream decoded %d times", opens)
+	}
+}
+
+func TestBlockCache(t *testing.T) {
+	data := gzipBytes(t, []byte(strings.Repeat("0123456789", 100000)))
+	for _, tt := range []struct {
+		name  string
+		spill *memfs.FS
+	}{
+		{"memory", nil},
+		{"spill", memfs.New()},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			opens := 0
+			s := &streamReaderAt{
+				open: func() (io.ReadCloser, error) {
+					opens++
+					return gzip.NewReader(bytes.NewReader(data))
+				},
+			}
+			if tt.spill != nil {
+				s.cache = newBlockCache(4096, 4, tt.spill)
+			} else {
+				s.cache = newBlockCache(4096, 4, nil)
+			}
+			cache := s.cache
+
+			p := make([]byte, 10)
+			for off := int64(999990); off >= 0; off -= 99999 {
+				if _, err := s.ReadAt(p, off); err != nil {
+					t.Fatal(err)
+				}
+				if want := strings.Repeat("0123456789", 2)[off%10 : off%10+10]; string(p) != want {
+					t.Fatalf("at %d: got %q, want %q", off, p, want)
+				}
+				if n := cache.lru.Len(); n > 4 {
+					t.Fatalf("%d blocks in memory", n)
+				}
+			}
+			if n, err := s.ReadAt(p, 999995); n != 5 || err != io.EOF {
+				t.Fatalf("read at end: %d, %v", n, err)
+			}
+
+			if tt.spill == nil {
+				if opens == 1 {
+					t.Fatal("expected evicted blocks to be decoded again")
+				}
+				return
+			}
+			if opens != 1 {
+				t.Fatalf("stream decoded %d times with a spill", opens)
+			}
+			s.Close()
+			if entries, _ := fs.ReadDir(tt.spill, "."); len(entries) != 0 {
+				t.Fatalf("spill not removed: %v", entries)
+			}
+		})
+	}
+}
+
+func TestHardLinkTypes(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, hdr := range []tar.Header{
+		{Name: "dir/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "sym", Typeflag: tar.TypeSymlink, Linkname: "dir", Mode: 0777},
+		{Name: "hardsym", Typeflag: tar.TypeLink, Linkname: "sym"},
+		{Name: "harddir", Typeflag: tar.TypeLink, Linkname: "dir"},
+	} {
+		if err := tw.WriteHeader(&hdr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tw.Close()
+
+	fsys, err := Index(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if link, err := fsys.Readlink("hardsym"); err != nil || link != "dir" {
+		t.Fatalf("hard link to symlink: %q, %v", link, err)
+	}
+	if _, err := fs.Stat(fsys, "harddir"); err == nil {
+		t.Fatal("expected hard link to a directory to be dropped")
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"path"
 	"slices"
 	"strings"
+	"sync"
 
 	"tractor.dev/wanix/fs"
 	"tractor.dev/wanix/fs/bind"
@@ -31,10 +32,19 @@ type BindAllocator interface {
 	BindAllocFS(name string) (fs.FS, error)
 }
 
+// Cloner is implemented by namespace values that are copied, rather
+// than shared, when the namespace is cloned.
+type Cloner interface {
+	Clone() any
+}
+
 // NS represents a namespace with Plan9-style file and directory bindings.
 type NS struct {
 	table *bind.Table
 	ctx   context.Context
+
+	mu     sync.Mutex
+	values map[any]any
 }
 
 var (
@@ -53,11 +63,42 @@ func New(ctx context.Context) *NS {
 	}
 }
 
+// Clone returns a namespace with a copy of the bindings and values of ns.
+// Values implementing Cloner are cloned, others are shared.
 func (ns *NS) Clone(ctx context.Context) *NS {
+	ns.mu.Lock()
+	values := make(map[any]any, len(ns.values))
+	for k, v := range ns.values {
+		if c, ok := v.(Cloner); ok {
+			v = c.Clone()
+		}
+		values[k] = v
+	}
+	ns.mu.Unlock()
 	return &NS{
-		table: ns.table.Clone(),
-		ctx:   ctx,
+		table:  ns.table.Clone(),
+		ctx:    ctx,
+		values: values,
+	}
+}
+
+// Value returns the value attached to the namespace for key, or nil.
+// Like context keys, keys should be of an unexported type.
+func (ns *NS) Value(key any) any {
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+	return ns.values[key]
+}
+
+// SetValue attaches value to the namespace for key. Namespaces cloned
+// from it afterwards get it too.
+func (ns *NS) SetValue(key, value any) {
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+	if ns.values == nil {
+		ns.values = make(map[any]any)
 	}
+	ns.values[key] = value
 }
 
 func (ns *NS) Context() context.Context {
@@ -605,3 +605,33 @@ func TestRecursiveUnion(t *testing.T) {
 		t.Fatalf("bin/b: got %q", content)
 	}
 }
+
+type counter struct{ n int }
+
+func (c *counter) Clone() any { return &counter{n: c.n} }
+
+func TestCloneValues(t *testing.T) {
+	type key struct{ name string }
+	shared, copied := &key{"shared"}, &key{"copied"}
+
+	ns := New(context.Background())
+	if ns.Value(shared) != nil {
+		t.Fatal("expected no value")
+	}
+	s := []string{"a"}
+	ns.SetValue(shared, &s)
+	ns.SetValue(copied, &counter{n: 1})
+
+	child := ns.Clone(context.Background())
+	if child.Value(shared) != &s {
+		t.Fatal("expected shared value")
+	}
+	c := child.Value(copied).(*counter)
+	if c == ns.Value(copied) || c.n != 1 {
+		t.Fatalf("expected a copy, got %v", c)
+	}
+	c.n = 2
+	if ns.Value(copied).(*counter).n != 1 {
+		t.Fatal("clone changed the parent's value")
+	}
+}
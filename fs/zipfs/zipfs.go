@@ -0,0 +1,299 @@
+// zipfs implements a read-only filesystem view of a zip archive. Entries
+// are read from the archive on demand; nothing is buffered up front.
+package zipfs
+
+import (
+	"archive/zip"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+type Reader struct {
+	files map[string]map[string]*File
+}
+
+func splitpath(name string) (dir, file string) {
+	name = path.Clean("/" + strings.TrimPrefix(name, "./"))
+	dir, file = path.Split(name)
+	dir = path.Clean(dir)
+	return
+}
+
+// Index opens the zip archive in r. r must remain valid for the lifetime
+// of the returned Reader.
+func Index(r io.ReaderAt, size int64) (*Reader, error) {
+	z, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+	return From(z), nil
+}
+
+// From builds a Reader over the entries of z. Parent directories that
+// the archive doesn't list explicitly are added.
+func From(z *zip.Reader) *Reader {
+	fsys := &Reader{files: make(map[string]map[string]*File)}
+	for _, zf := range z.File {
+		d, f := splitpath(zf.Name)
+		if f == "" {
+			continue
+		}
+		fsys.mkdirAll(d)
+		fsys.files[d][f] = &File{
+			name: path.Join(d, f),
+			zf:   zf,
+			info: zf.FileInfo(),
+			fs:   fsys,
+		}
+	}
+	// Add a pseudoroot
+	fsys.mkdirAll("/")
+	if _, ok := fsys.files["/"][""]; !ok {
+		fsys.files["/"][""] = fsys.implicitDir("/")
+	}
+	return fsys
+}
+
+// mkdirAll makes sure dir and its parents have entries, synthesizing the
+// ones the archive leaves out.
+func (fsys *Reader) mkdirAll(dir string) {
+	if _, ok := fsys.files[dir]; ok {
+		return
+	}
+	fsys.files[dir] = make(map[string]*File)
+	if dir == "/" {
+		return
+	}
+	d, f := splitpath(dir)
+	fsys.mkdirAll(d)
+	if _, ok := fsys.files[d][f]; !ok {
+		fsys.files[d][f] = fsys.implicitDir(dir)
+	}
+}
+
+func (fsys *Reader) implicitDir(name string) *File {
+	return &File{
+		name: name,
+		info: &dirInfo{name: path.Base(name)},
+		fs:   fsys,
+	}
+}
+
+func (fsys *Reader) lookup(op, name string) (*File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &os.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	d, f := splitpath(name)
+	if _, ok := fsys.files[d]; !ok {
+		return nil, &os.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	file, ok := fsys.files[d][f]
+	if !ok {
+		return nil, &os.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return file, nil
+}
+
+func (fsys *Reader) Open(name string) (fs.File, error) {
+	file, err := fsys.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	nf := &File{
+		name: file.name,
+		zf:   file.zf,
+		info: file.info,
+		fs:   fsys,
+	}
+	return nf, nil
+}
+
+func (fsys *Reader) Stat(name string) (fs.FileInfo, error) {
+	file, err := fsys.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return file.info, nil
+}
+
+func (fsys *Reader) Readlink(name string) (string, error) {
+	file, err := fsys.lookup("readlink", name)
+	if err != nil {
+		return "", err
+	}
+	if file.info.Mode()&fs.ModeSymlink == 0 {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+	rc, err := file.zf.Open()
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+	b, err := io.ReadAll(rc)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+type File struct {
+	name string
+	zf   *zip.File
+	info fs.FileInfo
+	fs   *Reader
+
+	// stored entries are read directly from the archive, compressed
+	// ones through a decompressor that is restarted to seek backwards
+	ra     io.ReaderAt
+	rc     io.ReadCloser
+	rcpos  int64
+	pos    int64
+	dirpos int
+	closed bool
+}
+
+func (f *File) Close() error {
+	if f.closed {
+		return fs.ErrClosed
+	}
+	f.closed = true
+	if f.rc != nil {
+		f.rc.Close()
+		f.rc = nil
+	}
+	f.ra = nil
+	return nil
+}
+
+func (f *File) Read(p []byte) (n int, err error) {
+	n, err = f.ReadAt(p, f.pos)
+	f.pos += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+func (f *File) ReadAt(p []byte, off int64) (n int, err error) {
+	if f.closed {
+		return 0, fs.ErrClosed
+	}
+	if f.info.IsDir() {
+		return 0, fs.ErrInvalid
+	}
+	if off >= f.info.Size() {
+		return 0, io.EOF
+	}
+
+	if f.ra == nil && f.zf.Method == zip.Store {
+		raw, err := f.zf.OpenRaw()
+		if err != nil {
+			return 0, err
+		}
+		if ra, ok := raw.(io.ReaderAt); ok {
+			f.ra = ra
+		}
+	}
+	if f.ra != nil {
+		return f.ra.ReadAt(p, off)
+	}
+
+	if f.rc == nil || off < f.rcpos {
+		if f.rc != nil {
+			f.rc.Close()
+		}
+		f.rc, err = f.zf.Open()
+		if err != nil {
+			return 0, err
+		}
+		f.rcpos = 0
+	}
+	if off > f.rcpos {
+		skipped, err := io.CopyN(io.Discard, f.rc, off-f.rcpos)
+		f.rcpos += skipped
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err = io.ReadFull(f.rc, p)
+	f.rcpos += int64(n)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (f *File) Seek(offset int64, whence int) (int64, error) {
+	if f.closed {
+		return 0, fs.ErrClosed
+	}
+	if f.info.IsDir() {
+		return 0, fs.ErrInvalid
+	}
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.pos
+	case io.SeekEnd:
+		offset += f.info.Size()
+	default:
+		return 0, fs.ErrInvalid
+	}
+	if offset < 0 {
+		return 0, fs.ErrInvalid
+	}
+	f.pos = offset
+	return offset, nil
+}
+
+func (f *File) ReadDir(count int) ([]fs.DirEntry, error) {
+	if f.closed {
+		return nil, fs.ErrClosed
+	}
+	if !f.info.IsDir() {
+		return nil, fs.ErrInvalid
+	}
+
+	d := f.fs.files[f.name]
+	var names []string
+	for n := range d {
+		if n != "" {
+			names = append(names, n)
+		}
+	}
+	sort.Strings(names)
+
+	names = names[min(f.dirpos, len(names)):]
+	if count > 0 {
+		if len(names) == 0 {
+			return nil, io.EOF
+		}
+		names = names[:min(count, len(names))]
+	}
+	f.dirpos += len(names)
+
+	var entries []fs.DirEntry
+	for _, n := range names {
+		entries = append(entries, fs.FileInfoToDirEntry(d[n].info))
+	}
+	return entries, nil
+}
+
+func (f *File) Stat() (fs.FileInfo, error) { return f.info, nil }
+
+// dirInfo describes a directory implied by the paths in the archive.
+type dirInfo struct {
+	name string
+}
+
+func (d *dirInfo) Name() string       { return d.name }
+func (d *dirInfo) Size() int64        { return 0 }
+func (d *dirInfo) Mode() fs.FileMode  { return fs.ModeDir | 0555 }
+func (d *dirInfo) ModTime() time.Time { return time.Time{} }
+func (d *dirInfo) IsDir() bool        { return true }
+func (d *dirInfo) Sys() any           { return nil }
@@ -0,0 +1,80 @@
+package zipfs
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func buildZip(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	add := func(name string, method uint16, mode fs.FileMode, body string) {
+		hdr := &zip.FileHeader{Name: name, Method: method}
+		hdr.SetMode(mode)
+		w, err := zw.CreateHeader(hdr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(w, body)
+	}
+	add("docs/", zip.Store, fs.ModeDir|0755, "")
+	add("docs/readme.txt", zip.Deflate, 0644, "read me\n")
+	add("src/pkg/main.go", zip.Deflate, 0644, strings.Repeat("package main\n", 1000))
+	add("raw.bin", zip.Store, 0644, "0123456789")
+	add("link", zip.Store, fs.ModeSymlink|0777, "docs/readme.txt")
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestZipFS(t *testing.T) {
+	data := buildZip(t)
+	fsys, err := Index(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := fstest.TestFS(fsys, "docs/readme.txt", "src/pkg/main.go", "raw.bin"); err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := fsys.Stat("src/pkg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fi.IsDir() {
+		t.Fatal("expected implicit directory src/pkg")
+	}
+
+	link, err := fsys.Readlink("link")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if link != "docs/readme.txt" {
+		t.Fatalf("unexpected link target: %q", link)
+	}
+
+	// seeking backwards in a compressed entry
+	f, err := fsys.Open("src/pkg/main.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	p := make([]byte, 4)
+	if _, err := f.(io.ReaderAt).ReadAt(p, 13*500+8); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.(io.ReaderAt).ReadAt(p, 8); err != nil {
+		t.Fatal(err)
+	}
+	if string(p) != "main" {
+		t.Fatalf("unexpected ReadAt: %q", p)
+	}
+}
@@ -0,0 +1,41 @@
+//go:build js && wasm
+
+package jsutil
+
+import (
+	"io"
+	"syscall/js"
+)
+
+// BlobReaderAt implements io.ReaderAt over a JS Blob, reading only the
+// requested range with Blob.slice. Browsers may keep large blobs on disk,
+// so this allows random access to big downloads without holding them in
+// wasm memory.
+type BlobReaderAt struct {
+	blob js.Value
+}
+
+func NewBlobReaderAt(blob js.Value) *BlobReaderAt {
+	return &BlobReaderAt{blob: blob}
+}
+
+func (b *BlobReaderAt) Size() int64 {
+	return int64(b.blob.Get("size").Float())
+}
+
+func (b *BlobReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	size := b.Size()
+	if off >= size {
+		return 0, io.EOF
+	}
+	end := min(off+int64(len(p)), size)
+	buf, err := AwaitErr(b.blob.Call("slice", off, end).Call("arrayBuffer"))
+	if err != nil {
+		return 0, err
+	}
+	n := js.CopyBytesToGo(p, js.Global().Get("Uint8Array").New(buf))
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
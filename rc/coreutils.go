@@ -26,6 +26,7 @@ import (
 	"tractor.dev/wanix/rc/bind"
 	"tractor.dev/wanix/rc/find"
 	"tractor.dev/wanix/rc/gzip"
+	"tractor.dev/wanix/rc/hget"
 	"tractor.dev/wanix/rc/invoke"
 	"tractor.dev/wanix/rc/ls"
 	"tractor.dev/wanix/rc/stat"
@@ -61,6 +62,7 @@ var coreutilsCommands = map[string]func() core.Command{
 	rcBindCmd: func() core.Command { return bind.New() },
 	"unbind":  func() core.Command { return unbind.New() },
 	"write":   func() core.Command { return write.New() },
+	"hget":    func() core.Command { return hget.New() },
 }
 
 func urootCoreutilsMiddleware() func(next interp.ExecHandlerFunc) interp.ExecHandlerFunc {
@@ -60,6 +60,11 @@ func runExternalCommand(ctx context.Context, hc interp.HandlerContext, path stri
 		}
 	}
 
+	// interrupts typed at the terminal go to the task
+	if err := AppendFile(filepath.Join(termPath, "ctl"), []byte("fg "+taskID)); err != nil {
+		return 1, err
+	}
+
 	termData, err := os.Open(filepath.Join(termPath, "data"))
 	if err != nil {
 		return 1, err
@@ -67,10 +72,19 @@ func runExternalCommand(ctx context.Context, hc interp.HandlerContext, path stri
 	defer termData.Close()
 
 	if shouldForwardStdin(hc.Stdin) {
-		// todo: do we need to do line discpline?
+		// stdin isn't typed, so it goes to the task as is and ends when
+		// it does, instead of being edited by the line discipline
+		if err := AppendFile(filepath.Join(termPath, "ctl"), []byte("rawon")); err != nil {
+			return 1, err
+		}
+		termInput, err := os.OpenFile(filepath.Join(termPath, "input"), os.O_WRONLY, 0)
+		if err != nil {
+			return 1, err
+		}
 		go func() {
 			println("copying stdin")
-			_, _ = io.Copy(termData, hc.Stdin)
+			_, _ = io.Copy(termInput, hc.Stdin)
+			termInput.Close()
 			println("stdin done")
 		}()
 	}
@@ -87,6 +101,10 @@ func runExternalCommand(ctx context.Context, hc interp.HandlerContext, path stri
 
 	code, err := waitExitCode(ctx, filepath.Join(taskPath, "exit"))
 	if err != nil {
+		if ctx.Err() != nil {
+			// the shell was interrupted, so interrupt the task too
+			_ = AppendFile(filepath.Join(termPath, "ctl"), []byte("intr"))
+		}
 		return 1, err
 	}
 
@@ -0,0 +1,189 @@
+// Package hget implements a small curl-like HTTP client on top of the
+// #net/http device.
+package hget
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/u-root/u-root/pkg/core"
+	"github.com/u-root/u-root/pkg/uroot/unixflag"
+)
+
+// DevicePath is where the http device is expected.
+const DevicePath = "#net/http"
+
+type command struct {
+	core.Base
+}
+
+// New creates a new hget command.
+func New() core.Command {
+	c := &command{}
+	c.Init()
+	return c
+}
+
+func (c *command) Run(args ...string) error {
+	return c.RunContext(context.Background(), args...)
+}
+
+type headers []string
+
+func (h *headers) String() string     { return strings.Join(*h, ", ") }
+func (h *headers) Set(v string) error { *h = append(*h, v); return nil }
+
+func (c *command) RunContext(ctx context.Context, args ...string) error {
+	var (
+		method  string
+		data    string
+		output  string
+		device  string
+		include bool
+		fail    bool
+		hdrs    headers
+	)
+
+	fs := flag.NewFlagSet("hget", flag.ContinueOnError)
+	fs.SetOutput(c.Stderr)
+	fs.StringVar(&method, "X", "", "request method (default GET, or POST with -d)")
+	fs.Var(&hdrs, "H", "add a `Name: value` request header (repeatable)")
+	fs.StringVar(&data, "d", "", "request body, or @FILE to read it from FILE (- for stdin)")
+	fs.StringVar(&output, "o", "", "write the response to FILE instead of stdout")
+	fs.StringVar(&device, "n", DevicePath, "http device directory")
+	fs.BoolVar(&include, "i", false, "print the status and headers before the response")
+	fs.BoolVar(&fail, "f", false, "fail on status 400 and above without printing the response")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: hget [-i] [-f] [-X METHOD] [-H HEADER]... [-d DATA] [-o FILE] URL\n\n")
+		fmt.Fprintf(fs.Output(), "Makes an HTTP request through %s and prints the response.\n\n", DevicePath)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(unixflag.ArgsToGoArgs(args)); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("expected one URL")
+	}
+	url := fs.Arg(0)
+	if !strings.Contains(url, "://") {
+		url = "http://" + url
+	}
+
+	var body []byte
+	if data != "" {
+		var err error
+		body, err = c.readData(data)
+		if err != nil {
+			return err
+		}
+		if method == "" {
+			method = "POST"
+		}
+	}
+
+	dev := c.ResolvePath(device)
+	idb, err := os.ReadFile(filepath.Join(dev, "new"))
+	if err != nil {
+		return err
+	}
+	dir := filepath.Join(dev, strings.TrimSpace(string(idb)))
+	ctl := func(cmd string) error {
+		return os.WriteFile(filepath.Join(dir, "ctl"), []byte(cmd+"\n"), 0644)
+	}
+	defer ctl("hangup")
+
+	if method != "" {
+		if err := ctl("method " + quote(method)); err != nil {
+			return err
+		}
+	}
+	if err := ctl("url " + quote(url)); err != nil {
+		return err
+	}
+	for _, h := range hdrs {
+		name, value, ok := strings.Cut(h, ":")
+		if !ok {
+			return fmt.Errorf("bad header %q", h)
+		}
+		if err := ctl("header " + quote(strings.TrimSpace(name)) + " " + quote(strings.TrimSpace(value))); err != nil {
+			return err
+		}
+	}
+	if body != nil {
+		if err := os.WriteFile(filepath.Join(dir, "body"), body, 0644); err != nil {
+			return err
+		}
+	}
+
+	status, err := os.ReadFile(filepath.Join(dir, "status"))
+	if err != nil {
+		return err
+	}
+	if include {
+		respHeaders, err := os.ReadFile(filepath.Join(dir, "headers"))
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(c.Stdout, "%s%s\n", status, respHeaders)
+	}
+	if fail {
+		code, _, _ := strings.Cut(string(status), " ")
+		if n, err := strconv.Atoi(code); err == nil && n >= 400 {
+			return errors.New(strings.TrimSpace(string(status)))
+		}
+	}
+
+	out := c.Stdout
+	if output != "" {
+		f, err := os.Create(c.ResolvePath(output))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	resp, err := os.Open(filepath.Join(dir, "response"))
+	if err != nil {
+		return err
+	}
+	defer resp.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(out, resp)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		ctl("hangup")
+		<-done
+		return ctx.Err()
+	}
+}
+
+// readData returns the body given to -d.
+func (c *command) readData(data string) ([]byte, error) {
+	name, ok := strings.CutPrefix(data, "@")
+	if !ok {
+		return []byte(data), nil
+	}
+	if name == "-" {
+		return io.ReadAll(c.Stdin)
+	}
+	return os.ReadFile(c.ResolvePath(name))
+}
+
+// quote quotes s as a single argument for a ctl file.
+func quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'"'"'`) + "'"
+}
@@ -12,6 +12,8 @@ import (
 	"tractor.dev/toolkit-go/engine/cli"
 	"tractor.dev/wanix/fs"
 	"tractor.dev/wanix/fs/fskit"
+	"tractor.dev/wanix/fs/net/egress"
+	"tractor.dev/wanix/fs/signal"
 	"tractor.dev/wanix/fs/vfs"
 	"tractor.dev/wanix/misc"
 	"tractor.dev/wanix/misc/shlex"
@@ -57,6 +59,7 @@ type Task struct {
 	fsys   *TaskFS
 	worker any
 	export fs.FS
+	notes  *signal.Broadcaster
 	mu     sync.Mutex
 }
 
@@ -149,6 +152,19 @@ func (r *Task) Parent() *Task {
 	return r.parent
 }
 
+// descendsFrom reports whether t is an ancestor of r.
+func (r *Task) descendsFrom(t *Task) bool {
+	if t == nil {
+		return false
+	}
+	for p := r.parent; p != nil; p = p.parent {
+		if p == t {
+			return true
+		}
+	}
+	return false
+}
+
 func (r *Task) Root() *Task {
 	if r.parent == nil {
 		return r
@@ -325,10 +341,31 @@ func (r *Task) taskMap() fskit.MapFS {
 			}
 			return nil
 		}),
+		"note": signal.NewFS(r.notes),
 		"binds": fskit.OpenFunc(func(ctx context.Context, name string) (fs.File, error) {
 			return fskit.Entry("binds", 0555, []byte(r.NS().String()+"\n")).Open(name)
 		}),
 		"ns": r.ns,
+		"netpolicy": fskit.OpenFunc(func(ctx context.Context, name string) (fs.File, error) {
+			writer, _ := FromContext(ctx)
+			return fs.OpenContext(ctx, misc.FieldFile(func() (string, error) {
+				return egress.For(r.ns).String(), nil
+			}, func(in []byte) error {
+				// an open and close without a write shouldn't clear it
+				if len(strings.TrimSpace(string(in))) == 0 {
+					return nil
+				}
+				// only the tasks above it can widen its policy, so one
+				// can't undo what it was started with
+				if r.descendsFrom(writer) {
+					return egress.For(r.ns).SetRules(string(in))
+				}
+				return egress.For(r.ns).Narrow(string(in))
+			}), name)
+		}),
+		"netaudit": misc.FieldFile(func() (string, error) {
+			return egress.For(r.ns).Audit().String(), nil
+		}),
 	}
 	if r.export != nil {
 		m["export"] = r.export
@@ -336,6 +373,26 @@ func (r *Task) taskMap() fskit.MapFS {
 	return m
 }
 
+// Note posts a note to the task. Notes are read from the task's note file.
+// If nothing is reading notes, an interrupt ends the task with exit
+// status 130.
+func (r *Task) Note(note string) {
+	if r.notes.SubscriberCount() > 0 {
+		r.notes.Broadcast([]byte(note+"\n"), signal.NoExclude)
+		return
+	}
+	if note != "interrupt" {
+		return
+	}
+	r.mu.Lock()
+	r.exit = "130"
+	closer := r.closer
+	r.mu.Unlock()
+	if closer != nil {
+		go closer()
+	}
+}
+
 func (r *Task) Route(ctx context.Context, name string) (fs.FS, string, error) {
 	return r.taskMap().Route(ctx, name)
 }
@@ -407,6 +464,7 @@ func (d *TaskFS) Alloc(kind string, parent *Task) (*Task, error) {
 		kind:   kind,
 		fds:    make(map[int]*openFile),
 		fdIdx:  3,
+		notes:  signal.NewBroadcasterWithOptions(signal.Options{Policy: signal.DropOldest}),
 	}
 	ctx := context.WithValue(context.Background(), TaskContextKey, p)
 	if parent != nil {
@@ -414,6 +472,8 @@ func (d *TaskFS) Alloc(kind string, parent *Task) (*Task, error) {
 		p.ns = parent.ns.Clone(ctx)
 	} else {
 		p.ns = vfs.New(ctx)
+		// attached up front so tasks started from this one layer on it
+		egress.For(p.ns)
 	}
 	d.resources[rid] = p
 	return p, nil
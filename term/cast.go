@@ -0,0 +1,169 @@
+package term
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// castHeader is the first line of an asciicast v2 file.
+type castHeader struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// Event codes in an asciicast v2 file.
+const (
+	castOutput = "o"
+	castInput  = "i"
+	castResize = "r"
+	castMarker = "m"
+)
+
+// recorder writes terminal events to w in asciicast v2 format. Events are
+// written from a goroutine so a slow file doesn't hold up the terminal.
+// Events that don't fit in the queue are dropped, and a marker saying how
+// many were is recorded in their place once there is room.
+type recorder struct {
+	mu      sync.Mutex
+	start   time.Time
+	pending map[string][]byte // trailing partial UTF-8 by event code
+	events  chan []byte
+	dropped int
+	stopped bool
+
+	w    io.WriteCloser
+	err  error
+	done chan struct{}
+}
+
+func newRecorder(w io.WriteCloser, cols, rows int) (*recorder, error) {
+	start := time.Now()
+	header, err := json.Marshal(castHeader{
+		Version:   2,
+		Width:     cols,
+		Height:    rows,
+		Timestamp: start.Unix(),
+		Env:       map[string]string{"TERM": "xterm-256color"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(append(header, '\n')); err != nil {
+		w.Close()
+		return nil, err
+	}
+	r := &recorder{
+		start:   start,
+		pending: make(map[string][]byte),
+		events:  make(chan []byte, 256),
+		w:       w,
+		done:    make(chan struct{}),
+	}
+	go r.loop()
+	return r, nil
+}
+
+func (r *recorder) loop() {
+	defer close(r.done)
+	for ev := range r.events {
+		if r.err == nil {
+			_, r.err = r.w.Write(ev)
+		}
+	}
+}
+
+// event records data with the time since recording started. Data is held
+// back until it ends on a whole UTF-8 sequence since events are strings.
+func (r *recorder) event(code string, data []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stopped {
+		return
+	}
+	data = append(r.pending[code], data...)
+	data, rest := splitUTF8(data)
+	r.pending[code] = append([]byte(nil), rest...)
+	if len(data) == 0 {
+		return
+	}
+	elapsed := time.Since(r.start)
+	if r.dropped > 0 {
+		if !r.send(elapsed, castMarker, droppedLabel(r.dropped)) {
+			r.dropped++
+			return
+		}
+		r.dropped = 0
+	}
+	if !r.send(elapsed, code, string(data)) {
+		r.dropped++
+	}
+}
+
+// send queues an event without waiting, reporting whether there was room.
+// Caller must hold mu.
+func (r *recorder) send(elapsed time.Duration, code, data string) bool {
+	select {
+	case r.events <- castEvent(elapsed, code, data):
+		return true
+	default:
+		return false
+	}
+}
+
+// castEvent encodes an event line.
+func castEvent(elapsed time.Duration, code, data string) []byte {
+	ev, _ := json.Marshal([]any{
+		json.Number(strconv.FormatFloat(elapsed.Seconds(), 'f', 6, 64)),
+		code,
+		data,
+	})
+	return append(ev, '\n')
+}
+
+func droppedLabel(n int) string {
+	return fmt.Sprintf("dropped %d events", n)
+}
+
+// stop finishes writing events and closes the file.
+func (r *recorder) stop() error {
+	r.mu.Lock()
+	if r.stopped {
+		r.mu.Unlock()
+		return nil
+	}
+	r.stopped = true
+	dropped := r.dropped
+	r.mu.Unlock()
+	// no more events are queued once stopped, so this can wait for room
+	// without holding up the terminal
+	if dropped > 0 {
+		r.events <- castEvent(time.Since(r.start), castMarker, droppedLabel(dropped))
+	}
+	close(r.events)
+	<-r.done
+	if err := r.w.Close(); r.err == nil {
+		r.err = err
+	}
+	return r.err
+}
+
+// splitUTF8 splits p before an incomplete UTF-8 sequence at its end.
+func splitUTF8(p []byte) (whole, rest []byte) {
+	for i := len(p) - 1; i >= 0 && i >= len(p)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(p[i]) {
+			if !utf8.FullRune(p[i:]) {
+				return p[:i], p[i:]
+			}
+			break
+		}
+	}
+	return p, nil
+}
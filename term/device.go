@@ -8,7 +8,6 @@ import (
 	"tractor.dev/wanix"
 	"tractor.dev/wanix/fs"
 	"tractor.dev/wanix/fs/fskit"
-	"tractor.dev/wanix/fs/pipe"
 	"tractor.dev/wanix/fs/signal"
 )
 
@@ -87,27 +86,76 @@ func (d *Device) Alloc() (rid string, err error) {
 
 	d.nextID++
 	rid = strconv.Itoa(d.nextID)
-	hub := signal.NewBroadcaster()
-	_, dataPF, progPF := pipe.NewFS(true)
+	// a slow winch reader only misses sizes it would have skipped past,
+	// and a new one starts with the current size
+	hub := signal.NewBroadcasterWithOptions(signal.Options{Policy: signal.Latest, Replay: true})
 	// remove := func() {
 	// 	d.remove(rid)
 	// }
-	progWrap := &programFile{PortFile: progPF}
-	root := fskit.MapFS{
+	res := &Resource{id: rid, hub: hub, screen: newScreen(DefaultCols, DefaultRows)}
+	res.interrupt = func() { d.interrupt(res) }
+	out := newFanout(res.screen)
+	res.out = out
+	// echoes go through the screen to viewers like program output
+	res.ld = newLdisc(out, out, res.interrupt)
+	res.end = res.ld
+	progFile := &programFile{out: out, ld: res.ld}
+	res.MapFS = fskit.MapFS{
 		"id":      fskit.RawNode([]byte(rid+"\n"), 0555),
-		"data":    fskit.FileFS(dataPF, "data"),
-		"program": fskit.FileFS(progWrap, "program"),
+		"data":    &dataFS{out: out, ld: res.ld},
+		"input":   &inputFS{out: out, ld: res.ld},
+		"program": fskit.FileFS(progFile, "program"),
 		"winch":   signal.NewFS(hub),
+		"ctl": fskit.OpenFunc(func(ctx context.Context, name string) (fs.File, error) {
+			if name == "." {
+				return &fskit.FuncFile{
+					Node: fskit.Entry(name, 0222),
+					CloseFunc: func(n *fskit.Node) error {
+						return res.control(ctx, string(n.Data()))
+					},
+				}, nil
+			}
+			return nil, fs.ErrNotExist
+		}),
+		"mode":       readFile(res.mode),
+		"size":       readFile(res.size),
+		"screen":     readFile(res.screen.Text),
+		"scrollback": readFile(res.screen.Scrollback),
 	}
-	d.resources[rid] = &Resource{
-		id:    rid,
-		MapFS: root,
-		hub:   hub,
-		end:   progPF.Port,
-	}
+	d.resources[rid] = res
 	return rid, nil
 }
 
+// readFile returns a read-only file with the contents returned by read
+// when it is opened.
+func readFile(read func() string) fs.FS {
+	return fskit.OpenFunc(func(ctx context.Context, name string) (fs.File, error) {
+		if name == "." {
+			return &fskit.FuncFile{
+				Node: fskit.Entry(name, 0444),
+				ReadFunc: func(n *fskit.Node) error {
+					fskit.SetData(n, []byte(read()))
+					return nil
+				},
+			}, nil
+		}
+		return nil, fs.ErrNotExist
+	})
+}
+
+// interrupt delivers an interrupt note to the terminal's foreground task.
+func (d *Device) interrupt(res *Resource) {
+	fg := res.Foreground()
+	if d.root == nil || fg == "" {
+		return
+	}
+	t, err := d.root.Lookup(fg)
+	if err != nil {
+		return
+	}
+	t.Note("interrupt")
+}
+
 func (d *Device) remove(rid string) {
 	d.mu.Lock()
 	res, err := d.Get(rid)
@@ -0,0 +1,260 @@
+package term
+
+import (
+	"context"
+	"io"
+	"os"
+	"sync"
+
+	"tractor.dev/wanix/fs"
+	"tractor.dev/wanix/fs/fskit"
+	"tractor.dev/wanix/fs/pipe"
+)
+
+// viewerBuffer is how much output is held for a viewer that isn't reading.
+const viewerBuffer = 256 << 10
+
+// fanout copies terminal output to the screen and to every attached
+// viewer. A viewer that attaches after output has been written first
+// receives a redraw of the screen. Output and input are also passed to
+// a recorder while there is one.
+type fanout struct {
+	mu      sync.Mutex
+	screen  *screen
+	viewers map[*viewer]struct{}
+	rec     *recorder
+	closed  bool
+}
+
+// viewer is the output held for one attached viewer. When more than
+// viewerBuffer is waiting, further output is dropped, and once the viewer
+// has read what was held it is sent a redraw of the screen instead.
+type viewer struct {
+	buf    *pipe.Buffer
+	behind bool
+}
+
+func newFanout(scr *screen) *fanout {
+	return &fanout{screen: scr, viewers: make(map[*viewer]struct{})}
+}
+
+func (f *fanout) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return 0, io.ErrClosedPipe
+	}
+	f.screen.Write(p)
+	if f.rec != nil {
+		f.rec.event(castOutput, p)
+	}
+	for v := range f.viewers {
+		switch {
+		case v.behind:
+		case v.buf.Size()+len(p) <= viewerBuffer:
+			v.buf.Write(p)
+		case v.buf.Size() == 0:
+			// too big to hold, but there's nothing to catch up on
+			v.buf.Write(f.screen.Redraw())
+		default:
+			v.behind = true
+		}
+	}
+	return len(p), nil
+}
+
+// read reads output for v, first queuing a redraw if v has caught up
+// after falling behind.
+func (f *fanout) read(v *viewer, p []byte) (int, error) {
+	f.mu.Lock()
+	if v.behind && v.buf.Size() == 0 {
+		v.behind = false
+		v.buf.Write(f.screen.Redraw())
+	}
+	f.mu.Unlock()
+	return v.buf.Read(p)
+}
+
+// input records input from a viewer.
+func (f *fanout) input(p []byte) {
+	f.mu.Lock()
+	rec := f.rec
+	f.mu.Unlock()
+	if rec != nil {
+		rec.event(castInput, p)
+	}
+}
+
+// setRecorder sets the recorder, returning the one it replaces.
+func (f *fanout) setRecorder(rec *recorder) *recorder {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	old := f.rec
+	f.rec = rec
+	return old
+}
+
+// attach returns a viewer that receives output from now on.
+func (f *fanout) attach() *viewer {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	v := &viewer{buf: pipe.NewBuffer(true)}
+	if redraw := f.screen.Redraw(); redraw != nil {
+		v.buf.Write(redraw)
+	}
+	if f.closed {
+		v.buf.Close()
+		return v
+	}
+	f.viewers[v] = struct{}{}
+	return v
+}
+
+func (f *fanout) detach(v *viewer) {
+	f.mu.Lock()
+	delete(f.viewers, v)
+	f.mu.Unlock()
+	v.buf.Close()
+}
+
+// Close ends output, so viewers read EOF once they've read what's left.
+func (f *fanout) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = true
+	for v := range f.viewers {
+		v.buf.Close()
+	}
+	f.viewers = nil
+	return nil
+}
+
+// dataFS exposes the data file at ".". Each open that reads attaches a
+// viewer; O_WRONLY opens only send input.
+type dataFS struct {
+	out *fanout
+	ld  *ldisc
+}
+
+func (d *dataFS) Route(ctx context.Context, name string) (fs.FS, string, error) {
+	if name == "." {
+		return d, ".", nil
+	}
+	return nil, "", &fs.PathError{Op: "route", Path: name, Err: fs.ErrNotExist}
+}
+
+func (d *dataFS) Open(name string) (fs.File, error) {
+	return d.OpenContext(context.Background(), name)
+}
+
+func (d *dataFS) OpenContext(ctx context.Context, name string) (fs.File, error) {
+	return d.OpenFile(name, os.O_RDWR, 0)
+}
+
+func (d *dataFS) OpenFile(name string, flag int, perm fs.FileMode) (fs.File, error) {
+	if name != "." {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	f := &dataFile{out: d.out, ld: d.ld}
+	if flag&0x3 != os.O_WRONLY {
+		f.view = d.out.attach()
+	}
+	return f, nil
+}
+
+// dataFile is one viewer's open data file: reads are the program's
+// output and writes are input to the line discipline.
+type dataFile struct {
+	out  *fanout
+	view *viewer
+	ld   *ldisc
+	once sync.Once
+}
+
+func (d *dataFile) Read(p []byte) (int, error) {
+	if d.view == nil {
+		return 0, fs.ErrPermission
+	}
+	return d.out.read(d.view, p)
+}
+
+func (d *dataFile) ReadAt(p []byte, off int64) (int, error) {
+	return d.Read(p)
+}
+
+func (d *dataFile) Write(p []byte) (int, error) {
+	d.out.input(p)
+	return d.ld.Write(p)
+}
+
+func (d *dataFile) WriteAt(p []byte, off int64) (int, error) {
+	return d.Write(p)
+}
+
+func (d *dataFile) Stat() (fs.FileInfo, error) {
+	var size int
+	if d.view != nil {
+		size = d.view.buf.Size()
+	}
+	return fskit.Entry("data", fs.FileMode(0644), int64(size)), nil
+}
+
+func (d *dataFile) Close() error {
+	if d.view != nil {
+		d.once.Do(func() { d.out.detach(d.view) })
+	}
+	return nil
+}
+
+// inputFS exposes the input file at ".". Opens for writing send input and
+// end it when they are closed.
+type inputFS struct {
+	out *fanout
+	ld  *ldisc
+}
+
+func (d *inputFS) Route(ctx context.Context, name string) (fs.FS, string, error) {
+	if name == "." {
+		return d, ".", nil
+	}
+	return nil, "", &fs.PathError{Op: "route", Path: name, Err: fs.ErrNotExist}
+}
+
+func (d *inputFS) Stat(name string) (fs.FileInfo, error) {
+	if name != "." {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	return fskit.Entry("input", fs.FileMode(0222), 0), nil
+}
+
+func (d *inputFS) Open(name string) (fs.File, error) {
+	return d.OpenFile(name, os.O_RDONLY, 0)
+}
+
+func (d *inputFS) OpenFile(name string, flag int, perm fs.FileMode) (fs.File, error) {
+	if name != "." {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	f := &inputFile{dataFile{out: d.out, ld: d.ld}}
+	if flag&0x3 == os.O_RDONLY {
+		// nothing was sent, so there's nothing to end
+		f.once.Do(func() {})
+	}
+	return f, nil
+}
+
+// inputFile is an open input file: writes are input like those to data,
+// and closing it ends input, so forwarded input such as a pipe can end
+// without a ^D in it being taken as EOF.
+type inputFile struct {
+	dataFile
+}
+
+func (f *inputFile) Stat() (fs.FileInfo, error) {
+	return fskit.Entry("input", fs.FileMode(0222), 0), nil
+}
+
+func (f *inputFile) Close() error {
+	f.once.Do(f.ld.EOF)
+	return nil
+}
@@ -0,0 +1,253 @@
+package term
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"unicode/utf8"
+)
+
+// Control characters handled in cooked mode.
+const (
+	charIntr   = 0x03 // ^C
+	charEOF    = 0x04 // ^D
+	charBS     = 0x08 // ^H
+	charKill   = 0x15 // ^U
+	charWerase = 0x17 // ^W
+	charErase  = 0x7f // DEL, sent by most terminals for backspace
+)
+
+// ldisc is a terminal line discipline. Input written by the terminal is
+// queued for the program to read. In cooked mode input is echoed and
+// edited a line at a time: erase, kill and word erase edit the line,
+// return or newline delivers it, ^D delivers it without a newline or, on
+// an empty line, makes the next read return EOF, and ^C discards it and
+// interrupts. In raw mode every byte is delivered as it arrives.
+type ldisc struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	raw    bool
+	echo   bool
+	line   []byte   // line being edited in cooked mode
+	queue  [][]byte // input ready to read; an empty chunk is an EOF
+	closed bool
+
+	out    io.Writer // echoes to the terminal
+	intr   func()    // called on an interrupt
+	closer io.Closer // the program's end of the terminal
+}
+
+func newLdisc(out io.Writer, closer io.Closer, intr func()) *ldisc {
+	l := &ldisc{echo: true, out: out, closer: closer, intr: intr}
+	l.cond = sync.NewCond(&l.mu)
+	return l
+}
+
+// Write processes input from the terminal.
+func (l *ldisc) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return 0, io.ErrClosedPipe
+	}
+	if len(p) == 0 {
+		// an empty chunk would read as EOF
+		l.mu.Unlock()
+		return 0, nil
+	}
+	if l.raw {
+		l.queue = append(l.queue, append([]byte(nil), p...))
+		l.cond.Broadcast()
+		l.mu.Unlock()
+		return len(p), nil
+	}
+
+	var echo []byte
+	interrupted := false
+	for _, b := range p {
+		switch b {
+		case '\r', '\n':
+			l.line = append(l.line, '\n')
+			l.deliver()
+			echo = append(echo, '\r', '\n')
+		case charEOF:
+			// an empty chunk is an EOF
+			l.deliver()
+		case charErase, charBS:
+			echo = append(echo, l.erase()...)
+		case charKill:
+			for len(l.line) > 0 {
+				echo = append(echo, l.erase()...)
+			}
+		case charWerase:
+			for len(l.line) > 0 && isSpace(l.line[len(l.line)-1]) {
+				echo = append(echo, l.erase()...)
+			}
+			for len(l.line) > 0 && !isSpace(l.line[len(l.line)-1]) {
+				echo = append(echo, l.erase()...)
+			}
+		case charIntr:
+			// drop the line and anything not yet read
+			l.line = nil
+			l.queue = nil
+			echo = append(echo, "^C\r\n"...)
+			interrupted = true
+		default:
+			l.line = append(l.line, b)
+			echo = append(echo, echoChar(b)...)
+		}
+	}
+	l.cond.Broadcast()
+	if !l.echo {
+		echo = nil
+	}
+	l.mu.Unlock()
+
+	if len(echo) > 0 {
+		l.out.Write(echo)
+	}
+	if interrupted && l.intr != nil {
+		l.intr()
+	}
+	return len(p), nil
+}
+
+// deliver queues the line being edited. Caller must hold mu.
+func (l *ldisc) deliver() {
+	l.queue = append(l.queue, l.line)
+	l.line = nil
+}
+
+// erase removes the last character of the line, returning what erases it
+// on screen. Caller must hold mu.
+func (l *ldisc) erase() []byte {
+	if len(l.line) == 0 {
+		return nil
+	}
+	r, size := utf8.DecodeLastRune(l.line)
+	l.line = l.line[:len(l.line)-size]
+	if r < 0x20 && r != '\t' {
+		// control characters are echoed as two columns
+		return []byte("\b \b\b \b")
+	}
+	return []byte("\b \b")
+}
+
+// Read returns input for the program, at most one line at a time in
+// cooked mode. It blocks until there is input.
+func (l *ldisc) Read(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for len(l.queue) == 0 && !l.closed {
+		l.cond.Wait()
+	}
+	if len(l.queue) == 0 {
+		return 0, io.EOF
+	}
+	chunk := l.queue[0]
+	if len(chunk) == 0 {
+		l.queue = l.queue[1:]
+		return 0, io.EOF
+	}
+	n := copy(p, chunk)
+	if n < len(chunk) {
+		l.queue[0] = chunk[n:]
+	} else {
+		l.queue = l.queue[1:]
+	}
+	return n, nil
+}
+
+// Size returns the number of bytes ready to read.
+func (l *ldisc) Size() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	n := 0
+	for _, chunk := range l.queue {
+		n += len(chunk)
+	}
+	return n
+}
+
+// SetRaw switches between raw and cooked mode. A line being edited is
+// delivered when switching to raw mode.
+func (l *ldisc) SetRaw(raw bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if raw && len(l.line) > 0 {
+		l.deliver()
+		l.cond.Broadcast()
+	}
+	l.raw = raw
+}
+
+// SetEcho turns echoing in cooked mode on or off.
+func (l *ldisc) SetEcho(echo bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.echo = echo
+}
+
+// Mode returns the terminal attributes, one per line.
+func (l *ldisc) Mode() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return fmt.Sprintf("raw %s\necho %s\nintr %s\neof %s\nerase %s\nkill %s\nwerase %s\n",
+		onOff(l.raw), onOff(l.echo),
+		caret(charIntr), caret(charEOF), caret(charErase), caret(charKill), caret(charWerase))
+}
+
+// EOF makes the program's next read, once the input before it has been
+// read, return EOF, as ^D on an empty line does in cooked mode. A line
+// being edited is delivered first.
+func (l *ldisc) EOF() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return
+	}
+	if len(l.line) > 0 {
+		l.deliver()
+	}
+	l.queue = append(l.queue, nil)
+	l.cond.Broadcast()
+}
+
+// Close ends input, closing the program's end of the terminal.
+func (l *ldisc) Close() error {
+	l.mu.Lock()
+	l.closed = true
+	l.cond.Broadcast()
+	l.mu.Unlock()
+	if l.closer != nil {
+		return l.closer.Close()
+	}
+	return nil
+}
+
+// echoChar returns how b is echoed, showing control characters like ^X
+// so escape sequences from arrow keys aren't interpreted.
+func echoChar(b byte) []byte {
+	if b < 0x20 && b != '\t' {
+		return []byte{'^', b + 0x40}
+	}
+	return []byte{b}
+}
+
+func caret(b byte) string {
+	if b == charErase {
+		return "^?"
+	}
+	return string([]byte{'^', b + 0x40})
+}
+
+func onOff(b bool) string {
+	if b {
+		return "on"
+	}
+	return "off"
+}
+
+func isSpace(b byte) bool {
+	return b == ' ' || b == '\t'
+}
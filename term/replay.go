@@ -0,0 +1,201 @@
+package term
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"tractor.dev/wanix"
+	"tractor.dev/wanix/fs"
+	"tractor.dev/wanix/misc/shlex"
+)
+
+// ReplayDriver is a task driver that plays an asciicast v2 recording. The
+// task's command is the cast file and options:
+//
+//	-speed n   play n times as fast (default 1)
+//	-idle s    cap pauses at s seconds (default no cap)
+//	-term p    play into the terminal at p, resizing it as recorded,
+//	           instead of writing to fd 1
+//
+// Register it on the root task (e.g. root.Register("replay", &term.ReplayDriver{})).
+type ReplayDriver struct{}
+
+var _ wanix.TaskDriver = (*ReplayDriver)(nil)
+
+func (d *ReplayDriver) Check(t *wanix.Task) bool {
+	return strings.HasSuffix(t.Arg(0), ".cast")
+}
+
+func (d *ReplayDriver) Start(t *wanix.Task) error {
+	args, err := shlex.Split(t.Cmd(), true)
+	if err != nil {
+		return err
+	}
+	var name string
+	if len(args) > 0 && strings.HasSuffix(args[0], ".cast") {
+		name, args = args[0], args[1:]
+	}
+	var (
+		speed float64
+		idle  float64
+		term  string
+	)
+	flags := flag.NewFlagSet("replay", flag.ContinueOnError)
+	flags.Float64Var(&speed, "speed", 1, "playback speed")
+	flags.Float64Var(&idle, "idle", 0, "longest pause in seconds")
+	flags.StringVar(&term, "term", "", "terminal to play into")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if name == "" {
+		name = flags.Arg(0)
+	}
+	if name == "" {
+		return fmt.Errorf("replay: no cast file")
+	}
+	if speed <= 0 {
+		return fmt.Errorf("replay: bad speed: %v", speed)
+	}
+
+	ctx := t.Context()
+	ns := t.NS()
+	if dir := t.Dir(); dir != "" && !path.IsAbs(name) {
+		name = path.Join(dir, name)
+	}
+	cast, err := fs.OpenContext(ctx, ns, strings.TrimPrefix(path.Clean(name), "/"))
+	if err != nil {
+		return err
+	}
+
+	var (
+		out    io.Writer
+		prog   fs.File // the terminal's program file, closed when play ends
+		resize func(cols, rows int)
+	)
+	if term != "" {
+		term = strings.TrimPrefix(path.Clean(term), "/")
+		prog, err = fs.OpenFile(ns, path.Join(term, "program"), os.O_WRONLY, 0)
+		if err != nil {
+			cast.Close()
+			return err
+		}
+		w, ok := prog.(io.Writer)
+		if !ok {
+			prog.Close()
+			cast.Close()
+			return fmt.Errorf("replay: %s is not writable", term)
+		}
+		out = w
+		resize = func(cols, rows int) {
+			fs.WriteFile(ns, path.Join(term, "ctl"), []byte(fmt.Sprintf("size %d %d", cols, rows)), 0)
+		}
+	} else {
+		stdout, _, err := t.FD(1)
+		if err != nil {
+			cast.Close()
+			return fmt.Errorf("open task fd 1: %w", err)
+		}
+		w, ok := stdout.(io.Writer)
+		if !ok {
+			cast.Close()
+			return fmt.Errorf("task fd 1 is not writable")
+		}
+		out = w
+	}
+
+	go func() {
+		code := 0
+		if err := play(ctx, cast, out, resize, speed, time.Duration(idle*float64(time.Second))); err != nil {
+			fmt.Fprintln(out, "replay:", err)
+			code = 1
+		}
+		cast.Close()
+		if prog != nil {
+			prog.Close()
+		}
+		f, err := t.Open("exit")
+		if err != nil {
+			return
+		}
+		_, _ = fs.Write(f, []byte(strconv.Itoa(code)))
+		_ = f.Close()
+	}()
+	return nil
+}
+
+// play writes the output events of the asciicast in r to out with their
+// recorded timing divided by speed. Pauses are capped at idle if it is
+// positive. Resizes are passed to resize if it isn't nil.
+func play(ctx context.Context, r io.Reader, out io.Writer, resize func(cols, rows int), speed float64, idle time.Duration) error {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	if !sc.Scan() {
+		if err := sc.Err(); err != nil {
+			return err
+		}
+		return fmt.Errorf("empty cast")
+	}
+	var header castHeader
+	if err := json.Unmarshal(sc.Bytes(), &header); err != nil {
+		return fmt.Errorf("bad header: %w", err)
+	}
+	if header.Version != 2 {
+		return fmt.Errorf("unsupported cast version: %d", header.Version)
+	}
+	if resize != nil && header.Width > 0 && header.Height > 0 {
+		resize(header.Width, header.Height)
+	}
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	<-timer.C
+	var last float64
+	for sc.Scan() {
+		line := sc.Bytes()
+		if len(strings.TrimSpace(string(line))) == 0 {
+			continue
+		}
+		var (
+			at   float64
+			code string
+			data string
+		)
+		if err := json.Unmarshal(line, &[]any{&at, &code, &data}); err != nil {
+			return fmt.Errorf("bad event: %w", err)
+		}
+		delay := time.Duration((at - last) * float64(time.Second))
+		last = at
+		if idle > 0 && delay > idle {
+			delay = idle
+		}
+		if delay = time.Duration(float64(delay) / speed); delay > 0 {
+			timer.Reset(delay)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-timer.C:
+			}
+		}
+		switch code {
+		case castOutput:
+			if _, err := out.Write([]byte(data)); err != nil {
+				return err
+			}
+		case castResize:
+			var cols, rows int
+			if _, err := fmt.Sscanf(data, "%dx%d", &cols, &rows); err == nil && resize != nil {
+				resize(cols, rows)
+			}
+		}
+	}
+	return sc.Err()
+}
@@ -1,36 +1,254 @@
 package term
 
 import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"tractor.dev/wanix"
+	"tractor.dev/wanix/fs"
 	"tractor.dev/wanix/fs/fskit"
-	"tractor.dev/wanix/fs/pipe"
 	"tractor.dev/wanix/fs/signal"
+	"tractor.dev/wanix/misc/shlex"
 )
 
-// Resource is one terminal instance (paths data, program, winch).
+// Resource is one terminal instance (paths data, input, program, winch,
+// ctl, mode, screen, scrollback).
 // MapFS is embedded so Route reaches the signal FS (for fs.OpenFile with O_WRONLY, etc.).
 type Resource struct {
 	id string
 	fskit.MapFS
-	hub *signal.Broadcaster
-	end *pipe.Port
+	hub       *signal.Broadcaster
+	ld        *ldisc
+	screen    *screen
+	out       *fanout
+	end       io.Closer
+	interrupt func()
+
+	mu        sync.Mutex
+	fg        string // foreground task id
+	recording string
+	unwinch   func()
 }
 
 func (r *Resource) ID() string {
 	return r.id
 }
 
+// Foreground returns the id of the task interrupts are delivered to.
+func (r *Resource) Foreground() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.fg
+}
+
+// SetForeground sets the id of the task interrupts are delivered to.
+func (r *Resource) SetForeground(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.fg = id
+}
+
+// Record starts recording the terminal to the file name in asciicast v2
+// format, replacing any recording in progress. The name is resolved in the
+// namespace of the task ctx comes from.
+func (r *Resource) Record(ctx context.Context, name string) error {
+	ns := namespace(ctx)
+	if ns == nil {
+		return fmt.Errorf("term: no namespace to record into")
+	}
+	f, err := fs.OpenFile(ns, strings.TrimPrefix(path.Clean(name), "/"), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	w, ok := f.(io.WriteCloser)
+	if !ok {
+		f.Close()
+		return fmt.Errorf("term: %s is not writable", name)
+	}
+	cols, rows := r.screen.Size()
+	rec, err := newRecorder(w, cols, rows)
+	if err != nil {
+		return err
+	}
+
+	// resizes are recorded as they are signaled on winch
+	id, ch := r.hub.Subscribe(signal.DropOldest, false)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for b := range ch {
+			var cols, rows int
+			if _, err := fmt.Sscanf(string(b), "%d %d", &cols, &rows); err == nil {
+				rec.event(castResize, []byte(fmt.Sprintf("%dx%d", cols, rows)))
+			}
+		}
+	}()
+
+	r.mu.Lock()
+	unwinch := r.unwinch
+	r.recording = name
+	r.unwinch = func() {
+		r.hub.RemoveReader(id)
+		<-done
+	}
+	old := r.out.setRecorder(rec)
+	r.mu.Unlock()
+	if unwinch != nil {
+		unwinch()
+	}
+	if old != nil {
+		return old.stop()
+	}
+	return nil
+}
+
+// namespace returns the namespace of the task ctx comes from, or the
+// filesystem the request was made through, or nil if there's neither.
+func namespace(ctx context.Context) fs.FS {
+	if t, ok := wanix.FromContext(ctx); ok && t.NS() != nil {
+		return t.NS()
+	}
+	if fsys, _, ok := fs.Origin(ctx); ok {
+		return fsys
+	}
+	return nil
+}
+
+// Stop ends the recording in progress.
+func (r *Resource) Stop() error {
+	r.mu.Lock()
+	unwinch := r.unwinch
+	r.recording = ""
+	r.unwinch = nil
+	rec := r.out.setRecorder(nil)
+	r.mu.Unlock()
+	if rec == nil {
+		return fmt.Errorf("term: not recording")
+	}
+	unwinch()
+	return rec.stop()
+}
+
 func (r *Resource) shutdown() {
+	r.Stop()
 	r.hub.Close()
 	if r.end != nil {
 		r.end.Close()
 	}
 }
 
+// mode returns the terminal attributes read from the mode file.
+func (r *Resource) mode() string {
+	fg := r.Foreground()
+	if fg == "" {
+		fg = "none"
+	}
+	r.mu.Lock()
+	recording := r.recording
+	r.mu.Unlock()
+	if recording == "" {
+		recording = "off"
+	}
+	cols, rows := r.screen.Size()
+	return r.ld.Mode() + fmt.Sprintf("fg %s\nsize %d %d\nrecord %s\n", fg, cols, rows, recording)
+}
+
+// size is the contents of the size file: the screen's columns and rows.
+func (r *Resource) size() string {
+	cols, rows := r.screen.Size()
+	return fmt.Sprintf("%d %d\n", cols, rows)
+}
+
+// control runs commands written to the ctl file, one per line:
+//
+//	rawon, rawoff    switch between raw and cooked input
+//	echoon, echooff  turn echoing of cooked input on or off
+//	fg <task>        deliver interrupts to task
+//	intr             interrupt the foreground task
+//	size <cols> <rows>
+//	                 resize the screen and signal winch
+//	record <path>    record to path in asciicast v2 format, resolved in
+//	                 the namespace of the task that opened ctl
+//	stop             stop recording
+func (r *Resource) control(ctx context.Context, cmds string) error {
+	for _, line := range strings.Split(cmds, "\n") {
+		args, err := shlex.Split(strings.TrimSpace(line), true)
+		if err != nil {
+			return err
+		}
+		if len(args) == 0 {
+			continue
+		}
+		switch {
+		case args[0] == "rawon" && len(args) == 1:
+			r.ld.SetRaw(true)
+		case args[0] == "rawoff" && len(args) == 1:
+			r.ld.SetRaw(false)
+		case args[0] == "echoon" && len(args) == 1:
+			r.ld.SetEcho(true)
+		case args[0] == "echooff" && len(args) == 1:
+			r.ld.SetEcho(false)
+		case args[0] == "fg" && len(args) == 2:
+			r.SetForeground(args[1])
+		case args[0] == "intr" && len(args) == 1:
+			if r.interrupt != nil {
+				r.interrupt()
+			}
+		case args[0] == "size" && len(args) == 3:
+			cols, err1 := strconv.Atoi(args[1])
+			rows, err2 := strconv.Atoi(args[2])
+			if err1 != nil || err2 != nil || cols <= 0 || rows <= 0 {
+				return fmt.Errorf("term: bad size: %s %s", args[1], args[2])
+			}
+			r.screen.Resize(cols, rows)
+			r.hub.Broadcast([]byte(fmt.Sprintf("%d %d\n", cols, rows)), signal.NoExclude)
+		case args[0] == "record" && len(args) == 2:
+			if err := r.Record(ctx, args[1]); err != nil {
+				return err
+			}
+		case args[0] == "stop" && len(args) == 1:
+			if err := r.Stop(); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("term: unknown command: %s", strings.Join(args, " "))
+		}
+	}
+	return nil
+}
+
+// programFile is the program's end: reads come from the line discipline
+// and writes are output to the terminal with newlines mapped to CRLF.
 type programFile struct {
-	*pipe.PortFile
+	out  io.Writer
+	ld   *ldisc
 	prev byte // last input byte seen, for cross-call lookbehind
 }
 
+func (c *programFile) Read(p []byte) (int, error) {
+	return c.ld.Read(p)
+}
+
+func (c *programFile) ReadAt(p []byte, off int64) (int, error) {
+	return c.ld.Read(p)
+}
+
+func (c *programFile) Stat() (fs.FileInfo, error) {
+	return fskit.Entry("program", fs.FileMode(0644), int64(c.ld.Size())), nil
+}
+
+func (c *programFile) WriteAt(p []byte, off int64) (int, error) {
+	return c.Write(p)
+}
+
+func (c *programFile) Close() error { return nil }
+
 func (c *programFile) Write(p []byte) (int, error) {
 	if len(p) == 0 {
 		return 0, nil
@@ -44,7 +262,7 @@ func (c *programFile) Write(p []byte) (int, error) {
 		buf = append(buf, b)
 		prev = b
 	}
-	if _, err := c.PortFile.Write(buf); err != nil {
+	if _, err := c.out.Write(buf); err != nil {
 		return 0, err
 	}
 	c.prev = prev
@@ -0,0 +1,725 @@
+package term
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+const (
+	// DefaultCols and DefaultRows are the size of a new terminal screen.
+	DefaultCols = 80
+	DefaultRows = 24
+	// DefaultScrollback is how many lines scrolled off the top of the
+	// screen are kept.
+	DefaultScrollback = 1000
+)
+
+// parser states
+const (
+	stGround = iota
+	stEsc
+	stEscSkip // ESC ( and friends take one more byte
+	stCSI
+	stOSC
+	stOSCEsc
+)
+
+type cell struct {
+	r    rune
+	attr attrs
+}
+
+// screen is a headless VT100/xterm emulator. It keeps the text and
+// attributes on screen, the cursor and lines scrolled off the top, so the
+// screen can be read back or redrawn for a viewer that attaches later.
+// Sequences it doesn't understand are ignored.
+type screen struct {
+	mu         sync.Mutex
+	cols, rows int
+	cells      [][]cell
+	x, y       int
+	wrapNext   bool
+	attr       attrs
+	top, bot   int // scroll region, inclusive
+	savedX     int
+	savedY     int
+	savedAttr  attrs
+	main       [][]cell // main screen while the alternate screen is up
+	scrollback []string
+	maxBack    int
+	written    bool
+
+	state  int
+	params []byte
+	utf    []byte
+}
+
+func newScreen(cols, rows int) *screen {
+	s := &screen{maxBack: DefaultScrollback}
+	s.reset(cols, rows)
+	return s
+}
+
+// reset clears the screen and all modes, keeping the scrollback.
+func (s *screen) reset(cols, rows int) {
+	s.cols, s.rows = cols, rows
+	s.cells = blankRows(cols, rows)
+	s.x, s.y, s.wrapNext = 0, 0, false
+	s.attr = attrs{}
+	s.top, s.bot = 0, rows-1
+	s.savedX, s.savedY, s.savedAttr = 0, 0, attrs{}
+	s.main = nil
+	s.state = stGround
+}
+
+func blankRows(cols, rows int) [][]cell {
+	cells := make([][]cell, rows)
+	for i := range cells {
+		cells[i] = blankRow(cols)
+	}
+	return cells
+}
+
+func blankRow(cols int) []cell {
+	row := make([]cell, cols)
+	for i := range row {
+		row[i].r = ' '
+	}
+	return row
+}
+
+// Write feeds program output to the emulator.
+func (s *screen) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(p) > 0 {
+		s.written = true
+	}
+	for _, b := range p {
+		s.feed(b)
+	}
+	return len(p), nil
+}
+
+func (s *screen) feed(b byte) {
+	switch s.state {
+	case stEsc:
+		s.esc(b)
+		return
+	case stEscSkip:
+		s.state = stGround
+		return
+	case stCSI:
+		switch {
+		case b >= 0x30 && b <= 0x3f:
+			s.params = append(s.params, b)
+		case b >= 0x40 && b <= 0x7e:
+			s.state = stGround
+			s.csi(b)
+		case b == 0x1b:
+			s.state = stEsc
+		case b < 0x20:
+			s.control(b)
+		}
+		return
+	case stOSC:
+		switch b {
+		case 0x07:
+			s.state = stGround
+		case 0x1b:
+			s.state = stOSCEsc
+		}
+		return
+	case stOSCEsc:
+		// ESC \ ends the string; anything else is dropped with it
+		s.state = stGround
+		return
+	}
+
+	if len(s.utf) > 0 || b >= 0x80 {
+		s.utf = append(s.utf, b)
+		if !utf8.FullRune(s.utf) {
+			return
+		}
+		r, _ := utf8.DecodeRune(s.utf)
+		s.utf = s.utf[:0]
+		s.put(r)
+		return
+	}
+	if b == 0x1b {
+		s.state = stEsc
+		return
+	}
+	if b < 0x20 || b == 0x7f {
+		s.control(b)
+		return
+	}
+	s.put(rune(b))
+}
+
+func (s *screen) control(b byte) {
+	switch b {
+	case '\r':
+		s.x, s.wrapNext = 0, false
+	case '\n', '\v', '\f':
+		s.linefeed()
+	case '\b':
+		if s.x > 0 {
+			s.x--
+		}
+		s.wrapNext = false
+	case '\t':
+		s.x = min((s.x/8+1)*8, s.cols-1)
+	}
+}
+
+func (s *screen) esc(b byte) {
+	s.state = stGround
+	switch b {
+	case '[':
+		s.state = stCSI
+		s.params = s.params[:0]
+	case ']', 'P', '_', '^':
+		s.state = stOSC
+	case '(', ')', '*', '+', '#':
+		s.state = stEscSkip
+	case '7':
+		s.save()
+	case '8':
+		s.restore()
+	case 'D':
+		s.linefeed()
+	case 'E':
+		s.x = 0
+		s.linefeed()
+	case 'M':
+		if s.y == s.top {
+			s.scrollDown(1)
+		} else if s.y > 0 {
+			s.y--
+		}
+	case 'c':
+		s.reset(s.cols, s.rows)
+	}
+}
+
+func (s *screen) put(r rune) {
+	if s.wrapNext {
+		s.x, s.wrapNext = 0, false
+		s.linefeed()
+	}
+	s.cells[s.y][s.x] = cell{r: r, attr: s.attr}
+	if s.x == s.cols-1 {
+		s.wrapNext = true
+	} else {
+		s.x++
+	}
+}
+
+func (s *screen) linefeed() {
+	s.wrapNext = false
+	if s.y == s.bot {
+		s.scrollUp(1, true)
+	} else if s.y < s.rows-1 {
+		s.y++
+	}
+}
+
+// scrollUp scrolls the scroll region up n lines. With history, lines
+// leaving the top of the main screen go to the scrollback.
+func (s *screen) scrollUp(n int, history bool) {
+	n = min(n, s.bot-s.top+1)
+	for i := 0; i < n; i++ {
+		if history && s.top == 0 && s.main == nil {
+			s.pushScrollback(rowText(s.cells[0]))
+		}
+		copy(s.cells[s.top:s.bot], s.cells[s.top+1:s.bot+1])
+		s.cells[s.bot] = blankRow(s.cols)
+	}
+}
+
+func (s *screen) scrollDown(n int) {
+	n = min(n, s.bot-s.top+1)
+	for i := 0; i < n; i++ {
+		copy(s.cells[s.top+1:s.bot+1], s.cells[s.top:s.bot])
+		s.cells[s.top] = blankRow(s.cols)
+	}
+}
+
+func (s *screen) pushScrollback(line string) {
+	if s.maxBack <= 0 {
+		return
+	}
+	if len(s.scrollback) >= s.maxBack {
+		s.scrollback = append(s.scrollback[:0], s.scrollback[len(s.scrollback)-s.maxBack+1:]...)
+	}
+	s.scrollback = append(s.scrollback, line)
+}
+
+func (s *screen) save() {
+	s.savedX, s.savedY, s.savedAttr = s.x, s.y, s.attr
+}
+
+func (s *screen) restore() {
+	s.x, s.y, s.attr = min(s.savedX, s.cols-1), min(s.savedY, s.rows-1), s.savedAttr
+	s.wrapNext = false
+}
+
+func (s *screen) csi(final byte) {
+	private := len(s.params) > 0 && s.params[0] == '?'
+	raw := string(s.params)
+	if private {
+		raw = raw[1:]
+	}
+	args := strings.Split(raw, ";")
+	arg := func(i, def int) int {
+		if i >= len(args) {
+			return def
+		}
+		n, err := strconv.Atoi(args[i])
+		if err != nil || n == 0 {
+			return def
+		}
+		return n
+	}
+
+	switch final {
+	case 'A':
+		s.y = max(s.y-arg(0, 1), 0)
+	case 'B':
+		s.y = min(s.y+arg(0, 1), s.rows-1)
+	case 'C':
+		s.x = min(s.x+arg(0, 1), s.cols-1)
+	case 'D':
+		s.x = max(s.x-arg(0, 1), 0)
+	case 'E':
+		s.x, s.y = 0, min(s.y+arg(0, 1), s.rows-1)
+	case 'F':
+		s.x, s.y = 0, max(s.y-arg(0, 1), 0)
+	case 'G', '`':
+		s.x = clamp(arg(0, 1)-1, s.cols)
+	case 'd':
+		s.y = clamp(arg(0, 1)-1, s.rows)
+	case 'H', 'f':
+		s.y, s.x = clamp(arg(0, 1)-1, s.rows), clamp(arg(1, 1)-1, s.cols)
+	case 'J':
+		switch arg(0, 0) {
+		case 0:
+			s.clear(s.y, s.x, s.rows-1, s.cols-1)
+		case 1:
+			s.clear(0, 0, s.y, s.x)
+		case 2:
+			s.clear(0, 0, s.rows-1, s.cols-1)
+		case 3:
+			s.scrollback = nil
+		}
+	case 'K':
+		switch arg(0, 0) {
+		case 0:
+			s.clear(s.y, s.x, s.y, s.cols-1)
+		case 1:
+			s.clear(s.y, 0, s.y, s.x)
+		case 2:
+			s.clear(s.y, 0, s.y, s.cols-1)
+		}
+	case 'L':
+		if s.y >= s.top && s.y <= s.bot {
+			top := s.top
+			s.top = s.y
+			s.scrollDown(arg(0, 1))
+			s.top = top
+		}
+	case 'M':
+		if s.y >= s.top && s.y <= s.bot {
+			top := s.top
+			s.top = s.y
+			s.scrollUp(arg(0, 1), false)
+			s.top = top
+		}
+	case '@':
+		row := s.cells[s.y]
+		n := min(arg(0, 1), s.cols-s.x)
+		copy(row[s.x+n:], row[s.x:])
+		s.clear(s.y, s.x, s.y, s.x+n-1)
+	case 'P':
+		row := s.cells[s.y]
+		n := min(arg(0, 1), s.cols-s.x)
+		copy(row[s.x:], row[s.x+n:])
+		s.clear(s.y, s.cols-n, s.y, s.cols-1)
+	case 'X':
+		s.clear(s.y, s.x, s.y, min(s.x+arg(0, 1), s.cols)-1)
+	case 'S':
+		s.scrollUp(arg(0, 1), true)
+	case 'T':
+		s.scrollDown(arg(0, 1))
+	case 'm':
+		s.sgr(raw)
+	case 'r':
+		if private {
+			return
+		}
+		top, bot := arg(0, 1)-1, arg(1, s.rows)-1
+		if top < bot && bot < s.rows {
+			s.top, s.bot = top, bot
+			s.x, s.y = 0, 0
+		}
+	case 's':
+		s.save()
+	case 'u':
+		s.restore()
+	case 'h', 'l':
+		if !private {
+			return
+		}
+		for _, a := range args {
+			switch a {
+			case "1049", "1047", "47":
+				s.altScreen(final == 'h', a == "1049")
+			}
+		}
+	}
+	if final != 'm' {
+		s.wrapNext = false
+	}
+}
+
+// sgr applies select graphic rendition parameters to the attributes.
+func (s *screen) sgr(params string) {
+	s.attr.set(params)
+}
+
+// attribute flags, by the SGR parameter that sets them
+const (
+	attrBold = 1 << iota
+	attrDim
+	attrItalic
+	attrUnderline
+	attrBlink
+	attrInverse
+	attrHidden
+	attrStrike
+)
+
+var attrCodes = []struct {
+	flag uint8
+	on   int
+}{
+	{attrBold, 1}, {attrDim, 2}, {attrItalic, 3}, {attrUnderline, 4},
+	{attrBlink, 5}, {attrInverse, 7}, {attrHidden, 8}, {attrStrike, 9},
+}
+
+// color kinds
+const (
+	colorDefault = iota
+	colorBasic   // value is 0-15, the 8 colors and their bright versions
+	colorIndexed // value is 0-255
+	colorRGB
+)
+
+type color struct {
+	kind    uint8
+	r, g, b uint8 // r is the value for basic and indexed colors
+}
+
+// attrs is the graphic rendition of a cell. It is kept normalised, so
+// however many SGR sequences have been applied it stays the same size and
+// equal renditions compare equal.
+type attrs struct {
+	flags  uint8
+	fg, bg color
+}
+
+// set applies SGR parameters. Unknown ones are ignored.
+func (a *attrs) set(params string) {
+	args := strings.Split(params, ";")
+	for i := 0; i < len(args); i++ {
+		p := args[i]
+		if sub := strings.Split(p, ":"); len(sub) > 1 {
+			// 38:5:n, 38:2:r:g:b and 38:2:cs:r:g:b
+			n, _ := strconv.Atoi(sub[0])
+			if n == 38 || n == 48 {
+				rest := sub[1:]
+				if len(rest) == 5 && rest[0] == "2" {
+					rest = append(rest[:1], rest[2:]...)
+				}
+				a.color(n, rest)
+			} else if n == 4 {
+				// underline styles; 4:0 is off
+				a.flags |= attrUnderline
+				if sub[1] == "0" {
+					a.flags &^= attrUnderline
+				}
+			}
+			continue
+		}
+		n, err := strconv.Atoi(p)
+		if p != "" && err != nil {
+			continue
+		}
+		switch {
+		case n == 0:
+			*a = attrs{}
+		case n == 38 || n == 48:
+			i += a.color(n, args[i+1:])
+		case n == 21:
+			a.flags |= attrUnderline
+		case n == 22:
+			a.flags &^= attrBold | attrDim
+		case n == 6:
+			a.flags |= attrBlink
+		case n >= 23 && n <= 29:
+			for _, c := range attrCodes {
+				if c.on == n-20 {
+					a.flags &^= c.flag
+				}
+			}
+		case n >= 30 && n <= 37:
+			a.fg = color{kind: colorBasic, r: uint8(n - 30)}
+		case n == 39:
+			a.fg = color{}
+		case n >= 40 && n <= 47:
+			a.bg = color{kind: colorBasic, r: uint8(n - 40)}
+		case n == 49:
+			a.bg = color{}
+		case n >= 90 && n <= 97:
+			a.fg = color{kind: colorBasic, r: uint8(n - 90 + 8)}
+		case n >= 100 && n <= 107:
+			a.bg = color{kind: colorBasic, r: uint8(n - 100 + 8)}
+		default:
+			for _, c := range attrCodes {
+				if c.on == n {
+					a.flags |= c.flag
+				}
+			}
+		}
+	}
+}
+
+// color sets the foreground (38) or background (48) color from the
+// arguments following the parameter, returning how many it used.
+func (a *attrs) color(n int, args []string) int {
+	arg := func(i int) (uint8, bool) {
+		if i >= len(args) {
+			return 0, false
+		}
+		v, err := strconv.Atoi(args[i])
+		if args[i] == "" {
+			v, err = 0, nil
+		}
+		return uint8(v), err == nil && v >= 0 && v <= 255
+	}
+	var c color
+	used := 1
+	switch mode, _ := arg(0); {
+	case len(args) == 0:
+		return 0
+	case mode == 5:
+		v, ok := arg(1)
+		if !ok {
+			return min(len(args), 2)
+		}
+		c, used = color{kind: colorIndexed, r: v}, 2
+	case mode == 2:
+		r, ok1 := arg(1)
+		g, ok2 := arg(2)
+		b, ok3 := arg(3)
+		if !ok1 || !ok2 || !ok3 {
+			return min(len(args), 4)
+		}
+		c, used = color{kind: colorRGB, r: r, g: g, b: b}, 4
+	default:
+		return used
+	}
+	if n == 38 {
+		a.fg = c
+	} else {
+		a.bg = c
+	}
+	return used
+}
+
+// sgr returns the sequence that resets the rendition and sets a.
+func (a attrs) sgr() string {
+	b := []byte("\x1b[0")
+	for _, c := range attrCodes {
+		if a.flags&c.flag != 0 {
+			b = fmt.Appendf(b, ";%d", c.on)
+		}
+	}
+	b = a.fg.appendSGR(b, 30, 90, 38)
+	b = a.bg.appendSGR(b, 40, 100, 48)
+	return string(append(b, 'm'))
+}
+
+func (c color) appendSGR(b []byte, base, bright, ext int) []byte {
+	switch c.kind {
+	case colorBasic:
+		if c.r < 8 {
+			return fmt.Appendf(b, ";%d", base+int(c.r))
+		}
+		return fmt.Appendf(b, ";%d", bright+int(c.r)-8)
+	case colorIndexed:
+		return fmt.Appendf(b, ";%d;5;%d", ext, c.r)
+	case colorRGB:
+		return fmt.Appendf(b, ";%d;2;%d;%d;%d", ext, c.r, c.g, c.b)
+	}
+	return b
+}
+
+func (s *screen) altScreen(on, saveCursor bool) {
+	if on == (s.main != nil) {
+		return
+	}
+	if on {
+		if saveCursor {
+			s.save()
+		}
+		s.main = s.cells
+		s.cells = blankRows(s.cols, s.rows)
+		return
+	}
+	s.cells, s.main = s.main, nil
+	if saveCursor {
+		s.restore()
+	}
+}
+
+// clear blanks the cells from (y1, x1) to (y2, x2) in reading order.
+func (s *screen) clear(y1, x1, y2, x2 int) {
+	for y := y1; y <= y2; y++ {
+		from, to := 0, s.cols-1
+		if y == y1 {
+			from = x1
+		}
+		if y == y2 {
+			to = x2
+		}
+		for x := from; x <= to && x < s.cols; x++ {
+			s.cells[y][x] = cell{r: ' '}
+		}
+	}
+}
+
+// Resize changes the size of the screen. When it gets shorter, lines
+// above the cursor are scrolled off the top to keep it on screen.
+func (s *screen) Resize(cols, rows int) {
+	if cols <= 0 || rows <= 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	resize := func(cells [][]cell, scroll bool) [][]cell {
+		if shift := s.y - rows + 1; shift > 0 {
+			for _, row := range cells[:shift] {
+				if scroll {
+					s.pushScrollback(rowText(row))
+				}
+			}
+			cells = cells[shift:]
+		}
+		out := blankRows(cols, rows)
+		for y := 0; y < len(cells) && y < rows; y++ {
+			copy(out[y], cells[y])
+		}
+		return out
+	}
+	if s.main != nil {
+		s.main = resize(s.main, false)
+	}
+	s.cells = resize(s.cells, s.main == nil)
+	if shift := s.y - rows + 1; shift > 0 {
+		s.y -= shift
+	}
+	s.cols, s.rows = cols, rows
+	s.x = min(s.x, cols-1)
+	s.top, s.bot = 0, rows-1
+	s.wrapNext = false
+}
+
+// Size returns the columns and rows of the screen.
+func (s *screen) Size() (cols, rows int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cols, s.rows
+}
+
+// Text returns the text on screen, one line per row with trailing spaces
+// removed.
+func (s *screen) Text() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var b strings.Builder
+	for _, row := range s.cells {
+		b.WriteString(rowText(row))
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// Scrollback returns the lines scrolled off the top, oldest first.
+func (s *screen) Scrollback() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.scrollback) == 0 {
+		return ""
+	}
+	return strings.Join(s.scrollback, "\n") + "\n"
+}
+
+// Redraw returns output that paints the screen as it is onto a fresh
+// terminal, or nil if nothing has been written to it yet.
+func (s *screen) Redraw() []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.written {
+		return nil
+	}
+	var b strings.Builder
+	b.WriteString("\x1b[0m\x1b[H\x1b[2J")
+	var attr attrs
+	if s.main != nil {
+		drawRows(&b, s.main, &attr)
+		b.WriteString("\x1b[?1049h\x1b[H\x1b[2J")
+	}
+	drawRows(&b, s.cells, &attr)
+	if s.top != 0 || s.bot != s.rows-1 {
+		fmt.Fprintf(&b, "\x1b[%d;%dr", s.top+1, s.bot+1)
+	}
+	b.WriteString(s.attr.sgr())
+	fmt.Fprintf(&b, "\x1b[%d;%dH", s.y+1, s.x+1)
+	return []byte(b.String())
+}
+
+// drawRows writes output that paints cells, tracking the attributes in
+// effect in attr.
+func drawRows(b *strings.Builder, cells [][]cell, attr *attrs) {
+	for y, row := range cells {
+		text := rowText(row)
+		if text == "" {
+			continue
+		}
+		fmt.Fprintf(b, "\x1b[%dH", y+1)
+		for _, c := range row[:utf8.RuneCountInString(text)] {
+			if c.attr != *attr {
+				b.WriteString(c.attr.sgr())
+				*attr = c.attr
+			}
+			b.WriteRune(c.r)
+		}
+	}
+}
+
+func rowText(row []cell) string {
+	var b strings.Builder
+	for _, c := range row {
+		b.WriteRune(c.r)
+	}
+	return strings.TrimRight(b.String(), " ")
+}
+
+func clamp(n, limit int) int {
+	return max(0, min(n, limit-1))
+}
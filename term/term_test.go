@@ -2,12 +2,18 @@ package term
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"io"
 	"os"
 	"runtime"
+	"strings"
 	"testing"
+	"time"
 
 	"tractor.dev/wanix/fs"
+	"tractor.dev/wanix/fs/memfs"
+	"tractor.dev/wanix/fs/vfs"
 )
 
 func TestNewAllocatesID(t *testing.T) {
@@ -51,7 +57,7 @@ func TestDataProgramPipe(t *testing.T) {
 	}
 	defer pf.Close()
 
-	msg := []byte("hello")
+	msg := []byte("hello\n")
 	if _, err := df.(io.Writer).Write(msg); err != nil {
 		t.Fatal(err)
 	}
@@ -64,6 +70,45 @@ func TestDataProgramPipe(t *testing.T) {
 	}
 }
 
+func TestInputEnds(t *testing.T) {
+	ctx := context.Background()
+	s := New(nil)
+	if _, err := fs.ReadFile(s, "new"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.WriteFile(s, "1/ctl", []byte("rawon"), 0); err != nil {
+		t.Fatal(err)
+	}
+	pf, err := fs.OpenContext(ctx, s, "1/program")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pf.Close()
+
+	// stat doesn't end input
+	if _, err := fs.Stat(s, "1/input"); err != nil {
+		t.Fatal(err)
+	}
+	in, err := fs.OpenFile(s, "1/input", os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// control characters are data in raw mode
+	msg := "a\r\x03\x7f\x04b"
+	if _, err := in.(io.Writer).Write([]byte(msg)); err != nil {
+		t.Fatal(err)
+	}
+	in.Close()
+
+	got, err := io.ReadAll(pf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != msg {
+		t.Fatalf("program read %q, want %q", got, msg)
+	}
+}
+
 func TestProgramEOFRemoves(t *testing.T) {
 	ctx := context.Background()
 	s := New(nil)
@@ -170,3 +215,426 @@ func TestWinchBroadcast(t *testing.T) {
 		t.Fatalf("r1=%q r2=%q", s1, s2)
 	}
 }
+
+type echoSink struct{ strings.Builder }
+
+func (r *echoSink) Close() error { return nil }
+
+func TestCookedLine(t *testing.T) {
+	out := &echoSink{}
+	ld := newLdisc(out, out, nil)
+	ld.Write([]byte("helo\x7flo wrld\x17world\r"))
+	buf := make([]byte, 64)
+	n, err := ld.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(buf[:n]); got != "hello world\n" {
+		t.Fatalf("read %q, want %q", got, "hello world\n")
+	}
+	if got := out.String(); !strings.HasSuffix(got, "world\r\n") || !strings.Contains(got, "\b \b") {
+		t.Fatalf("echo %q", got)
+	}
+
+	ld.Write([]byte("gone\x15kept\n"))
+	n, _ = ld.Read(buf)
+	if got := string(buf[:n]); got != "kept\n" {
+		t.Fatalf("read %q after kill, want %q", got, "kept\n")
+	}
+}
+
+func TestCookedEOF(t *testing.T) {
+	out := &echoSink{}
+	ld := newLdisc(out, out, nil)
+	ld.Write([]byte("partial\x04\x04"))
+	buf := make([]byte, 64)
+	n, err := ld.Read(buf)
+	if err != nil || string(buf[:n]) != "partial" {
+		t.Fatalf("read %q, %v", buf[:n], err)
+	}
+	if _, err := ld.Read(buf); err != io.EOF {
+		t.Fatalf("Read: got %v, want EOF", err)
+	}
+}
+
+func TestInterrupt(t *testing.T) {
+	out := &echoSink{}
+	interrupted := 0
+	ld := newLdisc(out, out, func() { interrupted++ })
+	ld.Write([]byte("line\n"))
+	ld.Write([]byte("typed\x03"))
+	if interrupted != 1 {
+		t.Fatalf("interrupted %d times, want 1", interrupted)
+	}
+	if n := ld.Size(); n != 0 {
+		t.Fatalf("%d bytes pending after interrupt, want 0", n)
+	}
+	if got := out.String(); !strings.HasSuffix(got, "^C\r\n") {
+		t.Fatalf("echo %q", got)
+	}
+}
+
+func TestRawModeCtl(t *testing.T) {
+	ctx := context.Background()
+	s := New(nil)
+	newf, err := fs.OpenContext(ctx, s, "new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newf.Read(make([]byte, 8)); err != nil {
+		t.Fatal(err)
+	}
+	newf.Close()
+
+	if err := fs.WriteFile(s, "1/ctl", []byte("rawon\nechooff\nfg 7\n"), 0); err != nil {
+		t.Fatal(err)
+	}
+	mode, err := fs.ReadFile(s, "1/mode")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"raw on\n", "echo off\n", "fg 7\n"} {
+		if !strings.Contains(string(mode), want) {
+			t.Fatalf("mode %q missing %q", mode, want)
+		}
+	}
+	if err := fs.WriteFile(s, "1/ctl", []byte("bogus"), 0); err == nil {
+		t.Fatal("expected error for unknown command")
+	}
+
+	df, err := fs.OpenContext(ctx, s, "1/data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer df.Close()
+	pf, err := fs.OpenContext(ctx, s, "1/program")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pf.Close()
+	if _, err := df.(io.Writer).Write([]byte("\x1b[A")); err != nil {
+		t.Fatal(err)
+	}
+	out := make([]byte, 3)
+	if _, err := io.ReadFull(pf, out); err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "\x1b[A" {
+		t.Fatalf("program read %q in raw mode", out)
+	}
+}
+
+func TestScreen(t *testing.T) {
+	s := newScreen(10, 3)
+	s.Write([]byte("one\r\ntwo\r\nthree\r\nfour"))
+	if got, want := s.Text(), "two\nthree\nfour\n"; got != want {
+		t.Fatalf("screen %q, want %q", got, want)
+	}
+	if got, want := s.Scrollback(), "one\n"; got != want {
+		t.Fatalf("scrollback %q, want %q", got, want)
+	}
+
+	s.Write([]byte("\x1b[1;1H\x1b[2J\x1b[31mred\x1b[0m\x1b[2;3Hx\x1b[K"))
+	if got, want := s.Text(), "red\n  x\n\n"; got != want {
+		t.Fatalf("screen %q, want %q", got, want)
+	}
+
+	// long lines wrap
+	s.Write([]byte("\x1b[3;1H0123456789ab"))
+	if got, want := s.Text(), "  x\n0123456789\nab\n"; got != want {
+		t.Fatalf("screen %q, want %q", got, want)
+	}
+
+	// the alternate screen is restored as it was
+	s.Write([]byte("\x1b[?1049hfull screen"))
+	s.Write([]byte("\x1b[?1049l"))
+	if got, want := s.Text(), "  x\n0123456789\nab\n"; got != want {
+		t.Fatalf("screen %q after alternate screen, want %q", got, want)
+	}
+
+	s.Resize(4, 2)
+	if got, want := s.Text(), "0123\nab\n"; got != want {
+		t.Fatalf("screen %q after resize, want %q", got, want)
+	}
+}
+
+func TestDataRedraw(t *testing.T) {
+	ctx := context.Background()
+	s := New(nil)
+	newf, err := fs.OpenContext(ctx, s, "new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newf.Read(make([]byte, 8)); err != nil {
+		t.Fatal(err)
+	}
+	newf.Close()
+
+	pf, err := fs.OpenContext(ctx, s, "1/program")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pf.Close()
+	if _, err := pf.(io.Writer).Write([]byte("\x1b[1mhello\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	// viewers that join later see the screen redrawn
+	var views []fs.File
+	for i := 0; i < 2; i++ {
+		df, err := fs.OpenContext(ctx, s, "1/data")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer df.Close()
+		redraw := newScreen(DefaultCols, DefaultRows)
+		buf := make([]byte, 256)
+		n, err := df.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		redraw.Write(buf[:n])
+		if got := redraw.Text(); !strings.HasPrefix(got, "hello\n\n") {
+			t.Fatalf("viewer %d redraw %q", i, got)
+		}
+		views = append(views, df)
+	}
+
+	// and all viewers get new output
+	if _, err := pf.(io.Writer).Write([]byte("world")); err != nil {
+		t.Fatal(err)
+	}
+	for i, df := range views {
+		buf := make([]byte, 5)
+		if _, err := io.ReadFull(df, buf); err != nil {
+			t.Fatal(err)
+		}
+		if string(buf) != "world" {
+			t.Fatalf("viewer %d read %q", i, buf)
+		}
+	}
+
+	screen, err := fs.ReadFile(s, "1/screen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(screen), "hello\nworld\n") {
+		t.Fatalf("screen %q", screen)
+	}
+}
+
+func TestRecord(t *testing.T) {
+	ctx := context.Background()
+	s := New(nil)
+	newf, err := fs.OpenContext(ctx, s, "new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newf.Read(make([]byte, 8)); err != nil {
+		t.Fatal(err)
+	}
+	newf.Close()
+	res, err := s.Get("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := res.Record(ctx, "demo.cast"); err == nil {
+		t.Fatal("recorded without a namespace")
+	}
+
+	// the path is resolved in the namespace ctl is written through
+	home := memfs.New()
+	ns := vfs.New(ctx)
+	if err := ns.Bind(home, ".", "."); err != nil {
+		t.Fatal(err)
+	}
+	if err := ns.Bind(s, ".", "#term"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.WriteFile(ns, "#term/1/ctl", []byte("record demo.cast"), 0); err != nil {
+		t.Fatal(err)
+	}
+	df, err := fs.OpenContext(ctx, s, "1/data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer df.Close()
+	if _, err := df.(io.Writer).Write([]byte("ls\r")); err != nil {
+		t.Fatal(err)
+	}
+	pf, err := fs.OpenContext(ctx, s, "1/program")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pf.Close()
+	if _, err := pf.(io.Writer).Write([]byte("héllo\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.WriteFile(s, "1/ctl", []byte("size 100 30"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.WriteFile(s, "1/ctl", []byte("stop"), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	cast, err := fs.ReadFile(home, "demo.cast")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(cast)), "\n")
+	if !strings.HasPrefix(lines[0], `{"version":2,"width":80,"height":24,`) {
+		t.Fatalf("header %s", lines[0])
+	}
+	var events []string
+	for _, line := range lines[1:] {
+		var (
+			at         float64
+			code, data string
+		)
+		if err := json.Unmarshal([]byte(line), &[]any{&at, &code, &data}); err != nil {
+			t.Fatalf("event %s: %v", line, err)
+		}
+		events = append(events, code+" "+data)
+	}
+	want := []string{"i ls\r", "o ls\r\n", "o héllo\r\n", "r 100x30"}
+	if strings.Join(events, "|") != strings.Join(want, "|") {
+		t.Fatalf("events %q, want %q", events, want)
+	}
+
+	var out strings.Builder
+	var sizes []string
+	resize := func(cols, rows int) { sizes = append(sizes, fmt.Sprintf("%dx%d", cols, rows)) }
+	if err := play(ctx, strings.NewReader(string(cast)), &out, resize, 1000, 0); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := out.String(), "ls\r\nhéllo\r\n"; got != want {
+		t.Fatalf("played %q, want %q", got, want)
+	}
+	if got, want := strings.Join(sizes, " "), "80x24 100x30"; got != want {
+		t.Fatalf("resized %q, want %q", got, want)
+	}
+}
+
+// blockingWriter holds up writes until release is closed.
+type blockingWriter struct {
+	strings.Builder
+	release chan struct{}
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	<-w.release
+	return w.Builder.Write(p)
+}
+
+func (w *blockingWriter) Close() error { return nil }
+
+func TestRecordDropsWhenBehind(t *testing.T) {
+	// the header is written right away, then the file stalls
+	w := &blockingWriter{release: make(chan struct{})}
+	close(w.release)
+	rec, err := newRecorder(w, 80, 24)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.release = make(chan struct{})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			rec.event(castOutput, []byte("x"))
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("event blocked on a stalled file")
+	}
+	close(w.release)
+	if err := rec.stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(w.String()), "\n")
+	var outputs int
+	var marker string
+	for _, line := range lines[1:] {
+		var (
+			at         float64
+			code, data string
+		)
+		if err := json.Unmarshal([]byte(line), &[]any{&at, &code, &data}); err != nil {
+			t.Fatalf("event %s: %v", line, err)
+		}
+		switch code {
+		case castOutput:
+			outputs++
+		case castMarker:
+			marker = data
+		}
+	}
+	if want := fmt.Sprintf("dropped %d events", 1000-outputs); marker != want {
+		t.Fatalf("marker %q, want %q", marker, want)
+	}
+}
+
+func TestSGR(t *testing.T) {
+	s := newScreen(10, 1)
+	// repeated and cancelled parameters don't accumulate
+	for i := 0; i < 100; i++ {
+		s.Write([]byte("\x1b[1;31m\x1b[22;4m\x1b[38;5;200;48;2;1;2;3m"))
+	}
+	want := attrs{flags: attrUnderline, fg: color{kind: colorIndexed, r: 200}, bg: color{kind: colorRGB, r: 1, g: 2, b: 3}}
+	if s.attr != want {
+		t.Fatalf("attributes %+v, want %+v", s.attr, want)
+	}
+	if got := s.attr.sgr(); got != "\x1b[0;4;38;5;200;48;2;1;2;3m" {
+		t.Fatalf("sgr %q", got)
+	}
+	s.Write([]byte("\x1b[24;38:2::4:5:6m"))
+	want = attrs{fg: color{kind: colorRGB, r: 4, g: 5, b: 6}, bg: want.bg}
+	if s.attr != want {
+		t.Fatalf("attributes %+v, want %+v", s.attr, want)
+	}
+	s.Write([]byte("\x1b[97m\x1b[m"))
+	if s.attr != (attrs{}) {
+		t.Fatalf("attributes %+v after reset", s.attr)
+	}
+}
+
+func TestViewerCatchesUp(t *testing.T) {
+	out := newFanout(newScreen(DefaultCols, DefaultRows))
+	v := out.attach()
+	defer out.detach(v)
+
+	// a viewer that doesn't read only holds so much
+	line := []byte(strings.Repeat("x", 79) + "\r\n")
+	for i := 0; i < 2*viewerBuffer/len(line); i++ {
+		out.Write(line)
+	}
+	out.Write([]byte("last"))
+	if n := v.buf.Size(); n > viewerBuffer {
+		t.Fatalf("viewer holds %d bytes", n)
+	}
+
+	// once it has read what was held it's sent a redraw
+	held := v.buf.Size()
+	var got []byte
+	buf := make([]byte, 32<<10)
+	for len(got) < held {
+		n, err := out.read(v, buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, buf[:n]...)
+	}
+	redraw := newScreen(DefaultCols, DefaultRows)
+	n, err := out.read(v, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	redraw.Write(buf[:n])
+	if got := redraw.Text(); !strings.Contains(got, "last") {
+		t.Fatalf("redraw %q", got)
+	}
+}
@@ -0,0 +1,84 @@
+//go:build js && wasm
+
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"io"
+
+	"tractor.dev/wanix/fs"
+	"tractor.dev/wanix/fs/cpiofs"
+	"tractor.dev/wanix/fs/tarfs"
+	"tractor.dev/wanix/fs/zipfs"
+)
+
+func isZip(magic []byte) bool {
+	return bytes.HasPrefix(magic, []byte("PK\x03\x04")) || bytes.HasPrefix(magic, []byte("PK\x05\x06"))
+}
+
+func readMagic(r io.ReaderAt) ([]byte, error) {
+	magic := make([]byte, 6)
+	n, err := r.ReadAt(magic, 0)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return magic[:n], nil
+}
+
+// openArchive returns a read-only filesystem for the archive in r, picking
+// the format by its magic bytes. Gzip and zstd compressed archives are
+// sniffed again after decompressing, so compressed cpio (the usual form of
+// an initramfs) goes to cpiofs. Anything else that isn't zip or cpio is
+// handed to tarfs.
+func openArchive(r io.ReaderAt, size int64) (fs.FS, error) {
+	magic, err := readMagic(r)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case isZip(magic):
+		return zipfs.Index(r, size)
+	case cpiofs.IsCPIO(magic):
+		return cpiofs.Index(r, size)
+	}
+
+	dr, err := tarfs.Decompress(r, size)
+	if err != nil {
+		return nil, err
+	}
+	if dr != nil {
+		inner, err := readMagic(dr)
+		if err != nil {
+			dr.Close()
+			return nil, err
+		}
+		if cpiofs.IsCPIO(inner) {
+			// the decoder stays open for the life of the filesystem
+			return cpiofs.Index(dr, dr.Size())
+		}
+		dr.Close()
+	}
+	return tarfs.Index(r, size)
+}
+
+// readArchive is like openArchive for an archive only available as a
+// stream. Tar archives are read as they stream in, while zip and cpio
+// archives are buffered first since they need random access.
+func readArchive(r io.Reader) (fs.FS, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(6)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if !isZip(magic) && !cpiofs.IsCPIO(magic) {
+		return tarfs.From(tar.NewReader(br))
+	}
+	b, err := io.ReadAll(br)
+	if err != nil {
+		return nil, err
+	}
+	return openArchive(bytes.NewReader(b), int64(len(b)))
+}
@@ -0,0 +1,43 @@
+//go:build js && wasm && s3
+
+package main
+
+import (
+	"context"
+	"log"
+
+	"tractor.dev/wanix"
+	"tractor.dev/wanix/fs"
+	"tractor.dev/wanix/fs/r2fs"
+	"tractor.dev/wanix/misc/allocfs"
+)
+
+// #s3 links in the AWS SDK, so it is only built into the kernel with the
+// s3 tag.
+func init() {
+	optional = append(optional, bindS3)
+}
+
+func bindS3(root *wanix.Task) {
+	s3fs := allocfs.New(func(ctx context.Context, id string, opts map[string]string) (fs.FS, error) {
+		cfg, err := r2fs.ParseConfig(opts)
+		if err != nil {
+			return nil, err
+		}
+		if cfg.AccessKeyID == "" && cfg.Profile == "" {
+			// no environment or instance role to find credentials in here
+			cfg.Anonymous = true
+		}
+		sfs, err := r2fs.NewS3(ctx, cfg)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := sfs.Stat("."); err != nil {
+			return nil, err
+		}
+		return sfs, nil
+	})
+	if err := root.NS().Bind(s3fs, ".", "#s3"); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -3,7 +3,6 @@
 package main
 
 import (
-	"archive/tar"
 	"context"
 	"errors"
 	"fmt"
@@ -21,12 +20,16 @@ import (
 	"tractor.dev/wanix"
 	"tractor.dev/wanix/api"
 	"tractor.dev/wanix/fs"
+	"tractor.dev/wanix/fs/contentcache"
 	"tractor.dev/wanix/fs/cowfs"
+	"tractor.dev/wanix/fs/davfs"
 	"tractor.dev/wanix/fs/httpfs"
 	"tractor.dev/wanix/fs/memfs"
+	wnet "tractor.dev/wanix/fs/net"
 	"tractor.dev/wanix/fs/p9kit"
 	"tractor.dev/wanix/fs/pipe"
 	"tractor.dev/wanix/fs/signal"
+	"tractor.dev/wanix/fs/syncfs"
 	"tractor.dev/wanix/fs/tarfs"
 	"tractor.dev/wanix/misc"
 	"tractor.dev/wanix/misc/allocfs"
@@ -34,12 +37,17 @@ import (
 	"tractor.dev/wanix/term"
 	"tractor.dev/wanix/vm"
 	"tractor.dev/wanix/web"
+	"tractor.dev/wanix/web/fsa"
 	"tractor.dev/wanix/web/idbfs"
 	"tractor.dev/wanix/web/jsfs"
 	"tractor.dev/wanix/web/sys"
 	"tractor.dev/wanix/web/worker"
 )
 
+// optional holds the bindings of devices left out of the kernel unless
+// it is built with their tags.
+var optional []func(root *wanix.Task)
+
 func main() {
 	log.SetFlags(log.Lshortfile)
 
@@ -57,6 +65,7 @@ func main() {
 		{"#vm", vm.New(root)},
 		{"#pipe", &pipe.Allocator{}},
 		{"#signal", &signal.Allocator{}},
+		{"#net", wnet.New()},
 		// {"#ramfs", &memfs.Allocator{}},
 		{"#js", jsfs.NewFS(js.Global())},
 	}
@@ -65,8 +74,29 @@ func main() {
 			log.Fatal(err)
 		}
 	}
+	root.Register("replay", &term.ReplayDriver{})
+
+	// decoded compressed archives are spilled to OPFS rather than kept
+	// in memory, since there are no temporary files
+	if spool, err := fsa.OPFS("tmp", "tarfs"); err == nil {
+		tarfs.SpoolFS = spool
+	} else {
+		log.Println("archives will be decoded without a spool:", err)
+	}
 
-	ramfs := allocfs.New(func(ctx context.Context, id string, opts map[string]string) (fs.FS, error) {
+	var ramfs *allocfs.FS
+	ramfs = allocfs.New(func(ctx context.Context, id string, opts map[string]string) (fs.FS, error) {
+		if from, ok := opts["from"]; ok {
+			src, err := ramfs.Lookup(from)
+			if err != nil {
+				return nil, fmt.Errorf("ramfs %s: %w", from, err)
+			}
+			mfs, ok := src.(*memfs.FS)
+			if !ok {
+				return nil, fmt.Errorf("ramfs %s: not a memfs", from)
+			}
+			return mfs.Snapshot(), nil
+		}
 		return memfs.New(), nil
 	})
 	if err := root.NS().Bind(ramfs, ".", "#ramfs"); err != nil {
@@ -102,12 +132,53 @@ func main() {
 		if err != nil {
 			return nil, err
 		}
-		return hfs, nil
+		// the filesystem outlives the request allocating it, so changes
+		// are followed until the page goes away
+		if _, ok := opts["sync"]; ok {
+			sfs := syncfs.New(memfs.New(), hfs, time.Second)
+			if err := sfs.Sync(); err != nil {
+				return nil, err
+			}
+			if err := sfs.Subscribe(context.Background()); err != nil {
+				log.Println("httpfs: no change stream, syncing on writes only:", err)
+			}
+			return sfs, nil
+		}
+		cfs := httpfs.NewCacher(hfs)
+		if err := cfs.Subscribe(context.Background()); err != nil {
+			log.Println("httpfs: no change stream, caching by expiry only:", err)
+		}
+		return cfs, nil
 	})
 	if err := root.NS().Bind(httpfs, ".", "#httpfs"); err != nil {
 		log.Fatal(err)
 	}
 
+	webdav := allocfs.New(func(ctx context.Context, id string, opts map[string]string) (fs.FS, error) {
+		u, err := url.Parse(opts["url"])
+		if err != nil {
+			return nil, err
+		}
+		if u.Scheme == "" {
+			return nil, fmt.Errorf("url is required")
+		}
+		dfs, err := davfs.New(u.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := dfs.Stat("."); err != nil {
+			return nil, err
+		}
+		return dfs, nil
+	})
+	if err := root.NS().Bind(webdav, ".", "#webdav"); err != nil {
+		log.Fatal(err)
+	}
+
+	for _, bind := range optional {
+		bind(root)
+	}
+
 	idbfs := allocfs.New(func(ctx context.Context, id string, opts map[string]string) (fs.FS, error) {
 		n, ok := opts["name"]
 		if !ok {
@@ -148,6 +219,46 @@ func main() {
 		log.Fatal(err)
 	}
 
+	cachefs := allocfs.New(func(ctx context.Context, id string, opts map[string]string) (fs.FS, error) {
+		originfs, _, ok := fs.Origin(ctx)
+		if !ok {
+			return nil, fmt.Errorf("no origin in context")
+		}
+		r, ok := opts["remote"]
+		if !ok {
+			return nil, fmt.Errorf("remote is required")
+		}
+		rfsys, err := fs.Sub(originfs, r)
+		if err != nil {
+			return nil, err
+		}
+
+		s, ok := opts["store"]
+		if !ok {
+			return nil, fmt.Errorf("store is required")
+		}
+		sfsys, err := fs.Sub(originfs, s)
+		if err != nil {
+			return nil, err
+		}
+
+		cfs, err := contentcache.New(rfsys, sfsys)
+		if err != nil {
+			return nil, err
+		}
+		if b, ok := opts["budget"]; ok {
+			n, err := strconv.ParseInt(b, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("budget: %w", err)
+			}
+			cfs.SetBudget(n)
+		}
+		return cfs, nil
+	})
+	if err := root.NS().Bind(cachefs, ".", "#cachefs"); err != nil {
+		log.Fatal(err)
+	}
+
 	el := sys.Element()
 	el.Set("_openPort", js.FuncOf(func(this js.Value, args []js.Value) any {
 		ch := js.Global().Get("MessageChannel").New()
@@ -284,7 +395,21 @@ func main() {
 						log.Println("error fetching archive", err)
 						return
 					}
-					archiveFS, err := tarfs.From(tar.NewReader(jsutil.NewReadableStream(v)))
+					if v.InstanceOf(js.Global().Get("Blob")) {
+						blob := jsutil.NewBlobReaderAt(v)
+						archiveFS, err := openArchive(blob, blob.Size())
+						if err != nil {
+							log.Println("error indexing archive", err)
+							return
+						}
+						cfs := &cowfs.FS{Base: archiveFS, Overlay: memfs.New()}
+						if err := task.NS().Bind(cfs, ".", dst); err != nil {
+							log.Println("error binding archive", err)
+							return
+						}
+						break
+					}
+					archiveFS, err := readArchive(jsutil.NewReadableStream(v))
 					if err != nil {
 						log.Println("error creating archive filesystem", err)
 						return
@@ -8,6 +8,7 @@ import (
 	"path"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"syscall/js"
 
 	"tractor.dev/toolkit-go/engine/cli"
@@ -28,6 +29,11 @@ type Resource struct {
 	src    string
 	worker js.Value
 	task   *wanix.Task
+
+	// guest caches the metadata of the vm guest filesystem exported by
+	// the worker, once it's mounted
+	guest       atomic.Pointer[metacache.FS]
+	guestCancel context.CancelFunc
 }
 
 type guestSetter interface {
@@ -80,6 +86,10 @@ func (r *Resource) Start(args ...string) error {
 
 	wanix.SetWorker(r.task, r.worker)
 
+	// the guest cache follows changes until the worker is terminated
+	guestCtx, cancel := context.WithCancel(context.Background())
+	r.guestCancel = cancel
+
 	port := sys.Element().Call("_openPort", r.task.ID())
 	p9 := sys.Element().Call("_open9P", r.task.ID())
 
@@ -118,9 +128,15 @@ func (r *Resource) Start(args ...string) error {
 						return
 					}
 					log.Println("mounting guest...")
-					if err := vm.SetGuest(metacache.New(exportFS)); err != nil {
+					guest := metacache.New(exportFS)
+					if err := guest.Subscribe(guestCtx); err != nil {
+						// without a change stream entries only expire
+						log.Println("guest cache not subscribed:", err)
+					}
+					if err := vm.SetGuest(guest); err != nil {
 						log.Println("error setting guest", err)
 					}
+					r.guest.Store(guest)
 				}()
 				return nil
 			}))
@@ -153,7 +169,7 @@ func (r *Resource) OpenContext(ctx context.Context, name string) (fs.File, error
 }
 
 func (r *Resource) rootFS() fskit.MapFS {
-	return fskit.MapFS{
+	root := fskit.MapFS{
 		"ctl": misc.ControlFile(&cli.Command{
 			Usage: "ctl",
 			Short: "control the worker",
@@ -165,6 +181,9 @@ func (r *Resource) rootFS() fskit.MapFS {
 					if !r.worker.IsUndefined() {
 						r.worker.Call("terminate")
 					}
+					if r.guestCancel != nil {
+						r.guestCancel()
+					}
 					r.state = "terminated"
 				}
 			},
@@ -175,6 +194,10 @@ func (r *Resource) rootFS() fskit.MapFS {
 			return nil
 		}),
 	}
+	if guest := r.guest.Load(); guest != nil {
+		root["guestcache"] = guest.StatsFS()
+	}
+	return root
 }
 
 func (r *Resource) Route(ctx context.Context, name string) (fs.FS, string, error) {
@@ -100,6 +100,9 @@ async function createTerminal(fsys: any, config: Config) {
 	await fsys.writeFile(`${taskPath}/ctl`, `bind ${termPathInner}/program ${taskPathInner}/fd/0`);
 	await fsys.writeFile(`${taskPath}/ctl`, `bind ${termPathInner}/program ${taskPathInner}/fd/1`);
 	await fsys.writeFile(`${taskPath}/ctl`, `bind ${termPathInner}/program ${taskPathInner}/fd/2`);
+	if (config.raw) {
+		await fsys.writeFile(`${termPath}/ctl`, "rawon");
+	}
 	await fsys.writeFile(`${taskPath}/ctl`, "start");
 
 	const writeEmitter = new vscode.EventEmitter<string>();
@@ -107,7 +110,6 @@ async function createTerminal(fsys: any, config: Config) {
 	const enc = new TextEncoder();
 	const readable = await fsys.openReadable(`${termPath}/data`);
 	const writable = (await fsys.openWritable(`${termPath}/data`)).getWriter();
-	let buffer = '';
 	return {
 		onDidWrite: writeEmitter.event,
 		open: () => {
@@ -121,25 +123,8 @@ async function createTerminal(fsys: any, config: Config) {
 			writable.close();
 		},
 		handleInput: async (data: string) => {
-			if (config.raw) {
-				writable.write(enc.encode(data));
-				return;
-			}
-			// may add line discipline as mode to terminals but for now we
-			// do as plan 9 and handle it here in "userspace"
-			if (data === '\r') {
-				writeEmitter.fire('\r\n');           // echo newline
-				writable.write(enc.encode(buffer+"\n"));
-				buffer = '';
-			} else if (data === '\x7f') {   // backspace
-				if (buffer.length > 0) {
-					buffer = buffer.slice(0, -1);
-					writeEmitter.fire('\b \b');
-				}
-			} else {
-				buffer += data;
-				writeEmitter.fire(data);             // echo
-			}
+			// the terminal device does line discipline unless in raw mode
+			writable.write(enc.encode(data));
 		},
 		setDimensions: async (dimensions: vscode.TerminalDimensions) => {
 			// const winch = (await fsys.openWritable(`${termPath}/winch`)).getWriter();